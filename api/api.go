@@ -0,0 +1,6 @@
+// Package api is the gRPC API of a running watcher, see fwbackup.proto.
+// The watcher serves it with --grpc-addr, clients in other languages are
+// generated from fwbackup.proto.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fwbackup.proto
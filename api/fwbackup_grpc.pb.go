@@ -0,0 +1,329 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fwbackup.proto
+
+// Remote management of a running watcher, served with --grpc-addr
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Watcher_Start_FullMethodName        = "/fwbackup.v1.Watcher/Start"
+	Watcher_Stop_FullMethodName         = "/fwbackup.v1.Watcher/Stop"
+	Watcher_Stats_FullMethodName        = "/fwbackup.v1.Watcher/Stats"
+	Watcher_ListVersions_FullMethodName = "/fwbackup.v1.Watcher/ListVersions"
+	Watcher_Restore_FullMethodName      = "/fwbackup.v1.Watcher/Restore"
+	Watcher_BackupNow_FullMethodName    = "/fwbackup.v1.Watcher/BackupNow"
+)
+
+// WatcherClient is the client API for Watcher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Watcher manages the watcher serving it
+type WatcherClient interface {
+	// Start resumes backups stopped with Stop and queues the files changed meanwhile
+	Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartResponse, error)
+	// Stop pauses backups, changes are still watched and collected until Start
+	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error)
+	// Stats returns the status of the watcher
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	// ListVersions returns one page of the stored versions
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	// Restore restores a version of a file in place, in the source directory
+	Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error)
+	// BackupNow backs up a file, or every file of a directory, right away
+	BackupNow(ctx context.Context, in *BackupNowRequest, opts ...grpc.CallOption) (*BackupNowResponse, error)
+}
+
+type watcherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWatcherClient(cc grpc.ClientConnInterface) WatcherClient {
+	return &watcherClient{cc}
+}
+
+func (c *watcherClient) Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartResponse)
+	err := c.cc.Invoke(ctx, Watcher_Start_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (*StopResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StopResponse)
+	err := c.cc.Invoke(ctx, Watcher_Stop_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, Watcher_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, Watcher_ListVersions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RestoreResponse)
+	err := c.cc.Invoke(ctx, Watcher_Restore_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) BackupNow(ctx context.Context, in *BackupNowRequest, opts ...grpc.CallOption) (*BackupNowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BackupNowResponse)
+	err := c.cc.Invoke(ctx, Watcher_BackupNow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WatcherServer is the server API for Watcher service.
+// All implementations must embed UnimplementedWatcherServer
+// for forward compatibility.
+//
+// Watcher manages the watcher serving it
+type WatcherServer interface {
+	// Start resumes backups stopped with Stop and queues the files changed meanwhile
+	Start(context.Context, *StartRequest) (*StartResponse, error)
+	// Stop pauses backups, changes are still watched and collected until Start
+	Stop(context.Context, *StopRequest) (*StopResponse, error)
+	// Stats returns the status of the watcher
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	// ListVersions returns one page of the stored versions
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	// Restore restores a version of a file in place, in the source directory
+	Restore(context.Context, *RestoreRequest) (*RestoreResponse, error)
+	// BackupNow backs up a file, or every file of a directory, right away
+	BackupNow(context.Context, *BackupNowRequest) (*BackupNowResponse, error)
+	mustEmbedUnimplementedWatcherServer()
+}
+
+// UnimplementedWatcherServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWatcherServer struct{}
+
+func (UnimplementedWatcherServer) Start(context.Context, *StartRequest) (*StartResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedWatcherServer) Stop(context.Context, *StopRequest) (*StopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedWatcherServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedWatcherServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedWatcherServer) Restore(context.Context, *RestoreRequest) (*RestoreResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedWatcherServer) BackupNow(context.Context, *BackupNowRequest) (*BackupNowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BackupNow not implemented")
+}
+func (UnimplementedWatcherServer) mustEmbedUnimplementedWatcherServer() {}
+func (UnimplementedWatcherServer) testEmbeddedByValue()                 {}
+
+// UnsafeWatcherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WatcherServer will
+// result in compilation errors.
+type UnsafeWatcherServer interface {
+	mustEmbedUnimplementedWatcherServer()
+}
+
+func RegisterWatcherServer(s grpc.ServiceRegistrar, srv WatcherServer) {
+	// If the following call pancis, it indicates UnimplementedWatcherServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Watcher_ServiceDesc, srv)
+}
+
+func _Watcher_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Start_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Start(ctx, req.(*StartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Stop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Stop(ctx, req.(*StopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_ListVersions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_Restore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Restore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Restore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Restore(ctx, req.(*RestoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_BackupNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackupNowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).BackupNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_BackupNow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).BackupNow(ctx, req.(*BackupNowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Watcher_ServiceDesc is the grpc.ServiceDesc for Watcher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Watcher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fwbackup.v1.Watcher",
+	HandlerType: (*WatcherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Start",
+			Handler:    _Watcher_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _Watcher_Stop_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _Watcher_Stats_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _Watcher_ListVersions_Handler,
+		},
+		{
+			MethodName: "Restore",
+			Handler:    _Watcher_Restore_Handler,
+		},
+		{
+			MethodName: "BackupNow",
+			Handler:    _Watcher_BackupNow_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "fwbackup.proto",
+}
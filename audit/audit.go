@@ -0,0 +1,134 @@
+package audit
+
+// Package audit records what happened to every file event in an append-only
+// log, one JSON object per line, so backups and skipped files can be reviewed
+// or processed by other tools later.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of a file event
+const (
+	OutcomeBackedUp        = "backed_up"         // A new version was stored
+	OutcomeFailed          = "failed"            // The backup failed
+	OutcomeSkippedVanished = "skipped_vanished"  // The file disappeared before it could be backed up
+	OutcomeSkippedInterval = "skipped_interval"  // The file was backed up too recently
+	OutcomeDropped         = "dropped"           // The backup queue was full
+	OutcomeSkippedTooLarge = "skipped_too_large" // The file is larger than the maximum file size
+	OutcomeSkippedPolicy   = "skipped_policy"    // The backup policy excluded the file
+	OutcomeDeferredLocked  = "deferred_locked"   // The file was locked by another process, its backup is tried again later
+	OutcomeDeferredChanged = "deferred_changed"  // The file changed while it was copied, its backup is tried again later
+	OutcomeDeferredCopies  = "deferred_copies"   // Too few mirrors stored the version, its backup is tried again later
+
+	// Not an outcome of a backup, the permissions or the owner of a file changed
+	OutcomePermissionsChanged = "permissions_changed"
+)
+
+// Entry is a single line of the audit log
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event,omitempty"`  // File event that triggered the entry, e.g. "WRITE"
+	Path    string    `json:"path"`             // Absolute path of the source file
+	Outcome string    `json:"outcome"`          // One of the Outcome* constants
+	Backup  string    `json:"backup,omitempty"` // Path of the stored version, if any
+	Reason  string    `json:"reason,omitempty"` // Human readable explanation of the outcome
+	Error   string    `json:"error,omitempty"`  // Error kind label, see utils.ErrorLabel
+	Mode    *Change   `json:"mode,omitempty"`   // Change of the permissions, e.g. "-rw-r--r--" to "-rw-------"
+	Owner   *Change   `json:"owner,omitempty"`  // Change of the owner and group, as "uid:gid"
+}
+
+// Change is a value of a file before and after a change
+type Change struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Log is an append-only audit log. A nil *Log is valid and discards all entries.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens or creates the audit log at path, appending to existing entries
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+
+	return &Log{path: path, file: file}, nil
+}
+
+// Reopen closes the audit log and opens its path again, so entries go to a
+// new file once the log was moved away, e.g. by logrotate
+func (l *Log) Reopen() error {
+	if l == nil {
+		return nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error reopening audit log: %w", err)
+	}
+
+	l.mu.Lock()
+	old := l.file
+	l.file = file
+	l.mu.Unlock()
+
+	old.Sync()
+	return old.Close()
+}
+
+// Record appends an entry, setting its time if it is not set yet
+func (l *Log) Record(entry Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Flush makes sure all recorded entries are on disk
+func (l *Log) Flush() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Sync()
+}
+
+// Close flushes and closes the audit log
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	if err := l.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
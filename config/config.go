@@ -2,7 +2,15 @@ package config
 
 // Config holds the configuration settings for the backup tool
 
-import "time"
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	SourceDir      string        // Directory to monitor
@@ -10,16 +18,155 @@ type Config struct {
 	MaxVersions    int           // Maximum number of backup versions to keep
 	MinInterval    time.Duration // Minimum interval between backups
 	IgnorePatterns []string      // Patterns to ignore when monitoring files
+	OnlyPatterns   []string      // When set, only files matching one of these patterns are backed up
+	BackupOn       []string      // Event types that trigger backups, see EventTypes
+	EmptyCreate    string        // What to do with files created empty: "backup" or "skip" until they are written
+	PanicPolicy    string        // What to do when a worker panics: "restart", "crash" or "stop"
+	DrainOnExit    bool          // Process all queued backup jobs before stopping
+	AuditLog       string        // Path of the audit log, empty to disable it
+	AuditPerms     bool          // Record changes of permissions and owners in the audit log, without backing files up
+	MaxOpsPerSec   float64       // Global limit of backup copies per second, 0 for unlimited
+	MaxBytesPerSec int64         // Global limit of bytes copied per second, 0 for unlimited
+	MaxReadPerSec  int64         // Limit of bytes read per second from each source file, 0 for unlimited
+	DropCache      bool          // Evict copied files from the page cache, keeping the cache of other applications
+	Reflink        bool          // Clone files copy-on-write (reflink, clonefile) instead of copying them where the file system supports it
+	CopyStreams    bool          // Back up alternate data streams (NTFS) and resource forks (macOS)
+	Dedup          bool          // Hard link new versions to stored versions with the same content
+	LinkUnchanged  bool          // Hard link versions of files whose content did not change to their latest version instead of copying
+	VerifyCopies   bool          // Hash the source and the new version after copying, files changed meanwhile are backed up again later
+	PreBackupCmd   string        // Shell command run before each backup, a failure skips the backup
+	PostBackupCmd  string        // Shell command run after each successful backup
+	HookTimeout    time.Duration // Maximum run time of the pre and post backup commands
+	JobTimeout     time.Duration // Maximum run time of a backup job, stalled workers are replaced after it, 0 for none
+	JobRetries     int           // Times a failed backup job is retried when the error is retryable
+	JobRetryDelay  time.Duration // Delay before the first retry of a job, doubled for each further one
+	LockRetries    int           // Times the backup of a locked file is deferred before it fails, 0 backs up locked files right away
+	LockDelay      time.Duration // Delay before a deferred backup of a locked file is tried again, doubled for each further one
+	Owner          string        // Only back up files owned by this user (name or UID), empty for anyone
+	Group          string        // Only back up files owned by this group (name or GID), empty for any
+	Policy         string        // CEL expression deciding whether a changed file is backed up, empty backs up all
+	Notify         bool          // Show desktop notifications when backups keep failing or jobs are dropped
+	WebhookURL     string        // URL that backup events are POSTed to as JSON, empty to disable
+	WebhookFormat  string        // Payload format of the webhook: "generic", "slack" or "discord"
+	KafkaURL       string        // Kafka REST Proxy that events and file changes are produced to, empty to disable
+	KafkaTopic     string        // Kafka topic of the events
+	ExternalTool   string        // Tool backed up files are pushed with: "restic" or "borg" into a repository, "rsync" to replicate versions, empty to disable
+	ExternalRepo   string        // Repository of ExternalTool, or the rsync target
+	ExternalDelay  time.Duration // Time backed up files are collected before they are pushed to ExternalRepo together
+	EmailTo        []string      // Recipients of failure alert emails, empty to disable them
+	EmailFrom      string        // Sender of failure alert emails
+	SMTPServer     string        // SMTP server as host:port
+	SMTPUser       string        // SMTP user, empty to send without authentication
+	SMTPPassword   string        // SMTP password
+	EmailThreshold int           // Consecutive backup failures before an email is sent
+	EmailInterval  time.Duration // Minimum time between two alert emails
+	MaxFileSize    int64         // Files larger than this are not backed up, 0 for no limit
+	LargeFileSize  int64         // Files at least this large are backed up after small ones, 0 to keep the queue order
+	LargeFileDelay time.Duration // Maximum time a large file is held back for small ones
+	SweepInterval  time.Duration // Interval of full scans catching changes fsnotify missed, 0 to disable them
+	PauseOnRemoval bool          // Hold queued backups while the source directory is removed, until it is back
+	DigestAt       string        // Time of day ("15:04") a daily digest is printed, empty to disable it
+	DigestTop      int           // Most versioned files listed in a digest
+	DigestFile     string        // File digests and the report on shutdown are appended to as JSON lines, empty to disable
+	DigestWebhook  string        // URL digests and the report on shutdown are POSTed to in WebhookFormat, empty to disable
+	VerifyAt       string        // Time of day ("15:04") a share of the stored versions is verified, empty to disable it
+	VerifyPercent  float64       // Share of the stored versions verified each day, in percent
+	MaxBackupSize  int64         // Quota of all stored versions in bytes, the oldest are pruned beyond it, 0 for none
+	MinFreeSpace   int64         // Free space to leave on the backup file system, 0 to not check
+	LowSpaceAction string        // What to do when free space is low: "pause", "prune" or "alert"
+	VersionIDs     string        // Kind of stable version IDs recorded in the manifests: "ulid" or "uuid"
+	VersionNaming  string        // How version files are named, one of the Naming* constants
+	Layout         string        // How versions are arranged in the backup directory, one of the Layout* constants
+	IndexKeyFile   string        // File with the secret encrypting version manifests, empty for plain text
+	Mirrors        []string      // Directories every new version is copied to as well, e.g. mounted remote storage
+	DateLinks      string        // Directory every new version is also linked into as <YYYY-MM-DD>/<path>, empty to disable
+	DateLinksDays  int           // Days kept in DateLinks, 0 keeps all
+	MinCopies      int           // Destinations, the backup directory and mirrors, that must store a version for its backup to succeed
+	FollowLinks    []string      // Directories outside the source that symbolic links in it are followed into
+	AllowMultiple  bool          // Start even when another watcher watches the source directory
+	ShareSource    bool          // Share the claim of the source directory with the watchers of this process that set it too, e.g. profiles
+	StatsHistory   int           // Stats snapshots kept for showing trends, one every 10 seconds, 0 to keep none
 }
 
+// Panic policies applied when a backup worker panics
+const (
+	PanicRestart = "restart" // Start a replacement worker and keep going
+	PanicCrash   = "crash"   // Crash the whole process immediately
+	PanicStop    = "stop"    // Let the worker die, the pool shrinks by one
+)
+
+// EventTypes are the event types that can trigger backups
+var EventTypes = []string{"CREATE", "WRITE", "CHMOD"}
+
+// Policies for files created empty
+const (
+	EmptyBackup = "backup" // Back them up like any other file
+	EmptySkip   = "skip"   // Wait for the first write, editors often create a file before filling it
+)
+
+// Kinds of version IDs
+const (
+	IDULID = "ulid" // Sortable by creation time
+	IDUUID = "uuid" // Random, version 4
+)
+
+// Actions taken when a backup would leave less than MinFreeSpace
+const (
+	LowSpacePause = "pause" // Hold backups until space is freed
+	LowSpacePrune = "prune" // Remove the oldest versions, keeping the latest of each file, then pause if still short
+	LowSpaceAlert = "alert" // Only log and notify, back up anyway
+)
+
+// Version naming modes. Timestamp modes embed the creation time with the given
+// precision, within a period the latest change replaces the version of that period.
+// Sequence names only show the order of versions, hash names only the content.
+const (
+	NamingMicrosecond = "microsecond"
+	NamingSecond      = "second"
+	NamingMinute      = "minute"
+	NamingHour        = "hour"
+	NamingDay         = "day"
+	NamingSequence    = "sequence"
+	NamingHash        = "hash"
+)
+
+// Layouts of the backup directory
+const (
+	LayoutFile = "file" // Versions of each file in <path of the file>_versions
+	LayoutDate = "date" // <YYYY-MM-DD>/<path of the file>, one version of a file per day
+)
+
 // TODO: In the future, this could be loaded from a file
-// NewConfig creates a new Config instance with default ignore patterns 
+// NewConfig creates a new Config instance with default ignore patterns
 func NewConfig(source, backup string, versions int, interval time.Duration) *Config {
 	return &Config{
-		SourceDir:   source,
-		BackupDir:   backup,
-		MaxVersions: versions,
-		MinInterval: interval,
+		SourceDir:      source,
+		BackupDir:      backup,
+		MaxVersions:    versions,
+		MinInterval:    interval,
+		PanicPolicy:    PanicRestart,
+		DrainOnExit:    true,
+		Reflink:        true,
+		HookTimeout:    30 * time.Second,
+		JobRetryDelay:  5 * time.Second,
+		LockRetries:    5,
+		LockDelay:      30 * time.Second,
+		VerifyPercent:  5,
+		WebhookFormat:  "generic",
+		DigestTop:      5,
+		MinCopies:      1,
+		KafkaTopic:     "file-watcher-events",
+		ExternalDelay:  time.Minute,
+		VersionNaming:  NamingMicrosecond,
+		Layout:         LayoutFile,
+		VersionIDs:     IDULID,
+		EmailThreshold: 5,
+		EmailInterval:  15 * time.Minute,
+		LowSpaceAction: LowSpacePause,
+		LargeFileDelay: 5 * time.Minute,
+		BackupOn:       []string{"CREATE", "WRITE"},
+		EmptyCreate:    EmptyBackup,
+		StatsHistory:   360,
 		IgnorePatterns: []string{
 			"*.tmp",
 			"*.swp",
@@ -28,3 +175,208 @@ func NewConfig(source, backup string, versions int, interval time.Duration) *Con
 		},
 	}
 }
+
+// BackupInsideSource reports whether the backup directory is inside the source
+// directory and returns its absolute path. It is excluded from watching then,
+// otherwise every version would be backed up again.
+func (c *Config) BackupInsideSource() (string, bool) {
+	backup, err := filepath.Abs(c.BackupDir)
+	if err != nil {
+		return "", false
+	}
+	return backup, within(backup, c.SourceDir) && !within(c.SourceDir, backup)
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	absPath, err1 := filepath.Abs(path)
+	absDir, err2 := filepath.Abs(dir)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Validate checks that the configuration can be used to start a watcher
+func (c *Config) Validate() error {
+	if c.SourceDir == "" || c.BackupDir == "" {
+		return fmt.Errorf("both source and backup directories are required")
+	}
+
+	info, err := os.Stat(c.SourceDir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("source directory does not exist: %s", c.SourceDir)
+	}
+	if err == nil && !info.IsDir() {
+		return fmt.Errorf("source is not a directory: %s", c.SourceDir)
+	}
+
+	// The other way round the backup directory is excluded from watching
+	if within(c.SourceDir, c.BackupDir) {
+		return fmt.Errorf("source directory must not be the backup directory or inside it: %s", c.SourceDir)
+	}
+
+	if c.MaxVersions < 1 {
+		return fmt.Errorf("at least one version must be kept, got %d", c.MaxVersions)
+	}
+
+	switch c.PanicPolicy {
+	case PanicRestart, PanicCrash, PanicStop:
+	default:
+		return fmt.Errorf("invalid panic policy: %s", c.PanicPolicy)
+	}
+
+	for _, pattern := range c.OnlyPatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+
+	if c.DigestAt != "" {
+		if _, err := time.Parse("15:04", c.DigestAt); err != nil {
+			return fmt.Errorf("invalid digest time %q, expected HH:MM", c.DigestAt)
+		}
+	}
+
+	if c.DigestTop < 0 {
+		return fmt.Errorf("digest top files must not be negative, got %d", c.DigestTop)
+	}
+
+	if c.DigestWebhook != "" {
+		if u, err := url.Parse(c.DigestWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid digest webhook URL: %s", c.DigestWebhook)
+		}
+	}
+
+	if c.VerifyAt != "" {
+		if _, err := time.Parse("15:04", c.VerifyAt); err != nil {
+			return fmt.Errorf("invalid verify time %q, expected HH:MM", c.VerifyAt)
+		}
+		if c.VerifyPercent <= 0 || c.VerifyPercent > 100 {
+			return fmt.Errorf("verify percent must be above 0 and at most 100, got %g", c.VerifyPercent)
+		}
+	}
+
+	if c.StatsHistory < 0 {
+		return fmt.Errorf("stats history must not be negative, got %d", c.StatsHistory)
+	}
+
+	if c.SweepInterval < 0 {
+		return fmt.Errorf("sweep interval must not be negative, got %s", c.SweepInterval)
+	}
+
+	if c.LargeFileDelay < 0 {
+		return fmt.Errorf("large file delay must not be negative, got %s", c.LargeFileDelay)
+	}
+
+	if c.JobTimeout < 0 {
+		return fmt.Errorf("job timeout must not be negative, got %s", c.JobTimeout)
+	}
+
+	if c.JobRetries < 0 {
+		return fmt.Errorf("job retries must not be negative, got %d", c.JobRetries)
+	}
+	if c.JobRetries > 0 && c.JobRetryDelay <= 0 {
+		return fmt.Errorf("job retry delay must be positive, got %s", c.JobRetryDelay)
+	}
+	if c.LockRetries < 0 {
+		return fmt.Errorf("lock retries must not be negative, got %d", c.LockRetries)
+	}
+	if c.LockRetries > 0 && c.LockDelay <= 0 {
+		return fmt.Errorf("lock delay must be positive, got %s", c.LockDelay)
+	}
+
+	for _, mirror := range c.Mirrors {
+		if within(mirror, c.SourceDir) || within(c.SourceDir, mirror) {
+			return fmt.Errorf("mirror must not be the source directory, inside it or around it: %s", mirror)
+		}
+		if within(mirror, c.BackupDir) || within(c.BackupDir, mirror) {
+			return fmt.Errorf("mirror must not be the backup directory, inside it or around it: %s", mirror)
+		}
+	}
+	if c.ExternalTool != "" && c.ExternalDelay <= 0 {
+		return fmt.Errorf("external delay must be positive, got %s", c.ExternalDelay)
+	}
+
+	if c.DateLinks != "" {
+		if within(c.DateLinks, c.SourceDir) || within(c.SourceDir, c.DateLinks) {
+			return fmt.Errorf("date links directory must not be the source directory, inside it or around it: %s", c.DateLinks)
+		}
+		if within(c.DateLinks, c.BackupDir) || within(c.BackupDir, c.DateLinks) {
+			return fmt.Errorf("date links directory must not be the backup directory, inside it or around it: %s", c.DateLinks)
+		}
+	}
+	if c.DateLinksDays < 0 {
+		return fmt.Errorf("date links days must not be negative, got %d", c.DateLinksDays)
+	}
+	for _, dir := range c.FollowLinks {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("directory to follow links into does not exist: %s", dir)
+		}
+		if within(dir, c.SourceDir) || within(c.SourceDir, dir) {
+			return fmt.Errorf("directory to follow links into must be outside the source directory and not around it: %s", dir)
+		}
+		if within(dir, c.BackupDir) || within(c.BackupDir, dir) {
+			return fmt.Errorf("directory to follow links into must not be the backup directory, inside it or around it: %s", dir)
+		}
+	}
+	if c.MinCopies < 1 || c.MinCopies > 1+len(c.Mirrors) {
+		return fmt.Errorf("min copies must be between 1 and the number of destinations (%d), got %d",
+			1+len(c.Mirrors), c.MinCopies)
+	}
+
+	if c.AuditPerms && c.AuditLog == "" {
+		return fmt.Errorf("auditing permissions needs an audit log")
+	}
+
+	if len(c.BackupOn) == 0 {
+		return fmt.Errorf("at least one event type must trigger backups")
+	}
+	for _, eventType := range c.BackupOn {
+		if !slices.Contains(EventTypes, eventType) {
+			return fmt.Errorf("invalid event type: %s, expected one of %s", eventType, strings.Join(EventTypes, ", "))
+		}
+	}
+
+	switch c.EmptyCreate {
+	case EmptyBackup, EmptySkip:
+	default:
+		return fmt.Errorf("invalid policy for empty files: %s", c.EmptyCreate)
+	}
+
+	switch c.LowSpaceAction {
+	case LowSpacePause, LowSpacePrune, LowSpaceAlert:
+	default:
+		return fmt.Errorf("invalid low space action: %s", c.LowSpaceAction)
+	}
+
+	switch c.VersionIDs {
+	case IDULID, IDUUID:
+	default:
+		return fmt.Errorf("invalid version ID kind: %s", c.VersionIDs)
+	}
+
+	switch c.VersionNaming {
+	case NamingMicrosecond, NamingSecond, NamingMinute, NamingHour, NamingDay, NamingSequence, NamingHash:
+	default:
+		return fmt.Errorf("invalid version naming: %s", c.VersionNaming)
+	}
+
+	switch c.Layout {
+	case LayoutFile:
+	case LayoutDate:
+		// Versions are named after the file, the day is their directory
+		if c.VersionNaming != NamingMicrosecond {
+			return fmt.Errorf("version naming does not apply to the date layout")
+		}
+		if c.DateLinks != "" {
+			return fmt.Errorf("date links duplicate the date layout")
+		}
+	default:
+		return fmt.Errorf("invalid layout: %s", c.Layout)
+	}
+
+	return nil
+}
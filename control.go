@@ -0,0 +1,459 @@
+package main
+
+// Control socket of a running watcher. It serves a small HTTP API on a unix
+// socket in the backup directory, so commands such as status can find the
+// watcher of a backup directory without knowing its process. Commands reply
+// with a controlReply, or with the status or statistics they ask for.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+// controlSocketName is the control socket in the backup directory
+const controlSocketName = ".control.sock"
+
+// controlReply is the reply to a command sent over the control socket
+type controlReply struct {
+	Done   bool   `json:"done"`             // False when there was nothing to do, e.g. pausing paused backups
+	Queued int    `json:"queued,omitempty"` // Files queued for backup
+	Error  string `json:"error,omitempty"`
+}
+
+// serveControl listens on the control socket of the backup directory and
+// answers requests until the returned function is called. Commands queueing
+// files stop waiting for room in the queue once ctx is canceled.
+func serveControl(ctx context.Context, fw *watcher.FileWatcher, backupDir string) (func(), error) {
+	path := filepath.Join(backupDir, controlSocketName)
+
+	if watcherRunning(backupDir) {
+		return nil, fmt.Errorf("another watcher is running for %s", backupDir)
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user running the watcher may query it
+	os.Chmod(path, 0600)
+
+	reply := func(w http.ResponseWriter, v interface{}, err error) {
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			v = controlReply{Error: err.Error()}
+		}
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, fw.Status(), nil)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, fw.GetStats(), nil)
+	})
+	mux.HandleFunc("GET /stats/history", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, fw.StatsHistory(), nil)
+	})
+	mux.Handle("GET /metrics", metricsHandler(fw))
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, controlReply{Done: fw.Pause()}, nil)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		if paused, _ := fw.Paused(); !paused {
+			reply(w, controlReply{}, nil)
+			return
+		}
+		// Not canceled with the request, the changes collected are released already
+		n, err := fw.Resume(ctx)
+		reply(w, controlReply{Done: true, Queued: n}, err)
+	})
+	mux.HandleFunc("POST /flush", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, controlReply{Done: true}, fw.Flush(r.Context()))
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, controlReply{Done: true}, fw.Reload())
+	})
+	mux.HandleFunc("POST /rules/try", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		// A list not sent is kept, one sent with only an empty pattern is cleared
+		patterns := func(name string) []string {
+			values, ok := query[name]
+			if !ok {
+				return nil
+			}
+			list := []string{}
+			for _, value := range values {
+				if value != "" {
+					list = append(list, value)
+				}
+			}
+			return list
+		}
+
+		period, err := time.ParseDuration(query.Get("for"))
+		if err != nil && query.Get("for") != "" {
+			reply(w, nil, fmt.Errorf("invalid period: %w", err))
+			return
+		}
+		rules := watcher.ShadowRules{IgnorePatterns: patterns("ignore"), OnlyPatterns: patterns("only")}
+		reply(w, controlReply{Done: true}, fw.TryRules(rules, period))
+	})
+	mux.HandleFunc("POST /rules/promote", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, controlReply{Done: fw.PromoteRules()}, nil)
+	})
+	mux.HandleFunc("POST /rules/discard", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, controlReply{Done: fw.DiscardRules()}, nil)
+	})
+	mux.HandleFunc("GET /queue", func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := fw.Queue()
+		reply(w, jobs, err)
+	})
+	mux.HandleFunc("POST /queue/drop", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			reply(w, nil, fmt.Errorf("invalid job ID: %w", err))
+			return
+		}
+		reply(w, controlReply{Done: true}, fw.DropJob(id))
+	})
+	mux.HandleFunc("POST /backup", func(w http.ResponseWriter, r *http.Request) {
+		n, err := fw.BackupNow(ctx, r.URL.Query().Get("path"))
+		reply(w, controlReply{Done: n > 0, Queued: n}, err)
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+
+	return func() {
+		srv.Close()
+		os.Remove(path)
+	}, nil
+}
+
+// watcherRunning reports whether a watcher answers on the control socket of
+// backupDir. A socket left behind by a crash refuses connections.
+func watcherRunning(backupDir string) bool {
+	conn, err := net.DialTimeout("unix", filepath.Join(backupDir, controlSocketName), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// controlCall sends a request to the watcher of the backup directory given
+// by the --backup flag and decodes its reply into out
+func controlCall(c *cli.Context, logger *utils.Logger, method, endpoint string, out interface{}) error {
+	backupDir := c.String("backup")
+	path := filepath.Join(backupDir, controlSocketName)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: 5 * time.Second}
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(c.Context, method, "http://watcher"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return cli.Exit(fmt.Sprintf(logger.Translate("no watcher is running for %s"), backupDir), 1)
+		}
+		return cli.Exit(fmt.Sprintf(logger.Translate("could not query the watcher: %v"), err), 1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failed controlReply
+		if err := json.NewDecoder(resp.Body).Decode(&failed); err != nil || failed.Error == "" {
+			failed.Error = resp.Status
+		}
+		return cli.Exit(failed.Error, 1)
+	}
+
+	// Numbers stay as sent, counters would be printed as floats otherwise
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return cli.Exit(fmt.Sprintf(logger.Translate("could not query the watcher: %v"), err), 1)
+	}
+	return nil
+}
+
+func runStatus(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context, 5*time.Second)
+	defer cancel()
+	c.Context = ctx
+
+	var status watcher.Status
+	if err := controlCall(c, logger, http.MethodGet, "/status", &status); err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(status)
+	}
+	printStatus(status, logger)
+	return nil
+}
+
+// runControl sends one of the commands of the control subcommand to the watcher
+func runControl(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	var reply controlReply
+	switch c.Command.Name {
+	case "pause":
+		if err := controlCall(c, logger, http.MethodPost, "/pause", &reply); err != nil {
+			return err
+		}
+		if !reply.Done {
+			logger.Info("Backups are already paused")
+			return nil
+		}
+		logger.Success("Backups paused")
+
+	case "resume":
+		if err := controlCall(c, logger, http.MethodPost, "/resume", &reply); err != nil {
+			return err
+		}
+		if !reply.Done {
+			logger.Info("Backups are not paused")
+			return nil
+		}
+		logger.Success("Backups resumed, %d files changed meanwhile are queued", reply.Queued)
+
+	case "flush":
+		if err := controlCall(c, logger, http.MethodPost, "/flush", &reply); err != nil {
+			return err
+		}
+		logger.Success("All queued backups are done")
+
+	case "reload":
+		if err := controlCall(c, logger, http.MethodPost, "/reload", &reply); err != nil {
+			return err
+		}
+		logger.Success("Watcher reloaded")
+
+	case "backup-now":
+		if c.NArg() != 1 {
+			return cli.Exit(logger.Translate("backup-now needs the path of a file or directory"), 1)
+		}
+		// Relative to the current directory here, the watcher may run elsewhere
+		path, err := filepath.Abs(c.Args().First())
+		if err != nil {
+			return err
+		}
+		endpoint := "/backup?" + url.Values{"path": {path}}.Encode()
+		if err := controlCall(c, logger, http.MethodPost, endpoint, &reply); err != nil {
+			return err
+		}
+		logger.Success("%d files queued for backup", reply.Queued)
+
+	case "try-rules":
+		query := url.Values{}
+		if c.IsSet("ignore") {
+			query["ignore"] = c.StringSlice("ignore")
+		}
+		if c.IsSet("only") {
+			query["only"] = c.StringSlice("only")
+		}
+		if c.IsSet("for") {
+			query.Set("for", c.Duration("for").String())
+		}
+		if err := controlCall(c, logger, http.MethodPost, "/rules/try?"+query.Encode(), &reply); err != nil {
+			return err
+		}
+		if c.IsSet("for") {
+			logger.Success("Trying the rules, they are promoted in %s", c.Duration("for"))
+		} else {
+			logger.Success("Trying the rules, promote them with control promote-rules")
+		}
+
+	case "promote-rules", "discard-rules":
+		endpoint := "/rules/promote"
+		if c.Command.Name == "discard-rules" {
+			endpoint = "/rules/discard"
+		}
+		if err := controlCall(c, logger, http.MethodPost, endpoint, &reply); err != nil {
+			return err
+		}
+		switch {
+		case !reply.Done:
+			logger.Info("No rules are tried")
+		case c.Command.Name == "discard-rules":
+			logger.Success("Rules tried discarded")
+		default:
+			logger.Success("Rules tried promoted")
+		}
+
+	case "stats":
+		if c.Bool("history") {
+			var history []watcher.StatsSample
+			if err := controlCall(c, logger, http.MethodGet, "/stats/history", &history); err != nil {
+				return err
+			}
+			if c.Bool("json") {
+				return json.NewEncoder(os.Stdout).Encode(history)
+			}
+			printStatsHistory(history, logger)
+			return nil
+		}
+
+		var stats map[string]interface{}
+		if err := controlCall(c, logger, http.MethodGet, "/stats", &stats); err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(stats)
+		}
+
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %v\n", name, stats[name])
+		}
+	}
+	return nil
+}
+
+// printStatsHistory prints a line per snapshot, with the backups since the
+// one before to show the rate
+func printStatsHistory(history []watcher.StatsSample, logger *utils.Logger) {
+	tr := logger.Translate
+	if len(history) == 0 {
+		fmt.Println(tr("No statistics kept, see --stats-history"))
+		return
+	}
+
+	var previous watcher.Stats
+	for i, sample := range history {
+		s := sample.Stats
+		if i == 0 {
+			previous = s
+		}
+		fmt.Printf(tr("%s  queue %d/%d, workers %d, +%d backed up, +%d failed, +%d dropped, +%s written")+"\n",
+			sample.Time.Local().Format(time.DateTime), s.QueueLength, s.QueueCapacity, s.ActiveWorkers,
+			s.BackupsSucceeded-previous.BackupsSucceeded, s.BackupsFailed-previous.BackupsFailed,
+			s.JobsDropped-previous.JobsDropped, utils.FormatSize(s.BytesWritten-previous.BytesWritten))
+		previous = s
+	}
+}
+
+// printStatus prints the status of a watcher in a human readable form
+func printStatus(s watcher.Status, logger *utils.Logger) {
+	tr := logger.Translate
+
+	arrow := "→"
+	if logger.Plain {
+		arrow = "->"
+	}
+	fmt.Printf(tr("Watching %s %s %s, up %s (since %s)")+"\n",
+		s.Source, arrow, s.Backup, time.Since(s.Started).Round(time.Second), s.Started.Local().Format(time.DateTime))
+	if s.Paused {
+		fmt.Printf(tr("Backups paused for %s")+"\n", time.Since(s.PausedSince).Round(time.Second))
+	}
+	fmt.Printf(tr("Queue: %d of %d, workers: %d active")+"\n",
+		s.Stats.QueueLength, s.Stats.QueueCapacity, s.Stats.ActiveWorkers)
+	fmt.Printf(tr("Jobs: %d backed up, %d failed, %d skipped, %d dropped")+"\n",
+		s.Outcomes["backed_up"], s.Outcomes["failed"],
+		s.Outcomes["skipped_vanished"]+s.Outcomes["skipped_interval"]+s.Outcomes["skipped_too_large"]+s.Outcomes["skipped_policy"],
+		s.Outcomes["dropped"])
+	if n := s.Outcomes["deferred_locked"]; n > 0 {
+		fmt.Printf(tr("%d backups deferred, the files were locked")+"\n", n)
+	}
+
+	if s.Stats.BackupsSucceeded > 0 {
+		fmt.Printf(tr("Written: %s, %s per backup on average")+"\n",
+			utils.FormatSize(s.Stats.BytesWritten), s.Stats.AvgLatency.Round(time.Millisecond))
+	}
+
+	if len(s.Stats.EventsObserved) > 0 {
+		fmt.Print(tr("Events (seen/queued):"))
+		var counts []string
+		for _, eventType := range []string{"CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD"} {
+			counts = append(counts, fmt.Sprintf("%s %d/%d", eventType, s.Stats.EventsObserved[eventType], s.Stats.EventsQueued[eventType]))
+		}
+		fmt.Println(" " + strings.Join(counts, ", "))
+	}
+
+	if sh := s.Shadow; sh != nil {
+		fmt.Printf(tr("Trying rules for %s: %d changes would be skipped, %d backed up in addition")+"\n",
+			time.Since(sh.Since).Round(time.Second), sh.WouldSkip, sh.WouldBackUp)
+		if !sh.PromoteAt.IsZero() {
+			fmt.Printf(tr("Rules tried are promoted at %s")+"\n", sh.PromoteAt.Local().Format(time.DateTime))
+		}
+	}
+
+	if len(s.Errors) > 0 {
+		kinds := make([]string, 0, len(s.Errors))
+		for kind := range s.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+
+		fmt.Print(tr("Errors:"))
+		for _, kind := range kinds {
+			fmt.Printf(" %s %d", kind, s.Errors[kind])
+		}
+		fmt.Println()
+	}
+	if n := s.Stats.DeadLetters; n > 0 {
+		fmt.Printf(tr("%d files could not be backed up, they are retried on their next change")+"\n", n)
+	}
+	if n := s.Stats.UnwatchedDirs; n > 0 {
+		fmt.Printf(tr("%d directories can not be watched and are polled instead")+"\n", n)
+	}
+	if len(s.Rewatch) > 0 {
+		fmt.Println(tr("Directories not watched yet, tried again:"))
+		for _, d := range s.Rewatch {
+			fmt.Printf("  %-40s "+tr("%d attempts, next in %s: %s")+"\n", d.Path, d.Attempts,
+				max(time.Until(d.Next), 0).Round(time.Second), d.Error)
+		}
+	}
+
+	if len(s.Recent) > 0 {
+		fmt.Println(tr("Recent backups:"))
+		for _, r := range s.Recent {
+			fmt.Printf("  %-40s "+tr("%s (%s ago)")+"\n", r.Path, r.Time.Local().Format(time.DateTime),
+				time.Since(r.Time).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+// diffSide is one of the two files compared by diff
+type diffSide struct {
+	label string // Shown in the diff header
+	path  string
+	size  int64
+	data  []byte // Content, nil when too large to diff line by line
+}
+
+func runDiff(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Clean(c.String("file"))
+	bm := watcher.NewBackupManager(c.String("backup"), 0, logger)
+
+	fromPath, err := bm.VersionPath(file, c.String("from"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	from, err := readDiffSide(fromPath, filepath.ToSlash(file)+"\t"+filepath.Base(fromPath))
+	if err != nil {
+		return err
+	}
+
+	var to *diffSide
+	switch {
+	case c.String("to") != "":
+		toPath, err := bm.VersionPath(file, c.String("to"))
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		to, err = readDiffSide(toPath, filepath.ToSlash(file)+"\t"+filepath.Base(toPath))
+		if err != nil {
+			return err
+		}
+	case c.String("source") != "":
+		to, err = readDiffSide(filepath.Join(c.String("source"), file), filepath.ToSlash(file)+"\t"+logger.Translate("current"))
+		if err != nil {
+			return err
+		}
+	default:
+		return cli.Exit(logger.Translate("either --to or --source is required"), 1)
+	}
+
+	if from.data != nil && to.data != nil {
+		diff, ok := utils.UnifiedDiff(from.label, to.label, from.data, to.data)
+		if ok {
+			if diff == "" {
+				logger.Info("No differences")
+			}
+			fmt.Print(diff)
+			return nil
+		}
+	}
+
+	// Binary, large or too different: compare sizes and checksums
+	fromSum, err := fileSHA256(from.path)
+	if err != nil {
+		return err
+	}
+	toSum, err := fileSHA256(to.path)
+	if err != nil {
+		return err
+	}
+	if fromSum == toSum {
+		logger.Info("No differences")
+		return nil
+	}
+
+	logger.Info("Files differ, they are binary, larger than %s or too different to show line by line:",
+		utils.FormatSize(utils.DiffMaxSize))
+	fmt.Printf("--- %s\t%s\tsha256 %s\n", from.label, utils.FormatSize(from.size), fromSum)
+	fmt.Printf("+++ %s\t%s\tsha256 %s\n", to.label, utils.FormatSize(to.size), toSum)
+	return nil
+}
+
+// readDiffSide reads a file to compare, its content only when it is small enough to diff
+func readDiffSide(path, label string) (*diffSide, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	side := &diffSide{label: label, path: path, size: info.Size()}
+	if info.Size() <= utils.DiffMaxSize {
+		if side.data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return side, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 checksum of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
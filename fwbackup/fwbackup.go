@@ -0,0 +1,278 @@
+// Package fwbackup embeds the file watcher and its versioned backups in other
+// Go programs. It reports what happens through callbacks and returned errors
+// instead of writing to the terminal.
+//
+//	w, err := fwbackup.New(fwbackup.Options{
+//		SourceDir: "./project",
+//		BackupDir: "./backups",
+//		OnEvent: func(e fwbackup.Event) {
+//			log.Printf("%s %s: %v", e.Outcome, e.Path, e.Err)
+//		},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	return w.Run(ctx)
+package fwbackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/config"
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+)
+
+// Errors classifying failed backups, match them with errors.Is on Event.Err
+var (
+	ErrSourceVanished  = utils.ErrSourceVanished
+	ErrDestinationFull = utils.ErrDestinationFull
+	ErrPermission      = utils.ErrPermission
+	ErrLocked          = utils.ErrLocked
+	ErrThrottled       = utils.ErrThrottled
+	ErrCanceled        = utils.ErrCanceled
+	ErrTimeout         = utils.ErrTimeout
+	ErrIO              = utils.ErrIO
+)
+
+// ErrSourceClaimed is returned by Run when another watcher watches the source
+// directory, unless Options.AllowMultiple is set
+var ErrSourceClaimed = watcher.ErrSourceClaimed
+
+// Outcomes reported in Event.Outcome
+const (
+	OutcomeBackedUp        = audit.OutcomeBackedUp
+	OutcomeFailed          = audit.OutcomeFailed
+	OutcomeSkippedVanished = audit.OutcomeSkippedVanished
+	OutcomeSkippedInterval = audit.OutcomeSkippedInterval
+	OutcomeDropped         = audit.OutcomeDropped
+	OutcomeDeferredLocked  = audit.OutcomeDeferredLocked
+	OutcomeDeferredChanged = audit.OutcomeDeferredChanged
+	OutcomeDeferredCopies  = audit.OutcomeDeferredCopies
+)
+
+// Options configures a Watcher. Zero values select the same defaults as the CLI.
+type Options struct {
+	SourceDir       string        // Directory to monitor, required
+	BackupDir       string        // Directory to store backups in, created if missing, required
+	MaxVersions     int           // Versions kept per file, default 3
+	MinInterval     time.Duration // Minimum time between two backups of the same file, default 5s
+	IgnorePatterns  []string      // Patterns to ignore, nil keeps the default list
+	OnlyPatterns    []string      // When set, only files matching one of these patterns are backed up
+	BackupOn        []string      // Event types that trigger backups: "CREATE", "WRITE" and "CHMOD", nil for CREATE and WRITE
+	SkipEmpty       bool          // Skip files created empty until they are written
+	PanicPolicy     string        // "restart" (default), "crash" or "stop"
+	AbandonOnExit   bool          // Don't process queued jobs when Run returns, only the ones in progress
+	ShutdownTimeout time.Duration // Maximum time to finish pending jobs when Run returns, 0 waits forever
+	AuditLog        string        // Path of the audit log, empty disables it
+	JobTimeout      time.Duration // Maximum run time of a backup, stalled workers are replaced after it, 0 for none
+	JobRetries      int           // Times a failed backup is retried when the error may be temporary
+	JobRetryDelay   time.Duration // Delay before the first retry, doubled for each further one, default 5s
+	LockRetries     int           // Times the backup of a locked file is deferred, default 5, negative backs up locked files right away
+	LockDelay       time.Duration // Delay before a locked file is tried again, doubled for each further try, default 30s
+	AllowMultiple   bool          // Run even when another watcher watches the source directory, Run fails with ErrSourceClaimed otherwise
+
+	// Log receives the human readable log the CLI prints, without colors.
+	// Nil discards it.
+	Log io.Writer
+
+	// Lang is the language of Log, "en" (default) or "pl"
+	Lang string
+
+	// LogLevel is the least severe message written to Log: "debug", "info"
+	// (default), "warn" or "error"
+	LogLevel string
+
+	// OnEvent is called with the outcome of every backup job, from worker
+	// goroutines, so it must be safe for concurrent use and return quickly.
+	OnEvent func(Event)
+
+	// OnChange is called for every file system change that is not ignored,
+	// before deciding whether to back it up. The same rules as for OnEvent apply.
+	OnChange func(Change)
+}
+
+// Change is a file system change observed in the watched directory
+type Change = watcher.FileEvent
+
+// DeadLetter is a file whose backup failed after all retries
+type DeadLetter = watcher.DeadLetter
+
+// Event describes the outcome of a backup job
+type Event struct {
+	Time    time.Time
+	Type    string // File event that caused the job, e.g. "CREATE" or "WRITE"
+	Path    string // Absolute path of the source file
+	Outcome string // One of the Outcome* constants
+	Backup  string // Path of the stored version when Outcome is OutcomeBackedUp
+	Reason  string // Human readable explanation for skipped and failed jobs
+	Err     error  // Classified error for failed and skipped jobs, nil otherwise
+}
+
+// Watcher monitors a directory and backs up changed files
+type Watcher struct {
+	opts Options
+	fw   *watcher.FileWatcher
+}
+
+// New validates the options and prepares a Watcher, nothing is watched until Run is called
+func New(opts Options) (*Watcher, error) {
+	if opts.MaxVersions == 0 {
+		opts.MaxVersions = 3
+	}
+	if opts.MinInterval == 0 {
+		opts.MinInterval = 5 * time.Second
+	}
+
+	cfg := config.NewConfig(opts.SourceDir, opts.BackupDir, opts.MaxVersions, opts.MinInterval)
+	if opts.IgnorePatterns != nil {
+		cfg.IgnorePatterns = opts.IgnorePatterns
+	}
+	if opts.PanicPolicy != "" {
+		cfg.PanicPolicy = opts.PanicPolicy
+	}
+	cfg.OnlyPatterns = opts.OnlyPatterns
+	if opts.BackupOn != nil {
+		cfg.BackupOn = opts.BackupOn
+	}
+	if opts.SkipEmpty {
+		cfg.EmptyCreate = config.EmptySkip
+	}
+	cfg.DrainOnExit = !opts.AbandonOnExit
+	cfg.AuditLog = opts.AuditLog
+	cfg.JobTimeout = opts.JobTimeout
+	cfg.JobRetries = opts.JobRetries
+	if opts.JobRetryDelay > 0 {
+		cfg.JobRetryDelay = opts.JobRetryDelay
+	}
+	if opts.LockRetries != 0 {
+		cfg.LockRetries = max(opts.LockRetries, 0)
+	}
+	if opts.LockDelay > 0 {
+		cfg.LockDelay = opts.LockDelay
+	}
+	cfg.AllowMultiple = opts.AllowMultiple
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	out := opts.Log
+	if out == nil {
+		out = io.Discard
+	}
+
+	logger := utils.NewLogger(out, false, true)
+	level, err := utils.ParseLevel(opts.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	logger.Level = level
+	if opts.Lang != "" {
+		lang, err := utils.ParseLanguage(opts.Lang)
+		if err != nil {
+			return nil, err
+		}
+		logger.Lang = lang
+	}
+
+	fw, err := watcher.NewFileWatcher(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.OnEvent != nil {
+		fw.OnOutcome(func(entry audit.Entry, err error) {
+			opts.OnEvent(newEvent(entry, err))
+		})
+	}
+
+	if opts.OnChange != nil {
+		fw.OnEvent(opts.OnChange)
+	}
+
+	return &Watcher{opts: opts, fw: fw}, nil
+}
+
+// newEvent converts an audit log entry and its error into an Event
+func newEvent(entry audit.Entry, err error) Event {
+	return Event{
+		Time:    entry.Time,
+		Type:    entry.Event,
+		Path:    entry.Path,
+		Outcome: entry.Outcome,
+		Backup:  entry.Backup,
+		Reason:  entry.Reason,
+		Err:     err,
+	}
+}
+
+// Run watches the source directory until ctx is cancelled or the watcher fails.
+// Before returning it finishes the pending backup jobs, bounded by ShutdownTimeout.
+// It returns nil after a clean stop. A Watcher can only be run once.
+func (w *Watcher) Run(ctx context.Context) error {
+	started := make(chan error, 1)
+	go func() {
+		started <- w.fw.Start(ctx)
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-started:
+	}
+
+	stopCtx := context.Background()
+	if w.opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(stopCtx, w.opts.ShutdownTimeout)
+		defer cancel()
+	}
+
+	if err := w.fw.Stop(stopCtx); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr == nil {
+		runErr = w.fw.Err()
+	}
+	return runErr
+}
+
+// PendingJobs returns the number of backup jobs waiting or in progress
+func (w *Watcher) PendingJobs() int {
+	return w.fw.PendingJobs()
+}
+
+// DeadLetters returns the files whose last backup failed after all retries,
+// the most recent failure first
+func (w *Watcher) DeadLetters() []DeadLetter {
+	return w.fw.DeadLetters()
+}
+
+// RetryFailed queues the files returned by DeadLetters for backup again and
+// returns how many were queued. It may only be called while Run is running.
+func (w *Watcher) RetryFailed(ctx context.Context) (int, error) {
+	return w.fw.RetryDeadLetters(ctx)
+}
+
+// Pause stops backing up changes until Resume is called, e.g. during a big
+// build. Changed files are collected meanwhile. It reports false when backups
+// were already paused.
+func (w *Watcher) Pause() bool {
+	return w.fw.Pause()
+}
+
+// Resume backs up again after Pause and queues the files changed meanwhile,
+// returning how many were queued. It may only be called while Run is running.
+func (w *Watcher) Resume(ctx context.Context) (int, error) {
+	return w.fw.Resume(ctx)
+}
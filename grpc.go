@@ -0,0 +1,260 @@
+package main
+
+// gRPC API of a running watcher, see api/fwbackup.proto, for managing it
+// remotely, e.g. from orchestration tools. Calls are authenticated with a
+// bearer token when one is set, and the connection is encrypted with TLS
+// when a certificate is given.
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cpprian/file-watcher-backup/api"
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcMaxPageSize is the largest page of versions ListVersions returns
+const grpcMaxPageSize = 5000
+
+// grpcOptions are the flags of the gRPC server
+type grpcOptions struct {
+	addr      string
+	certFile  string // TLS certificate, plain text without
+	keyFile   string
+	tokenFile string // File with the bearer token clients must send, no authentication without
+}
+
+// grpcServer implements api.WatcherServer for fw. ctx ends with the watcher,
+// commands queueing files stop waiting for room in the queue then.
+type grpcServer struct {
+	api.UnimplementedWatcherServer
+	ctx       context.Context
+	fw        *watcher.FileWatcher
+	sourceDir string
+}
+
+// serveGRPC serves the gRPC API of fw until the returned function is called.
+// Versions are restored to their file in sourceDir.
+func serveGRPC(ctx context.Context, fw *watcher.FileWatcher, sourceDir string, opts grpcOptions) (func(), error) {
+	var serverOpts []grpc.ServerOption
+	if opts.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})))
+	}
+
+	if opts.tokenFile != "" {
+		token, err := readToken(opts.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := checkToken(ctx, token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkToken(ss.Context(), token); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+
+	ln, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := grpc.NewServer(serverOpts...)
+	api.RegisterWatcherServer(srv, &grpcServer{ctx: ctx, fw: fw, sourceDir: sourceDir})
+	// Lets tools such as grpcurl call the API without the .proto file
+	reflection.Register(srv)
+	go srv.Serve(ln)
+
+	return srv.Stop, nil
+}
+
+// readToken reads the token clients must send from path
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// checkToken checks the bearer token in the authorization header of a call
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		sent, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && sameToken(sent, token) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+// grpcError converts err to a gRPC status, with the code matching its kind
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, utils.ErrSourceVanished):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, os.ErrPermission), errors.Is(err, utils.ErrProtected):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+}
+
+func (s *grpcServer) Start(ctx context.Context, _ *api.StartRequest) (*api.StartResponse, error) {
+	if paused, _ := s.fw.Paused(); !paused {
+		return &api.StartResponse{}, nil
+	}
+	// Not canceled with the call, the changes collected are released already
+	n, err := s.fw.Resume(s.ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.StartResponse{Started: true, Queued: int32(n)}, nil
+}
+
+func (s *grpcServer) Stop(ctx context.Context, _ *api.StopRequest) (*api.StopResponse, error) {
+	return &api.StopResponse{Stopped: s.fw.Pause()}, nil
+}
+
+func (s *grpcServer) Stats(ctx context.Context, _ *api.StatsRequest) (*api.StatsResponse, error) {
+	st := s.fw.Status()
+
+	// Nested maps of counters are not valid struct values, JSON turns them into ones that are
+	data, err := json.Marshal(st.Stats)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	stats := &structpb.Struct{}
+	if err := stats.UnmarshalJSON(data); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &api.StatsResponse{
+		Source:   st.Source,
+		Backup:   st.Backup,
+		Started:  timestamppb.New(st.Started),
+		Stopped:  st.Paused,
+		Outcomes: make(map[string]int64, len(st.Outcomes)),
+		Errors:   make(map[string]int64, len(st.Errors)),
+		Stats:    stats,
+	}
+	for outcome, n := range st.Outcomes {
+		resp.Outcomes[outcome] = int64(n)
+	}
+	for kind, n := range st.Errors {
+		resp.Errors[kind] = int64(n)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) ListVersions(ctx context.Context, req *api.ListVersionsRequest) (*api.ListVersionsResponse, error) {
+	size := int(req.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "page size must not be negative")
+	case size == 0:
+		size = webPageSize
+	}
+
+	q := watcher.VersionQuery{
+		Prefix: req.GetPrefix(),
+		Cursor: req.GetPageToken(),
+		Limit:  min(size, grpcMaxPageSize),
+	}
+	if req.Since != nil {
+		q.Since = req.GetSince().AsTime()
+	}
+	if req.Until != nil {
+		q.Until = req.GetUntil().AsTime()
+	}
+
+	page, err := s.fw.BackupManager.ListVersions(q)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp := &api.ListVersionsResponse{NextPageToken: page.NextCursor}
+	for _, v := range page.Versions {
+		resp.Versions = append(resp.Versions, &api.Version{
+			Id:      v.ID,
+			Path:    v.Path,
+			Version: v.Version,
+			Time:    timestamppb.New(v.Time),
+			Size:    v.Size,
+			Sha256:  v.SHA256,
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Restore(ctx context.Context, req *api.RestoreRequest) (*api.RestoreResponse, error) {
+	name := filepath.FromSlash(req.GetPath())
+	if name == "" || !filepath.IsLocal(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path: %q", req.GetPath())
+	}
+
+	restored, err := s.fw.BackupManager.Restore(ctx, name, req.GetVersion(), filepath.Join(s.sourceDir, name),
+		watcher.RestoreOptions{ClearProtection: req.GetClearProtection(), WithACLs: req.GetWithAcls()})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.RestoreResponse{Restored: filepath.Base(restored)}, nil
+}
+
+func (s *grpcServer) BackupNow(ctx context.Context, req *api.BackupNowRequest) (*api.BackupNowResponse, error) {
+	if req.GetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	}
+
+	// Gives up waiting for room in the queue with the call or the watcher
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	n, err := s.fw.BackupNow(ctx, req.GetPath())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.BackupNowResponse{Queued: int32(n)}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string // Authorization headers sent
+		ok     bool
+	}{
+		{"bearer", []string{"Bearer s3cret"}, true},
+		{"one of several", []string{"Basic czNjcmV0", "Bearer s3cret"}, true},
+		{"wrong token", []string{"Bearer secret"}, false},
+		{"longer token", []string{"Bearer s3cret2"}, false},
+		{"without scheme", []string{"s3cret"}, false},
+		{"other scheme", []string{"Basic s3cret"}, false},
+		{"empty", []string{"Bearer "}, false},
+		{"missing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			for _, value := range tt.values {
+				md.Append("authorization", value)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+
+			err := checkToken(ctx, "s3cret")
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.ok && status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("got %v, want Unauthenticated", err)
+			}
+		})
+	}
+
+	// Calls without any metadata
+	if status.Code(checkToken(context.Background(), "s3cret")) != codes.Unauthenticated {
+		t.Error("call without metadata accepted")
+	}
+}
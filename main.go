@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/cpprian/file-watcher-backup/audit"
 	"github.com/cpprian/file-watcher-backup/config"
 	"github.com/cpprian/file-watcher-backup/utils"
 	"github.com/cpprian/file-watcher-backup/watcher"
@@ -15,99 +23,1563 @@ import (
 )
 
 func main() {
-	app := &cli.App {
-		Name: "file-watcher-backup",
-		Usage: "Monitors a directory and creates backups of changed files.",
+	app := &cli.App{
+		Name:    "file-watcher-backup",
+		Usage:   "Monitors a directory and creates backups of changed files.",
 		Version: "1.0.0",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:     "source",
-				Aliases:  []string{"s"},
-				Usage:    "Directory to monitor for changes",
-				Required: true,
+		Flags: append(watcherFlags(), &cli.StringFlag{
+			Name:  "profiles",
+			Usage: "JSON file of named profiles, each with its own options, whose watchers run in this process",
+		}),
+		Action: runWatcher,
+		Commands: []*cli.Command{
+			{
+				Name:   "backup",
+				Usage:  "Backs up every changed file of the source directory once and exits, e.g. from cron",
+				Flags:  watcherFlags(),
+				Action: runBackup,
+			},
+			{
+				Name:  "simulate",
+				Usage: "Replays a recorded event trace or audit log against the given watcher options, without backing up anything",
+				Flags: append(watcherFlags(),
+					&cli.StringFlag{
+						Name:     "trace",
+						Usage:    "Event trace recorded with --record-trace, or an audit log",
+						Required: true,
+					},
+					&cli.DurationFlag{
+						Name:  "job-duration",
+						Usage: "Time a backup is assumed to take",
+						Value: 100 * time.Millisecond,
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the result as a JSON object",
+					},
+				),
+				Action: runSimulate,
+			},
+			{
+				Name:  "gen",
+				Usage: "Prints files for running the watcher with other tools",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "dashboard",
+						Usage:  "Prints a Grafana dashboard for the metrics of --metrics-addr, to import into Grafana",
+						Action: runGenDashboard,
+					},
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "Restores a backed up version of a file, or the whole tree as it was at a time, with the original metadata",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Usage:   "Path of the file relative to the watched directory",
+					},
+					&cli.StringFlag{
+						Name:  "version",
+						Usage: "Name or ID of the version to restore (default: latest)",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Restore every file as it was at the time given by --at, instead of one file",
+					},
+					&cli.StringFlag{
+						Name:  "at",
+						Usage: "Time to restore the tree at with --all (RFC 3339, YYYY-MM-DD or YYYY-MM-DD HH:MM)",
+					},
+					&cli.StringFlag{
+						Name:    "source",
+						Aliases: []string{"s"},
+						Usage:   "Watched directory, the file is restored in place",
+					},
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Usage:   "Path to restore the file to, or directory to restore the tree into, instead of the watched directory",
+					},
+					&cli.BoolFlag{
+						Name:  "clear-protection",
+						Usage: "Overwrite a read-only or immutable target, setting the attribute again afterwards (immutable files require root)",
+					},
+					&cli.BoolFlag{
+						Name:  "with-acls",
+						Usage: "Restore the ACL (Linux) or security descriptor (Windows) the file had when the version was created",
+					},
+					&cli.BoolFlag{
+						Name:  "backup-info",
+						Usage: "Write <file>.backupinfo.json next to every restored file, with the version ID, original timestamps, checksum and source host",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runRestore,
+			},
+			{
+				Name:  "diff",
+				Usage: "Shows the differences between two stored versions of a file, or between a version and the current file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "file",
+						Aliases:  []string{"f"},
+						Usage:    "Path of the file relative to the watched directory",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Name or ID of the older version (default: latest)",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Name or ID of the newer version, instead of the current file in --source",
+					},
+					&cli.StringFlag{
+						Name:    "source",
+						Aliases: []string{"s"},
+						Usage:   "Watched directory, the version is compared with the current file",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runDiff,
 			},
-			&cli.StringFlag{
-				Name:     "backup",
-				Aliases:  []string{"b"},
-				Usage:    "Directory to store backups",
-				Required: true,
+			{
+				Name:  "list",
+				Usage: "Lists stored versions, one page at a time",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "prefix",
+						Usage: "Only list files whose path relative to the watched directory starts with this",
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only list versions created at or after this time (RFC 3339 or YYYY-MM-DD)",
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "Only list versions created before this time (RFC 3339 or YYYY-MM-DD)",
+					},
+					&cli.StringFlag{
+						Name:  "min-size",
+						Usage: "Only list versions of at least this size, e.g. 10KB",
+					},
+					&cli.StringFlag{
+						Name:  "max-size",
+						Usage: "Only list versions of at most this size, e.g. 5MB",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Maximum number of versions per page (0 lists everything)",
+						Value: 100,
+					},
+					&cli.StringFlag{
+						Name:  "cursor",
+						Usage: "Continue with the page after the one that printed this cursor",
+					},
+					&cli.StringFlag{
+						Name:  "index-key-file",
+						Usage: "File with the secret the version manifests were encrypted with",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print one JSON object per version, followed by one with the next cursor",
+					},
+				},
+				Action: runList,
 			},
-			&cli.IntFlag{
-				Name:    "versions",
-				Aliases: []string{"vers"},
-				Usage:   "Maximum number of versions to store per file",
-				Value:   3,
+			{
+				Name:  "status",
+				Usage: "Prints the statistics, uptime, recent backups and error counts of the watcher running for a backup directory",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the status as a JSON object",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runStatus,
 			},
-			&cli.DurationFlag{
-				Name:    "interval",
-				Aliases: []string{"i"},
-				Usage:   "Interval between scans for changes",
-				Value:   5 * time.Second,
+			{
+				Name:  "control",
+				Usage: "Sends a command to the watcher running for a backup directory",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:   "pause",
+						Usage:  "Pauses backups, changes are collected and backed up on resume",
+						Action: runControl,
+					},
+					{
+						Name:   "resume",
+						Usage:  "Resumes backups and queues the files changed while paused",
+						Action: runControl,
+					},
+					{
+						Name:   "flush",
+						Usage:  "Waits until every queued backup is done and the watcher state is on disk",
+						Action: runControl,
+					},
+					{
+						Name:   "reload",
+						Usage:  "Reopens the audit log, e.g. after it was rotated",
+						Action: runControl,
+					},
+					{
+						Name:  "try-rules",
+						Usage: "Checks new ignore and include patterns alongside the ones in use, logging the changes they decide differently",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "ignore",
+								Usage: "Ignore patterns to try, replacing all ignore patterns (repeatable)",
+							},
+							&cli.StringSliceFlag{
+								Name:  "only",
+								Usage: "Include patterns to try, replacing all include patterns, '' for none (repeatable)",
+							},
+							&cli.DurationFlag{
+								Name:  "for",
+								Usage: "Promote the rules after this period, e.g. 24h, instead of with promote-rules",
+							},
+						},
+						Action: runControl,
+					},
+					{
+						Name:   "promote-rules",
+						Usage:  "Uses the rules tried instead of the rules in use",
+						Action: runControl,
+					},
+					{
+						Name:   "discard-rules",
+						Usage:  "Stops trying rules, keeping the rules in use",
+						Action: runControl,
+					},
+					{
+						Name:      "backup-now",
+						Usage:     "Backs up a file, or every file of a directory, right away",
+						ArgsUsage: "<path>",
+						Action:    runControl,
+					},
+					{
+						Name:  "stats",
+						Usage: "Prints the statistics of the watcher",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Print the statistics as a JSON object",
+							},
+							&cli.BoolFlag{
+								Name:  "history",
+								Usage: "Print the snapshots of the statistics kept, see --stats-history, oldest first",
+							},
+						},
+						Action: runControl,
+					},
+				},
+			},
+			{
+				Name:  "queue",
+				Usage: "Lists the backups pending in the queue journal of a backup directory and drops single ones",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "inspect",
+						Usage: "Lists the pending backups with their IDs, those being backed up are marked as running",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Print the jobs as a JSON array",
+							},
+						},
+						Action: runQueue,
+					},
+					{
+						Name:      "drop",
+						Usage:     "Removes a pending backup from the queue, e.g. one crashing the watcher every time it runs",
+						ArgsUsage: "<id>",
+						Action:    runQueue,
+					},
+				},
+			},
+			{
+				Name:  "snapshot",
+				Usage: "Packages the source directory, or the latest stored version of every file, into a timestamped tar.gz or zip archive",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "source",
+						Aliases: []string{"s"},
+						Usage:   "Directory to package",
+					},
+					&cli.StringFlag{
+						Name:    "backup",
+						Aliases: []string{"b"},
+						Usage:   "Directory where backups are stored, left out of a snapshot of the source when inside it",
+					},
+					&cli.BoolFlag{
+						Name:  "latest",
+						Usage: "Package the latest stored version of every file instead of the source directory",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Directory the archive is written to",
+						Value:   ".",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Archive format: tar.gz or zip",
+						Value: watcher.SnapshotTarGz,
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "Only package files matching these patterns, e.g. '*.docx,docs/*' (repeatable)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Leave out files and directories matching these patterns, e.g. '.git,*.log' (repeatable)",
+					},
+					&cli.StringFlag{
+						Name:  "index-key-file",
+						Usage: "File with the secret the version manifests were encrypted with",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runSnapshot,
+			},
+			{
+				Name:  "snapshot-diff",
+				Usage: "Lists the files that differ between two snapshots, or between a snapshot and the source directory, from their tree manifests",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "Tree manifest of the older snapshot, the .tree file next to its archive",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Tree manifest of the newer snapshot, instead of the source directory",
+					},
+					&cli.StringFlag{
+						Name:    "source",
+						Aliases: []string{"s"},
+						Usage:   "Directory the snapshot was made of, compared as it is now",
+					},
+					&cli.StringFlag{
+						Name:    "backup",
+						Aliases: []string{"b"},
+						Usage:   "Directory where backups are stored, left out of the source when inside it",
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "Only compare source files matching these patterns, as given to snapshot (repeatable)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Leave out source files and directories matching these patterns, as given to snapshot (repeatable)",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runSnapshotDiff,
+			},
+			{
+				Name:  "verify",
+				Usage: "Verifies stored versions against their checksums, continuing where the last verification stopped",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backup",
+						Aliases:  []string{"b"},
+						Usage:    "Directory where backups are stored",
+						Required: true,
+					},
+					&cli.Float64Flag{
+						Name:  "percent",
+						Usage: "Percentage of the stored versions to verify",
+						Value: 100,
+					},
+					&cli.StringFlag{
+						Name:  "index-key-file",
+						Usage: "File with the secret the version manifests were encrypted with",
+					},
+					langFlag(),
+					plainFlag(),
+					verboseFlag(),
+					quietFlag(),
+				},
+				Action: runVerify,
 			},
 		},
-		Action: runWatcher,
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(utils.ExitCode(err))
+	}
+}
+
+// watcherFlags returns the flags configuring how files are watched and backed up,
+// shared by the watcher and the backup command
+func watcherFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "source",
+			Aliases: []string{"s"},
+			Usage:   "Directory to monitor for changes",
+		},
+		&cli.StringFlag{
+			Name:    "backup",
+			Aliases: []string{"b"},
+			Usage:   "Directory to store backups",
+		},
+		&cli.IntFlag{
+			Name:    "versions",
+			Aliases: []string{"vers"},
+			Usage:   "Maximum number of versions to store per file",
+			Value:   3,
+		},
+		&cli.DurationFlag{
+			Name:    "interval",
+			Aliases: []string{"i"},
+			Usage:   "Interval between scans for changes",
+			Value:   5 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "panic-policy",
+			Usage: "What to do when a backup worker panics: restart, crash or stop",
+			Value: config.PanicRestart,
+		},
+		&cli.BoolFlag{
+			Name:  "drain-on-exit",
+			Usage: "Finish all queued backup jobs before exiting, instead of only the ones in progress",
+			Value: true,
+		},
+		&cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Usage: "Maximum time to wait for queued backups to finish on shutdown (0 waits forever)",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "File to append the outcome of every file event to, one JSON object per line",
+		},
+		&cli.BoolFlag{
+			Name:  "audit-permissions",
+			Usage: "Record changes of the permissions and owners of files in the audit log, without backing them up",
+		},
+		&cli.StringSliceFlag{
+			Name:  "mirror",
+			Usage: "Also copy every new version to this directory, e.g. a mounted remote file system, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "date-links",
+			Usage: "Also link the last version of each file per day into this directory as <YYYY-MM-DD>/<path>, versions are still stored in the backup directory, see --layout date to store them that way",
+		},
+		&cli.IntFlag{
+			Name:  "date-links-days",
+			Usage: "Days kept in the --date-links directory, older days are removed (0 keeps all)",
+		},
+		&cli.IntFlag{
+			Name:  "min-copies",
+			Usage: "Destinations, the backup directory and mirrors, that must store a version for its backup to succeed",
+			Value: 1,
+		},
+		&cli.StringSliceFlag{
+			Name:  "follow-links-into",
+			Usage: "Follow symbolic links in the source that point into this directory, watching and backing up its files under the link path, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-multiple",
+			Usage: "Start even when another watcher watches the source directory, only warning about it",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "Address to serve Prometheus metrics on at /metrics while watching, e.g. 127.0.0.1:9464",
+		},
+		&cli.StringFlag{
+			Name:  "web-addr",
+			Usage: "Address to serve a web UI on for browsing, downloading and restoring versions while watching, e.g. 127.0.0.1:8080",
+		},
+		&cli.StringFlag{
+			Name:  "web-token-file",
+			Usage: "File with the token the web UI asks for, given once as ?token= in its address",
+		},
+		&cli.StringFlag{
+			Name:  "grpc-addr",
+			Usage: "Address to serve the gRPC API on for managing the watcher remotely, e.g. 127.0.0.1:9470",
+		},
+		&cli.StringFlag{
+			Name:  "grpc-cert",
+			Usage: "TLS certificate of the gRPC API, PEM encoded, with --grpc-key",
+		},
+		&cli.StringFlag{
+			Name:  "grpc-key",
+			Usage: "Private key of the TLS certificate of the gRPC API, PEM encoded",
+		},
+		&cli.StringFlag{
+			Name:  "grpc-token-file",
+			Usage: "File with the bearer token gRPC clients must send",
+		},
+		&cli.IntFlag{
+			Name:  "stats-history",
+			Usage: "Statistics snapshots kept for control stats --history, one every 10 seconds (0 to keep none)",
+			Value: 360,
+		},
+		&cli.StringFlag{
+			Name:  "log-file",
+			Usage: "File to append the log to, without colors, besides printing it",
+		},
+		&cli.BoolFlag{
+			Name:  "foreground-summary",
+			Usage: "Print one updating status line instead of the log, e.g. in a tmux pane; the log only goes to --log-file",
+		},
+		&cli.StringFlag{
+			Name:  "record-trace",
+			Usage: "File to append every file event seen while watching to, for replaying with simulate",
+		},
+		&cli.Float64Flag{
+			Name:  "max-ops-per-sec",
+			Usage: "Maximum number of backups started per second across all workers (0 for unlimited)",
+		},
+		&cli.StringFlag{
+			Name:    "max-bytes-per-sec",
+			Aliases: []string{"bandwidth-limit"},
+			Usage:   "Maximum number of bytes written per second across all workers, e.g. 20MB (0 for unlimited), also passed to backup commands for their uploads",
+		},
+		&cli.StringFlag{
+			Name:  "max-read-per-sec",
+			Usage: "Maximum number of bytes read per second from each source file, e.g. 50MB (0 for unlimited)",
+		},
+		&cli.BoolFlag{
+			Name:  "drop-cache",
+			Usage: "Evict backed up files from the page cache, so backups do not push out the cache of other applications",
+		},
+		&cli.BoolFlag{
+			Name:  "reflink",
+			Usage: "Clone files copy-on-write on file systems that support it (Btrfs, XFS, APFS) instead of copying them",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "copy-streams",
+			Usage: "Back up alternate data streams (Windows) and resource forks (macOS) with each version",
+		},
+		&cli.BoolFlag{
+			Name:  "dedup",
+			Usage: "Store content already backed up, e.g. of copied or renamed files, once by hard linking versions",
+		},
+		&cli.BoolFlag{
+			Name:  "link-unchanged",
+			Usage: "Hard link a new version to the latest one when the file content did not change, instead of copying it",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-copies",
+			Usage: "Read the source and the new version again after copying, backing up files changed during the copy again later",
+		},
+		&cli.StringFlag{
+			Name:  "pre-backup-cmd",
+			Usage: "Shell command run before each backup, a non-zero exit skips the backup",
+		},
+		&cli.StringFlag{
+			Name:  "post-backup-cmd",
+			Usage: "Shell command run after each backup, with $BACKUP_SOURCE, $BACKUP_DEST and $EVENT_TYPE set",
+		},
+		&cli.DurationFlag{
+			Name:  "hook-timeout",
+			Usage: "Maximum run time of the pre and post backup commands",
+			Value: 30 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "job-timeout",
+			Usage: "Maximum run time of a backup job, a worker stuck longer is replaced (0 for none)",
+		},
+		&cli.IntFlag{
+			Name:  "job-retries",
+			Usage: "Times a failed backup is retried when the error may be temporary, e.g. a locked file",
+		},
+		&cli.DurationFlag{
+			Name:  "job-retry-delay",
+			Usage: "Delay before the first retry of a failed backup, doubled for each further retry",
+			Value: 5 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "lock-retries",
+			Usage: "Times the backup of a file locked by another process is deferred before it fails, 0 to back up locked files right away",
+			Value: 5,
+		},
+		&cli.DurationFlag{
+			Name:  "lock-delay",
+			Usage: "Delay before a deferred backup of a locked file is tried again, doubled for each further try",
+			Value: 30 * time.Second,
+		},
+		&cli.StringSliceFlag{
+			Name:  "ignore",
+			Usage: "Also ignore files and directories matching these patterns, e.g. 'node_modules,*.log' (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "only",
+			Usage: "Only back up files matching these patterns, e.g. '*.docx,*.md' (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "backup-on",
+			Usage: "Event types that trigger backups: CREATE, WRITE and CHMOD (repeatable)",
+			Value: cli.NewStringSlice("CREATE", "WRITE"),
+		},
+		&cli.StringFlag{
+			Name:  "empty-create",
+			Usage: "What to do with files created empty: backup, or skip them until they are written",
+			Value: config.EmptyBackup,
+		},
+		&cli.StringFlag{
+			Name:  "owner",
+			Usage: "Only back up files owned by this user (name or UID)",
+		},
+		&cli.StringFlag{
+			Name:  "group",
+			Usage: "Only back up files owned by this group (name or GID)",
+		},
+		&cli.StringFlag{
+			Name:  "policy",
+			Usage: "CEL expression over path, size, owner, group, mtime and event deciding whether a changed file is backed up, e.g. '!path.endsWith(\".tmp\") && size < 1073741824'",
+		},
+		&cli.BoolFlag{
+			Name:  "notify",
+			Usage: "Show a desktop notification when backups fail repeatedly or the queue drops jobs",
+		},
+		&cli.StringFlag{
+			Name:  "webhook-url",
+			Usage: "URL to POST backup created/failed, queue full and watcher error events to, in batches",
+		},
+		&cli.StringFlag{
+			Name:  "webhook-format",
+			Usage: "Payload format of the webhook: generic, slack or discord",
+			Value: "generic",
+		},
+		&cli.StringFlag{
+			Name:  "kafka-rest-url",
+			Usage: "Kafka REST Proxy URL to produce every file change and backup event to, keyed by path",
+		},
+		&cli.StringFlag{
+			Name:  "kafka-topic",
+			Usage: "Kafka topic of the events produced with --kafka-rest-url",
+			Value: "file-watcher-events",
+		},
+		&cli.StringFlag{
+			Name:  "external-tool",
+			Usage: "Also push backed up files into an existing repository of this tool, restic or borg, or replicate new versions with rsync",
+		},
+		&cli.StringFlag{
+			Name:  "external-repo",
+			Usage: "Repository of --external-tool, e.g. /srv/restic-repo or user@host:borg-repo, or the rsync target, e.g. host:/srv/backups",
+		},
+		&cli.DurationFlag{
+			Name:  "external-delay",
+			Usage: "Time backed up files are collected before they are pushed to --external-repo in one run",
+			Value: time.Minute,
+		},
+		&cli.StringSliceFlag{
+			Name:  "email-to",
+			Usage: "Send failure alert emails to this address, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "email-from",
+			Usage: "Sender address of alert emails",
+		},
+		&cli.StringFlag{
+			Name:  "smtp-server",
+			Usage: "SMTP server used to send alert emails, as host:port",
+		},
+		&cli.StringFlag{
+			Name:  "smtp-user",
+			Usage: "SMTP user, leave empty to send without authentication",
+		},
+		&cli.StringFlag{
+			Name:    "smtp-password",
+			Usage:   "SMTP password",
+			EnvVars: []string{"SMTP_PASSWORD"},
+		},
+		&cli.IntFlag{
+			Name:  "email-failure-threshold",
+			Usage: "Number of consecutive backup failures before an alert email is sent",
+			Value: 5,
+		},
+		&cli.DurationFlag{
+			Name:  "email-min-interval",
+			Usage: "Minimum time between two alert emails, alerts in between are summarized in the next one",
+			Value: 15 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "max-file-size",
+			Usage: "Skip files larger than this, e.g. 1GB (0 for unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "digest-at",
+			Usage: "Print a daily digest of backups, errors and space used at this time of day, e.g. 18:00",
+		},
+		&cli.IntFlag{
+			Name:  "digest-top",
+			Usage: "Most versioned files listed in the daily digest and the summary on shutdown",
+			Value: 5,
+		},
+		&cli.StringFlag{
+			Name:  "digest-file",
+			Usage: "File the daily digest and the summary on shutdown are appended to as JSON lines",
+		},
+		&cli.StringFlag{
+			Name:  "digest-webhook",
+			Usage: "URL the daily digest and the summary on shutdown are POSTed to, in --webhook-format",
+		},
+		&cli.StringFlag{
+			Name:  "verify-at",
+			Usage: "Verify a share of the stored versions against their checksums at this time of day, e.g. 03:00",
+		},
+		&cli.Float64Flag{
+			Name:  "verify-percent",
+			Usage: "Percentage of the stored versions verified each day, continuing where the last run stopped",
+			Value: 5,
+		},
+		&cli.DurationFlag{
+			Name:  "sweep-interval",
+			Usage: "Scan the whole source directory this often, e.g. 1h, to back up changes the file system watcher missed (0 to disable)",
+		},
+		&cli.BoolFlag{
+			Name:  "pause-on-source-removal",
+			Usage: "Hold queued backups while the source directory is removed, instead of failing them, until it is back",
+		},
+		&cli.StringFlag{
+			Name:  "large-file-size",
+			Usage: "Back up files of at least this size, e.g. 500MB, after the small ones queued with them (0 keeps the queue order)",
+		},
+		&cli.DurationFlag{
+			Name:  "large-file-max-delay",
+			Usage: "Maximum time a large file is held back while small files keep changing",
+			Value: 5 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "max-backup-size",
+			Usage: "Total size of all stored versions, e.g. 50GB, the oldest versions of any file are pruned beyond it (0 for unlimited)",
+		},
+		&cli.StringFlag{
+			Name:  "min-free-space",
+			Usage: "Free space to leave on the backup disk, e.g. 5GB (0 does not check)",
+		},
+		&cli.StringFlag{
+			Name:  "low-space-action",
+			Usage: "What to do when a backup would go below --min-free-space: pause, prune or alert",
+			Value: config.LowSpacePause,
+		},
+		&cli.StringFlag{
+			Name:  "layout",
+			Usage: "How versions are stored in the backup directory: file keeps every version in <path>_versions, date keeps the last version of each day as <YYYY-MM-DD>/<path>. A backup directory keeps the layout of its first versions",
+			Value: config.LayoutFile,
+		},
+		&cli.StringFlag{
+			Name:  "version-naming",
+			Usage: "How versions are named: microsecond, second, minute, hour, day, sequence or hash. Anything but microsecond keeps the full time only in the version manifest",
+			Value: config.NamingMicrosecond,
+		},
+		&cli.StringFlag{
+			Name:  "index-key-file",
+			Usage: "File with a secret used to encrypt the version manifests",
+		},
+		&cli.StringFlag{
+			Name:  "version-ids",
+			Usage: "Kind of stable IDs given to versions: ulid or uuid",
+			Value: config.IDULID,
+		},
+		&cli.IntFlag{
+			Name:  "exit-code-on-error",
+			Usage: "Exit status used when the watcher stops because of an error or its shutdown times out, a clean stop always exits with 0",
+			Value: 1,
+		},
+		langFlag(),
+		plainFlag(),
+		verboseFlag(),
+		quietFlag(),
+	}
+}
+
+// langFlag selects the language of the messages
+func langFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "lang",
+		Usage: "Language of the messages: en or pl (default: from LC_ALL, LC_MESSAGES or LANG)",
 	}
 }
 
+// verboseFlag prints debug messages as well
+func verboseFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "verbose",
+		Usage: "Print debug messages as well, e.g. every job queued",
+	}
+}
+
+// quietFlag prints only warnings and errors
+func quietFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "quiet",
+		Usage: "Print only warnings and errors",
+	}
+}
+
+// plainFlag selects the output for screen readers and dumb terminals
+func plainFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "plain",
+		Usage: "Print without colors, emoji and box drawing, with ERROR, WARN and INFO prefixes (default: when TERM is dumb)",
+	}
+}
+
+// newLogger creates a logger printing in the language given by --lang or the locale,
+// and in plain output when asked for by --plain or the terminal
+func newLogger(c *cli.Context, out io.Writer, colors, showTime bool) (*utils.Logger, error) {
+	logger := utils.NewLogger(out, colors, showTime)
+	logger.Lang = utils.DetectLanguage()
+	logger.Plain = c.Bool("plain") || os.Getenv("TERM") == "dumb"
+
+	if lang := c.String("lang"); lang != "" {
+		var err error
+		if logger.Lang, err = utils.ParseLanguage(lang); err != nil {
+			return nil, fmt.Errorf("invalid --lang: %w", err)
+		}
+	}
+	switch {
+	case c.Bool("verbose") && c.Bool("quiet"):
+		return nil, fmt.Errorf("--verbose and --quiet can not be combined")
+	case c.Bool("verbose"):
+		logger.Level = utils.LevelDebug
+	case c.Bool("quiet"):
+		logger.Level = utils.LevelWarning
+	}
+	if name, ok := c.App.Metadata["profile"].(string); ok {
+		logger.Prefix = "[" + name + "] "
+	}
+	return logger, nil
+}
+
 func runWatcher(c *cli.Context) error {
+	if c.String("profiles") != "" {
+		return runProfiles(c)
+	}
+
 	startTime := time.Now()
-	logger := utils.NewLogger(true, true)
+	logger, closeLog, err := watchLogger(c)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
 
-	source := c.String("source")
-	backup := c.String("backup")
-	versions := c.Int("versions")
-	interval := c.Duration("interval")
+	shutdownTimeout := c.Duration("shutdown-timeout")
+	exitCodeOnError := c.Int("exit-code-on-error")
 
-	if _, err := os.Stat(source); os.IsNotExist(err) {
-		return fmt.Errorf("source directory does not exist: %s", source)
+	cfg, err := configFromFlags(c)
+	if err != nil {
+		return err
 	}
 
-	if err := os.MkdirAll(backup, 0755); err != nil {
-		return fmt.Errorf("failed to create backup directory: %v", err)
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return fmt.Errorf(logger.Translate("failed to create backup directory: %v"), err)
 	}
 
-	cfg := config.NewConfig(source, backup, versions, interval)
-
-	fw, err := watcher.NewFileWatcher(cfg)
+	fw, err := watcher.NewFileWatcher(cfg, logger)
 	if err != nil {
-		return fmt.Errorf("failed to create file watcher: %v", err)
+		return fmt.Errorf(logger.Translate("failed to create file watcher: %v"), err)
 	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	pauseChan := make(chan os.Signal, 1)
+	if pauseSignal != nil {
+		signal.Notify(pauseChan, pauseSignal, resumeSignal)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if addr := c.String("metrics-addr"); addr != "" {
+		closeMetrics, err := serveMetrics(fw, addr)
+		if err != nil {
+			return fmt.Errorf(logger.Translate("failed to serve metrics: %v"), err)
+		}
+		defer closeMetrics()
+	}
+
+	if addr := c.String("web-addr"); addr != "" {
+		closeWeb, err := serveWeb(fw, cfg.SourceDir, addr, c.String("web-token-file"))
+		if err != nil {
+			return fmt.Errorf(logger.Translate("failed to serve the web UI: %v"), err)
+		}
+		defer closeWeb()
+		if c.String("web-token-file") == "" && !loopbackAddr(addr) {
+			logger.Warning("Web UI at http://%s, not on a loopback address and without a token anyone who can connect restores files", addr)
+		} else {
+			logger.Info("Web UI at http://%s", addr)
+		}
+	}
+
+	if addr := c.String("grpc-addr"); addr != "" {
+		if (c.String("grpc-cert") == "") != (c.String("grpc-key") == "") {
+			return cli.Exit(logger.Translate("--grpc-cert and --grpc-key must be given together"), 1)
+		}
+		closeGRPC, err := serveGRPC(ctx, fw, cfg.SourceDir, grpcOptions{
+			addr:      addr,
+			certFile:  c.String("grpc-cert"),
+			keyFile:   c.String("grpc-key"),
+			tokenFile: c.String("grpc-token-file"),
+		})
+		if err != nil {
+			return fmt.Errorf(logger.Translate("failed to serve the gRPC API: %v"), err)
+		}
+		defer closeGRPC()
+		if c.String("grpc-token-file") == "" {
+			logger.Warning("gRPC API at %s, without a token anyone who can connect manages the watcher", addr)
+		} else {
+			logger.Info("gRPC API at %s", addr)
+		}
+	}
+
+	if path := c.String("record-trace"); path != "" {
+		trace, err := watcher.NewTraceWriter(path)
+		if err != nil {
+			return err
+		}
+		defer trace.Close()
+		fw.OnEvent(trace.Record)
+	}
+
+	// Commands such as status still work without it, the watcher does not depend on it
+	if closeControl, err := serveControl(ctx, fw, cfg.BackupDir); err != nil {
+		logger.Warning("Could not open the control socket, the status and control commands are not available: %v", err)
+	} else {
+		defer closeControl()
+	}
+
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- fw.Start()
+		errChan <- fw.Start(ctx)
 	}()
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	// A nil channel never fires without --foreground-summary
+	var summary *summaryLine
+	var summaryC <-chan time.Time
+	if c.Bool("foreground-summary") {
+		summary = newSummaryLine(fw, os.Stdout, logger)
+		defer summary.end()
+		summaryTicker := time.NewTicker(summaryInterval)
+		defer summaryTicker.Stop()
+		summaryC = summaryTicker.C
+	}
+
+	// Keepalives come from this loop, a watcher stuck in it is restarted by systemd
+	sd := newSystemdNotifier()
+	ready := fw.Ready()
+	var watchdogC <-chan time.Time
+	if interval := sd.watchdogInterval(); interval > 0 {
+		watchdogTicker := time.NewTicker(interval)
+		defer watchdogTicker.Stop()
+		watchdogC = watchdogTicker.C
+	}
+
+	// A nil channel never fires when the digest is disabled
+	var digestC <-chan time.Time
+	if cfg.DigestAt != "" {
+		next, _ := watcher.NextDigest(cfg.DigestAt, time.Now())
+		digestTimer := time.NewTimer(time.Until(next))
+		defer digestTimer.Stop()
+		digestC = digestTimer.C
+	}
+
 	for {
 		select {
 		case <-sigChan:
-			ticker.Stop()
-			fw.Stop()
+			sd.stopping(fw.PendingJobs(), shutdownTimeout)
+			cancel()
+
+			if err := shutdown(fw, logger, sigChan, shutdownTimeout); err != nil {
+				return cli.Exit(err.Error(), exitCodeOnError)
+			}
+
+			reportDigest(fw, logger, fw.RunReport())
 
 			duration := time.Since(startTime)
 			logger.ShutdownComplete(duration)
-		
+
+			if err := fw.Err(); err != nil {
+				return cli.Exit(fmt.Sprintf(logger.Translate("watcher stopped after an error: %v"), err), exitCodeOnError)
+			}
+			return nil
+
 		case err := <-errChan:
-			return fmt.Errorf("error watcher: %w", err)
+			sd.stopping(fw.PendingJobs(), shutdownTimeout)
+			cancel()
+
+			// Still finish what was already queued before reporting the failure
+			if stopErr := shutdown(fw, logger, sigChan, shutdownTimeout); stopErr != nil {
+				logger.Error("%v", stopErr)
+			}
+			reportDigest(fw, logger, fw.RunReport())
+
+			if errors.Is(err, watcher.ErrSourceClaimed) {
+				return cli.Exit(fmt.Sprintf(logger.Translate("%v, --allow-multiple starts anyway"), err), exitCodeOnError)
+			}
+			return cli.Exit(fmt.Sprintf(logger.Translate("watcher failed: %v"), err), exitCodeOnError)
+
+		case <-ready:
+			ready = nil
+			if err := sd.notify("READY=1", systemdStatus(fw)); err != nil {
+				logger.Warning("Could not notify systemd: %v", err)
+			}
+
+		case <-watchdogC:
+			sd.notify("WATCHDOG=1")
+
+		case <-summaryC:
+			summary.draw()
+
+		case sig := <-pauseChan:
+			if sig == pauseSignal {
+				if !fw.Pause() {
+					logger.Info("Backups are already paused")
+				}
+				continue
+			}
+
+			if paused, _ := fw.Paused(); !paused {
+				logger.Info("Backups are not paused")
+				continue
+			}
+			// Queueing the collected changes waits for the workers
+			go func() {
+				if _, err := fw.Resume(ctx); err != nil && ctx.Err() == nil {
+					logger.Warning("Could not queue the files changed while paused: %v", err)
+				}
+			}()
+
+		case <-digestC:
+			// Posting to --digest-webhook may retry for a while
+			digest := fw.TakeDigest()
+			go reportDigest(fw, logger, digest)
+
+			next, _ := watcher.NextDigest(cfg.DigestAt, time.Now())
+			digestC = time.After(time.Until(next))
 
 		case <-ticker.C:
 			stats := fw.GetStats()
 			logger.Stats(
-				stats["tracked_files"].(int),
-				stats["queue_length"].(int),
-				stats["queue_capacity"].(int),
-				stats["active_workers"].(int),
+				stats.TrackedFiles,
+				stats.QueueLength,
+				stats.QueueCapacity,
+				stats.ActiveWorkers,
+				stats.RestartedWorkers,
+				stats.LostWorkers,
+				stats.StalledWorkers,
+				stats.TimedOutJobs,
+			)
+			logger.BackupTotals(
+				stats.BackupsSucceeded,
+				stats.BackupsFailed,
+				stats.JobsDropped,
+				stats.BytesWritten,
+				stats.AvgLatency,
 			)
+			logger.ThrottleStats(
+				stats.ThrottleOpsRate,
+				stats.ThrottleOpsLimit,
+				stats.ThrottleBytesRate,
+				stats.ThrottleBytesLimit,
+			)
+			if paused, since := fw.Paused(); paused {
+				logger.Warning("Backups paused for %s, %d files changed meanwhile",
+					time.Since(since).Round(time.Second), stats.PausedChanges)
+			}
+			if n := stats.DeadLetters; n > 0 {
+				logger.Warning("%d files could not be backed up, they are retried on their next change", n)
+			}
+			if n := stats.UnwatchedDirs; n > 0 {
+				logger.Warning("%d directories can not be watched and are polled instead", n)
+			}
+			if n := stats.RewatchDirs; n > 0 {
+				logger.Warning("%d directories could not be watched yet, they are tried again", n)
+			}
+			sd.notify(systemdStatus(fw))
 		}
 	}
-}
\ No newline at end of file
+}
+
+// configFromFlags builds and validates the configuration given by the watcher flags
+func configFromFlags(c *cli.Context) (*config.Config, error) {
+	cfg := config.NewConfig(c.String("source"), c.String("backup"), c.Int("versions"), c.Duration("interval"))
+	cfg.PanicPolicy = c.String("panic-policy")
+	cfg.DrainOnExit = c.Bool("drain-on-exit")
+	cfg.AuditLog = c.String("audit-log")
+	cfg.AuditPerms = c.Bool("audit-permissions")
+	cfg.MaxOpsPerSec = c.Float64("max-ops-per-sec")
+	cfg.PreBackupCmd = c.String("pre-backup-cmd")
+	cfg.PostBackupCmd = c.String("post-backup-cmd")
+	cfg.HookTimeout = c.Duration("hook-timeout")
+	cfg.JobTimeout = c.Duration("job-timeout")
+	cfg.JobRetries = c.Int("job-retries")
+	cfg.JobRetryDelay = c.Duration("job-retry-delay")
+	cfg.LockRetries = c.Int("lock-retries")
+	cfg.LockDelay = c.Duration("lock-delay")
+	cfg.IgnorePatterns = append(cfg.IgnorePatterns, c.StringSlice("ignore")...)
+	cfg.OnlyPatterns = c.StringSlice("only")
+	cfg.BackupOn = nil
+	for _, eventType := range c.StringSlice("backup-on") {
+		cfg.BackupOn = append(cfg.BackupOn, strings.ToUpper(eventType))
+	}
+	cfg.EmptyCreate = c.String("empty-create")
+	cfg.Owner = c.String("owner")
+	cfg.Group = c.String("group")
+	cfg.Policy = c.String("policy")
+	cfg.Notify = c.Bool("notify")
+	cfg.WebhookURL = c.String("webhook-url")
+	cfg.WebhookFormat = c.String("webhook-format")
+	cfg.KafkaURL = c.String("kafka-rest-url")
+	cfg.KafkaTopic = c.String("kafka-topic")
+	cfg.ExternalTool = c.String("external-tool")
+	cfg.ExternalRepo = c.String("external-repo")
+	cfg.ExternalDelay = c.Duration("external-delay")
+	cfg.VersionNaming = c.String("version-naming")
+	cfg.Layout = c.String("layout")
+	cfg.EmailTo = c.StringSlice("email-to")
+	cfg.EmailFrom = c.String("email-from")
+	cfg.SMTPServer = c.String("smtp-server")
+	cfg.SMTPUser = c.String("smtp-user")
+	cfg.SMTPPassword = c.String("smtp-password")
+	cfg.EmailThreshold = c.Int("email-failure-threshold")
+	cfg.EmailInterval = c.Duration("email-min-interval")
+	cfg.IndexKeyFile = c.String("index-key-file")
+	cfg.VersionIDs = c.String("version-ids")
+	cfg.Mirrors = c.StringSlice("mirror")
+	cfg.DateLinks = c.String("date-links")
+	cfg.DateLinksDays = c.Int("date-links-days")
+	cfg.FollowLinks = c.StringSlice("follow-links-into")
+	cfg.AllowMultiple = c.Bool("allow-multiple")
+	// Profiles may watch the same source, see checkProfileDirs
+	_, cfg.ShareSource = c.App.Metadata["profile"]
+	cfg.MinCopies = c.Int("min-copies")
+	cfg.StatsHistory = c.Int("stats-history")
+
+	maxBytesPerSec, err := utils.ParseSize(c.String("max-bytes-per-sec"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --max-bytes-per-sec: %v", err), 1)
+	}
+	cfg.MaxBytesPerSec = maxBytesPerSec
+
+	maxReadPerSec, err := utils.ParseSize(c.String("max-read-per-sec"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --max-read-per-sec: %v", err), 1)
+	}
+	cfg.MaxReadPerSec = maxReadPerSec
+	cfg.DropCache = c.Bool("drop-cache")
+	cfg.Reflink = c.Bool("reflink")
+	cfg.CopyStreams = c.Bool("copy-streams")
+	cfg.Dedup = c.Bool("dedup")
+	cfg.LinkUnchanged = c.Bool("link-unchanged")
+	cfg.VerifyCopies = c.Bool("verify-copies")
+
+	maxFileSize, err := utils.ParseSize(c.String("max-file-size"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --max-file-size: %v", err), 1)
+	}
+	cfg.MaxFileSize = maxFileSize
+
+	largeFileSize, err := utils.ParseSize(c.String("large-file-size"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --large-file-size: %v", err), 1)
+	}
+	cfg.LargeFileSize = largeFileSize
+	cfg.LargeFileDelay = c.Duration("large-file-max-delay")
+	cfg.SweepInterval = c.Duration("sweep-interval")
+	cfg.PauseOnRemoval = c.Bool("pause-on-source-removal")
+	cfg.DigestAt = c.String("digest-at")
+	cfg.DigestTop = c.Int("digest-top")
+	cfg.DigestFile = c.String("digest-file")
+	cfg.DigestWebhook = c.String("digest-webhook")
+	cfg.VerifyAt = c.String("verify-at")
+	cfg.VerifyPercent = c.Float64("verify-percent")
+
+	maxBackupSize, err := utils.ParseSize(c.String("max-backup-size"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --max-backup-size: %v", err), 1)
+	}
+	cfg.MaxBackupSize = maxBackupSize
+
+	minFreeSpace, err := utils.ParseSize(c.String("min-free-space"))
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("invalid --min-free-space: %v", err), 1)
+	}
+	cfg.MinFreeSpace = minFreeSpace
+	cfg.LowSpaceAction = c.String("low-space-action")
+
+	if err := cfg.Validate(); err != nil {
+		return nil, cli.Exit(err.Error(), 1)
+	}
+
+	return cfg, nil
+}
+
+// shutdown stops the watcher, giving up after timeout (0 waits forever).
+// A signal received while stopping terminates the process immediately.
+func shutdown(fw *watcher.FileWatcher, logger *utils.Logger, sigChan <-chan os.Signal, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- fw.Stop(ctx)
+	}()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			return fmt.Errorf("shutdown timed out after %s: %w", timeout, err)
+		}
+		return nil
+
+	case <-sigChan:
+		logger.ForcedExit(fw.PendingJobs())
+		if err := fw.FlushAudit(); err != nil {
+			logger.Error("Could not flush audit log: %v", err)
+		}
+		os.Exit(130)
+		return nil
+	}
+}
+
+// reportDigest prints a digest of the watcher and emits it to --digest-file
+// and --digest-webhook
+func reportDigest(fw *watcher.FileWatcher, logger *utils.Logger, digest watcher.Digest) {
+	var top []string
+	for _, file := range digest.TopFiles {
+		top = append(top, fmt.Sprintf(logger.Translate("%s (%d versions)"), file.Path, file.Versions))
+	}
+
+	title := "Daily digest"
+	if digest.Kind == watcher.DigestShutdown {
+		title = "Run summary"
+	}
+	logger.Digest(title, digest.Since, digest.BackedUp, digest.Failed, digest.BytesWritten, digest.RepositorySize, top)
+
+	if err := fw.EmitDigest(digest); err != nil {
+		logger.Warning("Could not emit the digest: %v", err)
+	}
+}
+
+func runBackup(c *cli.Context) error {
+	startTime := time.Now()
+	logger, err := newLogger(c, os.Stdout, true, true)
+	if err != nil {
+		return err
+	}
+	exitCodeOnError := c.Int("exit-code-on-error")
+
+	cfg, err := configFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return fmt.Errorf(logger.Translate("failed to create backup directory: %v"), err)
+	}
+
+	fw, err := watcher.NewFileWatcher(cfg, logger)
+	if err != nil {
+		return fmt.Errorf(logger.Translate("failed to create file watcher: %v"), err)
+	}
+
+	var backedUp, failed atomic.Int64
+	fw.OnOutcome(func(entry audit.Entry, err error) {
+		switch entry.Outcome {
+		case audit.OutcomeBackedUp:
+			backedUp.Add(1)
+		case audit.OutcomeFailed:
+			failed.Add(1)
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := fw.BackupAll(ctx)
+	logger.Success("Backup pass finished in %s: %d files, %d unchanged, %d backed up, %d failed",
+		time.Since(startTime).Round(time.Millisecond), summary.Scanned, summary.Unchanged, backedUp.Load(), failed.Load())
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return cli.Exit(logger.Translate("backup pass interrupted"), 130)
+	case err != nil:
+		return cli.Exit(fmt.Sprintf(logger.Translate("backup pass failed: %v"), err), exitCodeOnError)
+	case failed.Load() > 0:
+		return cli.Exit(fmt.Sprintf(logger.Translate("%d backups failed"), failed.Load()), exitCodeOnError)
+	}
+	return nil
+}
+
+func runRestore(c *cli.Context) error {
+	backup := c.String("backup")
+	file := filepath.Clean(c.String("file"))
+	target := c.String("target")
+
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("all") {
+		return runRestoreTree(c, logger)
+	}
+	if c.String("file") == "" {
+		return cli.Exit(logger.Translate("either --file or --all is required"), 1)
+	}
+
+	if target == "" {
+		if c.String("source") == "" {
+			return cli.Exit(logger.Translate("either --source or --target is required"), 1)
+		}
+		target = filepath.Join(c.String("source"), file)
+	}
+
+	bm := watcher.NewBackupManager(backup, 0, logger)
+	if _, err := bm.Restore(c.Context, file, c.String("version"), target, watcher.RestoreOptions{
+		ClearProtection: c.Bool("clear-protection"),
+		WithACLs:        c.Bool("with-acls"),
+		BackupInfo:      c.Bool("backup-info"),
+	}); err != nil {
+		return fmt.Errorf(logger.Translate("restore failed: %w"), err)
+	}
+
+	return nil
+}
+
+// runRestoreTree restores the whole tree as it was at --at
+func runRestoreTree(c *cli.Context, logger *utils.Logger) error {
+	if c.String("file") != "" || c.String("version") != "" {
+		return cli.Exit(logger.Translate("--all restores every file, --file and --version can not be used with it"), 1)
+	}
+
+	at, err := parseTime(c.String("at"))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf(logger.Translate("invalid --at: %v"), err), 1)
+	}
+	if at.IsZero() {
+		return cli.Exit(logger.Translate("--all requires --at"), 1)
+	}
+
+	target := c.String("target")
+	if target == "" {
+		target = c.String("source")
+	}
+	if target == "" {
+		return cli.Exit(logger.Translate("either --source or --target is required"), 1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bm := watcher.NewBackupManager(c.String("backup"), 0, logger)
+	summary, err := bm.RestoreTree(ctx, at, target, watcher.RestoreOptions{
+		ClearProtection: c.Bool("clear-protection"),
+		WithACLs:        c.Bool("with-acls"),
+		BackupInfo:      c.Bool("backup-info"),
+	})
+	if err == nil || summary.Restored+summary.Failed > 0 {
+		logger.Info("Restored %d files as of %s into %s, %d failed, %d left out with only newer versions",
+			summary.Restored, at.Format(time.DateTime), target, summary.Failed, summary.Newer)
+	}
+	if err != nil {
+		return fmt.Errorf(logger.Translate("restore failed: %w"), err)
+	}
+	return nil
+}
+
+func runVerify(c *cli.Context) error {
+	percent := c.Float64("percent")
+	if percent <= 0 || percent > 100 {
+		return cli.Exit(fmt.Sprintf("invalid --percent: %g, expected above 0 and at most 100", percent), 1)
+	}
+
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	bm := watcher.NewBackupManager(c.String("backup"), 0, logger)
+	if keyFile := c.String("index-key-file"); keyFile != "" {
+		key, err := watcher.LoadIndexKey(keyFile)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		bm.SetIndexKey(key)
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := bm.VerifySample(ctx, percent)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return cli.Exit(logger.Translate("verification interrupted"), 130)
+	case err != nil:
+		return cli.Exit(fmt.Sprintf(logger.Translate("verification failed: %v"), err), 1)
+	}
+
+	bm.LogVerifyResult(result, percent)
+	if result.Failed() {
+		return cli.Exit(fmt.Sprintf(logger.Translate("%d versions are corrupt or unreadable"),
+			len(result.Corrupt)+len(result.Unreadable)), 1)
+	}
+	return nil
+}
+
+func runList(c *cli.Context) error {
+	query := watcher.VersionQuery{
+		Prefix: filepath.ToSlash(c.String("prefix")),
+		Cursor: c.String("cursor"),
+		Limit:  c.Int("limit"),
+	}
+
+	var err error
+	if query.Since, err = parseTime(c.String("since")); err != nil {
+		return cli.Exit(fmt.Sprintf("invalid --since: %v", err), 1)
+	}
+	if query.Until, err = parseTime(c.String("until")); err != nil {
+		return cli.Exit(fmt.Sprintf("invalid --until: %v", err), 1)
+	}
+	if query.MinSize, err = utils.ParseSize(c.String("min-size")); err != nil {
+		return cli.Exit(fmt.Sprintf("invalid --min-size: %v", err), 1)
+	}
+	if query.MaxSize, err = utils.ParseSize(c.String("max-size")); err != nil {
+		return cli.Exit(fmt.Sprintf("invalid --max-size: %v", err), 1)
+	}
+
+	logger, err := newLogger(c, os.Stderr, false, false)
+	if err != nil {
+		return err
+	}
+
+	bm := watcher.NewBackupManager(c.String("backup"), 0, logger)
+	if keyFile := c.String("index-key-file"); keyFile != "" {
+		key, err := watcher.LoadIndexKey(keyFile)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		bm.SetIndexKey(key)
+	}
+	enc := json.NewEncoder(os.Stdout)
+
+	// Results are printed as they are found, nothing is buffered
+	next, err := bm.QueryVersions(query, func(v watcher.VersionInfo) error {
+		if c.Bool("json") {
+			return enc.Encode(v)
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", v.Path, v.Version, v.ID, v.Time.Format(time.DateTime), utils.FormatSize(v.Size))
+		if v.Origin != "" {
+			line += "\tsame as " + v.Origin
+		}
+		if v.MovedFrom != "" {
+			line += "\tmoved from " + v.MovedFrom
+		}
+		_, err := fmt.Println(line)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("list failed: %w", err)
+	}
+
+	if c.Bool("json") {
+		return enc.Encode(struct {
+			NextCursor string `json:"next_cursor"`
+		}{next})
+	}
+	if next != "" {
+		fmt.Fprintf(os.Stderr, "More versions available, continue with --cursor %s\n", next)
+	}
+	return nil
+}
+
+// parseTime accepts RFC 3339 timestamps, dates and local times such as
+// "2024-06-01 12:00", an empty string is the zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
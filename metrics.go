@@ -0,0 +1,52 @@
+package main
+
+// Prometheus metrics endpoint and the Grafana dashboard built on its metrics
+
+import (
+	_ "embed"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+// dashboard is the Grafana dashboard for the metrics of WriteMetrics. Its
+// version is raised whenever its panels or the metrics they use change.
+//
+//go:embed dashboards/grafana.json
+var dashboard []byte
+
+// metricsHandler serves the metrics of fw in the Prometheus text format
+func metricsHandler(fw *watcher.FileWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fw.WriteMetrics(w)
+	}
+}
+
+// serveMetrics serves the metrics of fw on addr until the returned function is called
+func serveMetrics(fw *watcher.FileWatcher, addr string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metricsHandler(fw))
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+
+	return func() { srv.Close() }, nil
+}
+
+func runGenDashboard(c *cli.Context) error {
+	if _, err := os.Stdout.Write(dashboard); err != nil {
+		return fmt.Errorf("error writing dashboard: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Desktop shows desktop notifications when backups keep failing or the
+// queue drops jobs. Single failures are not reported, only a run of
+// consecutive failures reaching the threshold, and at most one notification
+// is shown per cooldown period to avoid flooding the desktop.
+type Desktop struct {
+	trigger *trigger
+
+	queue chan Event
+	done  chan struct{}
+}
+
+// NewDesktop creates a desktop notifier, threshold below 1 notifies on every failure
+func NewDesktop(threshold int, cooldown time.Duration) *Desktop {
+	d := &Desktop{
+		trigger: newTrigger(threshold, cooldown),
+		queue:   make(chan Event, 16),
+		done:    make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Publish implements EventSink
+func (d *Desktop) Publish(event Event) {
+	if !d.trigger.fire(&event) {
+		return
+	}
+
+	select {
+	case d.queue <- event:
+	default:
+		// Notifications are best effort, never block a worker
+	}
+}
+
+// Close implements EventSink, it waits for queued notifications to be shown
+func (d *Desktop) Close() error {
+	close(d.queue)
+	<-d.done
+	return nil
+}
+
+func (d *Desktop) run() {
+	defer close(d.done)
+
+	for event := range d.queue {
+		title := "File Watcher Backup"
+		switch event.Type {
+		case EventBackupFailed:
+			title = "Backups are failing"
+		case EventQueueFull:
+			title = "Backup queue full, changes skipped"
+		case EventWatcherError:
+			title = "File watcher error"
+		case EventWatcherClosed:
+			title = "File watcher stopped"
+		case EventDiskLow:
+			title = "Backup disk almost full"
+		case EventWorkerStalled:
+			title = "Backup worker stalled"
+		case EventSourceRemoved:
+			title = "Watched directory removed"
+		case EventVerifyFailed:
+			title = "Backup verification failed"
+		}
+
+		// There is nobody to report a failing notifier to but the log, which is already noisy
+		_ = showDesktopNotification(title, event.Message)
+	}
+}
+
+// showDesktopNotification uses the notification tool of the platform
+func showDesktopNotification(title, body string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", "--app-name=file-watcher-backup", "--urgency=critical", title, body)
+
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+
+	case "windows":
+		// Passed in the environment, PowerShell takes typographic quotes for
+		// quotes too, so no literal is safe for any text
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript)
+		cmd.Env = append(os.Environ(), "FWB_TOAST_TITLE="+title, "FWB_TOAST_BODY="+body)
+
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+
+	return cmd.Run()
+}
+
+// windowsToastScript shows a toast notification with the built-in WinRT API,
+// the title and body are read from the environment
+const windowsToastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:FWB_TOAST_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:FWB_TOAST_BODY)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('file-watcher-backup').Show($toast)
+`
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
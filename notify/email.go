@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// EmailOptions configures email alerts
+type EmailOptions struct {
+	Server           string        // SMTP server as host:port, STARTTLS is used when offered
+	Username         string        // SMTP user, empty to send without authentication
+	Password         string        // SMTP password
+	From             string        // Sender address
+	To               []string      // Recipient addresses
+	FailureThreshold int           // Consecutive backup failures before alerting
+	MinInterval      time.Duration // Minimum time between two emails
+}
+
+// Email sends alerts over SMTP for sustained failure conditions: a number of
+// consecutive backup failures, a full destination, dropped jobs and watcher
+// errors. Emails are rate limited, alerts within MinInterval of the previous
+// email are only counted in the next one.
+type Email struct {
+	opts    EmailOptions
+	auth    smtp.Auth
+	trigger *trigger
+	logger  *utils.Logger
+
+	queue chan Event
+	done  chan struct{}
+}
+
+// NewEmail creates an email notifier
+func NewEmail(opts EmailOptions, logger *utils.Logger) (*Email, error) {
+	host, _, err := net.SplitHostPort(opts.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP server, expected host:port: %s", opts.Server)
+	}
+	if opts.From == "" || len(opts.To) == 0 {
+		return nil, fmt.Errorf("email alerts need a sender and at least one recipient")
+	}
+
+	e := &Email{
+		opts:    opts,
+		trigger: newTrigger(opts.FailureThreshold, opts.MinInterval),
+		logger:  logger,
+		queue:   make(chan Event, 16),
+		done:    make(chan struct{}),
+	}
+	if opts.Username != "" {
+		e.auth = smtp.PlainAuth("", opts.Username, opts.Password, host)
+	}
+
+	go e.run()
+	return e, nil
+}
+
+// Publish implements EventSink
+func (e *Email) Publish(event Event) {
+	if !e.trigger.fire(&event) {
+		return
+	}
+
+	select {
+	case e.queue <- event:
+	default:
+		// Rate limiting makes this nearly impossible, but never block a worker
+	}
+}
+
+// Close implements EventSink, it sends the alerts still queued before returning
+func (e *Email) Close() error {
+	close(e.queue)
+	<-e.done
+	return nil
+}
+
+func (e *Email) run() {
+	defer close(e.done)
+
+	for event := range e.queue {
+		if err := smtp.SendMail(e.opts.Server, e.auth, e.opts.From, e.opts.To, e.message(event)); err != nil {
+			e.logger.Error("Could not send alert email: %v", err)
+		}
+	}
+}
+
+// message renders an alert as an RFC 5322 message
+func (e *Email) message(event Event) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", e.opts.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.opts.To, ", "))
+	fmt.Fprintf(&b, "Subject: [file-watcher-backup] %s\r\n", event.Type)
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", event.Message)
+	fmt.Fprintf(&b, "Time: %s\r\n", event.Time.Format(time.RFC3339))
+	if event.Path != "" {
+		fmt.Fprintf(&b, "File: %s\r\n", event.Path)
+	}
+	if event.Error != "" {
+		fmt.Fprintf(&b, "Error kind: %s\r\n", event.Error)
+	}
+
+	return []byte(b.String())
+}
@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Tools External pushes with
+const (
+	ToolRestic = "restic" // Source files into a restic repository
+	ToolBorg   = "borg"   // Source files into a borg repository
+	ToolRsync  = "rsync"  // Version directories to an rsync target
+)
+
+// externalQueueSize is the number of files waiting to be batched, newer ones
+// are dropped beyond it
+const externalQueueSize = 10000
+
+// External pushes backed up files into an existing restic or borg repository
+// by running the tool, so its snapshots follow changes as they happen instead
+// of a scheduled full scan. With rsync it replicates the version directories
+// of new versions to a target instead, many small changes in one transfer.
+// Files are collected for a delay and pushed in one run, a snapshot (restic)
+// or archive (borg). A failed run keeps its files for the next one. The tool
+// reads its password from its usual environment variables, e.g.
+// RESTIC_PASSWORD_FILE, BORG_PASSCOMMAND or RSYNC_PASSWORD.
+type External struct {
+	tool    string
+	repo    string // Repository, or target of rsync
+	base    string // Backup directory the paths sent to rsync are relative to
+	delay   time.Duration
+	logger  *utils.Logger
+	dropped atomic.Int64
+
+	queue chan string
+	done  chan struct{}
+}
+
+// NewExternal creates a sink pushing to the repository repo of tool every
+// delay. backupDir is the backup directory rsync replicates.
+func NewExternal(tool, repo, backupDir string, delay time.Duration, logger *utils.Logger) (*External, error) {
+	switch tool {
+	case ToolRestic, ToolBorg, ToolRsync:
+	default:
+		return nil, fmt.Errorf("invalid external backup tool: %s", tool)
+	}
+	if repo == "" {
+		return nil, fmt.Errorf("a %s repository is required", tool)
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("%s not found: %w", tool, err)
+	}
+
+	e := &External{
+		tool:   tool,
+		repo:   repo,
+		base:   backupDir,
+		delay:  delay,
+		logger: logger,
+		queue:  make(chan string, externalQueueSize),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Publish implements EventSink, only new versions are pushed
+func (e *External) Publish(event Event) {
+	path := event.Path
+	if e.tool == ToolRsync {
+		path = event.Backup
+	}
+	if event.Type != EventBackupCreated || path == "" {
+		return
+	}
+
+	select {
+	case e.queue <- path:
+	default:
+		// The tool is too slow, never block a worker
+		e.dropped.Add(1)
+	}
+}
+
+// Close implements EventSink, it pushes the files still pending before returning
+func (e *External) Close() error {
+	close(e.queue)
+	<-e.done
+	return nil
+}
+
+func (e *External) run() {
+	defer close(e.done)
+
+	pending := make(map[string]struct{})
+	timer := time.NewTimer(e.delay)
+	timer.Stop()
+
+	push := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if n := e.dropped.Swap(0); n > 0 {
+			e.logger.Warning("%s: dropped %d files, they are pushed with their next change", e.tool, n)
+		}
+
+		paths := make([]string, 0, len(pending))
+		for path := range pending {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		if err := e.push(paths); err != nil {
+			e.logger.Error("%s: could not push %d files, trying again with the next ones: %v", e.tool, len(paths), err)
+			return
+		}
+		e.logger.Info("%s: pushed %d files to %s", e.tool, len(paths), e.repo)
+		clear(pending)
+	}
+
+	for {
+		select {
+		case path, ok := <-e.queue:
+			if !ok {
+				push()
+				return
+			}
+
+			if len(pending) == 0 {
+				timer.Reset(e.delay)
+			}
+			pending[path] = struct{}{}
+
+		case <-timer.C:
+			push()
+			if len(pending) > 0 {
+				timer.Reset(e.delay)
+			}
+		}
+	}
+}
+
+// push runs the tool once for paths. Files removed since they were backed up
+// are left out, the tools fail on them.
+func (e *External) push(paths []string) error {
+	if e.tool == ToolRsync {
+		return e.rsync(paths)
+	}
+
+	var list bytes.Buffer
+	for _, path := range paths {
+		// Snapshots store the paths they were given
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if _, err := os.Lstat(path); err == nil {
+			list.WriteString(path)
+			list.WriteByte('\n')
+		}
+	}
+	if list.Len() == 0 {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	switch e.tool {
+	case ToolRestic:
+		listFile, err := os.CreateTemp("", "fwbackup-restic-*.txt")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(listFile.Name())
+		if _, err := listFile.Write(list.Bytes()); err != nil {
+			listFile.Close()
+			return err
+		}
+		if err := listFile.Close(); err != nil {
+			return err
+		}
+		cmd = exec.Command(e.tool, "--repo", e.repo, "backup", "--tag", "file-watcher-backup",
+			"--files-from-verbatim", listFile.Name())
+
+	case ToolBorg:
+		cmd = exec.Command(e.tool, "create", "--paths-from-stdin",
+			e.repo+"::file-watcher-backup-{now:%Y-%m-%dT%H:%M:%S.%f}")
+		cmd.Stdin = &list
+	}
+
+	return runTool(cmd)
+}
+
+// rsync copies the version directories of the versions at paths to the
+// target. Whole directories are synced so their manifests come along, rsync
+// only transfers what the target misses. Versions removed by retention stay.
+func (e *External) rsync(paths []string) error {
+	dirs := make(map[string]struct{})
+	for _, path := range paths {
+		rel, err := filepath.Rel(e.base, filepath.Dir(path))
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Dir(path)); err == nil {
+			dirs[filepath.ToSlash(rel)] = struct{}{}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	var list bytes.Buffer
+	for dir := range dirs {
+		list.WriteString(dir)
+		list.WriteByte(0)
+	}
+
+	cmd := exec.Command(e.tool, "--archive", "--recursive", "--from0", "--files-from=-",
+		e.base+string(filepath.Separator), e.repo)
+	cmd.Stdin = &list
+	return runTool(cmd)
+}
+
+// runTool runs cmd, failing with the last line of its output
+func runTool(cmd *exec.Cmd) error {
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// The last line usually says what went wrong
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+const (
+	kafkaBatchSize  = 500              // Records sent in one request at most
+	kafkaBatchDelay = time.Second      // Maximum time an event waits for others to join its batch
+	kafkaQueueSize  = 10000            // Events waiting to be sent, newer ones are dropped beyond it
+	kafkaRetries    = 6                // Attempts per record
+	kafkaRetryDelay = time.Second      // First delay between attempts, doubled every time
+	kafkaTimeout    = 30 * time.Second // Timeout of one request
+)
+
+// Content types of the Kafka REST Proxy v2 API
+const (
+	kafkaContentType = "application/vnd.kafka.json.v2+json"
+	kafkaAccept      = "application/vnd.kafka.v2+json"
+)
+
+// kafkaRecord is a record of a produce request. Records with the same key go
+// to the same partition, so the events of a file stay in order.
+type kafkaRecord struct {
+	Key   string `json:"key,omitempty"`
+	Value Event  `json:"value"`
+}
+
+// kafkaOffset is the outcome of one record in a produce response
+type kafkaOffset struct {
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+	ErrorCode *int   `json:"error_code"` // 1 for errors that are final, 2 for ones worth retrying
+	Error     string `json:"error"`
+}
+
+// Kafka produces events to a Kafka topic through a Kafka REST Proxy. Records
+// are keyed by the path of their file and sent in batches. Delivery is at
+// least once: records the brokers did not acknowledge are retried with
+// exponential backoff, so a retried request may store a record twice.
+// Events are dropped rather than blocking the watcher when the proxy can not
+// keep up, the number dropped is logged.
+type Kafka struct {
+	url     string
+	client  *http.Client
+	logger  *utils.Logger
+	dropped atomic.Int64
+
+	ctx    context.Context // Canceled when Close gives up waiting, see closeTimeout
+	cancel context.CancelFunc
+	queue  chan Event
+	done   chan struct{}
+}
+
+// NewKafka creates a sink producing to topic through the REST Proxy at proxyURL
+func NewKafka(proxyURL, topic string, logger *utils.Logger) (*Kafka, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Kafka REST Proxy URL: %s", proxyURL)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("a Kafka topic is required")
+	}
+
+	k := &Kafka{
+		url:    strings.TrimSuffix(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: kafkaTimeout},
+		logger: logger,
+		queue:  make(chan Event, kafkaQueueSize),
+		done:   make(chan struct{}),
+	}
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	go k.run()
+	return k, nil
+}
+
+// Publish implements EventSink
+func (k *Kafka) Publish(event Event) {
+	select {
+	case k.queue <- event:
+	default:
+		// The proxy is too slow or down, never block a worker
+		k.dropped.Add(1)
+	}
+}
+
+// WantsChanges implements ChangeSink, the audit pipeline wants every change
+func (k *Kafka) WantsChanges() bool {
+	return true
+}
+
+// Close implements EventSink, it sends the events still queued before
+// returning, for at most closeTimeout
+func (k *Kafka) Close() error {
+	close(k.queue)
+	waitClosed(k.done, k.cancel)
+	return nil
+}
+
+func (k *Kafka) run() {
+	defer close(k.done)
+
+	var batch []kafkaRecord
+	timer := time.NewTimer(kafkaBatchDelay)
+	timer.Stop()
+
+	flush := func() {
+		if n := k.dropped.Swap(0); n > 0 {
+			k.logger.Warning("Kafka: dropped %d events, the queue was full", n)
+		}
+		if len(batch) > 0 {
+			k.send(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-k.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(kafkaBatchDelay)
+			}
+			batch = append(batch, kafkaRecord{Key: event.Path, Value: event})
+
+			if len(batch) >= kafkaBatchSize {
+				timer.Stop()
+				flush()
+			}
+
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// send produces one batch, retrying the records that failed with a retryable error
+func (k *Kafka) send(batch []kafkaRecord) {
+	delay := kafkaRetryDelay
+	for attempt := 1; ; attempt++ {
+		failed, retry, err := k.produce(batch)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt == kafkaRetries || !sleep(k.ctx, delay) {
+			k.logger.Error("Kafka: dropped %d events after %d attempts: %v", len(failed), attempt, err)
+			return
+		}
+
+		batch = failed
+		delay *= 2
+	}
+}
+
+// produce sends records once. It returns the records that were not stored
+// and whether sending them again is worth it.
+func (k *Kafka) produce(records []kafkaRecord) ([]kafkaRecord, bool, error) {
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": records})
+	if err != nil {
+		return records, false, fmt.Errorf("could not encode events: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(k.ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return records, false, err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", kafkaAccept)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return records, true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return records, true, fmt.Errorf("Kafka REST Proxy returned %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return records, false, fmt.Errorf("Kafka REST Proxy returned %s", resp.Status)
+	}
+
+	var reply struct {
+		Offsets []kafkaOffset `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil || len(reply.Offsets) != len(records) {
+		// Accepted, but which records were stored is unknown, sending them again may duplicate them
+		return records, true, fmt.Errorf("unexpected reply of the Kafka REST Proxy")
+	}
+
+	var failed []kafkaRecord
+	retry := true
+	var lastErr string
+	for i, offset := range reply.Offsets {
+		if offset.ErrorCode == nil {
+			continue
+		}
+		failed = append(failed, records[i])
+		lastErr = offset.Error
+		if *offset.ErrorCode != 2 {
+			retry = false
+		}
+	}
+	if len(failed) > 0 {
+		return failed, retry, fmt.Errorf("%d records were not stored: %s", len(failed), lastErr)
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,78 @@
+// Package notify delivers watcher events to people and external systems,
+// such as desktop notifications. Every destination implements EventSink.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// Event types published by the watcher
+const (
+	EventBackupCreated = "backup_created" // A new version was stored
+	EventBackupFailed  = "backup_failed"  // A backup job failed
+	EventQueueFull     = "queue_full"     // A backup job was dropped because the queue was full
+	EventWatcherError  = "watcher_error"  // The file system watcher reported an error
+	EventWatcherClosed = "watcher_closed" // The file system watcher stopped delivering events
+	EventDiskLow       = "disk_low"       // Free space on the backup file system fell below the minimum
+	EventWorkerStalled = "worker_stalled" // A worker was stuck in a job past its timeout and was replaced
+	EventSourceRemoved = "source_removed" // The watched source directory was removed or moved away
+	EventVerifyFailed  = "verify_failed"  // Stored versions were found corrupt or unreadable
+	EventFileChanged   = "file_changed"   // A watched file changed, only sent to a ChangeSink
+)
+
+// Event is something that happened in the watcher that may be worth telling someone about
+type Event struct {
+	Type    string    `json:"type"`             // One of the Event* constants
+	Time    time.Time `json:"time"`             // When it happened
+	Path    string    `json:"path,omitempty"`   // Source file concerned, if any
+	Backup  string    `json:"backup,omitempty"` // Stored version, for EventBackupCreated
+	Message string    `json:"message"`          // Human readable description
+	Error   string    `json:"error,omitempty"`  // Error kind label, see utils.ErrorLabel
+}
+
+// EventSink receives watcher events. Publish is called from worker goroutines,
+// it must be safe for concurrent use and must never block the caller for long,
+// sinks doing I/O should queue events and deliver them in the background.
+type EventSink interface {
+	Publish(event Event)
+	Close() error
+}
+
+// ChangeSink is an EventSink that also wants an EventFileChanged for every
+// change seen by the watcher, e.g. to feed an audit pipeline. Other sinks
+// only get the outcomes worth telling someone about.
+type ChangeSink interface {
+	EventSink
+	WantsChanges() bool
+}
+
+// closeTimeout is how long Close waits for queued events to be delivered,
+// requests and retries still running then are abandoned
+const closeTimeout = 10 * time.Second
+
+// waitClosed waits for the delivery goroutine to finish with done, calling
+// cancel to abandon its requests once closeTimeout passed
+func waitClosed(done <-chan struct{}, cancel context.CancelFunc) {
+	defer cancel()
+
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		cancel()
+		<-done
+	}
+}
+
+// sleep waits for d, it returns false early when ctx ends
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// trigger decides which events are worth interrupting someone for: a run of
+// consecutive failures reaching the threshold, a full destination, queue drops,
+// stalled workers and watcher errors. At most one alert passes per cooldown, the others are
+// counted and reported with the next alert.
+type trigger struct {
+	threshold int           // Consecutive failures before alerting
+	cooldown  time.Duration // Minimum time between two alerts
+
+	mu         sync.Mutex
+	failures   int       // Consecutive failed backups
+	last       time.Time // When the last alert passed
+	suppressed int       // Alerts held back by the cooldown since the last one
+}
+
+func newTrigger(threshold int, cooldown time.Duration) *trigger {
+	return &trigger{
+		threshold: max(threshold, 1),
+		cooldown:  cooldown,
+	}
+}
+
+// fire reports whether event should raise an alert. The message of event
+// is updated to describe the condition, including suppressed alerts.
+func (t *trigger) fire(event *Event) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch event.Type {
+	case EventBackupCreated:
+		t.failures = 0
+		return false
+
+	case EventBackupFailed:
+		t.failures++
+		switch {
+		case event.Error == "destination_full":
+			event.Message = "backup destination is full: " + event.Message
+		case t.failures >= t.threshold:
+			event.Message = fmt.Sprintf("%d backups failed in a row, last: %s", t.failures, event.Message)
+		default:
+			return false
+		}
+
+	case EventQueueFull, EventWatcherError, EventWatcherClosed, EventDiskLow, EventWorkerStalled, EventSourceRemoved,
+		EventVerifyFailed:
+
+	default:
+		return false
+	}
+
+	if time.Since(t.last) < t.cooldown {
+		t.suppressed++
+		return false
+	}
+
+	if t.suppressed > 0 {
+		event.Message = fmt.Sprintf("%s (%d more alerts suppressed)", event.Message, t.suppressed)
+	}
+	t.last = time.Now()
+	t.suppressed = 0
+	return true
+}
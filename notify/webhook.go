@@ -0,0 +1,244 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Webhook payload formats
+const (
+	FormatGeneric = "generic" // {"events": [...]} with the Event fields
+	FormatSlack   = "slack"   // Slack incoming webhook message
+	FormatDiscord = "discord" // Discord webhook message
+)
+
+const (
+	webhookBatchSize  = 50               // Events sent in one request at most
+	webhookBatchDelay = 5 * time.Second  // Maximum time an event waits for others to join its batch
+	webhookQueueSize  = 1000             // Events waiting to be sent, newer ones are dropped beyond it
+	webhookRetries    = 4                // Attempts per batch
+	webhookRetryDelay = time.Second      // First delay between attempts, doubled every time
+	webhookTimeout    = 10 * time.Second // Timeout of one request
+	discordMaxLength  = 2000             // Discord rejects longer messages
+)
+
+// Webhook POSTs events as JSON to a URL. Events are batched so a burst of
+// failures produces a single request, and failed requests are retried with
+// exponential backoff. A batch that still fails is dropped and logged.
+type Webhook struct {
+	url    string
+	format string
+	client *http.Client
+	logger *utils.Logger
+
+	ctx    context.Context // Canceled when Close gives up waiting, see closeTimeout
+	cancel context.CancelFunc
+	queue  chan Event
+	done   chan struct{}
+}
+
+// NewWebhook creates a webhook notifier posting to rawURL in the given format
+func NewWebhook(rawURL, format string, logger *utils.Logger) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL: %s", rawURL)
+	}
+
+	switch format {
+	case FormatGeneric, FormatSlack, FormatDiscord:
+	default:
+		return nil, fmt.Errorf("invalid webhook format: %s", format)
+	}
+
+	w := &Webhook{
+		url:    rawURL,
+		format: format,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+		queue:  make(chan Event, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+	return w, nil
+}
+
+// Publish implements EventSink
+func (w *Webhook) Publish(event Event) {
+	select {
+	case w.queue <- event:
+	default:
+		// The endpoint is too slow or down, never block a worker
+	}
+}
+
+// Close implements EventSink, it sends the events still queued before
+// returning, for at most closeTimeout
+func (w *Webhook) Close() error {
+	close(w.queue)
+	waitClosed(w.done, w.cancel)
+	return nil
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+
+	var batch []Event
+	timer := time.NewTimer(webhookBatchDelay)
+	timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			w.send(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(webhookBatchDelay)
+			}
+			batch = append(batch, event)
+
+			if len(batch) >= webhookBatchSize {
+				timer.Stop()
+				flush()
+			}
+
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// send posts one batch, retrying server errors and network failures
+func (w *Webhook) send(batch []Event) {
+	body, err := w.payload(batch)
+	if err != nil {
+		w.logger.Error("Webhook: could not encode events: %v", err)
+		return
+	}
+
+	if attempts, err := postRetrying(w.ctx, w.client, w.url, body); err != nil {
+		w.logger.Error("Webhook: dropped %d events after %d attempts: %v", len(batch), attempts, err)
+	}
+}
+
+// PostReport posts a report to rawURL: as JSON in the generic format, as
+// the chat message text in the others. Failures are retried like batches
+// of events.
+func PostReport(rawURL, format string, report any, text string) error {
+	var body []byte
+	var err error
+	switch format {
+	case FormatSlack:
+		body, err = json.Marshal(map[string]string{"text": text})
+	case FormatDiscord:
+		body, err = json.Marshal(map[string]string{"content": truncate(text, discordMaxLength)})
+	default:
+		body, err = json.Marshal(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	if attempts, err := postRetrying(context.Background(), client, rawURL, body); err != nil {
+		return fmt.Errorf("failed after %d attempts: %w", attempts, err)
+	}
+	return nil
+}
+
+// postRetrying sends body, retrying server errors and network failures
+// until ctx ends. It returns the number of attempts made.
+func postRetrying(ctx context.Context, client *http.Client, rawURL string, body []byte) (int, error) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := post(ctx, client, rawURL, body)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if !retry || attempt == webhookRetries || !sleep(ctx, delay) {
+			return attempt, err
+		}
+		delay *= 2
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying
+func post(ctx context.Context, client *http.Client, rawURL string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// payload encodes a batch in the configured format
+func (w *Webhook) payload(batch []Event) ([]byte, error) {
+	switch w.format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": summary(batch, 0)})
+	case FormatDiscord:
+		return json.Marshal(map[string]string{"content": summary(batch, discordMaxLength)})
+	default:
+		return json.Marshal(map[string][]Event{"events": batch})
+	}
+}
+
+// summary renders events as chat message lines, cut to maxLen characters if it is not 0
+func summary(batch []Event, maxLen int) string {
+	var b strings.Builder
+	for i, event := range batch {
+		line := fmt.Sprintf("[%s] %s: %s\n", event.Time.Format(time.DateTime), event.Type, event.Message)
+
+		if maxLen > 0 && b.Len()+len(line) > maxLen {
+			more := fmt.Sprintf("… and %d more events", len(batch)-i)
+			if b.Len()+len(more) <= maxLen {
+				b.WriteString(more)
+			}
+			break
+		}
+		b.WriteString(line)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// truncate cuts text to maxLen bytes, ending it with an ellipsis when cut
+func truncate(text string, maxLen int) string {
+	if len(text) <= maxLen {
+		return text
+	}
+	return strings.ToValidUTF8(text[:maxLen-len("…")], "") + "…"
+}
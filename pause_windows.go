@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// pauseSignal and resumeSignal are nil, Windows has no user signals
+var pauseSignal, resumeSignal os.Signal
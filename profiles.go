@@ -0,0 +1,262 @@
+package main
+
+// Profiles run several watchers in one process, each with its own source,
+// backup directory and options. The profile file is JSON: every profile is a
+// set of command-line options by their long name, with defaults shared by
+// all profiles.
+//
+//	{
+//	  "defaults": {"interval": "10s", "versions": 5},
+//	  "profiles": {
+//	    "docs":   {"source": "/home/me/Documents", "backup": "/srv/backup/docs", "only": ["*.docx", "*.md"]},
+//	    "photos": {"source": "/home/me/Pictures", "backup": "/srv/backup/photos", "versions": 2}
+//	  }
+//	}
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// profileFile is the file given with --profiles
+type profileFile struct {
+	Defaults map[string]interface{}            `json:"defaults"`
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+}
+
+// profile is a named watcher of a profile file
+type profile struct {
+	name   string
+	args   []string // Command-line options of the watcher
+	source string   // Absolute path of the source directory, once checked
+	backup string   // Absolute path of the backup directory, once checked
+}
+
+// loadProfiles reads a profile file and returns its profiles sorted by name
+func loadProfiles(path string) ([]profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profiles: %w", err)
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing profiles %s: %w", path, err)
+	}
+	if len(file.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles in %s", path)
+	}
+
+	var profiles []profile
+	for name, options := range file.Profiles {
+		if name == "" {
+			return nil, fmt.Errorf("profile without a name in %s", path)
+		}
+
+		// Options of the profile replace the defaults, lists included
+		merged := make(map[string]interface{}, len(file.Defaults)+len(options))
+		for key, value := range file.Defaults {
+			merged[key] = value
+		}
+		for key, value := range options {
+			merged[key] = value
+		}
+
+		args, err := profileArgs(merged)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		profiles = append(profiles, profile{name: name, args: args})
+	}
+
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].name < profiles[j].name })
+	return profiles, nil
+}
+
+// profileArgs turns options into command-line arguments, lists into an
+// option repeated for each element
+func profileArgs(options map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, key := range keys {
+		if key == "profiles" {
+			return nil, fmt.Errorf("profiles can not be nested")
+		}
+
+		values, ok := options[key].([]interface{})
+		if !ok {
+			values = []interface{}{options[key]}
+		}
+		for _, value := range values {
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				s = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("invalid value of %s: %v", key, value)
+			}
+			// With "=" values starting with a dash are not taken for options
+			args = append(args, "--"+key+"="+s)
+		}
+	}
+	return args, nil
+}
+
+// profileSharedFlags are the flags that may be given next to --profiles,
+// they apply to every profile
+var profileSharedFlags = []string{"profiles", "lang", "plain", "verbose", "quiet"}
+
+// runProfiles runs the watchers of the profiles in the file given with
+// --profiles until all of them stopped. A signal stops every watcher, each
+// with its own control socket, statistics and log lines prefixed with its name.
+func runProfiles(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, true)
+	if err != nil {
+		return err
+	}
+
+	// Everything else belongs in the profiles, mixing both would be ambiguous
+	for _, flag := range c.App.Flags {
+		name := flag.Names()[0]
+		if c.IsSet(name) && !slices.Contains(profileSharedFlags, name) {
+			return cli.Exit(fmt.Sprintf(logger.Translate("--%s can not be combined with --profiles, set it in the profiles"), name), 1)
+		}
+	}
+
+	profiles, err := loadProfiles(c.String("profiles"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	var shared []string
+	if c.IsSet("lang") {
+		shared = append(shared, "--lang="+c.String("lang"))
+	}
+	for _, name := range []string{"plain", "verbose", "quiet"} {
+		if c.IsSet(name) {
+			shared = append(shared, "--"+name+"="+strconv.FormatBool(c.Bool(name)))
+		}
+	}
+
+	// Every configuration is checked before any watcher starts
+	for i := range profiles {
+		p := &profiles[i]
+		p.args = append(append([]string{c.App.Name}, shared...), p.args...)
+
+		check := profileApp(c, p.name, func(pc *cli.Context) error {
+			if pc.Bool("foreground-summary") {
+				return errors.New(logger.Translate("--foreground-summary can not be used in a profile, the status lines would overwrite each other"))
+			}
+			cfg, err := configFromFlags(pc)
+			if err != nil {
+				return err
+			}
+			if p.source, err = filepath.Abs(cfg.SourceDir); err != nil {
+				return err
+			}
+			p.backup, err = filepath.Abs(cfg.BackupDir)
+			return err
+		})
+		if err := check.Run(p.args); err != nil {
+			return cli.Exit(fmt.Sprintf(logger.Translate("profile %s: %v"), p.name, err), 1)
+		}
+	}
+	if err := checkProfileDirs(profiles, logger); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	logger.Info("Running %d profiles", len(profiles))
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(profiles))
+	for i, p := range profiles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = profileApp(c, p.name, runWatcher).Run(p.args)
+			if errs[i] != nil {
+				logger.Error("Profile %s stopped: %v", p.name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkProfileDirs checks the directories of the profiles against each other.
+// Profiles may watch the same source, or one inside another, e.g. to keep
+// documents and photos of one directory apart: no profile takes precedence,
+// each backs up the files it matches to its own backup directory, and a file
+// matched by both is backed up twice. That is only warned about. A backup
+// directory shared by two profiles, or inside the source of another profile,
+// is an error: the other watcher would back up the backups.
+func checkProfileDirs(profiles []profile, logger *utils.Logger) error {
+	for i, p := range profiles {
+		for _, other := range profiles[i+1:] {
+			switch {
+			case p.backup == other.backup:
+				return fmt.Errorf(logger.Translate("profiles %s and %s use the same backup directory"), p.name, other.name)
+			case p.source == other.source:
+				logger.Warning("Profiles %s and %s watch the same source directory, files matched by both are backed up by both", p.name, other.name)
+			case isWithin(other.source, p.source):
+				logger.Warning("The source of profile %s is inside the source of %s, files matched by both are backed up by both", other.name, p.name)
+			case isWithin(p.source, other.source):
+				logger.Warning("The source of profile %s is inside the source of %s, files matched by both are backed up by both", p.name, other.name)
+			}
+		}
+		for _, other := range profiles {
+			if other.name != p.name && isWithin(p.backup, other.source) {
+				return fmt.Errorf(logger.Translate("the backup directory of profile %s is inside the source of profile %s"), p.name, other.name)
+			}
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether the absolute path is dir or inside it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// profileApp returns an app running action with the options of the watcher
+// of a profile. Its errors are returned instead of exiting the process.
+func profileApp(c *cli.Context, name string, action cli.ActionFunc) *cli.App {
+	return &cli.App{
+		Name:           c.App.Name,
+		Flags:          watcherFlags(),
+		Action:         action,
+		Metadata:       map[string]interface{}{"profile": name},
+		HideHelp:       true,
+		ExitErrHandler: func(*cli.Context, error) {},
+		OnUsageError: func(_ *cli.Context, err error, _ bool) error {
+			return err
+		},
+	}
+}
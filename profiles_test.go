@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+func TestCheckProfileDirs(t *testing.T) {
+	root := t.TempDir()
+	dir := func(name string) string { return filepath.Join(root, name) }
+
+	tests := []struct {
+		name     string
+		profiles []profile
+		warning  string // Part of the warning logged, none when empty
+		err      string // Part of the error returned, none when empty
+	}{
+		{
+			name: "apart",
+			profiles: []profile{
+				{name: "docs", source: dir("docs"), backup: dir("bak/docs")},
+				{name: "photos", source: dir("photos"), backup: dir("bak/photos")},
+			},
+		},
+		{
+			name: "same source",
+			profiles: []profile{
+				{name: "docs", source: dir("home"), backup: dir("bak/docs")},
+				{name: "photos", source: dir("home"), backup: dir("bak/photos")},
+			},
+			warning: "Profiles docs and photos watch the same source directory",
+		},
+		{
+			name: "nested source",
+			profiles: []profile{
+				{name: "all", source: dir("home"), backup: dir("bak/all")},
+				{name: "docs", source: dir("home/docs"), backup: dir("bak/docs")},
+			},
+			warning: "The source of profile docs is inside the source of all",
+		},
+		{
+			name: "similar names are not nested",
+			profiles: []profile{
+				{name: "a", source: dir("home"), backup: dir("bak/a")},
+				{name: "b", source: dir("home2"), backup: dir("bak/b")},
+			},
+		},
+		{
+			name: "same backup",
+			profiles: []profile{
+				{name: "docs", source: dir("docs"), backup: dir("bak")},
+				{name: "photos", source: dir("photos"), backup: dir("bak")},
+			},
+			err: "profiles docs and photos use the same backup directory",
+		},
+		{
+			name: "backup inside another source",
+			profiles: []profile{
+				{name: "all", source: dir("home"), backup: dir("bak/all")},
+				{name: "docs", source: dir("docs"), backup: dir("home/bak")},
+			},
+			err: "the backup directory of profile docs is inside the source of profile all",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := checkProfileDirs(tt.profiles, utils.NewLogger(&out, false, false))
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if tt.warning == "" && out.Len() > 0 {
+				t.Errorf("unexpected warning: %s", out.String())
+			}
+			if !strings.Contains(out.String(), tt.warning) {
+				t.Errorf("warning %q, want %q", out.String(), tt.warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+// The queue command lists the jobs pending in the queue journal of a backup
+// directory and drops single jobs, e.g. one that crashes the watcher every
+// time it runs and would be queued again at every start. With a watcher
+// running it is asked over its control socket, otherwise the journal is read
+// and written directly.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+// runQueue runs one of the subcommands of the queue command
+func runQueue(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	backupDir := c.String("backup")
+	if _, err := os.Stat(backupDir); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	running := watcherRunning(backupDir)
+
+	switch c.Command.Name {
+	case "inspect":
+		var jobs []watcher.QueuedJob
+		if running {
+			if err := controlCall(c, logger, http.MethodGet, "/queue", &jobs); err != nil {
+				return err
+			}
+		} else if jobs, err = watcher.ReadQueue(backupDir); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		if c.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(jobs)
+		}
+		printQueue(jobs, logger)
+
+	case "drop":
+		if c.NArg() != 1 {
+			return cli.Exit(logger.Translate("drop needs the ID of a job, see queue inspect"), 1)
+		}
+		id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf(logger.Translate("invalid job ID: %s"), c.Args().First()), 1)
+		}
+
+		if running {
+			var reply controlReply
+			endpoint := "/queue/drop?id=" + strconv.FormatUint(id, 10)
+			if err := controlCall(c, logger, http.MethodPost, endpoint, &reply); err != nil {
+				return err
+			}
+		} else if err := watcher.DropQueued(backupDir, id); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		logger.Success("Job %d dropped, it is not backed up", id)
+	}
+	return nil
+}
+
+// printQueue prints a line per queued job
+func printQueue(jobs []watcher.QueuedJob, logger *utils.Logger) {
+	tr := logger.Translate
+	if len(jobs) == 0 {
+		fmt.Println(tr("No jobs are queued"))
+		return
+	}
+
+	for _, job := range jobs {
+		line := fmt.Sprintf("%d  %s  %-6s  %8s  %s", job.ID, job.Time.Local().Format(time.DateTime),
+			job.Event, utils.FormatSize(job.Size), job.Path)
+		if job.Running {
+			line += "  " + tr("(running)")
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+func runSimulate(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := configFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(c.String("trace"))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf(logger.Translate("could not open the trace: %v"), err), 1)
+	}
+	events, err := watcher.ReadTrace(file)
+	file.Close()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	result := watcher.Simulate(cfg, events, c.Duration("job-duration"))
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	var span time.Duration
+	if len(events) > 0 {
+		span = events[len(events)-1].Time.Sub(events[0].Time)
+	}
+	logger.Info("Replayed %d events over %s, %d removals, renames and permission changes left out",
+		result.Events, span.Round(time.Second), result.Other)
+	logger.Info("Backed up %d times (%d files), %d ignored, %d skipped as too soon, %d skipped as too large, %d skipped by the policy, %d dropped",
+		result.Outcomes[audit.OutcomeBackedUp], result.Files, result.Outcomes[watcher.OutcomeIgnored],
+		result.Outcomes[audit.OutcomeSkippedInterval], result.Outcomes[audit.OutcomeSkippedTooLarge],
+		result.Outcomes[audit.OutcomeSkippedPolicy], result.Outcomes[audit.OutcomeDropped])
+	logger.Info("Queue: at most %d jobs, longest wait %s, workers busy %s",
+		result.MaxQueue, result.MaxWait.Round(time.Millisecond), result.Busy.Round(time.Millisecond))
+
+	printHits(logger, "Ignore pattern hits:", result.Ignores)
+	printHits(logger, "Include pattern hits:", result.Includes)
+
+	if len(result.Changed) > 0 {
+		changes := make([]string, 0, len(result.Changed))
+		for change := range result.Changed {
+			changes = append(changes, change)
+		}
+		sort.Strings(changes)
+
+		logger.Warning("Outcomes differing from the audit log:")
+		for _, change := range changes {
+			fmt.Printf("  %-40s %d\n", change, result.Changed[change])
+		}
+	}
+	return nil
+}
+
+// printHits prints the hit counts of patterns on one line, most hits first
+func printHits(logger *utils.Logger, title string, hits map[string]int) {
+	if len(hits) == 0 {
+		return
+	}
+
+	patterns := make([]string, 0, len(hits))
+	for pattern := range hits {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if hits[patterns[i]] != hits[patterns[j]] {
+			return hits[patterns[i]] > hits[patterns[j]]
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	counts := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		counts[i] = fmt.Sprintf("%s %d", pattern, hits[pattern])
+	}
+	logger.Info("%s %s", logger.Translate(title), strings.Join(counts, ", "))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+func runSnapshot(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	format := c.String("format")
+	if format != watcher.SnapshotTarGz && format != watcher.SnapshotZip {
+		return cli.Exit(fmt.Sprintf(logger.Translate("invalid --format: %s, expected tar.gz or zip"), format), 1)
+	}
+
+	dir := c.String("source")
+	if c.Bool("latest") {
+		dir = c.String("backup")
+	}
+	if dir == "" {
+		return cli.Exit(logger.Translate("--source is required, or --backup with --latest"), 1)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(dir)
+	if c.Bool("latest") {
+		name += "-latest"
+	}
+	name = fmt.Sprintf("%s_%s.%s", name, time.Now().Format("20060102_150405"), format)
+
+	output := c.String("output")
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf(logger.Translate("error creating output directory: %w"), err)
+	}
+
+	// Written under a temporary name, an interrupted snapshot never looks complete
+	file, err := os.CreateTemp(output, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf(logger.Translate("error creating snapshot: %w"), err)
+	}
+	defer os.Remove(file.Name())
+
+	treeName := strings.TrimSuffix(name, "."+format) + ".tree"
+	tree, err := os.CreateTemp(output, "."+treeName+".*")
+	if err != nil {
+		file.Close()
+		return fmt.Errorf(logger.Translate("error creating snapshot: %w"), err)
+	}
+	defer os.Remove(tree.Name())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := watcher.SnapshotOptions{
+		Format:  format,
+		Include: c.StringSlice("include"),
+		Exclude: c.StringSlice("exclude"),
+		Tree:    tree,
+	}
+
+	var summary watcher.SnapshotSummary
+	if c.Bool("latest") {
+		bm := watcher.NewBackupManager(dir, 0, logger)
+		if keyFile := c.String("index-key-file"); keyFile != "" {
+			key, err := watcher.LoadIndexKey(keyFile)
+			if err != nil {
+				file.Close()
+				tree.Close()
+				return cli.Exit(err.Error(), 1)
+			}
+			bm.SetIndexKey(key)
+		}
+		summary, err = bm.SnapshotLatest(ctx, file, opts)
+	} else {
+		// Neither the backups nor the archive itself belong in a snapshot of the source
+		opts.Skip = []string{file.Name(), tree.Name()}
+		if backup := c.String("backup"); backup != "" {
+			opts.Skip = append(opts.Skip, backup)
+		}
+		summary, err = watcher.SnapshotSource(ctx, dir, file, opts)
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = tree.Sync()
+	}
+	if closeErr := tree.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf(logger.Translate("snapshot failed: %w"), err)
+	}
+
+	// The manifest comes first, a complete archive always has one
+	if err := os.Rename(tree.Name(), filepath.Join(output, treeName)); err != nil {
+		return fmt.Errorf(logger.Translate("snapshot failed: %w"), err)
+	}
+	target := filepath.Join(output, name)
+	if err := os.Rename(file.Name(), target); err != nil {
+		return fmt.Errorf(logger.Translate("snapshot failed: %w"), err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	logger.Success("Snapshot %s: %d files, %s, %s compressed",
+		target, summary.Files, utils.FormatSize(summary.Bytes), utils.FormatSize(info.Size()))
+	return nil
+}
+
+func runSnapshotDiff(c *cli.Context) error {
+	logger, err := newLogger(c, os.Stdout, true, false)
+	if err != nil {
+		return err
+	}
+
+	from, err := watcher.ReadTreeFile(c.String("from"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	var to *watcher.Tree
+	switch {
+	case c.String("to") != "":
+		if to, err = watcher.ReadTreeFile(c.String("to")); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	case c.String("source") != "":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		opts := watcher.SnapshotOptions{
+			Include: c.StringSlice("include"),
+			Exclude: c.StringSlice("exclude"),
+		}
+		if backup := c.String("backup"); backup != "" {
+			opts.Skip = []string{backup}
+		}
+		// Only files changed by size or modification time are read
+		if to, err = watcher.SourceTree(ctx, c.String("source"), opts, from); err != nil {
+			return err
+		}
+	default:
+		return cli.Exit(logger.Translate("either --to or --source is required"), 1)
+	}
+
+	changes := watcher.CompareTrees(from, to)
+	if len(changes) == 0 {
+		logger.Info("No differences")
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", treeChangeMarks[change.Kind], change.Path)
+		counts[change.Kind]++
+	}
+	logger.Info("%d added, %d modified, %d deleted",
+		counts[watcher.TreeAdded], counts[watcher.TreeModified], counts[watcher.TreeDeleted])
+	return nil
+}
+
+// treeChangeMarks prefix the files listed by snapshot-diff, like git status --short
+var treeChangeMarks = map[string]string{
+	watcher.TreeAdded:    "A",
+	watcher.TreeModified: "M",
+	watcher.TreeDeleted:  "D",
+}
@@ -0,0 +1,142 @@
+package main
+
+// The status line of --foreground-summary. Instead of scrolling through a
+// line per event, the terminal shows a single line redrawn in place, for a
+// watcher sharing a tmux window with other tools. The log goes to
+// --log-file only.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+	"github.com/urfave/cli/v2"
+)
+
+// summaryInterval is how often the status line is redrawn
+const summaryInterval = 250 * time.Millisecond
+
+// summaryWindow is the time the event rate of the status line is counted over
+const summaryWindow = time.Minute
+
+var (
+	spinnerFrames      = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	plainSpinnerFrames = []string{"|", "/", "-", "\\"}
+)
+
+// watchLogger creates the logger of the watcher. It prints to the terminal
+// and appends to --log-file; with --foreground-summary it only writes to the
+// file. The returned function closes the file.
+func watchLogger(c *cli.Context) (*utils.Logger, func(), error) {
+	var file *os.File
+	if path := c.String("log-file"); path != "" {
+		var err error
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed to open the log file: %w", err)
+		}
+	}
+	closeLog := func() {
+		if file != nil {
+			file.Close()
+		}
+	}
+
+	var logger *utils.Logger
+	var err error
+	switch {
+	case !c.Bool("foreground-summary"):
+		logger, err = newLogger(c, os.Stdout, true, true)
+		if err == nil && file != nil {
+			logger.Tee = file
+		}
+	case file != nil:
+		logger, err = newLogger(c, file, false, true)
+	default:
+		logger, err = newLogger(c, io.Discard, false, true)
+	}
+	if err != nil {
+		closeLog()
+		return nil, nil, err
+	}
+	return logger, closeLog, nil
+}
+
+// eventSample is the number of events seen up to a time
+type eventSample struct {
+	time   time.Time
+	events int
+}
+
+// summaryLine draws the status line of a watcher
+type summaryLine struct {
+	fw      *watcher.FileWatcher
+	out     io.Writer
+	logger  *utils.Logger // For the language and --plain
+	frame   int
+	samples []eventSample // Within summaryWindow, oldest first
+}
+
+func newSummaryLine(fw *watcher.FileWatcher, out io.Writer, logger *utils.Logger) *summaryLine {
+	return &summaryLine{fw: fw, out: out, logger: logger}
+}
+
+// draw replaces the status line with the current state
+func (s *summaryLine) draw() {
+	fmt.Fprint(s.out, "\r\033[K"+s.line())
+}
+
+// end draws the status line a last time and moves below it
+func (s *summaryLine) end() {
+	s.draw()
+	fmt.Fprintln(s.out)
+}
+
+// line returns the status line, e.g. "⠋ 12 events/min · queue 0/100 · last backup notes.txt 3s ago"
+func (s *summaryLine) line() string {
+	tr := s.logger.Translate
+	status := s.fw.Status()
+	now := time.Now()
+
+	// Events over the last minute, or since the start when it is shorter
+	events := 0
+	for _, n := range status.Stats.EventsObserved {
+		events += n
+	}
+	s.samples = append(s.samples, eventSample{time: now, events: events})
+	for len(s.samples) > 1 && now.Sub(s.samples[0].time) > summaryWindow {
+		s.samples = s.samples[1:]
+	}
+	perMinute := events - s.samples[0].events
+
+	frames := spinnerFrames
+	separator := " · "
+	if s.logger.Plain {
+		frames = plainSpinnerFrames
+		separator = " | "
+	}
+	s.frame = (s.frame + 1) % len(frames)
+
+	parts := []string{
+		fmt.Sprintf(tr("%d events/min"), perMinute),
+		fmt.Sprintf(tr("queue %d/%d"), status.Stats.QueueLength, status.Stats.QueueCapacity),
+	}
+	if len(status.Recent) > 0 {
+		last := status.Recent[0]
+		parts = append(parts, fmt.Sprintf(tr("last backup %s %s ago"),
+			filepath.Base(last.Path), now.Sub(last.Time).Round(time.Second)))
+	} else {
+		parts = append(parts, tr("no backup yet"))
+	}
+	if n := status.Stats.BackupsFailed; n > 0 {
+		parts = append(parts, fmt.Sprintf(tr("%d failed"), n))
+	}
+	if status.Paused {
+		parts = append(parts, tr("paused"))
+	}
+	return frames[s.frame] + " " + strings.Join(parts, separator)
+}
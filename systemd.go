@@ -0,0 +1,93 @@
+package main
+
+// Notifications to systemd for services of Type=notify, see sd_notify(3).
+// The watcher reports when it is ready, its queue as the status shown by
+// systemctl status, when it is stopping, and keepalives for WatchdogSec=.
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/watcher"
+)
+
+// systemdNotifier sends state changes to the service manager. A nil
+// *systemdNotifier, when not run by systemd, discards them.
+type systemdNotifier struct {
+	socket   string        // NOTIFY_SOCKET, a leading @ names an abstract socket
+	watchdog time.Duration // WatchdogSec= of the service, 0 when disabled
+}
+
+// newSystemdNotifier returns a notifier when the process was started by
+// systemd with a notification socket, nil otherwise. The variables are
+// removed from the environment, so backup commands do not notify in its place.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	usec := os.Getenv("WATCHDOG_USEC")
+	pid := os.Getenv("WATCHDOG_PID")
+	os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+
+	if socket == "" {
+		return nil
+	}
+
+	sd := &systemdNotifier{socket: socket}
+	// The watchdog may be meant for another process, e.g. a wrapper script
+	if n, err := strconv.ParseInt(usec, 10, 64); err == nil && n > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		sd.watchdog = time.Duration(n) * time.Microsecond
+	}
+	return sd
+}
+
+// notify sends state assignments such as "READY=1" in one datagram
+func (sd *systemdNotifier) notify(states ...string) error {
+	if sd == nil {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sd.socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("error connecting to the systemd notification socket: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// watchdogInterval returns how often keepalives are sent, half of WatchdogSec=
+// as systemd recommends, 0 when the watchdog is disabled
+func (sd *systemdNotifier) watchdogInterval() time.Duration {
+	if sd == nil {
+		return 0
+	}
+	return sd.watchdog / 2
+}
+
+// stopping tells systemd the watcher is shutting down. timeout is the
+// --shutdown-timeout, systemd waits that long before killing the watcher
+// instead of its own TimeoutStopSec=.
+func (sd *systemdNotifier) stopping(pending int, timeout time.Duration) error {
+	states := []string{"STOPPING=1", fmt.Sprintf("STATUS=Stopping, %d backups pending", pending)}
+	if timeout > 0 {
+		states = append(states, fmt.Sprintf("EXTEND_TIMEOUT_USEC=%d", timeout.Microseconds()))
+	}
+	return sd.notify(states...)
+}
+
+// systemdStatus returns the STATUS= line of a running watcher
+func systemdStatus(fw *watcher.FileWatcher) string {
+	status := fw.Status()
+	if status.Paused {
+		return fmt.Sprintf("STATUS=Backups paused for %s", time.Since(status.PausedSince).Round(time.Second))
+	}
+	return fmt.Sprintf("STATUS=Queue: %d of %d, %d backed up, %d failed",
+		status.Stats.QueueLength, status.Stats.QueueCapacity,
+		status.Outcomes["backed_up"], status.Outcomes["failed"])
+}
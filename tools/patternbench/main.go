@@ -0,0 +1,93 @@
+// Command patternbench measures the ignore patterns on large rule sets.
+//
+// It generates file events and a mix of name, extension, prefix and wildcard
+// patterns, replays the events through watcher.Simulate, which checks every
+// event against the compiled patterns, and times the same checks parsing
+// every pattern with filepath.Match as the watcher used to.
+//
+//	go run ./tools/patternbench -rules 1000 -events 100000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+	"github.com/cpprian/file-watcher-backup/watcher"
+)
+
+func main() {
+	rules := flag.Int("rules", 1000, "Number of ignore patterns")
+	events := flag.Int("events", 100000, "Number of file events replayed")
+	flag.Parse()
+
+	patterns := make([]string, *rules)
+	for i := range patterns {
+		switch i % 5 {
+		case 0, 1:
+			patterns[i] = fmt.Sprintf("*.ext%d", i)
+		case 2:
+			patterns[i] = fmt.Sprintf("name%d", i)
+		case 3:
+			patterns[i] = fmt.Sprintf("prefix%d*", i)
+		default:
+			patterns[i] = fmt.Sprintf("[ab]cache%d?", i)
+		}
+	}
+
+	source := filepath.Join(string(filepath.Separator), "patternbench", "source")
+	start := time.Now()
+	trace := make([]watcher.TraceEvent, *events)
+	for i := range trace {
+		// Every tenth file matches a pattern, the others are checked against all of them
+		name := fmt.Sprintf("file%d.txt", i)
+		if i%10 == 0 {
+			name = fmt.Sprintf("file%d.ext%d", i, (i/10)%*rules/5*5)
+		}
+		trace[i] = watcher.TraceEvent{
+			Time:  start.Add(time.Duration(i) * time.Millisecond),
+			Event: "WRITE",
+			Path:  filepath.Join(source, fmt.Sprintf("dir%d", i%100), name),
+			Size:  1,
+		}
+	}
+
+	cfg := config.NewConfig(source, filepath.Join(string(filepath.Separator), "patternbench", "backup"), 3, time.Second)
+	cfg.IgnorePatterns = patterns
+
+	start = time.Now()
+	result := watcher.Simulate(cfg, trace, time.Millisecond)
+	compiled := time.Since(start)
+
+	start = time.Now()
+	var ignored int
+	for _, event := range trace {
+		if parsedIgnore(patterns, event.Path) {
+			ignored++
+		}
+	}
+	parsed := time.Since(start)
+
+	fmt.Printf("%d patterns, %d events, %d ignored\n", *rules, *events, result.Outcomes[watcher.OutcomeIgnored])
+	fmt.Printf("compiled: %s (%s per event, including the rest of the simulation)\n",
+		compiled.Round(time.Millisecond), compiled/time.Duration(*events))
+	fmt.Printf("parsed:   %s (%s per event, %d ignored)\n",
+		parsed.Round(time.Millisecond), parsed/time.Duration(*events), ignored)
+}
+
+// parsedIgnore is the check of the watcher before patterns were compiled
+func parsedIgnore(patterns []string, path string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, base); matched {
+			return true
+		}
+		if strings.Contains(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
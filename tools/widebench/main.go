@@ -0,0 +1,105 @@
+// Command widebench measures the watcher on a very shallow, very wide tree,
+// like a maildir or a photo dump: one directory holding a huge number of files.
+//
+// It creates the files in a temporary directory, starts the watcher through
+// the fwbackup package and reports how fast a burst of modifications is
+// backed up, including version retention.
+//
+//	go run ./tools/widebench -files 500000 -changes 5000
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/fwbackup"
+)
+
+func main() {
+	files := flag.Int("files", 100000, "Number of files in the flat source directory")
+	changes := flag.Int("changes", 2000, "Number of modifications made while watching")
+	versions := flag.Int("versions", 3, "Versions kept per file")
+	keep := flag.Bool("keep", false, "Keep the temporary directory")
+	flag.Parse()
+
+	root, err := os.MkdirTemp("", "widebench-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*keep {
+		defer os.RemoveAll(root)
+	}
+
+	source := filepath.Join(root, "source")
+	backup := filepath.Join(root, "backup")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := range *files {
+		name := filepath.Join(source, fmt.Sprintf("file%07d.eml", i))
+		if err := os.WriteFile(name, []byte("initial content\n"), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("created %d files in %s\n", *files, time.Since(start).Round(time.Millisecond))
+
+	var done atomic.Int64
+	allDone := make(chan struct{})
+
+	w, err := fwbackup.New(fwbackup.Options{
+		SourceDir:   source,
+		BackupDir:   backup,
+		MaxVersions: *versions,
+		MinInterval: time.Nanosecond,
+		OnEvent: func(e fwbackup.Event) {
+			if done.Add(1) == int64(*changes) {
+				close(allDone)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+
+	go func() {
+		runErr <- w.Run(ctx)
+	}()
+
+	// Give the watcher time to register the directory
+	time.Sleep(500 * time.Millisecond)
+
+	start = time.Now()
+	for i := range *changes {
+		// Touch a small set of files repeatedly so retention kicks in
+		name := filepath.Join(source, fmt.Sprintf("file%07d.eml", i%(*files/10+1)))
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("change %d\n", i)), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	select {
+	case <-allDone:
+	case <-time.After(5 * time.Minute):
+		fmt.Println("timed out waiting for backups")
+	}
+	elapsed := time.Since(start)
+
+	cancel()
+	if err := <-runErr; err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d backup jobs finished in %s (%.0f jobs/s)\n",
+		done.Load(), elapsed.Round(time.Millisecond), float64(done.Load())/elapsed.Seconds())
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// aclXattr holds the POSIX access ACL of a file
+const aclXattr = "system.posix_acl_access"
+
+// ReadACL returns the POSIX access ACL of a file in its extended attribute
+// format, nil when the file has none beyond its permission bits or the file
+// system does not support ACLs
+func ReadACL(path string) ([]byte, error) {
+	acl, err := getXattr(path, aclXattr)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	return acl, err
+}
+
+// ApplyACL sets an ACL returned by ReadACL on a file. It also sets the
+// permission bits, as the ACL includes them.
+func ApplyACL(path string, acl []byte) error {
+	return unix.Setxattr(path, aclXattr, acl, 0)
+}
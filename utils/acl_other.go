@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package utils
+
+import "errors"
+
+// ReadACL returns nil, ACLs are not backed up on this platform
+func ReadACL(path string) ([]byte, error) {
+	return nil, nil
+}
+
+// ApplyACL is not supported on this platform
+func ApplyACL(path string, acl []byte) error {
+	return errors.ErrUnsupported
+}
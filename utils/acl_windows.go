@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// aclInfo selects the parts of a security descriptor that are backed up,
+// the SACL needs a privilege to be read
+const aclInfo = windows.OWNER_SECURITY_INFORMATION | windows.GROUP_SECURITY_INFORMATION | windows.DACL_SECURITY_INFORMATION
+
+// ReadACL returns the owner, group and DACL of a file as an SDDL string
+func ReadACL(path string) ([]byte, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, aclInfo)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sd.String()), nil
+}
+
+// ApplyACL sets a security descriptor returned by ReadACL on a file. The owner
+// can only be set with the restore privilege, without it only the DACL is set.
+func ApplyACL(path string, acl []byte) error {
+	sd, err := windows.SecurityDescriptorFromString(string(acl))
+	if err != nil {
+		return err
+	}
+
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	group, _, err := sd.Group()
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return err
+	}
+
+	// Keep a DACL that did not inherit from its parent that way
+	var info windows.SECURITY_INFORMATION = windows.DACL_SECURITY_INFORMATION | windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	if control&windows.SE_DACL_PROTECTED != 0 {
+		info = windows.DACL_SECURITY_INFORMATION | windows.PROTECTED_DACL_SECURITY_INFORMATION
+	}
+
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		info|windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION, owner, group, dacl, nil)
+	if errors.Is(err, windows.ERROR_INVALID_OWNER) || errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) ||
+		errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src with clonefile, sharing
+// its blocks on APFS. It fails when src and dst are on different volumes or
+// the file system can not clone, leaving no dst.
+func cloneFile(src, dst string) error {
+	// clonefile does not replace, a previous attempt may have left dst behind
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src with FICLONE, sharing its
+// blocks on file systems such as Btrfs and XFS. It fails when src and dst are
+// on different file systems or the file system can not clone, leaving no dst.
+func cloneFile(src, dst string) error {
+	srcFile, err := openSource(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
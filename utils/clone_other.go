@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "errors"
+
+// cloneFile always fails, there is no copy-on-write clone on this platform
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
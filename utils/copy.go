@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// CopyOptions configures CopyFile
+type CopyOptions struct {
+	MaxRetries    int       // Attempts before giving up on transient failures
+	Throttle      *Throttle // Shared rate limit for all copies, nil for none
+	MaxReadPerSec int64     // Limit of bytes read per second from this source, 0 for unlimited
+	DropCache     bool      // Evict the copied pages from the page cache as the copy goes
+	SkipMetadata  bool      // Only copy the content, e.g. of an alternate stream
+	Hash          hash.Hash // Fed with the content as it is copied, reset for every attempt, nil for none
+	Clone         bool      // Clone src when the file system supports it (reflink, clonefile), else copy it
+}
+
+// dropCacheChunk is how much is read between two page cache evictions
+const dropCacheChunk = 8 << 20
+
+// SafeCopyFile copies src to dst with its metadata, retrying transient failures
+func SafeCopyFile(ctx context.Context, src, dst string, maxRetries int) error {
+	return CopyFile(ctx, src, dst, CopyOptions{MaxRetries: maxRetries})
+}
+
+// CopyFile copies src to dst with its metadata as configured by opts.
+// Canceling ctx aborts the copy between two reads, as well as throttle and
+// retry waits, and removes the partial copy.
+func CopyFile(ctx context.Context, src, dst string, opts CopyOptions) error {
+	err := RetryWithBackoff(ctx, opts.MaxRetries, 100*time.Millisecond, func() error {
+		if err := opts.Throttle.WaitOp(ctx); err != nil {
+			return NewBackupError(src, OpOpenSource, err)
+		}
+
+		srcInfo, err := os.Stat(src)
+		if err != nil {
+			return NewBackupError(src, OpStatSource, err)
+		}
+
+		if srcInfo.IsDir() {
+			return &BackupError{
+				FilePath:  src,
+				Operation: OpCheckType,
+				Kind:      ErrSourceIsDir,
+			}
+		}
+
+		if opts.Hash != nil {
+			opts.Hash.Reset()
+		}
+
+		// A clone shares the blocks of src, nothing is written. Any failure,
+		// e.g. src on another file system, falls back to copying.
+		if opts.Clone && cloneFile(src, dst) == nil {
+			if opts.Hash != nil {
+				if err := hashClone(ctx, dst, opts); err != nil {
+					return err
+				}
+			}
+			if !opts.SkipMetadata {
+				_ = PreserveMetadata(src, dst, srcInfo)
+			}
+			return nil
+		}
+
+		srcFile, err := openSource(src)
+		if err != nil {
+			return NewBackupError(src, OpOpenSource, err)
+		}
+		defer srcFile.Close()
+
+		dstFile, err := os.Create(dst)
+		if err != nil {
+			return NewBackupError(dst, OpCreateDest, err)
+		}
+		defer dstFile.Close()
+
+		// Fail before copying a file that can not fit, and keep it unfragmented.
+		// The holes of sparse files are kept, reserving them would fill them.
+		sparse := isSparse(srcInfo)
+		if !sparse {
+			if err := preallocate(dstFile, srcInfo.Size()); err != nil {
+				dstFile.Close()
+				os.Remove(dst)
+				return NewBackupError(dst, OpReserve, err)
+			}
+		}
+
+		var reader io.Reader = srcFile
+		if opts.Throttle != nil {
+			reader = opts.Throttle.Reader(ctx, reader)
+		}
+		if opts.MaxReadPerSec > 0 {
+			// Don't starve the application writing the file of disk bandwidth
+			reader = NewThrottle(0, opts.MaxReadPerSec).Reader(ctx, reader)
+		}
+
+		var offset, dropped int64
+		dataEnd := int64(-1)
+		buf := make([]byte, 32*1024)
+		for {
+			if opts.DropCache && offset-dropped >= dropCacheChunk {
+				dropCache(srcFile, dropped, offset-dropped)
+				dropped = offset
+			}
+
+			if err := ctx.Err(); err != nil {
+				// Do not leave a partial copy behind
+				dstFile.Close()
+				os.Remove(dst)
+				return NewBackupError(src, OpRead, err)
+			}
+
+			// Holes are skipped without reading them
+			if sparse && offset >= dataEnd {
+				var start int64
+				start, dataEnd = nextData(srcFile, offset, srcInfo.Size())
+				if start > offset {
+					if err := skipHole(dstFile, opts.Hash, start-offset); err != nil {
+						return NewBackupError(dst, OpWrite, err)
+					}
+					offset = start
+				}
+			}
+
+			n, err := reader.Read(buf)
+			if n > 0 {
+				if sparse && isZero(buf[:n]) {
+					if _, err := dstFile.Seek(int64(n), io.SeekCurrent); err != nil {
+						return NewBackupError(dst, OpWrite, err)
+					}
+				} else if _, err := dstFile.Write(buf[:n]); err != nil {
+					return NewBackupError(dst, OpWrite, err)
+				}
+				if opts.Hash != nil {
+					opts.Hash.Write(buf[:n])
+				}
+				offset += int64(n)
+			}
+
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if ctx.Err() != nil {
+					// Canceled while throttled
+					continue
+				}
+				return NewBackupError(src, OpRead, err)
+			}
+		}
+
+		if offset < srcInfo.Size() || sparse {
+			// The source shrank while it was copied, release the space reserved
+			// past its end. A sparse copy ending in a hole gets its size.
+			if err := dstFile.Truncate(offset); err != nil {
+				return NewBackupError(dst, OpWrite, err)
+			}
+		}
+
+		if opts.DropCache {
+			// Only written back pages can be dropped
+			if err := dstFile.Sync(); err != nil {
+				return NewBackupError(dst, OpCloseDest, err)
+			}
+			dropCache(srcFile, dropped, 0)
+			dropCache(dstFile, 0, 0)
+		}
+
+		if err := dstFile.Close(); err != nil {
+			return NewBackupError(dst, OpCloseDest, err)
+		}
+
+		// Metadata is best effort, the content is what matters
+		if !opts.SkipMetadata {
+			_ = PreserveMetadata(src, dst, srcInfo)
+		}
+
+		return nil
+	})
+
+	// Canceled while waiting to retry
+	var backupErr *BackupError
+	if err != nil && !errors.As(err, &backupErr) {
+		return NewBackupError(src, OpRead, err)
+	}
+	return err
+}
+
+// hashClone feeds opts.Hash with the content of the clone at dst. Hashing the
+// clone instead of the source matches what is stored, even when the source
+// changed meanwhile.
+func hashClone(ctx context.Context, dst string, opts CopyOptions) error {
+	f, err := os.Open(dst)
+	if err != nil {
+		return NewBackupError(dst, OpRead, err)
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if opts.Throttle != nil {
+		reader = opts.Throttle.Reader(ctx, reader)
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			// Do not leave a clone without a checksum behind
+			f.Close()
+			os.Remove(dst)
+			return NewBackupError(dst, OpRead, err)
+		}
+
+		n, err := reader.Read(buf)
+		opts.Hash.Write(buf[:n])
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return NewBackupError(dst, OpRead, err)
+		}
+	}
+}
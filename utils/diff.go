@@ -0,0 +1,218 @@
+package utils
+
+// Line based unified diffs of text files, computed with the Myers algorithm.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	diffContext  = 3       // Unchanged lines shown around each change
+	diffMaxEdits = 2000    // Lines added and removed at most, larger diffs are not computed
+	DiffMaxSize  = 8 << 20 // Size of a file at most to be diffed line by line
+)
+
+// IsText reports whether data looks like text: valid UTF-8 without NUL bytes
+func IsText(data []byte) bool {
+	return bytes.IndexByte(data, 0) < 0 && utf8.Valid(data)
+}
+
+// UnifiedDiff returns the unified diff turning from into to, with the file
+// names fromName and toName in the header. It returns "" when both are equal
+// and false when they can not be diffed line by line: when one is binary or
+// larger than 8 MB, or when more than 2000 lines differ.
+func UnifiedDiff(fromName, toName string, from, to []byte) (string, bool) {
+	if bytes.Equal(from, to) {
+		return "", true
+	}
+	if len(from) > DiffMaxSize || len(to) > DiffMaxSize || !IsText(from) || !IsText(to) {
+		return "", false
+	}
+
+	a, b := splitLines(from), splitLines(to)
+	ops, ok := diffLines(a, b, diffMaxEdits)
+	if !ok {
+		return "", false
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Hunks are runs of changes closer than twice the context to each other
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		first := max(start-diffContext, 0)
+		last := min(end+diffContext, len(ops))
+		writeHunk(&out, ops, first, last)
+		start = last
+	}
+	return out.String(), true
+}
+
+// diffOp is a line of an edit script: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind  byte
+	line  string
+	aLine int // Line number in the old file, counted from 1, of kept and removed lines
+	bLine int // Line number in the new file, counted from 1, of kept and added lines
+}
+
+func writeHunk(out *strings.Builder, ops []diffOp, first, last int) {
+	var aStart, bStart, aCount, bCount int
+	for _, op := range ops[first:last] {
+		if op.kind != '+' {
+			if aCount == 0 {
+				aStart = op.aLine
+			}
+			aCount++
+		}
+		if op.kind != '-' {
+			if bCount == 0 {
+				bStart = op.bLine
+			}
+			bCount++
+		}
+	}
+
+	// An empty range is given by the line before it
+	if aCount == 0 {
+		aStart = lineBefore(ops, first, func(op diffOp) int { return op.aLine }, '+')
+	}
+	if bCount == 0 {
+		bStart = lineBefore(ops, first, func(op diffOp) int { return op.bLine }, '-')
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, op := range ops[first:last] {
+		out.WriteByte(op.kind)
+		out.WriteString(strings.TrimSuffix(op.line, "\n"))
+		out.WriteByte('\n')
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\\ No newline at end of file\n")
+		}
+	}
+}
+
+// lineBefore returns the number of the last line before ops[first] on one
+// side, skipping the operations of the other side
+func lineBefore(ops []diffOp, first int, number func(diffOp) int, other byte) int {
+	for i := first - 1; i >= 0; i-- {
+		if ops[i].kind != other {
+			return number(ops[i])
+		}
+	}
+	return 0
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits data after every newline, the last line may lack one
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b, or false when
+// it takes more than maxEdits lines added and removed
+func diffLines(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k, trace keeps the
+	// part of v used by each step to walk back the path found
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Walk back from the end, trace[d] holds v[-d-1..d+1] before step d
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			ops = append(ops, diffOp{kind: ' ', line: a[x-1], aLine: x, bLine: y})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{kind: '+', line: b[y-1], bLine: y})
+			y--
+		} else {
+			ops = append(ops, diffOp{kind: '-', line: a[x-1], aLine: x})
+			x--
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package utils
+
+import "errors"
+
+// FreeSpace is not implemented on this platform
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
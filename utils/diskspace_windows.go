@@ -0,0 +1,18 @@
+package utils
+
+import "golang.org/x/sys/windows"
+
+// FreeSpace returns the number of bytes available to the current user
+// on the volume holding path
+func FreeSpace(path string) (int64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
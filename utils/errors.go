@@ -1,30 +1,226 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"time"
 )
 
+// Sentinel errors classifying why a backup operation failed. A BackupError wraps
+// exactly one of them, so callers can use errors.Is instead of parsing messages.
+var (
+	ErrSourceVanished  = errors.New("source vanished")
+	ErrSourceIsDir     = errors.New("source is a directory")
+	ErrDestinationFull = errors.New("destination full")
+	ErrPermission      = errors.New("permission denied")
+	ErrLocked          = errors.New("file locked by another process")
+	ErrThrottled       = errors.New("throttled")
+	ErrCanceled        = errors.New("canceled")
+	ErrTimeout         = errors.New("timed out")
+	ErrIO              = errors.New("i/o error")
+	ErrProtected       = errors.New("destination is read-only or immutable")
+	ErrTooFewCopies    = errors.New("too few destinations stored the version")
+	ErrSourceChanged   = errors.New("source changed while it was copied")
+)
+
+// errorKind describes how a sentinel error is reported outside of the program
+type errorKind struct {
+	err       error
+	label     string // Stable, machine-readable name used in metrics labels and API bodies
+	exitCode  int    // Process exit code, following sysexits.h where it fits
+	retryable bool   // Whether retrying the same operation may succeed
+}
+
+var errorKinds = []errorKind{
+	{ErrSourceVanished, "source_vanished", 66, false},
+	{ErrSourceIsDir, "source_is_dir", 65, false},
+	{ErrDestinationFull, "destination_full", 73, false},
+	{ErrPermission, "permission", 77, true},
+	{ErrLocked, "locked", 75, true},
+	{ErrThrottled, "throttled", 75, true},
+	{ErrCanceled, "canceled", 130, false},
+	{ErrTimeout, "timeout", 75, false},
+	{ErrIO, "io", 74, true},
+	{ErrProtected, "protected", 77, false},
+	{ErrTooFewCopies, "too_few_copies", 75, true},
+	{ErrSourceChanged, "source_changed", 75, true},
+}
+
+// Op names the step of a backup that failed
+type Op string
+
+const (
+	OpStatSource Op = "stat_source"
+	OpCheckType  Op = "check_type"
+	OpOpenSource Op = "open_source"
+	OpCreateDest Op = "create_destination"
+	OpReserve    Op = "reserve_space"
+	OpRead       Op = "read"
+	OpWrite      Op = "write"
+	OpCloseDest  Op = "close_destination"
+	OpCreateDir  Op = "create_directory"
+	OpCleanup    Op = "cleanup"
+	OpReplicate  Op = "replicate"
+	OpVerify     Op = "verify"
+)
+
+// isSourceOp reports whether the operation works on the file being backed up
+func (op Op) isSourceOp() bool {
+	return op == OpStatSource || op == OpCheckType || op == OpOpenSource || op == OpRead
+}
+
 type BackupError struct {
 	FilePath  string
-	Operation string
-	Err       error
+	Operation Op
+	Kind      error // One of the Err* sentinels
+	Err       error // Underlying error, may be nil when Kind says it all
 	Retryable bool
 }
 
+// NewBackupError wraps err, classifying it into one of the sentinel errors
+func NewBackupError(path string, op Op, err error) *BackupError {
+	kind := classify(op, err)
+	return &BackupError{
+		FilePath:  path,
+		Operation: op,
+		Kind:      kind.err,
+		Err:       err,
+		Retryable: kind.retryable,
+	}
+}
+
 func (e *BackupError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("backup error [%s] %s: %v", e.Operation, e.FilePath, e.Kind)
+	}
 	return fmt.Sprintf("backup error [%s] %s: %v", e.Operation, e.FilePath, e.Err)
 }
 
+// Unwrap exposes both the sentinel kind and the underlying error to errors.Is and errors.As
+func (e *BackupError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// classify maps an error returned by the operating system to an error kind
+func classify(op Op, err error) errorKind {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return kindOf(ErrTimeout)
+	case errors.Is(err, context.Canceled):
+		return kindOf(ErrCanceled)
+	case errors.Is(err, os.ErrNotExist) && op.isSourceOp():
+		return kindOf(ErrSourceVanished)
+	case errors.Is(err, os.ErrPermission):
+		return kindOf(ErrPermission)
+	case isSharingViolation(err):
+		return kindOf(ErrLocked)
+	case isNoSpace(err):
+		return kindOf(ErrDestinationFull)
+	default:
+		return kindOf(ErrIO)
+	}
+}
+
+// kindOf returns the description of a sentinel error
+func kindOf(sentinel error) errorKind {
+	for _, kind := range errorKinds {
+		if kind.err == sentinel {
+			return kind
+		}
+	}
+	return errorKind{err: sentinel, label: "unknown", exitCode: 1}
+}
+
+// lookupKind finds the first sentinel error wrapped by err
+func lookupKind(err error) (errorKind, bool) {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind, true
+		}
+	}
+	return errorKind{}, false
+}
+
+// ErrorLabel returns a stable, machine-readable name for the kind of err,
+// suitable as a metrics label. Unclassified errors are labeled "unknown".
+func ErrorLabel(err error) string {
+	if kind, ok := lookupKind(err); ok {
+		return kind.label
+	}
+	return "unknown"
+}
+
+// ErrorLabels returns every label ErrorLabel can return
+func ErrorLabels() []string {
+	labels := make([]string, 0, len(errorKinds)+1)
+	for _, kind := range errorKinds {
+		labels = append(labels, kind.label)
+	}
+	return append(labels, "unknown")
+}
+
+// ExitCode returns the process exit code for err, 0 for nil and 1 for unclassified errors
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if kind, ok := lookupKind(err); ok {
+		return kind.exitCode
+	}
+	return 1
+}
+
+// ErrorResponse is the JSON body describing a failed request or backup
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Operation string `json:"operation,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// NewErrorResponse describes err for API clients
+func NewErrorResponse(err error) ErrorResponse {
+	resp := ErrorResponse{
+		Code:      ErrorLabel(err),
+		Message:   err.Error(),
+		Retryable: IsRetryable(err),
+	}
+
+	var backupErr *BackupError
+	if errors.As(err, &backupErr) {
+		resp.Operation = string(backupErr.Operation)
+		resp.Path = backupErr.FilePath
+	}
+	return resp
+}
+
 func IsRetryable(err error) bool {
 	var backupErr *BackupError
 	if errors.As(err, &backupErr) {
 		return backupErr.Retryable
 	}
 
-	if errors.Is(err, os.ErrPermission) {
+	if kind, ok := lookupKind(err); ok {
+		return kind.retryable
+	}
+
+	if errors.Is(err, os.ErrPermission) || isSharingViolation(err) {
 		return true
 	}
 	if errors.Is(err, os.ErrNotExist) {
@@ -34,7 +230,10 @@ func IsRetryable(err error) bool {
 	return false
 }
 
-func RetryWithBackoff(maxRetries int, initialDelay time.Duration, fn func() error) error {
+// RetryWithBackoff calls fn until it succeeds, fails with an error that is not
+// retryable or was called maxRetries times, doubling the delay between attempts.
+// It stops waiting as soon as ctx is canceled and returns the context error then.
+func RetryWithBackoff(ctx context.Context, maxRetries int, initialDelay time.Duration, fn func() error) error {
 	var lastErr error
 	delay := initialDelay
 
@@ -47,7 +246,9 @@ func RetryWithBackoff(maxRetries int, initialDelay time.Duration, fn func() erro
 			}
 
 			if i < maxRetries-1 {
-				time.Sleep(delay)
+				if err := Sleep(ctx, delay); err != nil {
+					return fmt.Errorf("%w, last error: %v", err, lastErr)
+				}
 				delay *= 2
 				continue
 			}
@@ -59,86 +260,16 @@ func RetryWithBackoff(maxRetries int, initialDelay time.Duration, fn func() erro
 	return fmt.Errorf("exceed max retries (%d): %w", maxRetries, lastErr)
 }
 
-func SafeCopyFile(src, dst string, maxRetries int) error {
-	return RetryWithBackoff(maxRetries, 100*time.Millisecond, func() error {
-		srcInfo, err := os.Stat(src)
-		if err != nil {
-			return &BackupError{
-				FilePath:  src,
-				Operation: "stat_source",
-				Err:       err,
-				Retryable: errors.Is(err, os.ErrPermission),
-			}
-		}
-
-		if srcInfo.IsDir() {
-			return &BackupError{
-				FilePath:  src,
-				Operation: "check_type",
-				Err:       errors.New("source is a directory"),
-				Retryable: false,
-			}
-		}
-
-		srcFile, err := os.Open(src)
-		if err != nil {
-			return &BackupError{
-				FilePath:  src,
-				Operation: "open_source",
-				Err:       err,
-				Retryable: errors.Is(err, os.ErrPermission),
-			}
-		}
-		defer srcFile.Close()
-
-		dstFile, err := os.Create(dst)
-		if err != nil {
-			return &BackupError{
-				FilePath:  dst,
-				Operation: "create_destination",
-				Err:       err,
-				Retryable: errors.Is(err, os.ErrPermission),
-			}
-		}
-		defer dstFile.Close()
-
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := srcFile.Read(buf)
-			if n > 0 {
-				if _, err := dstFile.Write(buf[:n]); err != nil {
-					return &BackupError{
-						FilePath:  dst,
-						Operation: "write",
-						Err:       err,
-						Retryable: true,
-					}
-				}
-			}
-
-			if err != nil {
-				if err.Error() == "EOF" {
-					break
-				}
-				return &BackupError{
-					FilePath: src,
-					Operation: "read",
-					Err: err,
-					Retryable: true,
-				}
-			}
-		}
-
-		if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
-			return nil
-		}
+// Sleep waits for d or until ctx is canceled, whichever comes first.
+// It returns the context error in the latter case.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
+	select {
+	case <-timer.C:
 		return nil
-	})
-}
-
-func HandlePanic(logger *Logger, context string) {
-	if r := recover(); r != nil {
-		logger.Error("PANIC in %s: %v", context, r)
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,65 @@
+package utils
+
+// Translation of user-facing messages. Messages are looked up by their English
+// text, a format string for the formatted ones, so messages without a
+// translation are shown in English. Structured output, such as the audit log,
+// JSON and notification payloads, is never translated.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	LangEnglish = "en"
+	LangPolish  = "pl"
+)
+
+// Languages lists the supported languages
+var Languages = []string{LangEnglish, LangPolish}
+
+// catalogs holds the translations of each language but English
+var catalogs = map[string]map[string]string{
+	LangPolish: polish,
+}
+
+// ParseLanguage validates a language code such as "pl" or a locale such as
+// "pl_PL.UTF-8" and returns the supported language it names
+func ParseLanguage(s string) (string, error) {
+	lang := strings.ToLower(s)
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+
+	for _, l := range Languages {
+		if lang == l {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported language %q, supported: %s", s, strings.Join(Languages, ", "))
+}
+
+// DetectLanguage returns the language of the user's locale, taken from
+// LC_ALL, LC_MESSAGES and LANG like gettext does, English if it is not supported
+func DetectLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if lang, err := ParseLanguage(value); err == nil {
+			return lang
+		}
+		return LangEnglish
+	}
+	return LangEnglish
+}
+
+// Translate returns msg in lang, or msg itself when there is no translation
+func Translate(lang, msg string) string {
+	if translated, ok := catalogs[lang][msg]; ok {
+		return translated
+	}
+	return msg
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs, without I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID for time t: 48 bits of milliseconds followed by
+// 80 random bits, as 26 characters of Crockford base32. ULIDs sort by time.
+func NewULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := range 6 {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	rand.Read(b[6:])
+
+	// 128 bits in 26 characters of 5 bits, the first one only holds 3
+	var out [26]byte
+	for i := range out {
+		bit := 128 - 5*(26-i)
+		var v byte
+		for j := range 5 {
+			pos := bit + j
+			if pos < 0 {
+				continue
+			}
+			v = v<<1 | (b[pos/8]>>(7-pos%8))&1
+		}
+		out[i] = crockford[v]
+	}
+	return string(out[:])
+}
+
+// NewUUID returns a random (version 4) UUID, t is ignored
+func NewUUID(t time.Time) string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
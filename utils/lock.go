@@ -0,0 +1,44 @@
+package utils
+
+// Detecting files in use, whose copies would be inconsistent: files held with
+// an exclusive lock by another process, and documents open in an office
+// suite, which leaves an owner file next to them.
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// LockReason returns why the file at path should not be copied right now, or
+// "" when it is not locked. Files that can not be checked count as unlocked.
+func LockReason(path string) string {
+	if owner := officeOwnerFile(path); owner != "" {
+		return "open in an office application (" + owner + ")"
+	}
+	if lockedExclusively(path) {
+		return "locked by another process"
+	}
+	return ""
+}
+
+// officeOwnerFile returns the name of the owner file an office suite keeps
+// next to the open document at path, or "" when there is none. Word shortens
+// long names by up to two characters, Excel and PowerPoint keep them whole
+// and LibreOffice uses a file of its own.
+func officeOwnerFile(path string) string {
+	dir, name := filepath.Split(path)
+	candidates := []string{"~$" + name, ".~lock." + name + "#"}
+	for cut := 1; cut <= 2 && cut < len(name); cut++ {
+		candidates = append(candidates, "~$"+name[cut:])
+	}
+
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
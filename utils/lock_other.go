@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package utils
+
+import "os"
+
+// lockedExclusively is always false, locks are not checked on this platform
+func lockedExclusively(path string) bool {
+	return false
+}
+
+// TryLock always succeeds, files are not locked on this platform
+func TryLock(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedExclusively reports whether another process holds an exclusive
+// flock(2) lock on the file, as editors and some databases take, or a POSIX
+// write lock on any part of it, as SQLite takes while writing. Locks are
+// advisory, so a shared lock is taken and dropped right away to check.
+func lockedExclusively(path string) bool {
+	f, err := openSource(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	if err := unix.Flock(fd, unix.LOCK_SH|unix.LOCK_NB); err != nil {
+		return errors.Is(err, unix.EWOULDBLOCK)
+	}
+	unix.Flock(fd, unix.LOCK_UN)
+
+	// A read lock on the whole file conflicts with any write lock, F_GETLK
+	// reports the first one without taking it
+	lock := unix.Flock_t{Type: unix.F_RDLCK, Whence: io.SeekStart}
+	if err := unix.FcntlFlock(uintptr(fd), unix.F_GETLK, &lock); err != nil {
+		return false
+	}
+	return lock.Type == unix.F_WRLCK
+}
+
+// TryLock takes an exclusive flock(2) lock on f, held until f is closed. It
+// returns false when another process holds a lock on it.
+func TryLock(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockedExclusively reports whether another process opened the file without
+// sharing it, or holds an exclusive lock on a region of it. The check takes a
+// shared lock on the whole file and drops it right away.
+func lockedExclusively(path string) bool {
+	f, err := openSource(path)
+	if err != nil {
+		return isSharingViolation(err)
+	}
+	defer f.Close()
+
+	handle := windows.Handle(f.Fd())
+	overlapped := new(windows.Overlapped)
+	err = windows.LockFileEx(handle, windows.LOCKFILE_FAIL_IMMEDIATELY, 0, math.MaxUint32, math.MaxUint32, overlapped)
+	if err != nil {
+		return errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+	}
+	windows.UnlockFileEx(handle, 0, math.MaxUint32, math.MaxUint32, overlapped)
+	return false
+}
+
+// TryLock takes an exclusive lock on f, held until f is closed. It returns
+// false when another process holds it. The lock is on a byte beyond 4 GB,
+// locked regions can not be read, the content stays readable this way.
+func TryLock(f *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{OffsetHigh: 1})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
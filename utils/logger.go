@@ -2,6 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,177 +33,541 @@ const (
 	IconWorker  = "🔧"
 	IconStats   = "📊"
 	IconWatch   = "👀"
+	IconDebug   = "🔍"
+
+	// Level prefixes replacing the icons in plain output
+	prefixDebug   = "DEBUG"
+	prefixError   = "ERROR"
+	prefixWarning = "WARN"
+	prefixInfo    = "INFO"
+	prefixSuccess = "OK"
+)
+
+// Level is the severity of a message. A logger prints the messages of its
+// level and above, the zero value prints everything but debug messages.
+type Level int
+
+const (
+	LevelDebug   Level = iota - 1 // Details of every event, e.g. each job queued
+	LevelInfo                     // What the watcher does, the default
+	LevelWarning                  // Problems it works around
+	LevelError                    // Failures
 )
 
+// ParseLevel parses a level name: "debug", "info", "warn" or "error"
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+}
+
+// colorCodes matches the escape sequences of the colors
+var colorCodes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// plainText replaces the non-ASCII symbols left in messages in plain output
+var plainText = strings.NewReplacer("→", "->", "…", "...")
+
+// Logger writes human readable, colored messages to an io.Writer.
+// It is safe for concurrent use, every message is written in one piece,
+// so a single Logger should be shared by all components.
 type Logger struct {
 	EnableColors bool
 	ShowTime     bool
+	Lang         string    // Language of the messages, English when empty, see Languages
+	Plain        bool      // No colors, emoji or box drawing, level prefixes instead, for screen readers and dumb terminals
+	Prefix       string    // Put before every line, e.g. the name of a profile
+	Tee          io.Writer // Gets every message as well, without colors, e.g. a log file
+	Level        Level     // Messages below it are not printed
+
+	mu  sync.Mutex
+	out io.Writer
 }
 
-func NewLogger(colors, showTime bool) *Logger {
+func NewLogger(out io.Writer, colors, showTime bool) *Logger {
 	return &Logger{
 		EnableColors: colors,
 		ShowTime:     showTime,
+		out:          out,
+	}
+}
+
+// write outputs a complete message while holding the lock
+func (l *Logger) write(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Plain {
+		msg = plainText.Replace(msg)
 	}
+	if l.Prefix != "" {
+		// Every line but the empty one after the last newline
+		body, newline := strings.CutSuffix(msg, "\n")
+		msg = l.Prefix + strings.ReplaceAll(body, "\n", "\n"+l.Prefix)
+		if newline {
+			msg += "\n"
+		}
+	}
+	io.WriteString(l.out, msg)
+	if l.Tee != nil {
+		io.WriteString(l.Tee, colorCodes.ReplaceAllString(msg, ""))
+	}
+}
+
+// enabled reports whether messages of level are printed
+func (l *Logger) enabled(level Level) bool {
+	return level >= l.Level
+}
+
+func (l *Logger) printf(format string, args ...interface{}) {
+	l.write(fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) println(text string) {
+	l.write(text + "\n")
+}
+
+// tr translates a message to the language of the logger
+func (l *Logger) tr(msg string) string {
+	return Translate(l.Lang, msg)
+}
+
+// Translate translates a message printed outside the logger, such as an
+// exit message, to the language of the logger
+func (l *Logger) Translate(msg string) string {
+	return l.tr(msg)
 }
 
 func (l *Logger) colorize(color, text string) string {
-	if !l.EnableColors {
+	if !l.EnableColors || l.Plain {
 		return text
 	}
 	return color + text + ColorReset
 }
 
+// icon returns the colored icon of a message, or its level prefix in plain output
+func (l *Logger) icon(color, icon, level string) string {
+	if l.Plain {
+		return level
+	}
+	return l.colorize(color, icon)
+}
+
+// label prefixes a label of the header with its icon, except in plain output
+func (l *Logger) label(icon, text string) string {
+	if l.Plain {
+		return text
+	}
+	return icon + "  " + text
+}
+
 func (l *Logger) timestamp() string {
 	if !l.ShowTime {
 		return ""
 	}
-	return l.colorize(ColorGray, fmt.Sprintf("[%s] ", time.Now().Format("00:00:00")))
+	return l.colorize(ColorGray, fmt.Sprintf("[%s] ", time.Now().Format("15:04:05")))
+}
+
+// Debug prints details only wanted when looking into a problem, see LevelDebug
+func (l *Logger) Debug(format string, args ...interface{}) {
+	if !l.enabled(LevelDebug) {
+		return
+	}
+	msg := fmt.Sprintf(l.tr(format), args...)
+	l.printf("%s%s %s\n",
+		l.timestamp(),
+		l.icon(ColorGray, IconDebug, prefixDebug),
+		l.colorize(ColorGray, msg))
 }
 
 func (l *Logger) Error(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("%s%s %s\n",
+	if !l.enabled(LevelError) {
+		return
+	}
+	msg := fmt.Sprintf(l.tr(format), args...)
+	l.printf("%s%s %s\n",
 		l.timestamp(),
-		l.colorize(ColorRed, IconError),
+		l.icon(ColorRed, IconError, prefixError),
 		l.colorize(ColorRed, msg))
 }
 
 func (l *Logger) Success(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("%s%s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	msg := fmt.Sprintf(l.tr(format), args...)
+	l.printf("%s%s %s\n",
 		l.timestamp(),
-		l.colorize(ColorGreen, IconSuccess),
+		l.icon(ColorGreen, IconSuccess, prefixSuccess),
 		l.colorize(ColorGreen, msg))
 }
 
 func (l *Logger) Warning(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("%s%s %s\n",
+	if !l.enabled(LevelWarning) {
+		return
+	}
+	msg := fmt.Sprintf(l.tr(format), args...)
+	l.printf("%s%s %s\n",
 		l.timestamp(),
-		l.colorize(ColorYellow, IconWarning),
+		l.icon(ColorYellow, IconWarning, prefixWarning),
 		l.colorize(ColorYellow, msg))
 }
 
 func (l *Logger) Info(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("%s%s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	msg := fmt.Sprintf(l.tr(format), args...)
+	l.printf("%s%s %s\n",
 		l.timestamp(),
-		l.colorize(ColorCyan, IconInfo),
+		l.icon(ColorCyan, IconInfo, prefixInfo),
 		l.colorize(ColorCyan, msg))
 }
 
 func (l *Logger) FileCreated(filename string) {
-	fmt.Printf("%s%s %s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s\n",
 		l.timestamp(),
-		l.colorize(ColorGreen, IconFile),
-		l.colorize(ColorWhite, "New file:"),
+		l.icon(ColorGreen, IconFile, prefixInfo),
+		l.colorize(ColorWhite, l.tr("New file:")),
 		l.colorize(ColorCyan+Bold, filename))
 }
 
 func (l *Logger) FileModified(filename string) {
-	fmt.Printf("%s%s %s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s\n",
 		l.timestamp(),
-		l.colorize(ColorBlue, IconFile),
-		l.colorize(ColorWhite, "Modified"),
+		l.icon(ColorBlue, IconFile, prefixInfo),
+		l.colorize(ColorWhite, l.tr("Modified")),
 		l.colorize(ColorCyan+Bold, filename))
 }
 
 func (l *Logger) FileRenamed(filename string) {
-	fmt.Printf("%s%s %s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s\n",
 		l.timestamp(),
-		l.colorize(ColorMagenta, IconFile),
-		l.colorize(ColorWhite, "Renamed"),
+		l.icon(ColorMagenta, IconFile, prefixInfo),
+		l.colorize(ColorWhite, l.tr("Renamed")),
 		l.colorize(ColorCyan+Bold, filename))
 }
 
 func (l *Logger) FileDeleted(filename string) {
-	fmt.Printf("%s%s %s %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s\n",
 		l.timestamp(),
-		l.colorize(ColorRed, IconDelete),
-		l.colorize(ColorWhite, "Deleted"),
+		l.icon(ColorRed, IconDelete, prefixInfo),
+		l.colorize(ColorWhite, l.tr("Deleted")),
 		l.colorize(ColorGray, filename))
 }
 
 func (l *Logger) BackupCreated(filename, backupName string) {
-	fmt.Printf("%s%s %s %s → %s\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s → %s\n",
 		l.timestamp(),
-		l.colorize(ColorGreen, IconBackup),
-		l.colorize(ColorWhite, "Backup:"),
+		l.icon(ColorGreen, IconBackup, prefixInfo),
+		l.colorize(ColorWhite, l.tr("Backup:")),
 		l.colorize(ColorCyan, filename),
 		l.colorize(ColorGray, backupName))
 }
 
 func (l *Logger) BackupSkipped(filename, reason string) {
-	fmt.Printf("%s%s %s %s (%s)\n",
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s%s %s %s (%s)\n",
 		l.timestamp(),
-		l.colorize(ColorYellow, "⏭"),
-		l.colorize(ColorWhite, "Skipped:"),
+		l.icon(ColorYellow, "⏭", prefixInfo),
+		l.colorize(ColorWhite, l.tr("Skipped:")),
 		l.colorize(ColorGray, filename),
-		l.colorize(ColorYellow, reason))
+		l.colorize(ColorYellow, l.tr(reason)))
 }
 
 func (l *Logger) WorkerStarted(id int, filename string) {
-	fmt.Printf("%s%s %s %s\n",
+	if !l.enabled(LevelDebug) {
+		return
+	}
+	l.printf("%s%s %s %s\n",
 		l.timestamp(),
-		l.colorize(ColorMagenta, IconWorker),
-		l.colorize(ColorWhite, fmt.Sprintf("Worker #%d →", id)),
+		l.icon(ColorMagenta, IconWorker, prefixDebug),
+		l.colorize(ColorWhite, fmt.Sprintf(l.tr("Worker #%d →"), id)),
 		l.colorize(ColorCyan, filename))
 }
 
-func (l *Logger) Stats(tracked, queueLen, queueCap, workers int) {
-	fmt.Printf("\n%s%s %s\n",
+func (l *Logger) Stats(tracked, queueLen, queueCap, workers, restarted, lost, stalled, timedOut int) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\n%s%s %s\n",
 		l.timestamp(),
-		l.colorize(ColorCyan, IconStats),
-		l.colorize(ColorWhite+Bold, "Statistics"))
+		l.icon(ColorCyan, IconStats, prefixInfo),
+		l.colorize(ColorWhite+Bold, l.tr("Statistics")))
 
-	fmt.Printf("	%s Tracked files: %s\n",
+	fmt.Fprintf(&b, "	%s %s\n",
 		l.colorize(ColorGray, "*"),
-		l.colorize(ColorGreen+Bold, fmt.Sprintf("%d", tracked)))
+		fmt.Sprintf(l.tr("Tracked files: %s"), l.colorize(ColorGreen+Bold, fmt.Sprintf("%d", tracked))))
 
-	fmt.Printf("	%s Queue: %s\n",
+	fmt.Fprintf(&b, "	%s %s\n",
 		l.colorize(ColorGray, "*"),
-		l.colorize(ColorYellow+Bold, fmt.Sprintf("%d/%d", queueLen, queueCap)))
+		fmt.Sprintf(l.tr("Queue: %s"), l.colorize(ColorYellow+Bold, fmt.Sprintf("%d/%d", queueLen, queueCap))))
 
-	fmt.Printf("	%s Active workers: %s\n",
+	fmt.Fprintf(&b, "	%s %s\n",
 		l.colorize(ColorGray, "*"),
-		l.colorize(ColorMagenta+Bold, fmt.Sprintf("%d", workers)))
+		fmt.Sprintf(l.tr("Active workers: %s"), l.colorize(ColorMagenta+Bold, fmt.Sprintf("%d", workers))))
+
+	if restarted > 0 || lost > 0 {
+		fmt.Fprintf(&b, "	%s %s\n",
+			l.colorize(ColorGray, "*"),
+			fmt.Sprintf(l.tr("Restarted workers: %s, lost workers: %s"),
+				l.colorize(ColorYellow+Bold, fmt.Sprintf("%d", restarted)),
+				l.colorize(ColorRed+Bold, fmt.Sprintf("%d", lost))))
+	}
+
+	if stalled > 0 || timedOut > 0 {
+		fmt.Fprintf(&b, "	%s %s\n",
+			l.colorize(ColorGray, "*"),
+			fmt.Sprintf(l.tr("Timed out jobs: %s, stalled workers replaced: %s"),
+				l.colorize(ColorYellow+Bold, fmt.Sprintf("%d", timedOut)),
+				l.colorize(ColorRed+Bold, fmt.Sprintf("%d", stalled))))
+	}
+
+	l.write(b.String())
 }
 
-func (l *Logger) Headder(source, backup string, versions, workers int) {
-	fmt.Println(l.colorize(ColorCyan+Bold, "\n╔════════════════════════════════════════════╗"))
-	fmt.Println(l.colorize(ColorCyan+Bold, "║   📂 File Watcher & Auto-Backup CLI      ║"))
-	fmt.Println(l.colorize(ColorCyan+Bold, "╚════════════════════════════════════════════╝\n"))
+// BackupTotals prints the outcomes of the backups since the start, as part of Stats
+func (l *Logger) BackupTotals(succeeded, failed, dropped int, written int64, avgLatency time.Duration) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
 
-	fmt.Printf("%s %s %s\n",
-		l.colorize(ColorWhite, IconWatch+"  Monitoring:"),
-		l.colorize(ColorGreen+Bold, source),
-		l.colorize(ColorGray, "(recursive)"))
+	var b strings.Builder
 
-	fmt.Printf("%s %s\n",
-		l.colorize(ColorWhite, IconBackup+"  Backup to:"),
-		l.colorize(ColorGreen+Bold, backup))
+	fmt.Fprintf(&b, "	%s %s\n",
+		l.colorize(ColorGray, "*"),
+		fmt.Sprintf(l.tr("Backups: %s succeeded, %s failed, %s dropped"),
+			l.colorize(ColorGreen+Bold, fmt.Sprintf("%d", succeeded)),
+			l.colorize(ColorRed+Bold, fmt.Sprintf("%d", failed)),
+			l.colorize(ColorYellow+Bold, fmt.Sprintf("%d", dropped))))
 
-	fmt.Printf("%s %s\n",
-		l.colorize(ColorWhite, "📦  Versions:"),
-		l.colorize(ColorYellow+Bold, fmt.Sprintf("%d", versions)))
+	if succeeded > 0 {
+		fmt.Fprintf(&b, "	%s %s\n",
+			l.colorize(ColorGray, "*"),
+			fmt.Sprintf(l.tr("Written: %s, %s per backup on average"),
+				l.colorize(ColorCyan+Bold, FormatSize(written)),
+				l.colorize(ColorCyan+Bold, avgLatency.Round(time.Millisecond).String())))
+	}
 
-	fmt.Printf("%s %s\n",
-		l.colorize(ColorWhite, IconWorker+"  Workers:"),
-		l.colorize(ColorMagenta+Bold, fmt.Sprintf("%d", workers)))
+	l.write(b.String())
+}
+
+// Digest prints a rollup such as the daily digest under title. top lists the
+// most versioned files, already formatted.
+func (l *Logger) Digest(title string, since time.Time, backedUp, failed int, written, repoSize int64, top []string) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\n%s%s %s %s\n",
+		l.timestamp(),
+		l.icon(ColorCyan, IconStats, prefixInfo),
+		l.colorize(ColorWhite+Bold, l.tr(title)),
+		l.colorize(ColorGray, fmt.Sprintf(l.tr("since %s"), since.Format("2006-01-02 15:04"))))
+
+	fmt.Fprintf(&b, "	%s %s\n",
+		l.colorize(ColorGray, "*"),
+		fmt.Sprintf(l.tr("Backed up: %s, written: %s"),
+			l.colorize(ColorGreen+Bold, fmt.Sprintf(l.tr("%d versions"), backedUp)),
+			l.colorize(ColorGreen+Bold, FormatSize(written))))
+
+	errColor := ColorGreen
+	if failed > 0 {
+		errColor = ColorRed
+	}
+	fmt.Fprintf(&b, "	%s %s\n",
+		l.colorize(ColorGray, "*"),
+		fmt.Sprintf(l.tr("Errors: %s"), l.colorize(errColor+Bold, fmt.Sprintf("%d", failed))))
+
+	fmt.Fprintf(&b, "	%s %s\n",
+		l.colorize(ColorGray, "*"),
+		fmt.Sprintf(l.tr("Space used: %s"), l.colorize(ColorYellow+Bold, FormatSize(repoSize))))
+
+	if len(top) > 0 {
+		fmt.Fprintf(&b, "	%s %s\n", l.colorize(ColorGray, "*"), l.tr("Most versioned:"))
+		for _, line := range top {
+			fmt.Fprintf(&b, "	    %s\n", l.colorize(ColorCyan, line))
+		}
+	}
 
-	fmt.Println(l.colorize(ColorGray, "\n"+"----------------------------------"))
-	fmt.Println(l.colorize(ColorYellow, "Press Ctrl+C to stop watching and exit."))
-	fmt.Println(l.colorize(ColorGray, "----------------------------------\n"))
+	l.write(b.String())
+}
+
+func (l *Logger) ThrottleStats(opsRate, opsLimit, bytesRate, bytesLimit float64) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	var b strings.Builder
+
+	if opsLimit > 0 {
+		fmt.Fprintf(&b, "	%s %s\n",
+			l.colorize(ColorGray, "*"),
+			fmt.Sprintf(l.tr("Backups/s: %s"),
+				l.colorize(ColorCyan+Bold, fmt.Sprintf("%.1f/%.1f (%.0f%%)", opsRate, opsLimit, opsRate/opsLimit*100))))
+	}
+
+	if bytesLimit > 0 {
+		fmt.Fprintf(&b, "	%s %s\n",
+			l.colorize(ColorGray, "*"),
+			fmt.Sprintf(l.tr("Write rate: %s"),
+				l.colorize(ColorCyan+Bold, fmt.Sprintf(l.tr("%s/s of %s/s (%.0f%%)"),
+					FormatSize(int64(bytesRate)), FormatSize(int64(bytesLimit)), bytesRate/bytesLimit*100))))
+	}
+
+	l.write(b.String())
+}
+
+// HeaderInfo is what the startup banner shows
+type HeaderInfo struct {
+	Source   string // Watched directory
+	Backup   string // Backup directory
+	Versions int    // Versions kept per file
+	Workers  int    // Number of backup workers
+
+	// State of the backup repository at startup
+	Files          int       // Files with at least one stored version
+	StoredVersions int       // Number of stored versions
+	RepositorySize int64     // Size of all stored versions in bytes
+	LastBackup     time.Time // When the newest version was created, zero when there is none
+}
+
+// Header prints the startup banner
+func (l *Logger) Header(h HeaderInfo) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	var b strings.Builder
+
+	if l.Plain {
+		fmt.Fprintln(&b, "File Watcher & Auto-Backup CLI")
+	} else {
+		fmt.Fprintln(&b, l.colorize(ColorCyan+Bold, "\n╔════════════════════════════════════════════╗"))
+		fmt.Fprintln(&b, l.colorize(ColorCyan+Bold, "║   📂 File Watcher & Auto-Backup CLI      ║"))
+		fmt.Fprintln(&b, l.colorize(ColorCyan+Bold, "╚════════════════════════════════════════════╝\n"))
+	}
+
+	fmt.Fprintf(&b, "%s %s %s\n",
+		l.colorize(ColorWhite, l.label(IconWatch, l.tr("Monitoring:"))),
+		l.colorize(ColorGreen+Bold, h.Source),
+		l.colorize(ColorGray, l.tr("(recursive)")))
+
+	fmt.Fprintf(&b, "%s %s\n",
+		l.colorize(ColorWhite, l.label(IconBackup, l.tr("Backup to:"))),
+		l.colorize(ColorGreen+Bold, h.Backup))
+
+	fmt.Fprintf(&b, "%s %s\n",
+		l.colorize(ColorWhite, l.label("📦", l.tr("Versions:"))),
+		l.colorize(ColorYellow+Bold, fmt.Sprintf("%d", h.Versions)))
+
+	fmt.Fprintf(&b, "%s %s\n",
+		l.colorize(ColorWhite, l.label(IconWorker, l.tr("Workers:"))),
+		l.colorize(ColorMagenta+Bold, fmt.Sprintf("%d", h.Workers)))
+
+	fmt.Fprintf(&b, "%s %s\n",
+		l.colorize(ColorWhite, l.label(IconStats, l.tr("Repository:"))),
+		l.colorize(ColorCyan+Bold, fmt.Sprintf(l.tr("%d files, %d versions, %s"),
+			h.Files, h.StoredVersions, FormatSize(h.RepositorySize))))
+
+	last := l.tr("never")
+	if !h.LastBackup.IsZero() {
+		last = fmt.Sprintf(l.tr("%s (%s ago)"), h.LastBackup.Format(time.DateTime),
+			time.Since(h.LastBackup).Round(time.Second))
+	}
+	fmt.Fprintf(&b, "%s %s\n",
+		l.colorize(ColorWhite, l.label("🕒", l.tr("Last backup:"))),
+		l.colorize(ColorCyan+Bold, last))
+
+	if l.Plain {
+		fmt.Fprintln(&b, l.tr("Press Ctrl+C to stop watching and exit."))
+	} else {
+		fmt.Fprintln(&b, l.colorize(ColorGray, "\n"+"----------------------------------"))
+		fmt.Fprintln(&b, l.colorize(ColorYellow, l.tr("Press Ctrl+C to stop watching and exit.")))
+		fmt.Fprintln(&b, l.colorize(ColorGray, "----------------------------------\n"))
+	}
+
+	l.write(b.String())
 }
 
 func (l *Logger) Shutdown() {
-	fmt.Println(l.colorize(ColorYellow+Bold, "\n\n👋 Closing application..."))
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	var b strings.Builder
+
+	if l.Plain {
+		fmt.Fprintln(&b, prefixInfo+" "+l.tr("Closing application..."))
+	} else {
+		fmt.Fprintln(&b, l.colorize(ColorYellow+Bold, "\n\n👋 "+l.tr("Closing application...")))
+	}
+	fmt.Fprintln(&b, l.colorize(ColorGray, l.tr("Press Ctrl+C again to force exit.")))
+
+	l.write(b.String())
+}
+
+func (l *Logger) DrainProgress(remaining int, eta time.Duration) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+
+	estimate := l.tr("unknown")
+	if eta > 0 {
+		estimate = eta.Round(time.Second).String()
+	}
+
+	l.printf("%s%s %s %s\n",
+		l.timestamp(),
+		l.icon(ColorYellow, "⏳", prefixInfo),
+		l.colorize(ColorWhite, fmt.Sprintf(l.tr("%d jobs remaining,"), remaining)),
+		l.colorize(ColorCyan, fmt.Sprintf(l.tr("est. %s"), estimate)))
+}
+
+func (l *Logger) ForcedExit(remaining int) {
+	if !l.enabled(LevelError) {
+		return
+	}
+	l.printf("%s %s\n",
+		l.icon(ColorRed, IconError, prefixError),
+		l.colorize(ColorRed+Bold, fmt.Sprintf(l.tr("Forced exit, %d jobs abandoned"), remaining)))
 }
 
 func (l *Logger) ShutdownComplete(duration time.Duration) {
-	fmt.Printf("%s %s in %s\n",
-		l.colorize(ColorGreen, IconSuccess),
-		l.colorize(ColorGreen+Bold, "Application closed"),
-		l.colorize(ColorCyan, duration.Round(time.Millisecond).String()))
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.printf("%s %s %s\n",
+		l.icon(ColorGreen, IconSuccess, prefixSuccess),
+		l.colorize(ColorGreen+Bold, l.tr("Application closed")),
+		fmt.Sprintf(l.tr("in %s"), l.colorize(ColorCyan, duration.Round(time.Millisecond).String())))
 }
@@ -0,0 +1,278 @@
+package utils
+
+// polish translates the user-facing messages to Polish, keyed by their English text
+var polish = map[string]string{
+	// Logger labels
+	"New file:":          "Nowy plik:",
+	"Modified":           "Zmieniono",
+	"Renamed":            "Zmieniono nazwę",
+	"Deleted":            "Usunięto",
+	"Backup:":            "Kopia:",
+	"Skipped:":           "Pominięto:",
+	"Worker #%d →":       "Wątek #%d →",
+	"Statistics":         "Statystyki",
+	"Tracked files: %s":  "Śledzone pliki: %s",
+	"Queue: %s":          "Kolejka: %s",
+	"Active workers: %s": "Aktywne wątki: %s",
+	"Restarted workers: %s, lost workers: %s":          "Zrestartowane wątki: %s, utracone wątki: %s",
+	"Timed out jobs: %s, stalled workers replaced: %s": "Przekroczony czas zadań: %s, zastąpione zawieszone wątki: %s",
+	"Daily digest":               "Podsumowanie dnia",
+	"since %s":                   "od %s",
+	"Backed up: %s, written: %s": "Kopie: %s, zapisano: %s",
+	"%d versions":                "%d wersji",
+	"Errors: %s":                 "Błędy: %s",
+	"Space used: %s":             "Zajęte miejsce: %s",
+	"Most versioned:":            "Najwięcej wersji:",
+	"Backups/s: %s":              "Kopie/s: %s",
+	"Write rate: %s":             "Szybkość zapisu: %s",
+	"%s/s of %s/s (%.0f%%)":      "%s/s z %s/s (%.0f%%)",
+	"Monitoring:":                "Obserwowany katalog:",
+	"(recursive)":                "(rekurencyjnie)",
+	"Backup to:":                 "Kopie w:",
+	"Versions:":                  "Wersje:",
+	"Repository:":                "Repozytorium:",
+	"%d files, %d versions, %s":  "plików: %d, wersji: %d, %s",
+	"Last backup:":               "Ostatnia kopia:",
+	"never":                      "nigdy",
+	"%s (%s ago)":                "%s (%s temu)",
+	"Workers:":                   "Wątki:",
+	"Press Ctrl+C to stop watching and exit.": "Naciśnij Ctrl+C, aby zakończyć obserwowanie i wyjść.",
+	"Closing application...":                  "Zamykanie aplikacji...",
+	"Press Ctrl+C again to force exit.":       "Naciśnij ponownie Ctrl+C, aby wymusić wyjście.",
+	"unknown":                                 "nieznany",
+	"%d jobs remaining,":                      "pozostało zadań: %d,",
+	"est. %s":                                 "szac. %s",
+	"Forced exit, %d jobs abandoned":          "Wymuszone wyjście, porzucono zadań: %d",
+	"Application closed":                      "Aplikacja zamknięta",
+	"in %s":                                   "w %s",
+
+	// Skip reasons
+	"file vanished before backup":   "plik zniknął przed wykonaniem kopii",
+	"too soon since last backup":    "za wcześnie od poprzedniej kopii",
+	"excluded by the backup policy": "wykluczony przez politykę kopii",
+
+	// Errors
+	"Could not flush audit log: %v":                                             "Nie można zapisać dziennika audytu: %v",
+	"Could not list versions to prune: %v":                                      "Nie można wyświetlić wersji do usunięcia: %v",
+	"Could not measure backup size: %v":                                         "Nie można zmierzyć rozmiaru kopii: %v",
+	"Could not restore protection of %s: %v":                                    "Nie można przywrócić ochrony %s: %v",
+	"Could not send alert email: %v":                                            "Nie można wysłać e-maila z alertem: %v",
+	"Error from watcher: %v":                                                    "Błąd obserwatora: %v",
+	"PANIC in %s hook: %v":                                                      "PANIKA w haku %s: %v",
+	"PANIC in Worker #%d after it was replaced: %v":                             "PANIKA w wątku #%d po jego zastąpieniu: %v",
+	"PANIC in Worker #%d: %v (panic policy: crash)":                             "PANIKA w wątku #%d: %v (polityka paniki: crash)",
+	"PANIC in Worker #%d: %v, restarting worker (restart #%d)":                  "PANIKA w wątku #%d: %v, ponowne uruchomienie wątku (restart #%d)",
+	"PANIC in Worker #%d: %v, worker lost (%d of %d workers lost)":              "PANIKA w wątku #%d: %v, wątek utracony (utracono %d z %d wątków)",
+	"Webhook: could not encode events: %v":                                      "Webhook: nie można zakodować zdarzeń: %v",
+	"Kafka: dropped %d events, the queue was full":                              "Kafka: porzucono %d zdarzeń, kolejka była pełna",
+	"Kafka: dropped %d events after %d attempts: %v":                            "Kafka: porzucono %d zdarzeń po %d próbach: %v",
+	"Webhook: dropped %d events after %d attempts: %v":                          "Webhook: porzucono %d zdarzeń po %d próbach: %v",
+	"Worker #%d stalled on %s for %s, replacing it (%d stalled workers so far)": "Wątek #%d zawiesił się na %s na %s, zostaje zastąpiony (dotąd zawieszonych wątków: %d)",
+	"Worker #%d: %s timed out after %s":                                         "Wątek #%d: przekroczono czas dla %s po %s",
+	"Worker #%d: %s: %v":                                                        "Wątek #%d: %s: %v",
+	"Worker #%d: %v":                                                            "Wątek #%d: %v",
+	"watcher failed: %v":                                                        "obserwator zakończył się błędem: %v",
+	"failed to create backup directory: %v":                                     "nie można utworzyć katalogu kopii: %v",
+	"failed to create file watcher: %v":                                         "nie można utworzyć obserwatora plików: %v",
+	"backup pass interrupted":                                                   "przebieg kopii przerwany",
+	"backup pass failed: %v":                                                    "przebieg kopii nie powiódł się: %v",
+	"%d backups failed":                                                         "nieudanych kopii: %d",
+	"either --source or --target is required":                                   "wymagana jest opcja --source lub --target",
+	"restore failed: %w":                                                        "odtwarzanie nie powiodło się: %w",
+	"watcher stopped after an error: %v":                                        "obserwator zatrzymał się po błędzie: %v",
+
+	// Information
+	"	Removed old version: %s (%s)": "	Usunięto starą wersję: %s (%s)",
+	"	Removed old version: %s":      "	Usunięto starą wersję: %s",
+	"Add to backup queue: %s [%s]":  "Dodano do kolejki kopii: %s [%s]",
+	"Free space on the backup disk is above the minimum again, backups resume": "Wolne miejsce na dysku kopii znów przekracza minimum, kopie są wznawiane",
+	"New catalog: %s": "Nowy katalog: %s",
+	"Sweep checked %d files, nothing missed (%s)":                                 "Przegląd sprawdził %d plików, nic nie pominięto (%s)",
+	"Backup pass finished in %s: %d files, %d unchanged, %d backed up, %d failed": "Przebieg kopii zakończony w %s: plików %d, bez zmian %d, skopiowano %d, błędów %d",
+	"Restored %s → %s": "Przywrócono %s → %s",
+	"Watcher stopped":  "Obserwator zatrzymany",
+
+	// Warnings
+	"%d directories can not be watched and are polled instead":                                 "Katalogów, których nie można obserwować i które są odpytywane: %d",
+	"Abandoning %d queued backup jobs":                                                         "Porzucanie zadań w kolejce kopii: %d",
+	"Backups use %s, above the quota of %s, only the latest version of each file is left":      "Kopie zajmują %s, ponad limit %s, zostały tylko najnowsze wersje plików",
+	"%s has alternate streams that are not backed up: %s":                                      "%s ma alternatywne strumienie, które nie są kopiowane: %s",
+	"Could not back up the streams of %s: %v":                                                  "Nie można skopiować strumieni %s: %v",
+	"Could not remove the streams of %s: %v":                                                   "Nie można usunąć strumieni %s: %v",
+	"Could not restore the streams of %s: %v":                                                  "Nie można przywrócić strumieni %s: %v",
+	"Restored %d alternate streams of %s":                                                      "Przywrócono %d alternatywnych strumieni %s",
+	"Backups paused, changes are collected and backed up on resume":                            "Kopie wstrzymane, zmiany są zbierane i kopiowane po wznowieniu",
+	"Backups resumed after %s, queueing %d files changed meanwhile":                            "Kopie wznowione po %s, kolejkowanie %d plików zmienionych w tym czasie",
+	"%d files changed while paused are backed up at the next start":                            "%d plików zmienionych podczas wstrzymania zostanie skopiowanych przy następnym uruchomieniu",
+	"Backups are already paused":                                                               "Kopie są już wstrzymane",
+	"Backups are not paused":                                                                   "Kopie nie są wstrzymane",
+	"Could not queue the files changed while paused: %v":                                       "Nie można zakolejkować plików zmienionych podczas wstrzymania: %v",
+	"Backups paused for %s, %d files changed meanwhile":                                        "Kopie wstrzymane od %s, w tym czasie zmieniono %d plików",
+	"Version %s of %s is corrupt, its content does not match its checksum":                     "Wersja %s pliku %s jest uszkodzona, jej zawartość nie zgadza się z sumą kontrolną",
+	"Could not verify version %s of %s: %v":                                                    "Nie można zweryfikować wersji %s pliku %s: %v",
+	"Could not save where verification stopped: %v":                                            "Nie można zapisać miejsca zakończenia weryfikacji: %v",
+	"Verified %d of %d versions in %s: %d corrupt, %d unreadable":                              "Zweryfikowano %d z %d wersji w %s: %d uszkodzonych, %d nieczytelnych",
+	"Verified %d of %d versions (%s) in %s, every version is checked within %d runs":           "Zweryfikowano %d z %d wersji (%s) w %s, każda wersja jest sprawdzana w ciągu %d uruchomień",
+	"%d versions were stored without a checksum and only checked to be readable":               "%d wersji zapisano bez sumy kontrolnej, sprawdzono tylko ich czytelność",
+	"Verification failed: %v":                                                                  "Weryfikacja nie powiodła się: %v",
+	"verification interrupted":                                                                 "weryfikacja przerwana",
+	"verification failed: %v":                                                                  "weryfikacja nie powiodła się: %v",
+	"%d versions are corrupt or unreadable":                                                    "%d wersji jest uszkodzonych lub nieczytelnych",
+	"Could not open the control socket, the status and control commands are not available: %v": "Nie można otworzyć gniazda sterującego, polecenia status i control są niedostępne: %v",
+	"no watcher is running for %s":                                                             "żaden watcher nie działa dla %s",
+	"could not query the watcher: %v":                                                          "nie można odpytać watchera: %v",
+	"Watching %s %s %s, up %s (since %s)":                                                      "Obserwacja %s %s %s, działa %s (od %s)",
+	"Backups paused for %s":                                                                    "Kopie wstrzymane od %s",
+	"Queue: %d of %d, workers: %d active":                                                      "Kolejka: %d z %d, workery: %d aktywnych",
+	"Jobs: %d backed up, %d failed, %d skipped, %d dropped":                                    "Zadania: %d skopiowanych, %d nieudanych, %d pominiętych, %d odrzuconych",
+	"Backups paused": "Kopie wstrzymane",
+	"Backups resumed, %d files changed meanwhile are queued": "Kopie wznowione, dodano do kolejki plików zmienionych w międzyczasie: %d",
+	"All queued backups are done":                            "Wszystkie kopie z kolejki są gotowe",
+	"Watcher reloaded":                                       "Watcher przeładowany",
+	"Audit log reopened":                                     "Dziennik audytu otwarty ponownie",
+	"backup-now needs the path of a file or directory":       "backup-now wymaga ścieżki pliku lub katalogu",
+	"%d files queued for backup":                             "Plików dodanych do kolejki kopii: %d",
+	"Could not notify systemd: %v":                           "Nie można powiadomić systemd: %v",
+	"could not open the trace: %v":                           "nie można otworzyć śladu zdarzeń: %v",
+	"Replayed %d events over %s, %d removals, renames and permission changes left out":                                                 "Odtworzono %d zdarzeń z okresu %s, pominięto usunięcia, zmiany nazw i uprawnień: %d",
+	"Backed up %d times (%d files), %d ignored, %d skipped as too soon, %d skipped as too large, %d skipped by the policy, %d dropped": "Kopii: %d (plików: %d), zignorowanych: %d, pominiętych jako zbyt wczesne: %d, pominiętych jako zbyt duże: %d, pominiętych przez politykę: %d, odrzuconych: %d",
+	"Queue: at most %d jobs, longest wait %s, workers busy %s":                                                                         "Kolejka: najwyżej %d zadań, najdłuższe oczekiwanie %s, czas pracy workerów %s",
+	"Outcomes differing from the audit log:":                                                                                           "Wyniki różniące się od dziennika audytu:",
+	"failed to serve metrics: %v":                                                                                                      "nie można udostępnić metryk: %v",
+	"Worker #%d: retrying %d mirrors of %s (retry %d of %d)":                                                                           "Worker #%d: ponowienie %d kopii lustrzanych %s (ponowienie %d z %d)",
+	"Version %s is stored in %d of %d destinations: %s":                                                                                "Wersja %s jest zapisana w %d z %d miejsc docelowych: %s",
+	"Events (seen/queued):":                                                                                                            "Zdarzenia (widziane/w kolejce):",
+	"invalid --format: %s, expected tar.gz or zip":                                                                                     "nieprawidłowy --format: %s, oczekiwano tar.gz lub zip",
+	"--source is required, or --backup with --latest":                                                                                  "wymagany jest --source albo --backup z --latest",
+	"error creating output directory: %w":                                                                                              "błąd tworzenia katalogu docelowego: %w",
+	"error creating snapshot: %w":                                                                                                      "błąd tworzenia migawki: %w",
+	"snapshot failed: %w":                                                                                                              "migawka nie powiodła się: %w",
+	"Snapshot %s: %d files, %s, %s compressed":                                                                                         "Migawka %s: %d plików, %s, %s po kompresji",
+	"Ignore pattern hits:":                                                                                                             "Trafienia wzorców pomijania:",
+	"Include pattern hits:":                                                                                                            "Trafienia wzorców dołączania:",
+	"either --file or --all is required":                                                                                               "wymagany jest --file albo --all",
+	"--all restores every file, --file and --version can not be used with it":                                                          "--all odtwarza wszystkie pliki, nie można go łączyć z --file i --version",
+	"invalid --at: %v":                                                                                                                 "nieprawidłowy --at: %v",
+	"--all requires --at":                                                                                                              "--all wymaga --at",
+	"Restored %d files as of %s into %s, %d failed, %d left out with only newer versions":                                              "Odtworzono %d plików według stanu z %s do %s, %d nie powiodło się, %d pominięto, mają tylko nowsze wersje",
+	"Could not restore %s: %v":                                                                                                         "Nie można odtworzyć %s: %v",
+	"Not following %s again, it leads to a directory already walked":                                                                   "%s nie jest śledzony ponownie, prowadzi do katalogu już przejrzanego",
+	"current":                             "bieżący",
+	"either --to or --source is required": "wymagany jest --to albo --source",
+	"No differences":                      "Brak różnic",
+	"Files differ, they are binary, larger than %s or too different to show line by line:": "Pliki się różnią, są binarne, większe niż %s lub zbyt różne, by pokazać je wiersz po wierszu:",
+	"failed to serve the web UI: %v":                                                     "nie można udostępnić interfejsu WWW: %v",
+	"Web UI at http://%s":                                                                "Interfejs WWW pod adresem http://%s",
+	"Trying new rules, changes they decide differently are logged":                       "Próba nowych reguł, zmiany ocenione przez nie inaczej są logowane",
+	"Rules tried discarded":                                                              "Próbowane reguły odrzucone",
+	"Rules tried promoted: %d would have been skipped, %d backed up in addition":         "Próbowane reguły wprowadzone: %d zmian zostałoby pominiętych, %d dodatkowo skopiowanych",
+	"Rules tried would skip %s":                                                          "Próbowane reguły pominęłyby %s",
+	"Rules tried would back up %s":                                                       "Próbowane reguły skopiowałyby %s",
+	"Trying the rules, they are promoted in %s":                                          "Próba reguł, zostaną wprowadzone za %s",
+	"Trying the rules, promote them with control promote-rules":                          "Próba reguł, wprowadź je poleceniem control promote-rules",
+	"No rules are tried":                                                                 "Żadne reguły nie są próbowane",
+	"Rules tried promoted":                                                               "Próbowane reguły wprowadzone",
+	"Trying rules for %s: %d changes would be skipped, %d backed up in addition":         "Próba reguł od %s: %d zmian zostałoby pominiętych, %d dodatkowo skopiowanych",
+	"Rules tried are promoted at %s":                                                     "Próbowane reguły zostaną wprowadzone o %s",
+	"Worker #%d: %s is still %s, giving up after %d tries":                               "Worker #%d: %s jest nadal %s, rezygnacja po %d próbach",
+	"Worker #%d: backup of %s deferred, %s, %s":                                          "Worker #%d: kopia %s odłożona, %s, %s",
+	"%d backups deferred, the files were locked":                                         "%d kopii odłożonych, pliki były zablokowane",
+	"--grpc-cert and --grpc-key must be given together":                                  "--grpc-cert i --grpc-key muszą być podane razem",
+	"failed to serve the gRPC API: %v":                                                   "nie udało się uruchomić API gRPC: %v",
+	"gRPC API at %s, without a token anyone who can connect manages the watcher":         "API gRPC na %s, bez tokenu każdy, kto może się połączyć, zarządza obserwatorem",
+	"gRPC API at %s":                                                                     "API gRPC na %s",
+	"--%s can not be combined with --profiles, set it in the profiles":                   "--%s nie może być użyte razem z --profiles, ustaw je w profilach",
+	"profile %s: %v":                                                                     "profil %s: %v",
+	"profiles %s and %s use the same backup directory":                                   "profile %s i %s używają tego samego katalogu kopii",
+	"Running %d profiles":                                                                "Uruchamianie profili: %d",
+	"Profile %s stopped: %v":                                                             "Profil %s zatrzymany: %v",
+	"Could not index stored content: %v":                                                 "Nie udało się zindeksować przechowywanej zawartości: %v",
+	"Could not link %s to stored content: %v":                                            "Nie udało się dowiązać %s do przechowywanej zawartości: %v",
+	"Content of %s is stored already, linked to %s":                                      "Zawartość %s jest już przechowywana, dowiązano do %s",
+	"Permissions changed: %s":                                                            "Zmieniono uprawnienia: %s",
+	"created empty, backed up once written":                                              "utworzony pusty, kopia po pierwszym zapisie",
+	"Could not check for other watchers of the source directory: %v":                     "Nie udało się sprawdzić innych obserwatorów katalogu źródłowego: %v",
+	"Another watcher watches this source directory, every change is backed up twice: %s": "Inny obserwator obserwuje ten katalog źródłowy, każda zmiana jest kopiowana dwa razy: %s",
+	"%v, --allow-multiple starts anyway":                                                 "%v, --allow-multiple uruchamia mimo to",
+	"Permissions of %d files recorded for auditing (%s)":                                 "Zapisano uprawnienia %d plików do audytu (%s)",
+	"Permissions of %s changed: %s":                                                      "Zmieniono uprawnienia %s: %s",
+	"Backups: %s succeeded, %s failed, %s dropped":                                       "Kopie: %s udanych, %s nieudanych, %s odrzuconych",
+	"Written: %s, %s per backup on average":                                              "Zapisano: %s, średnio %s na kopię",
+	"%d events/min":                                                                      "%d zdarzeń/min",
+	"queue %d/%d":                                                                        "kolejka %d/%d",
+	"last backup %s %s ago":                                                              "ostatnia kopia %s %s temu",
+	"no backup yet":                                                                      "jeszcze bez kopii",
+	"%d failed":                                                                          "%d nieudanych",
+	"paused":                                                                             "wstrzymane",
+	"--foreground-summary can not be used in a profile, the status lines would overwrite each other": "--foreground-summary nie może być użyte w profilu, wiersze stanu nadpisywałyby się nawzajem",
+	"No statistics kept, see --stats-history":                                                        "Statystyki nie są przechowywane, zobacz --stats-history",
+	"%s  queue %d/%d, workers %d, +%d backed up, +%d failed, +%d dropped, +%s written":               "%s  kolejka %d/%d, wątki %d, +%d zapisanych kopii, +%d nieudanych, +%d odrzuconych, +%s zapisano",
+	"Could not watch %s, trying again in %s: %v":                                                     "Nie można obserwować %s, ponowna próba za %s: %v",
+	"Not watching %s anymore, it was removed":                                                        "Koniec obserwowania %s, katalog został usunięty",
+	"Watching %s after %d failed attempts":                                                           "Obserwowanie %s po %d nieudanych próbach",
+	"Queued %d files of %s changed while it was not watched":                                         "Dodano do kolejki %d plików z %s zmienionych, gdy nie był obserwowany",
+	"Directories not watched yet, tried again:":                                                      "Katalogi jeszcze nieobserwowane, ponawiane próby:",
+	"%d attempts, next in %s: %s":                                                                    "%d prób, następna za %s: %s",
+	"%d directories could not be watched yet, they are tried again":                                  "%d katalogów nie udało się jeszcze obserwować, próby są ponawiane",
+	"Run summary":                   "Podsumowanie działania",
+	"%s (%d versions)":              "%s (wersje: %d)",
+	"Could not emit the digest: %v": "Nie można wysłać podsumowania: %v",
+	"%s is unchanged, linked to %s": "%s nie zmienił się, połączono z %s",
+	"Could not link %s to its latest version, copying unchanged files from now on: %v":                 "Nie można połączyć %s z najnowszą wersją, niezmienione pliki będą odtąd kopiowane: %v",
+	"Waiting for the backup of %s in progress":                                                         "Oczekiwanie na trwającą kopię %s",
+	"Could not add %s to the date links directory: %v":                                                 "Nie można dodać %s do katalogu dat: %v",
+	"Could not prune the date links directory: %v":                                                     "Nie można oczyścić katalogu dat: %v",
+	"Removed %s from the date links directory":                                                         "Usunięto %s z katalogu dat",
+	"%s: dropped %d files, they are pushed with their next change":                                     "%s: porzucono %d plików, zostaną wysłane przy następnej zmianie",
+	"%s: could not push %d files, trying again with the next ones: %v":                                 "%s: nie można wysłać %d plików, ponowna próba z kolejnymi: %v",
+	"%s: pushed %d files to %s":                                                                        "%s: wysłano %d plików do %s",
+	"Worker #%d: %s still changes, giving up after %d tries":                                           "Worker #%d: %s nadal się zmienia, rezygnacja po %d próbach",
+	"Could not move the history of %s to %s: %v":                                                       "Nie można przenieść historii %s do %s: %v",
+	"Moved %s to %s, its versions moved along":                                                         "Przeniesiono %s do %s, jego wersje zostały przeniesione razem z nim",
+	"Could not restore the times and permissions of %s: %v":                                            "Nie można przywrócić czasów i uprawnień %s: %v",
+	"Profiles %s and %s watch the same source directory, files matched by both are backed up by both":  "Profile %s i %s obserwują ten sam katalog źródłowy, pliki pasujące do obu są kopiowane przez oba",
+	"The source of profile %s is inside the source of %s, files matched by both are backed up by both": "Katalog źródłowy profilu %s leży wewnątrz katalogu źródłowego %s, pliki pasujące do obu są kopiowane przez oba",
+	"the backup directory of profile %s is inside the source of profile %s":                            "katalog kopii profilu %s leży wewnątrz katalogu źródłowego profilu %s",
+	"drop needs the ID of a job, see queue inspect":                                                    "drop wymaga ID zadania, zobacz queue inspect",
+	"invalid job ID: %s":                         "nieprawidłowe ID zadania: %s",
+	"Job %d dropped, it is not backed up":        "Zadanie %d usunięte, plik nie zostanie skopiowany",
+	"No jobs are queued":                         "Brak zadań w kolejce",
+	"(running)":                                  "(w toku)",
+	"Skipping %s, it was dropped from the queue": "Pomijanie %s, usunięto go z kolejki",
+	"Dropped job %d from the queue":              "Usunięto zadanie %d z kolejki",
+	"Web UI at http://%s, not on a loopback address and without a token anyone who can connect restores files": "Interfejs WWW pod adresem http://%s, nie na adresie pętli zwrotnej i bez tokenu każdy, kto może się połączyć, przywraca pliki",
+	"Backup policy failed for %s, backing it up: %v":                                                           "Polityka kopii nie powiodła się dla %s, kopia zostanie wykonana: %v",
+	"%d added, %d modified, %d deleted":                                                                        "%d dodanych, %d zmienionych, %d usuniętych",
+	"Errors:":                                                                                                  "Błędy:",
+	"Recent backups:":                                                                                          "Ostatnie kopie:",
+	"Could not read ACL of %s: %v":                                                                             "Nie można odczytać ACL %s: %v",
+	"Could not restore ACL of %s: %v":                                                                          "Nie można przywrócić ACL %s: %v",
+	"No ACL recorded for %s, %s keeps its current one":                                                         "Brak zapisanej ACL dla %s, %s zachowuje obecną",
+	"Cleared protection of %s, it is set again after the restore":                                              "Zdjęto ochronę %s, zostanie przywrócona po odtworzeniu",
+	"Could not close audit log: %v":                                                                            "Nie można zamknąć dziennika audytu: %v",
+	"Could not close event sink: %v":                                                                           "Nie można zamknąć odbiorcy zdarzeń: %v",
+	"Could not prune %s: %v":                                                                                   "Nie można usunąć %s: %v",
+	"Could not read the backup repository: %v":                                                                 "Nie można odczytać repozytorium kopii: %v",
+	"Could not read watcher state, starting without it: %v":                                                    "Nie można odczytać stanu obserwatora, start bez niego: %v",
+	"Could not save watcher state: %v":                                                                         "Nie można zapisać stanu obserwatora: %v",
+	"Could not open queue journal, queued jobs are lost on a crash: %v":                                        "Nie można otworzyć dziennika kolejki, zadania w kolejce zostaną utracone po awarii: %v",
+	"Could not write queue journal: %v":                                                                        "Nie można zapisać dziennika kolejki: %v",
+	"Could not close queue journal: %v":                                                                        "Nie można zamknąć dziennika kolejki: %v",
+	"Queueing %d backup jobs left over from the last run":                                                      "Kolejkowanie zadań kopii pozostałych z poprzedniego uruchomienia: %d",
+	"The backup directory %s is inside the source directory, it is neither watched nor backed up":              "Katalog kopii %s leży wewnątrz katalogu źródłowego, nie jest obserwowany ani kopiowany",
+	"Source directory %s was removed, watching resumes when it is back":                                        "Katalog źródłowy %s został usunięty, obserwowanie zostanie wznowione, gdy wróci",
+	"Could not watch the source directory again: %v":                                                           "Nie można ponownie obserwować katalogu źródłowego: %v",
+	"Source directory %s is back, watching it again":                                                           "Katalog źródłowy %s wrócił, jest znów obserwowany",
+	"Reconciliation scan checked %d files, %d queued for backup (%s)":                                          "Skanowanie uzgadniające sprawdziło %d plików, do kopii dodano %d (%s)",
+	"Worker #%d: retrying %s (retry %d of %d)":                                                                 "Wątek #%d: ponowna próba %s (%d z %d)",
+	"%d files could not be backed up, they are retried on their next change":                                   "Plików, których nie udało się skopiować: %d, kolejna próba przy ich następnej zmianie",
+	"Could not read %s: %v":                                                                                    "Nie można odczytać %s: %v",
+	"Could not record version in manifest: %v":                                                                 "Nie można zapisać wersji w manifeście: %v",
+	"Could not write audit log: %v":                                                                            "Nie można zapisać dziennika audytu: %v",
+	"Post-backup command failed for %s: %v":                                                                    "Polecenie po kopii nie powiodło się dla %s: %v",
+	"Pruned %d old versions to stay within the %s":                                                             "Usunięto starych wersji: %d, aby zmieścić się w: %s",
+	"Queue full, skipping backup for: %s":                                                                      "Kolejka pełna, pominięto kopię: %s",
+	"Sweep found %d changes missed by the file system watcher in %d files (%s)":                                "Przegląd znalazł %d zmian pominiętych przez obserwatora systemu plików w %d plikach (%s)",
+	"Worker #%d returned from %s after it was replaced, exiting":                                               "Wątek #%d wrócił z %s po zastąpieniu, kończy pracę",
+	"Could not watch %s, %s. Directories above the limit are polled every %s, which is slower and misses short-lived files. %s": "Nie można obserwować %s, %s. Katalogi ponad limit są odpytywane co %s, co jest wolniejsze i pomija krótko istniejące pliki. %s",
+}
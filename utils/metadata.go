@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// PreserveMetadata copies the permissions, access and modification times,
+// ownership (when running as root) and extended attributes of src onto dst.
+// info must describe src. All steps are attempted even if one of them fails,
+// the returned error joins every failure.
+func PreserveMetadata(src, dst string, info os.FileInfo) error {
+	var errs []error
+
+	// Extended attributes first, a read-only mode would forbid writing them
+	if err := copyXattrs(src, dst); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Ownership before the mode, chown clears the setuid and setgid bits
+	if err := preserveOwnership(dst, info); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := os.Chmod(dst, info.Mode()&os.ModePerm|info.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Times go last, changing ownership or attributes must not bump them again
+	if err := os.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time is not portable
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// preserveOwnership is not supported on this platform
+func preserveOwnership(dst string, info os.FileInfo) error {
+	return nil
+}
+
+// copyXattrs is not supported on this platform
+func copyXattrs(src, dst string) error {
+	return nil
+}
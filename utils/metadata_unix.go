@@ -0,0 +1,96 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// preserveOwnership copies the owner and group of a file, only root is allowed to do so
+func preserveOwnership(dst string, info os.FileInfo) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+}
+
+// copyXattrs copies all extended attributes of src to dst, except ACLs and
+// resource forks. They are kept separately and only restored on request, see
+// ReadACL and ListStreams.
+func copyXattrs(src, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return fmt.Errorf("listing extended attributes: %w", err)
+	}
+
+	var errs []error
+	for _, name := range names {
+		if name == "system.posix_acl_access" || name == "com.apple.ResourceFork" {
+			continue
+		}
+
+		value, err := getXattr(src, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading extended attribute %s: %w", name, err))
+			continue
+		}
+
+		if err := unix.Setxattr(dst, name, value, 0); err != nil {
+			errs = append(errs, fmt.Errorf("writing extended attribute %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// listXattrs returns the names of all extended attributes of a file
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of a single extended attribute
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
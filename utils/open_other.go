@@ -0,0 +1,18 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isSharingViolation is always false, sharing violations only exist on Windows
+func isSharingViolation(err error) bool {
+	return false
+}
+
+// isNoSpace reports whether err means the file system or the user's quota is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes of disk space for f without changing its
+// size, contiguous when possible. File systems without F_PREALLOCATE support
+// are left to allocate as the copy goes.
+func preallocate(f *os.File, size int64) error {
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if err != nil && !isNoSpace(err) {
+		// Not enough contiguous space, any will do
+		store.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	}
+	if err != nil && !isNoSpace(err) {
+		return nil
+	}
+	return err
+}
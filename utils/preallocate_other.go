@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package utils
+
+import "os"
+
+// preallocate does nothing, there is no portable way to reserve disk space
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// preallocate reserves size bytes of disk space for f by setting its
+// allocation size, which unlike SetEndOfFile leaves its size alone
+func preallocate(f *os.File, size int64) error {
+	info := struct{ AllocationSize int64 }{size}
+	err := windows.SetFileInformationByHandle(windows.Handle(f.Fd()), windows.FileAllocationInfo,
+		(*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err != nil && !isNoSpace(err) {
+		// Not supported by every file system, the copy allocates as it goes
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Protection describes the attributes that keep an existing file from being overwritten
+type Protection struct {
+	ReadOnly  bool   // The owner write permission (or the Windows read-only attribute) is not set
+	Immutable bool   // The file is immutable or append-only (chattr +i/+a, chflags uchg/schg)
+	flags     uint32 // Platform immutability flags that are set, restored by ApplyProtection
+}
+
+// Protected reports whether any attribute forbids overwriting the file
+func (p Protection) Protected() bool {
+	return p.ReadOnly || p.Immutable
+}
+
+// FileProtection returns the protection attributes of path,
+// a file that does not exist is not protected
+func FileProtection(path string) (Protection, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Protection{}, nil
+	}
+	if err != nil {
+		return Protection{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Protection{}, nil
+	}
+
+	flags, err := immutableFlags(path)
+	if err != nil {
+		return Protection{}, err
+	}
+
+	return Protection{
+		ReadOnly:  info.Mode().Perm()&0200 == 0,
+		Immutable: flags != 0,
+		flags:     flags,
+	}, nil
+}
+
+// ProtectedError explains why path can not be overwritten and how to fix it.
+// It wraps ErrProtected.
+func ProtectedError(path string, p Protection) error {
+	if p.Immutable {
+		return fmt.Errorf("%w: %s is immutable, clear it with '%s' or rerun as root with --clear-protection",
+			ErrProtected, path, immutableHint(path))
+	}
+	return fmt.Errorf("%w: %s is read-only, make it writable (chmod u+w, attrib -r on Windows) or rerun with --clear-protection",
+		ErrProtected, path)
+}
+
+// ClearProtection removes the attributes described by p so path can be
+// overwritten. Clearing immutability usually requires root privileges.
+func ClearProtection(path string, p Protection) error {
+	if p.Immutable {
+		if err := setImmutableFlags(path, p.flags, false); err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				return fmt.Errorf("%w: clearing the immutable attribute of %s requires root privileges",
+					ErrProtected, path)
+			}
+			return fmt.Errorf("error clearing immutable attribute: %w", err)
+		}
+	}
+
+	if p.ReadOnly {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, info.Mode()|0200); err != nil {
+			return fmt.Errorf("error clearing read-only attribute: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyProtection sets the attributes described by p on path again,
+// typically after it was overwritten following ClearProtection
+func ApplyProtection(path string, p Protection) error {
+	if p.ReadOnly {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, info.Mode()&^0222); err != nil {
+			return fmt.Errorf("error setting read-only attribute: %w", err)
+		}
+	}
+
+	// Immutability last, nothing can be changed afterwards
+	if p.Immutable {
+		if err := setImmutableFlags(path, p.flags, true); err != nil {
+			return fmt.Errorf("error setting immutable attribute: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// immutableMask selects the user and system immutable and append-only flags, see chflags(1)
+const immutableMask = unix.UF_IMMUTABLE | unix.UF_APPEND | unix.SF_IMMUTABLE | unix.SF_APPEND
+
+// immutableFlags returns the immutable and append-only file flags set on path
+func immutableFlags(path string) (uint32, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Flags & immutableMask, nil
+	}
+	return 0, nil
+}
+
+// setImmutableFlags sets or clears the given file flags, the system flags require root
+func setImmutableFlags(path string, flags uint32, on bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	var current uint32
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		current = stat.Flags
+	}
+
+	if on {
+		current |= flags
+	} else {
+		current &^= flags
+	}
+
+	if err := unix.Chflags(path, int(current)); err != nil {
+		return &os.PathError{Op: "chflags", Path: path, Err: err}
+	}
+	return nil
+}
+
+func immutableHint(path string) string {
+	return "chflags nouchg,noschg,nouappnd,nosappnd " + path
+}
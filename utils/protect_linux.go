@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode flags from linux/fs.h, see chattr(1)
+const (
+	fsImmutableFl = 0x00000010
+	fsAppendFl    = 0x00000020
+)
+
+// immutableFlags returns the immutable and append-only inode flags set on path.
+// File systems without inode flags report none.
+func immutableFlags(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err == unix.ENOTTY || err == unix.EOPNOTSUPP || err == unix.EINVAL {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return flags & (fsImmutableFl | fsAppendFl), nil
+}
+
+// setImmutableFlags sets or clears the given inode flags, requires CAP_LINUX_IMMUTABLE
+func setImmutableFlags(path string, flags uint32, on bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	current, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+
+	if on {
+		current |= flags
+	} else {
+		current &^= flags
+	}
+
+	if err := unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, int(current)); err != nil {
+		return &os.PathError{Op: "setflags", Path: path, Err: err}
+	}
+	return nil
+}
+
+func immutableHint(path string) string {
+	return "chattr -i -a " + path
+}
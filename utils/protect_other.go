@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package utils
+
+// immutableFlags reports no flags, only the read-only attribute is supported here
+func immutableFlags(path string) (uint32, error) {
+	return 0, nil
+}
+
+func setImmutableFlags(path string, flags uint32, on bool) error {
+	return nil
+}
+
+// immutableHint is never used, files are not reported immutable here
+func immutableHint(path string) string {
+	return ""
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size such as "512", "64KB" or "5GB".
+// Units are binary, 1KB is 1024 bytes. An empty string is zero.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			factor = unit.factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which no longer fits
+	bytes := value * float64(factor)
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size too large: %q", s)
+	}
+	return int64(bytes), nil
+}
+
+// FormatSize formats a number of bytes for humans, e.g. "1.5 MB"
+func FormatSize(bytes int64) string {
+	for _, unit := range sizeUnits[:4] {
+		if bytes >= unit.factor {
+			return fmt.Sprintf("%.1f %s", float64(bytes)/float64(unit.factor), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
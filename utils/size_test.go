@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"512", 512, true},
+		{"64KB", 64 << 10, true},
+		{"1.5m", 3 << 19, true},
+		{"8EB", 0, false},
+		{"-1K", 0, false},
+		{"nan", 0, false},
+		{"inf", 0, false},
+		{"+Inf GB", 0, false},
+		{"1e30GB", 0, false},
+		{"8388608TB", 0, false}, // 2^63 bytes
+		{"8388607TB", 8388607 << 40, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package utils
+
+import "os"
+
+// openSource opens a file for reading
+func openSource(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// dropCache does nothing, there is no portable way to advise the page cache
+func dropCache(f *os.File, offset, length int64) {}
@@ -0,0 +1,48 @@
+package utils
+
+// Sparse files. VM images and databases are often mostly holes, regions never
+// written that take no space. Copied byte by byte the holes become zeros on
+// disk and a 100 GB image with 2 GB of data takes 100 GB in every version.
+// CopyFile keeps the holes of sparse sources instead: holes the file system
+// reports (SEEK_DATA and SEEK_HOLE on Linux) are skipped without reading them,
+// and blocks of zeros read elsewhere are seeked over in the copy rather than
+// written. The copy gets its size by truncation, so trailing holes stay too.
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"os"
+)
+
+// zeroBlock is compared against to find blocks of zeros
+var zeroBlock [32 * 1024]byte
+
+// isZero reports whether b holds only zeros
+func isZero(b []byte) bool {
+	for len(b) > 0 {
+		n := min(len(b), len(zeroBlock))
+		if !bytes.Equal(b[:n], zeroBlock[:n]) {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
+
+// skipHole moves dst over a hole of length bytes, which reads as zeros, and
+// feeds the zeros to h when it is not nil
+func skipHole(dst *os.File, h hash.Hash, length int64) error {
+	if _, err := dst.Seek(length, io.SeekCurrent); err != nil {
+		return err
+	}
+	if h == nil {
+		return nil
+	}
+	for length > 0 {
+		n := min(length, int64(len(zeroBlock)))
+		h.Write(zeroBlock[:n])
+		length -= n
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// isSparse reports whether the file of info takes fewer blocks than its size
+// needs, so it has holes
+func isSparse(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < info.Size()
+}
+
+// nextData reports the rest of f from offset as data, holes are only found
+// as blocks of zeros
+func nextData(f *os.File, offset, size int64) (int64, int64) {
+	return offset, max(size, offset)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isSparse reports whether the file of info takes fewer blocks than its size
+// needs, so it has holes
+func isSparse(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < info.Size()
+}
+
+// nextData returns the start and the end of the first region of data of f at
+// or after offset, and leaves f at its start. Past the last one both are the
+// end of the file. File systems without hole support report all data.
+func nextData(f *os.File, offset, size int64) (int64, int64) {
+	fd := int(f.Fd())
+
+	start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		// Only a hole is left
+		if end, err := f.Seek(0, io.SeekEnd); err == nil {
+			return end, end
+		}
+	}
+	if err != nil {
+		f.Seek(offset, io.SeekStart)
+		return offset, max(size, offset)
+	}
+
+	end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+	if err != nil {
+		end = max(size, start)
+	}
+	f.Seek(start, io.SeekStart)
+	return start, end
+}
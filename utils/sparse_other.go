@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "os"
+
+// isSparse reports false, the holes of a file are not known
+func isSparse(info os.FileInfo) bool {
+	return false
+}
+
+// nextData reports the rest of f from offset as data
+func nextData(f *os.File, offset, size int64) (int64, int64) {
+	return offset, max(size, offset)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ListStreams returns "rsrc" when a file has a non-empty resource fork
+func ListStreams(path string) ([]string, error) {
+	info, err := os.Stat(StreamPath(path, "rsrc"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return []string{"rsrc"}, nil
+}
+
+// StreamPath returns the path opening the named fork of a file
+func StreamPath(path, name string) string {
+	return filepath.Join(path, "..namedfork", name)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+
+package utils
+
+// ListStreams returns nil, files only have a single stream on this platform
+func ListStreams(path string) ([]string, error) {
+	return nil, nil
+}
+
+// StreamPath returns "", streams can not be opened on this platform
+func StreamPath(path, name string) string {
+	return ""
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procFindFirstStreamW = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = windows.NewLazySystemDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// ListStreams returns the names of the alternate data streams of a file,
+// e.g. Zone.Identifier, without the unnamed main stream
+func ListStreams(path string) ([]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		// No streams at all, e.g. on FAT, or a file system without stream support
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) || errors.Is(err, windows.ERROR_INVALID_PARAMETER) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+
+	var names []string
+	for {
+		// Names look like ":Zone.Identifier:$DATA", the main stream is "::$DATA"
+		name := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if name != "" {
+			names = append(names, name)
+		}
+
+		ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+				return names, nil
+			}
+			return names, err
+		}
+	}
+}
+
+// StreamPath returns the path opening the stream name of a file
+func StreamPath(path, name string) string {
+	return path + ":" + name
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Throttle limits the number of file operations and bytes per second across
+// every copy that shares it. A nil *Throttle does not limit anything.
+type Throttle struct {
+	ops   *rateLimiter // nil when operations are not limited
+	bytes *rateLimiter // nil when bytes are not limited
+
+	opsMeter   rateMeter
+	bytesMeter rateMeter
+}
+
+// NewThrottle creates a throttle allowing opsPerSec file operations and
+// bytesPerSec bytes per second, zero disables the respective limit.
+// It returns nil when neither limit is set.
+func NewThrottle(opsPerSec float64, bytesPerSec int64) *Throttle {
+	if opsPerSec <= 0 && bytesPerSec <= 0 {
+		return nil
+	}
+
+	t := &Throttle{}
+	if opsPerSec > 0 {
+		t.ops = newRateLimiter(opsPerSec, max(opsPerSec, 1))
+	}
+	if bytesPerSec > 0 {
+		// Allow one copy buffer worth of burst, so small limits still make progress
+		t.bytes = newRateLimiter(float64(bytesPerSec), max(float64(bytesPerSec), 32*1024))
+	}
+	return t
+}
+
+// WaitOp blocks until another file operation is allowed or ctx is canceled
+func (t *Throttle) WaitOp(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.opsMeter.add(1)
+	if t.ops != nil {
+		return t.ops.wait(ctx, 1)
+	}
+	return nil
+}
+
+// WaitBytes blocks until n more bytes may be transferred or ctx is canceled
+func (t *Throttle) WaitBytes(ctx context.Context, n int) error {
+	if t == nil || n <= 0 {
+		return nil
+	}
+
+	t.bytesMeter.add(int64(n))
+	if t.bytes != nil {
+		return t.bytes.wait(ctx, float64(n))
+	}
+	return nil
+}
+
+// Reader wraps r so every read counts against the byte limit.
+// Reads fail with the context error once ctx is canceled.
+func (t *Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, t: t}
+}
+
+// ThrottleUsage is a snapshot of the current throughput and the configured limits
+type ThrottleUsage struct {
+	OpsPerSec        float64 // Operations per second over the last few seconds
+	OpsLimit         float64 // Configured operations per second, 0 when unlimited
+	BytesPerSec      float64 // Bytes per second over the last few seconds
+	BytesLimit       float64 // Configured bytes per second, 0 when unlimited
+	OpsUtilization   float64 // OpsPerSec as a fraction of OpsLimit, 0 when unlimited
+	BytesUtilization float64 // BytesPerSec as a fraction of BytesLimit, 0 when unlimited
+}
+
+// Usage returns the current throughput and how much of the limits it uses
+func (t *Throttle) Usage() ThrottleUsage {
+	if t == nil {
+		return ThrottleUsage{}
+	}
+
+	usage := ThrottleUsage{
+		OpsPerSec:   t.opsMeter.rate(),
+		BytesPerSec: t.bytesMeter.rate(),
+	}
+	if t.ops != nil {
+		usage.OpsLimit = t.ops.rate
+		usage.OpsUtilization = usage.OpsPerSec / usage.OpsLimit
+	}
+	if t.bytes != nil {
+		usage.BytesLimit = t.bytes.rate
+		usage.BytesUtilization = usage.BytesPerSec / usage.BytesLimit
+	}
+	return usage
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *Throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if waitErr := tr.t.WaitBytes(tr.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
+
+// rateLimiter is a token bucket shared by concurrent callers.
+// Tokens may go negative, callers then sleep until the debt is paid back,
+// which keeps large requests fair to small ones.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64 // Maximum number of tokens saved up
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, sleeping as long as needed to stay within the rate.
+// The tokens are given back when ctx is canceled while sleeping.
+func (l *rateLimiter) wait(ctx context.Context, n float64) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= n
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	if err := Sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		l.tokens += n
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// meterWindow is the number of one second buckets a rateMeter averages over
+const meterWindow = 5
+
+// rateMeter measures a per-second rate over the last few seconds
+type rateMeter struct {
+	mu      sync.Mutex
+	buckets [meterWindow]int64
+	seconds [meterWindow]int64 // Unix second each bucket belongs to
+}
+
+func (m *rateMeter) add(n int64) {
+	now := time.Now().Unix()
+	i := now % meterWindow
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seconds[i] != now {
+		m.seconds[i] = now
+		m.buckets[i] = 0
+	}
+	m.buckets[i] += n
+}
+
+// rate returns the average per second over the last complete seconds
+func (m *rateMeter) rate() float64 {
+	now := time.Now().Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for i := range m.buckets {
+		age := now - m.seconds[i]
+		if age >= 1 && age <= meterWindow-1 {
+			total += m.buckets[i]
+		}
+	}
+	return float64(total) / float64(meterWindow-1)
+}
@@ -3,101 +3,349 @@ package watcher
 // BackupManager handles creating and managing file backup with versioning.
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cpprian/file-watcher-backup/config"
 	"github.com/cpprian/file-watcher-backup/utils"
 )
 
 // BackupManager handles creating and managing file backup with versioning.
 type BackupManager struct {
-	backupDir   string        // Directory where backup are stored
-	maxVersions int           // Maximum number of versions to keep, the oldest are deleted
-	logger      *utils.Logger // Logger instance for logging events
+	backupDir       string          // Directory where backup are stored
+	maxVersions     int             // Maximum number of versions to keep, the oldest are deleted
+	logger          *utils.Logger   // Logger instance for logging events
+	throttle        *utils.Throttle // Global rate limit shared by all copies, nil for none
+	readLimit       int64           // Bytes per second read from each source file, 0 for unlimited
+	dropCache       bool            // Evict copied files from the page cache
+	reflink         bool            // Clone files instead of copying them where the file system supports it
+	copyStreams     bool            // Store alternate streams and resource forks with each version
+	contents        *contentIndex   // Stored content by checksum for de-duplication, nil when off
+	linkUnchanged   bool            // Hard link versions of unchanged files to their latest version, see linkToLatest
+	linkUnsupported atomic.Bool     // Set once linking failed, the backup directory does not support hard links
+	verifyCopies    bool            // Check copies against the source and the written version, see verifyCopy
+	streamsSeen     sync.Map        // Files reported for streams that were not copied
+	index           *versionIndex   // Cached version lists, avoids listing directories on every backup
+	fileLocks       keyedMutex      // Held by version directory while a version of its file is created
+	naming          string          // Version naming mode, see config.VersionNaming
+	dateLayout      bool            // Versions are stored in the date layout, see datelayout.go
+	newID           IDGenerator     // Generates the stable IDs of new versions
+
+	maxTotalSize int64        // Quota of all stored versions in bytes, 0 for none
+	totalSize    atomic.Int64 // Estimated size of all stored versions
+	totalKnown   atomic.Bool  // Set once totalSize was counted
+	pruneMu      sync.Mutex   // Only one global prune at a time
+	indexKey     []byte       // Key encrypting version manifests, nil for plain text
+	host         string       // Name of this host, recorded with every version
 }
 
 // NewBackupManager initializes a new BackupManager
-func NewBackupManager(backupDir string, maxVersions int) *BackupManager {
-	return &BackupManager{
+func NewBackupManager(backupDir string, maxVersions int, logger *utils.Logger) *BackupManager {
+	host, _ := os.Hostname()
+	bm := &BackupManager{
 		backupDir:   backupDir,
 		maxVersions: maxVersions,
-		logger:      utils.NewLogger(true, true),
+		logger:      logger,
+		naming:      config.NamingMicrosecond,
+		newID:       utils.NewULID,
+		host:        host,
+		dateLayout:  readLayout(backupDir) == config.LayoutDate,
 	}
+	bm.index = newVersionIndex(bm.scanVersions)
+	return bm
+}
+
+// CreateBackup creates a timestamped backup of the specified file and returns its path.
+// Canceling ctx aborts the copy.
+func (bm *BackupManager) CreateBackup(ctx context.Context, sourcePath, sourceDir string) (string, error) {
+	return bm.createBackup(ctx, sourcePath, sourceDir, nil)
 }
 
-// CreateBackup creates a timestamped backup of the specified file
-func (bm *BackupManager) CreateBackup(sourcePath, sourceDir string) error {
+// createBackup is CreateBackup, with replicate copying the new version at
+// src elsewhere, as backupPath, before it is recorded and old versions are
+// pruned. When replicate fails the new version is removed again and its
+// error returned.
+func (bm *BackupManager) createBackup(ctx context.Context, sourcePath, sourceDir string, replicate func(src, backupPath string) error) (string, error) {
 	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		return fmt.Errorf("source file does not exist: %s", sourcePath)
+		return "", utils.NewBackupError(sourcePath, utils.OpStatSource, err)
 	}
 
 	relPath, err := filepath.Rel(sourceDir, sourcePath)
 	if err != nil {
-		return fmt.Errorf("error while calculating relative path: %w", err)
+		return "", fmt.Errorf("error while calculating relative path: %w", err)
+	}
+
+	// Versions of a file are created one at a time
+	key := bm.fileKey(relPath)
+	unlock := bm.fileLocks.tryLock(key)
+	if unlock == nil {
+		bm.logger.Debug("Waiting for the backup of %s in progress", relPath)
+		if unlock, err = bm.fileLocks.lock(ctx, key); err != nil {
+			return "", fmt.Errorf("error waiting for the backup in progress: %w", err)
+		}
 	}
+	defer unlock()
+
+	created := time.Now()
+
+	fileVersionDir, fileName := bm.newVersionDir(relPath, created)
+	ext := filepath.Ext(fileName)
+	nameWithoutExt := strings.TrimSuffix(fileName, ext)
 
-	timestamp := time.Now().Format("20060102_150405.000000")
+	if err := bm.index.ensureDir(fileVersionDir); err != nil {
+		return "", fmt.Errorf("error while creating directory version: %w",
+			utils.NewBackupError(fileVersionDir, utils.OpCreateDir, err))
+	}
 
-	ext := filepath.Ext(relPath)
-	nameWithoutExt := strings.TrimSuffix(filepath.Base(relPath), ext)
-	backupName := fmt.Sprintf("%s_%s%s", nameWithoutExt, timestamp, ext)
+	var seq int
+	if bm.naming == config.NamingSequence {
+		lastSeq := func() (int, error) { return bm.lastSequence(fileVersionDir, fileName) }
+		if seq, err = bm.index.nextSequence(fileVersionDir, lastSeq); err != nil {
+			return "", fmt.Errorf("error numbering version: %w",
+				utils.NewBackupError(fileVersionDir, utils.OpCreateDir, err))
+		}
+	}
 
-	fileVersionDir := filepath.Join(bm.backupDir, relPath+"_versions")
+	backupName := versionName(bm.naming, nameWithoutExt, ext, created, seq)
+	if bm.dateLayout {
+		// The day is the version, the file keeps its name
+		backupName = fileName
+	}
+	// Hidden from listings until complete: hash names are only known once the
+	// content is copied, and in the date layout the version of the day is
+	// only replaced once the new one is stored everywhere
+	staged := bm.naming == config.NamingHash || bm.dateLayout
+	if staged {
+		backupName = "." + backupName + ".partial"
+	}
 	backupPath := filepath.Join(fileVersionDir, backupName)
 
-	if err := os.MkdirAll(fileVersionDir, 0755); err != nil {
-		return fmt.Errorf("error while creating directory version: %w", err)
+	entry := manifestEntry{
+		ID:      bm.newID(created),
+		Version: backupName,
+		Time:    created,
+		Seq:     seq,
+		Host:    bm.host,
+	}
+
+	linked := bm.linkToLatest(ctx, sourcePath, relPath, backupPath, &entry)
+	if !linked {
+		copyOpts := utils.CopyOptions{
+			MaxRetries:    3,
+			Throttle:      bm.throttle,
+			MaxReadPerSec: bm.readLimit,
+			DropCache:     bm.dropCache,
+			Hash:          sha256.New(),
+			Clone:         bm.reflink,
+		}
+		before, _ := os.Stat(sourcePath)
+		if err := utils.CopyFile(ctx, sourcePath, backupPath, copyOpts); err != nil {
+			// The version directory may have been removed by someone else
+			if errors.Is(err, os.ErrNotExist) {
+				bm.index.forgetDir(fileVersionDir)
+			}
+			if staged {
+				os.Remove(backupPath)
+			}
+			return "", fmt.Errorf("error copying file: %w", err)
+		}
+		entry.SHA256 = hex.EncodeToString(copyOpts.Hash.Sum(nil))
+
+		if err := bm.verifyCopy(ctx, sourcePath, backupPath, before, entry.SHA256); err != nil {
+			os.Remove(backupPath)
+			return "", fmt.Errorf("error verifying copy: %w", err)
+		}
+	}
+
+	var stored bool
+	if bm.naming == config.NamingHash {
+		stored, err = bm.nameByHash(backupPath, nameWithoutExt, ext, entry.SHA256)
+		if err != nil {
+			os.Remove(backupPath)
+			return "", fmt.Errorf("error naming version: %w",
+				utils.NewBackupError(backupPath, utils.OpWrite, err))
+		}
+		// Content stored already takes no additional space
+		linked = linked || stored
+		backupName = hashVersionName(nameWithoutExt, ext, entry.SHA256)
+		backupPath = filepath.Join(fileVersionDir, backupName)
+		entry.Version = backupName
+	}
+
+	finalPath := backupPath
+	if bm.dateLayout {
+		finalPath = filepath.Join(fileVersionDir, fileName)
 	}
 
-	if err := utils.SafeCopyFile(sourcePath, backupPath, 3); err != nil {
-		return fmt.Errorf("error copying file: %w", err)
+	if err := bm.backupStreams(ctx, sourcePath, finalPath); err != nil {
+		bm.logger.Warning("Could not back up the streams of %s: %v", sourcePath, err)
+	}
+
+	// Not recorded yet, a version the mirrors miss leaves nothing behind
+	if replicate != nil {
+		if err := replicate(backupPath, finalPath); err != nil {
+			if !stored {
+				removeStreams(finalPath)
+				os.Remove(backupPath)
+			}
+			return "", err
+		}
+	}
+
+	replaced := false
+	if bm.dateLayout {
+		replaced = fileExists(finalPath)
+		if err := bm.replaceDayVersion(backupPath, finalPath); err != nil {
+			os.Remove(backupPath)
+			return "", fmt.Errorf("error storing version: %w",
+				utils.NewBackupError(finalPath, utils.OpWrite, err))
+		}
+		backupName = fileName
+		backupPath = finalPath
+		entry.Version = backupName
 	}
 
 	bm.logger.BackupCreated(filepath.Base(sourcePath), backupName)
 
-	if err := bm.cleanOldVersions(fileVersionDir, nameWithoutExt, ext); err != nil {
-		return fmt.Errorf("error cleaning old versions: %w", err)
+	if entry.ACL, err = utils.ReadACL(sourcePath); err != nil {
+		bm.logger.Warning("Could not read ACL of %s: %v", sourcePath, err)
+	}
+	if !linked {
+		linked = bm.dedupVersion(backupPath, filepath.ToSlash(relPath), &entry)
+	}
+	if err := bm.appendManifest(fileVersionDir, entry); err != nil {
+		bm.logger.Warning("Could not record version in manifest: %v", err)
+	} else if replaced {
+		// Only the entry of the latest version of the day is kept
+		if err := bm.compactDay(fileVersionDir); err != nil {
+			bm.logger.Warning("Could not update the manifest of %s: %v", fileVersionDir, err)
+		}
 	}
 
-	return nil
-}
+	// Linked versions take no additional space
+	var size int64
+	if info, err := os.Stat(backupPath); err == nil && !linked {
+		size = info.Size()
+	}
 
-// cleanOldVersions remove old versions exceeding maxVersions
-func (bm *BackupManager) cleanOldVersions(dir, baseName, ext string) error {
-	pattern := filepath.Join(dir, fmt.Sprintf("%s_*%s", baseName, ext))
-	matches, err := filepath.Glob(pattern)
+	freed, err := bm.cleanOldVersions(key, nameWithoutExt, ext, backupPath)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("error cleaning old versions: %w",
+			utils.NewBackupError(fileVersionDir, utils.OpCleanup, err))
+	}
+
+	bm.enforceQuota(size - freed)
+
+	return backupPath, nil
+}
+
+// nameByHash renames the new version at tmp after its checksum sum. When a
+// version with the same content exists already, it is kept as it is and tmp
+// removed; its new manifest entry makes it the newest version. It reports
+// whether the content was stored already.
+func (bm *BackupManager) nameByHash(tmp, baseName, ext, sum string) (bool, error) {
+	path := filepath.Join(filepath.Dir(tmp), hashVersionName(baseName, ext, sum))
+	if _, err := os.Lstat(path); err == nil {
+		return true, os.Remove(tmp)
 	}
+	return false, os.Rename(tmp, path)
+}
 
-	if len(matches) <= bm.maxVersions {
-		return nil
+// replaceDayVersion puts the version staged at staged in place of the
+// version of its day at path, see config.LayoutDate
+func (bm *BackupManager) replaceDayVersion(staged, path string) error {
+	if bm.contents != nil {
+		// The content of the replaced version is gone, nothing may be linked to it
+		if entries, _ := bm.readManifest(filepath.Dir(path)); entries != nil {
+			bm.contents.forget(entries[filepath.Base(path)].SHA256, path)
+		}
 	}
+	return os.Rename(staged, path)
+}
 
-	sort.Strings(matches)
+// cleanOldVersions records the new version of the file with the key key, see
+// fileKey, and removes old versions exceeding maxVersions. It returns the
+// number of bytes freed.
+func (bm *BackupManager) cleanOldVersions(key, baseName, ext, newVersion string) (int64, error) {
+	excess, err := bm.index.add(key, baseName, ext, newVersion, bm.maxVersions)
+	if err != nil {
+		return 0, err
+	}
 
-	toRemove := len(matches) - bm.maxVersions
-	for i := range toRemove {
-		if err := os.Remove(matches[i]); err != nil {
-			return err
+	var freed int64
+	for _, path := range excess {
+		if info, err := os.Stat(path); err == nil {
+			freed += info.Size()
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return freed, err
+		}
+		if err := removeStreams(path); err != nil {
+			bm.logger.Warning("Could not remove the streams of %s: %v", filepath.Base(path), err)
+		}
+		bm.logger.Info("	Removed old version: %s", bm.versionNameOf(path))
+
+		// The days of the date layout have a manifest of their own
+		if bm.dateLayout {
+			if err := bm.compactDay(filepath.Dir(path)); err != nil {
+				return freed, err
+			}
 		}
-		bm.logger.Info("	Removed old version: %s", filepath.Base(matches[i]))
 	}
 
-	return nil
+	if len(excess) > 0 && !bm.dateLayout {
+		return freed, bm.compactManifest(key, bm.index.list(key))
+	}
+	return freed, nil
 }
 
-// GetVersionCount returns the number of backup versions for a given file
-func (bm *BackupManager) GetVersionCount(baseName, ext string) (int, error) {
-	pattern := filepath.Join(bm.backupDir, fmt.Sprintf("%s_*%s", baseName, ext))
-	matches, err := filepath.Glob(pattern)
+// IsCurrent reports whether the latest version of a file has the size and the
+// modification time of info, so backing it up again would store the same content.
+// relPath is the path of the file relative to the watched source directory.
+func (bm *BackupManager) IsCurrent(relPath string, info os.FileInfo) bool {
+	versions, err := bm.Versions(relPath)
+	if err != nil || len(versions) == 0 {
+		return false
+	}
+
+	latest, err := os.Stat(versions[len(versions)-1])
 	if err != nil {
-		return 0, err
+		return false
 	}
 
-	return len(matches), nil
+	// Versions keep the modification time of the file they were copied from,
+	// linked versions the one of the version they are linked to
+	if latest.Size() != info.Size() {
+		return false
+	}
+	if latest.ModTime().Equal(info.ModTime()) {
+		return true
+	}
+	entries, _ := bm.readManifest(filepath.Dir(versions[len(versions)-1]))
+	return entries[latest.Name()].ModTime.Equal(info.ModTime())
+}
+
+// IDGenerator returns a new, unique version ID for a version created at t
+type IDGenerator func(t time.Time) string
+
+// SetIDGenerator replaces how version IDs are generated, ULIDs by default.
+// utils.NewUUID generates random UUIDs instead.
+func (bm *BackupManager) SetIDGenerator(fn IDGenerator) {
+	bm.newID = fn
+}
+
+// GetVersionCount returns the number of backup versions for a given file at
+// the top of the watched directory
+func (bm *BackupManager) GetVersionCount(baseName, ext string) (int, error) {
+	versions, err := bm.Versions(baseName + ext)
+	return len(versions), err
 }
@@ -0,0 +1,91 @@
+package watcher
+
+// Provenance of restored files. Restored data handed to auditors has lost
+// where it came from: a restore with RestoreOptions.BackupInfo writes a
+// sidecar next to every restored file, naming the version, when the file
+// was backed up and changed, its checksum and the host it was backed up on.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupInfoSuffix is appended to the path of a restored file to name its sidecar
+const BackupInfoSuffix = ".backupinfo.json"
+
+// BackupInfo describes the version a file was restored from
+type BackupInfo struct {
+	File     string    `json:"file"`               // Relative to the watched directory, with forward slashes
+	Version  string    `json:"version"`            // Version name, as accepted by Restore
+	ID       string    `json:"id,omitempty"`       // Stable ID of the version, empty for versions made before IDs existed
+	BackedUp time.Time `json:"backed_up,omitzero"` // When the version was created
+	ModTime  time.Time `json:"mtime"`              // Modification time of the file when it was backed up
+	Mode     string    `json:"mode"`               // Permissions of the file when it was backed up
+	Size     int64     `json:"size"`               // Size in bytes
+	SHA256   string    `json:"sha256"`             // Checksum of the content, hex encoded
+	Host     string    `json:"host,omitempty"`     // Host the file was backed up on, empty for versions made before hosts were recorded
+	Origin   string    `json:"origin,omitempty"`   // File the content was first stored for, see dedup
+	Restored time.Time `json:"restored"`           // When the file was restored
+}
+
+// writeBackupInfo writes the sidecar of target, restored from the version at
+// versionPath of the file relPath
+func (bm *BackupManager) writeBackupInfo(relPath, versionPath, target string, entry manifestEntry) error {
+	f, err := os.Open(versionPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	info := BackupInfo{
+		File:     filepath.ToSlash(relPath),
+		Version:  bm.versionNameOf(versionPath),
+		ID:       entry.ID,
+		BackedUp: entry.Time,
+		ModTime:  stat.ModTime(),
+		Mode:     stat.Mode().Perm().String(),
+		Size:     stat.Size(),
+		SHA256:   entry.SHA256,
+		Host:     entry.Host,
+		Origin:   entry.Origin,
+		Restored: time.Now(),
+	}
+	if !entry.ModTime.IsZero() {
+		// A linked version has the modification time of the content it is linked to
+		info.ModTime = entry.ModTime
+	}
+	if entry.Mode != 0 {
+		info.Mode = entry.Mode.Perm().String()
+	}
+	if info.BackedUp.IsZero() {
+		info.BackedUp, _ = parseVersionTime(filepath.Base(relPath), info.Version)
+	}
+	if info.SHA256 == "" {
+		// Made before checksums were recorded
+		sum := sha256.New()
+		if _, err := io.Copy(sum, f); err != nil {
+			return err
+		}
+		info.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(target+BackupInfoSuffix, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing backup info: %w", err)
+	}
+	return nil
+}
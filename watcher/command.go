@@ -0,0 +1,61 @@
+package watcher
+
+// External commands run before and after each backup, e.g. for custom
+// uploads, virus scans or notifications.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runCommand runs a shell command line with the job described in environment
+// variables: BACKUP_SOURCE, BACKUP_DEST (empty before the backup) and EVENT_TYPE.
+// BACKUP_BANDWIDTH_LIMIT holds the bytes per second uploads should stay below,
+// 0 for unlimited. The command is killed after timeout, 0 disables the timeout,
+// or when ctx is canceled.
+func runCommand(ctx context.Context, cmdline string, timeout time.Duration, job BackupJob, backupPath string, bandwidthLimit int64) error {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", cmdline)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+	}
+
+	cmd.Env = append(os.Environ(),
+		"BACKUP_SOURCE="+job.FilePath,
+		"BACKUP_DEST="+backupPath,
+		"EVENT_TYPE="+job.EventType,
+		"BACKUP_BANDWIDTH_LIMIT="+strconv.FormatInt(bandwidthLimit, 10),
+	)
+	// Don't wait forever for children that inherited the output pipe
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if parent.Err() != nil {
+		return fmt.Errorf("command aborted: %w", parent.Err())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %s", timeout)
+	}
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+
+	return nil
+}
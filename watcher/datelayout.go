@@ -0,0 +1,353 @@
+package watcher
+
+// Date layout of the backup directory, see config.LayoutDate. A version is
+// stored as <YYYY-MM-DD>/<path of the file>, so the directory of a day holds
+// the files backed up that day under their own names and can be copied as it
+// is. A file has one version per day, a later backup on the same day
+// replaces it. The name of a version is its day. Paths are stored as in the
+// file layout, see encodePath, and names that would be taken for the files
+// of the layout, e.g. a file named .manifest, get their dot encoded. Every
+// directory of a day has a manifest of the versions in it. The layout is
+// recorded in the backup directory, so commands reading it need no option.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// layoutMarkerName records the layout of a backup directory not in the file layout
+const layoutMarkerName = ".layout"
+
+// dayLayout names the day directories, of the date layout and of the date links directory
+const dayLayout = "2006-01-02"
+
+// readLayout returns the layout the backup directory dir is written in
+func readLayout(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, layoutMarkerName))
+	if err != nil {
+		return config.LayoutFile
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// useLayout makes sure the backup directory is written in layout. A backup
+// directory without versions takes any layout, one with versions keeps its own.
+func (bm *BackupManager) useLayout(layout string) error {
+	current := readLayout(bm.backupDir)
+	if current == layout {
+		return nil
+	}
+
+	if current != config.LayoutFile {
+		return fmt.Errorf("backup directory %s uses the %s layout", bm.backupDir, current)
+	}
+	found := errors.New("found")
+	if _, err := bm.QueryVersions(VersionQuery{}, func(VersionInfo) error { return found }); err != nil {
+		if errors.Is(err, found) {
+			return fmt.Errorf("backup directory %s holds versions in the %s layout", bm.backupDir, current)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(bm.backupDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(bm.backupDir, layoutMarkerName), []byte(layout+"\n"), 0644); err != nil {
+		return fmt.Errorf("error recording the layout: %w", err)
+	}
+	bm.dateLayout = layout == config.LayoutDate
+	return nil
+}
+
+// encodeDatePath returns how the path p, with forward slashes, is stored in a day
+func encodeDatePath(p string) string {
+	parts := strings.Split(encodePath(p), "/")
+	for i, part := range parts {
+		if isLayoutName(part) {
+			parts[i] = "%2E" + part[1:]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// isLayoutName reports whether name in a day is one of the files of the
+// layout: a manifest, the streams of versions or a version being written
+func isLayoutName(name string) bool {
+	switch name {
+	case manifestName, manifestName + ".tmp", streamsDirName:
+		return true
+	}
+	return strings.HasPrefix(name, ".") &&
+		(strings.HasSuffix(name, ".partial") || strings.HasSuffix(name, ".dedup"))
+}
+
+// days returns the day directories of the backup directory, oldest first
+func (bm *BackupManager) days() ([]string, error) {
+	entries, err := os.ReadDir(bm.backupDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var days []string
+	for _, entry := range entries {
+		if _, err := time.Parse(dayLayout, entry.Name()); err == nil && entry.IsDir() {
+			days = append(days, entry.Name())
+		}
+	}
+	return days, nil
+}
+
+// dayTime returns when a version of day was created, for versions missing
+// from the manifest: the start of the day
+func dayTime(day string) time.Time {
+	t, _ := time.ParseInLocation(dayLayout, day, time.Local)
+	return t
+}
+
+// listDateVersions returns the paths of the versions of the file stored as
+// storedRel in the days, oldest first
+func (bm *BackupManager) listDateVersions(storedRel string) ([]string, error) {
+	days, err := bm.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []storedVersion
+	for _, day := range days {
+		versionPath := filepath.Join(bm.backupDir, day, filepath.FromSlash(storedRel))
+		if info, err := os.Lstat(versionPath); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		// Unreadable manifests only cost the time of the day's version
+		manifest, _ := bm.readManifest(filepath.Dir(versionPath))
+		created := manifest[filepath.Base(versionPath)].Time
+		if created.IsZero() {
+			created = dayTime(day)
+		}
+		found = append(found, storedVersion{name: day, created: created})
+	}
+	sortVersions(found)
+
+	paths := make([]string, len(found))
+	for i, v := range found {
+		paths[i] = filepath.Join(bm.backupDir, v.name, filepath.FromSlash(storedRel))
+	}
+	return paths, nil
+}
+
+// compactDay drops the manifest entries of the versions no longer in dir, a
+// directory of a day, and removes dir and the directories above it in the
+// day once they are empty
+func (bm *BackupManager) compactDay(dir string) error {
+	entries, err := bm.readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var kept []manifestEntry
+	for name, entry := range entries {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) > 0 {
+		sort.Slice(kept, func(i, j int) bool { return kept[i].Version < kept[j].Version })
+		return bm.writeManifest(dir, kept)
+	}
+
+	if err := os.Remove(filepath.Join(dir, manifestName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Removing stops at the first directory still holding something
+	for dir != bm.backupDir && os.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+// moveDateHistory is MoveHistory in the date layout, the version of every
+// day is moved within its day
+func (bm *BackupManager) moveDateHistory(oldRel, newRel string) error {
+	if versions, _ := bm.Versions(newRel); len(versions) > 0 {
+		return fmt.Errorf("%s has versions already", newRel)
+	}
+	versions, err := bm.Versions(oldRel)
+	if err != nil {
+		return fmt.Errorf("error listing versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("%s has no versions", oldRel)
+	}
+
+	for _, version := range versions {
+		day := bm.versionNameOf(version)
+		moved := bm.versionPath(newRel, day)
+		oldDir, newDir := filepath.Dir(version), filepath.Dir(moved)
+
+		entries, err := bm.readManifest(oldDir)
+		if err != nil {
+			return fmt.Errorf("error reading manifest: %w", err)
+		}
+		entry, ok := entries[filepath.Base(version)]
+		if !ok {
+			entry = manifestEntry{Time: dayTime(day)}
+		}
+		if entry.MovedFrom == "" {
+			entry.MovedFrom = filepath.ToSlash(oldRel)
+		}
+		entry.Version = filepath.Base(moved)
+
+		if err := os.MkdirAll(newDir, 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(version, moved); err != nil {
+			return fmt.Errorf("error moving version %s: %w", day, err)
+		}
+		if streams := streamsDir(version); fileExists(streams) {
+			os.MkdirAll(filepath.Dir(streamsDir(moved)), 0755)
+			os.Rename(streams, streamsDir(moved))
+		}
+
+		if err := bm.appendManifest(newDir, entry); err != nil {
+			return err
+		}
+		if err := bm.compactDay(oldDir); err != nil {
+			bm.logger.Warning("Could not update the manifest of %s: %v", oldDir, err)
+		}
+	}
+
+	bm.index.forgetDir(bm.fileKey(oldRel))
+	bm.index.forgetDir(bm.fileKey(newRel))
+	return nil
+}
+
+// fileExists reports whether something exists at path
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// walkDays visits the directory key of all days at once, as if the days
+// were laid over each other, so the versions of a file are visited in a row
+// like in the file layout. onCursor is set while key is a prefix of the
+// cursor key.
+func (w *versionWalk) walkDays(days []string, key []string, onCursor bool) error {
+	// Where each name is a file and where a directory, it may be both on different days
+	type found struct {
+		fileDays, dirDays []string
+	}
+	names := make(map[string]*found)
+
+	relDir := filepath.FromSlash(path.Join(key...))
+	for _, day := range days {
+		entries, err := os.ReadDir(filepath.Join(w.bm.backupDir, day, relDir))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Removed by retention while we were walking
+				continue
+			}
+			return err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if isLayoutName(name) {
+				continue
+			}
+			f := names[name]
+			if f == nil {
+				f = &found{}
+				names[name] = f
+			}
+			switch {
+			case entry.IsDir():
+				f.dirDays = append(f.dirDays, day)
+			case entry.Type().IsRegular():
+				f.fileDays = append(f.fileDays, day)
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	// Manifests of the directory key, by day, read when first needed
+	manifests := make(map[string]map[string]manifestEntry)
+	manifest := func(day string) map[string]manifestEntry {
+		m, ok := manifests[day]
+		if !ok {
+			// Unreadable manifests only cost precision and IDs
+			m, _ = w.bm.readManifest(filepath.Join(w.bm.backupDir, day, relDir))
+			manifests[day] = m
+		}
+		return m
+	}
+
+	depth := len(key)
+	for _, name := range sorted {
+		childOnCursor := false
+		if onCursor {
+			switch {
+			case name < w.after[depth]:
+				continue
+			case name == w.after[depth]:
+				childOnCursor = depth+1 < len(w.after)
+			}
+		}
+
+		childKey := append(key[:depth:depth], name)
+		storedRel := path.Join(childKey...)
+		plain := decodePath(storedRel)
+		f := names[name]
+
+		// A name can be a file on one day and a directory on another, its
+		// versions come first. The cursor is among them when it ends there.
+		inDir := childOnCursor && len(w.after) > depth+2
+		if len(f.fileDays) > 0 && !inDir && strings.HasPrefix(plain, w.query.Prefix) {
+			var versions []versionCandidate
+			for _, day := range f.fileDays {
+				entry := manifest(day)[name]
+				created := entry.Time
+				if created.IsZero() {
+					created = dayTime(day)
+				}
+				versions = append(versions, versionCandidate{
+					storedVersion: storedVersion{name: day, created: created},
+					path:          filepath.Join(w.bm.backupDir, day, filepath.FromSlash(storedRel)),
+					entry:         entry,
+				})
+			}
+			if err := w.emit(childKey, plain, versions, childOnCursor); err != nil {
+				return err
+			}
+		}
+
+		// Only descend where files matching the prefix can be
+		if len(f.dirDays) == 0 ||
+			(!strings.HasPrefix(plain+"/", w.query.Prefix) && !strings.HasPrefix(w.query.Prefix, plain+"/")) {
+			continue
+		}
+		if err := w.walkDays(f.dirDays, childKey, inDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
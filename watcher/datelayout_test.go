@@ -0,0 +1,120 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// storeDay stores content as the version of relPath of an earlier day
+func storeDay(t *testing.T, bm *BackupManager, day, relPath, content string) {
+	t.Helper()
+	path := bm.versionPath(relPath, day)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	created := dayTime(day).Add(12 * time.Hour)
+	if err := bm.appendManifest(filepath.Dir(path), manifestEntry{Version: filepath.Base(path), Time: created}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDateLayout(t *testing.T) {
+	bm, source := newTestManager(t)
+	if err := bm.useLayout(config.LayoutDate); err != nil {
+		t.Fatal(err)
+	}
+	storeDay(t, bm, "2024-06-01", "docs/notes.txt", "june")
+	storeDay(t, bm, "2024-06-02", "docs/.manifest", "not a manifest")
+
+	if err := os.MkdirAll(filepath.Join(source, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A later backup the same day replaces the version of the day
+	backupVersions(t, bm, source, []string{"docs/notes.txt", "docs/.manifest"}, 2)
+
+	today := time.Now().Format(dayLayout)
+	stored, err := os.ReadFile(filepath.Join(bm.backupDir, today, "docs", "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != "docs/notes.txt version 1" {
+		t.Errorf("version of today holds %q", stored)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(bm.backupDir, today, "docs", manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(manifest), "\n"); lines != 2 {
+		t.Errorf("manifest of today has %d entries, want 2", lines)
+	}
+
+	versions, err := bm.Versions("docs/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || bm.versionNameOf(versions[0]) != "2024-06-01" || bm.versionNameOf(versions[1]) != today {
+		t.Fatalf("got versions %v", versions)
+	}
+
+	target := filepath.Join(t.TempDir(), "notes.txt")
+	if _, err := bm.Restore(context.Background(), "docs/notes.txt", "2024-06-01", target, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if restored, _ := os.ReadFile(target); string(restored) != "june" {
+		t.Errorf("restored %q from 2024-06-01", restored)
+	}
+
+	// Pages of one version each visit every version once, in order
+	var got []string
+	q := VersionQuery{Prefix: "docs/", Limit: 1}
+	for {
+		page, err := bm.ListVersions(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range page.Versions {
+			got = append(got, v.Path+"@"+v.Version)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	want := []string{
+		"docs/.manifest@2024-06-02", "docs/.manifest@" + today,
+		"docs/notes.txt@2024-06-01", "docs/notes.txt@" + today,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// Retention removes the oldest day, and the day once it is empty
+	bm.maxVersions = 1
+	backupVersions(t, bm, source, []string{"docs/notes.txt"}, 1)
+	if _, err := os.Stat(filepath.Join(bm.backupDir, "2024-06-01")); !os.IsNotExist(err) {
+		t.Errorf("emptied day kept: %v", err)
+	}
+	if versions, _ := bm.Versions("docs/notes.txt"); len(versions) != 1 {
+		t.Errorf("got %d versions after retention, want 1", len(versions))
+	}
+
+	// A backup directory holding versions keeps its layout
+	if err := bm.useLayout(config.LayoutFile); err == nil {
+		t.Error("layout of a backup directory in use changed")
+	}
+}
@@ -0,0 +1,107 @@
+package watcher
+
+// Date links, a view of the versions by day. Versions are stored per file in
+// the backup directory, which makes finding "everything from Tuesday" a
+// query. With config.DateLinks every new version is also linked to
+// <date links>/<YYYY-MM-DD>/<path of the file>, so the versions of a day can
+// be copied as a directory. It is not a layout of the backup directory: only
+// the last version of a file per day is linked, and nothing reads the links
+// back. Links take no space while the version is kept, retention does not
+// touch them; config.DateLinksDays removes days.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// dateLinks tracks when the date links directory was last pruned
+type dateLinks struct {
+	mu     sync.Mutex
+	pruned string // Day the old days were last removed on
+}
+
+// addToDateLinks links the version at backupPath of the file relPath, with
+// forward slashes, into the date links directory under the current day.
+// Failures are only logged, the version is stored in the backup directory either way.
+func (fw *FileWatcher) addToDateLinks(ctx context.Context, backupPath, relPath string) {
+	if fw.config.DateLinks == "" {
+		return
+	}
+
+	day := time.Now().Format(dayLayout)
+	fw.pruneDateLinks(day)
+
+	dst := filepath.Join(fw.config.DateLinks, day, filepath.FromSlash(relPath))
+	if err := linkOrCopy(ctx, backupPath, dst); err != nil {
+		fw.logger.Warning("Could not add %s to the date links directory: %v", relPath, err)
+	}
+}
+
+// linkOrCopy replaces dst by a hard link to src, or by a copy when src is on
+// another file system
+func linkOrCopy(ctx context.Context, src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// Replaced atomically, a day never misses a file it had
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".partial")
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		if err := utils.CopyFile(ctx, src, tmp, utils.CopyOptions{MaxRetries: 3, Clone: true}); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// pruneDateLinks removes the days older than config.DateLinksDays, once a day
+func (fw *FileWatcher) pruneDateLinks(today string) {
+	if fw.config.DateLinksDays <= 0 {
+		return
+	}
+
+	fw.dateLinks.mu.Lock()
+	defer fw.dateLinks.mu.Unlock()
+
+	if fw.dateLinks.pruned == today {
+		return
+	}
+	fw.dateLinks.pruned = today
+
+	entries, err := os.ReadDir(fw.config.DateLinks)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fw.logger.Warning("Could not prune the date links directory: %v", err)
+		}
+		return
+	}
+
+	oldest := time.Now().AddDate(0, 0, -fw.config.DateLinksDays+1).Format(dayLayout)
+	for _, entry := range entries {
+		name := entry.Name()
+		// Only day directories, anything else in the date links directory is not ours
+		if !entry.IsDir() || name >= oldest {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, name); err != nil {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(fw.config.DateLinks, name)); err != nil {
+			fw.logger.Warning("Could not prune the date links directory: %v", err)
+			continue
+		}
+		fw.logger.Info("Removed %s from the date links directory", name)
+	}
+}
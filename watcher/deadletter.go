@@ -0,0 +1,141 @@
+package watcher
+
+// Dead-letter list. Jobs that still failed after their retries are kept here,
+// one entry per file, so persistent failures stay visible in the statistics
+// and can be retried once the cause is fixed. A later successful backup of the
+// file removes its entry.
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+)
+
+// EventRetry is the event type of jobs queued by RetryDeadLetters
+const EventRetry = "RETRY"
+
+// maxDeadLetters bounds the list, the oldest entries are dropped beyond it
+const maxDeadLetters = 1000
+
+// DeadLetter is a file whose backup failed
+type DeadLetter struct {
+	Path      string    `json:"path"`     // Path of the source file
+	EventType string    `json:"event"`    // Event of the last failed job
+	Failed    time.Time `json:"failed"`   // When the last job failed
+	Failures  int       `json:"failures"` // Failed jobs since the last successful backup
+	Error     string    `json:"error"`    // Error kind of the last failure, see utils.ErrorLabel
+	Reason    string    `json:"reason"`   // Error message of the last failure
+}
+
+// deadLetters holds the dead-letter list, keyed by path
+type deadLetters struct {
+	mu      sync.Mutex
+	entries map[string]*DeadLetter
+}
+
+// update adds or removes the entry of a file according to the outcome of its job
+func (d *deadLetters) update(job BackupJob, entry audit.Entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch entry.Outcome {
+	case audit.OutcomeBackedUp:
+		delete(d.entries, job.FilePath)
+
+	case audit.OutcomeFailed:
+		if d.entries == nil {
+			d.entries = make(map[string]*DeadLetter)
+		}
+
+		letter, ok := d.entries[job.FilePath]
+		if !ok {
+			if len(d.entries) >= maxDeadLetters {
+				d.dropOldest()
+			}
+			letter = &DeadLetter{Path: job.FilePath}
+			d.entries[job.FilePath] = letter
+		}
+		letter.EventType = job.EventType
+		letter.Failed = entry.Time
+		letter.Failures++
+		letter.Error = entry.Error
+		letter.Reason = entry.Reason
+	}
+}
+
+// dropOldest removes the entry that failed longest ago, d.mu must be held
+func (d *deadLetters) dropOldest() {
+	var oldest *DeadLetter
+	for _, letter := range d.entries {
+		if oldest == nil || letter.Failed.Before(oldest.Failed) {
+			oldest = letter
+		}
+	}
+	if oldest != nil {
+		delete(d.entries, oldest.Path)
+	}
+}
+
+// count returns the number of entries
+func (d *deadLetters) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.entries)
+}
+
+// DeadLetters returns the files whose last backup failed after all retries,
+// the most recent failure first
+func (fw *FileWatcher) DeadLetters() []DeadLetter {
+	fw.deadLetters.mu.Lock()
+	letters := make([]DeadLetter, 0, len(fw.deadLetters.entries))
+	for _, letter := range fw.deadLetters.entries {
+		letters = append(letters, *letter)
+	}
+	fw.deadLetters.mu.Unlock()
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].Failed.After(letters[j].Failed)
+	})
+	return letters
+}
+
+// RetryDeadLetters queues the files of the dead-letter list for backup again,
+// waiting for room in the queue until ctx is canceled. Entries stay in the list
+// until a backup succeeds. It returns the number of files queued.
+func (fw *FileWatcher) RetryDeadLetters(ctx context.Context) (int, error) {
+	// Registered as a sender, Stop must not close the queue while sending
+	fw.senders.Add(1)
+	defer fw.senders.Done()
+	if fw.stopping.Load() {
+		return 0, errors.New("watcher is stopping")
+	}
+
+	queued := 0
+	for _, letter := range fw.DeadLetters() {
+		job := BackupJob{FilePath: letter.Path, EventType: EventRetry, Timestamp: time.Now()}
+
+		if err := fw.journal.add(&job); err != nil {
+			fw.logger.Warning("Could not write queue journal: %v", err)
+		}
+
+		select {
+		case fw.backupQueue <- job:
+		case <-ctx.Done():
+			fw.journal.done(job.ID)
+			return queued, ctx.Err()
+		case <-fw.sendersCtx.Done():
+			fw.journal.done(job.ID)
+			return queued, errors.New("watcher is stopping")
+		}
+
+		queued++
+		fw.logger.Debug("Add to backup queue: %s [%s]", filepath.Base(letter.Path), EventRetry)
+	}
+	return queued, nil
+}
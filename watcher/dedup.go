@@ -0,0 +1,124 @@
+package watcher
+
+// De-duplication of versions across files. A file copied or renamed to a new
+// name starts a new history, yet its content is usually stored already. With
+// dedup on, a new version whose checksum matches a stored version is replaced
+// by a hard link to it, so the bytes are stored once, and its manifest entry
+// names the file the content was first stored for. Each name of a linked
+// version is still a version of its own: retention removing one keeps the
+// others, and nothing changes for restoring.
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// storedContent is a version holding some content
+type storedContent struct {
+	path string // Version file
+	file string // File it is a version of, relative to the watched directory with forward slashes
+}
+
+// contentIndex maps the checksums of stored versions to one of them. It is
+// filled from the manifests on first use, entries are checked before use as
+// retention may have removed their version since.
+type contentIndex struct {
+	mu      sync.Mutex
+	loaded  bool
+	content map[string]storedContent
+}
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{content: make(map[string]storedContent)}
+}
+
+// lookup returns a stored version with the checksum sum, or records path as
+// the version of sum when there is none
+func (ci *contentIndex) lookup(bm *BackupManager, sum, path, file string) (storedContent, bool) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if !ci.loaded {
+		_, err := bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+			if v.SHA256 != "" {
+				ci.content[v.SHA256] = storedContent{path: bm.versionPath(v.Path, v.Version), file: v.Path}
+			}
+			return nil
+		})
+		if err != nil {
+			bm.logger.Warning("Could not index stored content: %v", err)
+		}
+		ci.loaded = true
+	}
+
+	stored, ok := ci.content[sum]
+	if !ok || stored.path == path {
+		ci.content[sum] = storedContent{path: path, file: file}
+		return storedContent{}, false
+	}
+	return stored, true
+}
+
+// forget drops the version of sum when it is path, e.g. after it vanished
+func (ci *contentIndex) forget(sum, path string) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if ci.content[sum].path == path {
+		delete(ci.content, sum)
+	}
+}
+
+// dedupVersion replaces the new version at path by a hard link to a stored
+// version with the same content, when there is one. file is the file it is a
+// version of, relative to the watched directory. It records where the content
+// came from in entry and reports whether the version was linked.
+func (bm *BackupManager) dedupVersion(path, file string, entry *manifestEntry) bool {
+	if bm.contents == nil || entry.SHA256 == "" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 0 {
+		// Linking empty files saves nothing
+		return false
+	}
+
+	stored, ok := bm.contents.lookup(bm, entry.SHA256, path, file)
+	if !ok {
+		return false
+	}
+
+	other, err := os.Stat(stored.path)
+	if err != nil || other.Size() != info.Size() {
+		// Removed by retention, or changed behind our back
+		bm.contents.forget(entry.SHA256, stored.path)
+		bm.contents.lookup(bm, entry.SHA256, path, file)
+		return false
+	}
+
+	if !os.SameFile(info, other) {
+		// Replaced atomically, the version must never be missing
+		tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dedup")
+		if err := os.Link(stored.path, tmp); err != nil {
+			bm.logger.Warning("Could not link %s to stored content: %v", filepath.Base(path), err)
+			return false
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			bm.logger.Warning("Could not link %s to stored content: %v", filepath.Base(path), err)
+			return false
+		}
+	}
+
+	// The link has the modification time and permissions of the stored version,
+	// those of the copy it replaced are recorded for restores
+	entry.ModTime = info.ModTime()
+	entry.Mode = info.Mode().Perm()
+	if stored.file != file {
+		entry.Origin = stored.file
+	}
+	bm.logger.Info("Content of %s is stored already, linked to %s", file, filepath.Join(filepath.FromSlash(stored.file), filepath.Base(stored.path)))
+	return true
+}
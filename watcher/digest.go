@@ -0,0 +1,220 @@
+package watcher
+
+// Daily digest of what the watcher did, for users who leave it running, and
+// the report of a whole run printed on shutdown. Both can also be appended to
+// config.DigestFile and posted to config.DigestWebhook, to look at what the
+// tool did overnight without reading its log.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/notify"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Kinds of digests
+const (
+	DigestDaily    = "daily"    // Since the previous daily digest, see config.DigestAt
+	DigestShutdown = "shutdown" // Since the watcher was created, when it stops
+)
+
+// Digest summarizes the backups made in a period
+type Digest struct {
+	Kind           string      `json:"kind"`            // One of the Digest* kinds
+	Since          time.Time   `json:"since"`           // Start of the period
+	Until          time.Time   `json:"until"`           // End of the period
+	BackedUp       int         `json:"backed_up"`       // Versions created
+	Failed         int         `json:"failed"`          // Failed backups
+	BytesWritten   int64       `json:"bytes_written"`   // Total size of the versions created
+	RepositorySize int64       `json:"repository_size"` // Size of all stored versions at the end of the period
+	TopFiles       []FileCount `json:"top_files"`       // Files with the most versions created, most first
+}
+
+// FileCount is the number of versions created for a file
+type FileCount struct {
+	Path     string `json:"path"` // Relative to the watched source directory
+	Versions int    `json:"versions"`
+}
+
+// digestCounter collects the outcomes of jobs for the next digest
+type digestCounter struct {
+	mu       sync.Mutex
+	since    time.Time
+	backedUp int
+	failed   int
+	written  int64
+	versions map[string]int // Source path → versions created
+}
+
+// add counts the outcome of a job
+func (dc *digestCounter) add(entry audit.Entry) {
+	var size int64
+	if entry.Outcome == audit.OutcomeBackedUp {
+		if info, err := os.Stat(entry.Backup); err == nil {
+			size = info.Size()
+		}
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	switch entry.Outcome {
+	case audit.OutcomeBackedUp:
+		if dc.versions == nil {
+			dc.versions = make(map[string]int)
+		}
+		dc.backedUp++
+		dc.written += size
+		dc.versions[entry.Path]++
+
+	case audit.OutcomeFailed:
+		dc.failed++
+	}
+}
+
+// take returns the digest of the outcomes counted so far. With reset a new
+// period starts.
+func (dc *digestCounter) take(kind string, reset bool) (Digest, map[string]int) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	digest := Digest{
+		Kind:         kind,
+		Since:        dc.since,
+		Until:        time.Now(),
+		BackedUp:     dc.backedUp,
+		Failed:       dc.failed,
+		BytesWritten: dc.written,
+	}
+	versions := dc.versions
+
+	if reset {
+		dc.since = digest.Until
+		dc.backedUp, dc.failed, dc.written = 0, 0, 0
+		dc.versions = nil
+	} else {
+		versions = make(map[string]int, len(dc.versions))
+		for path, n := range dc.versions {
+			versions[path] = n
+		}
+	}
+	return digest, versions
+}
+
+// TakeDigest returns the digest of the backups made since the previous call,
+// or since the watcher was created, and starts a new period
+func (fw *FileWatcher) TakeDigest() Digest {
+	return fw.finishDigest(fw.digest.take(DigestDaily, true))
+}
+
+// RunReport returns the digest of the backups made since the watcher was
+// created, for the report printed on shutdown
+func (fw *FileWatcher) RunReport() Digest {
+	return fw.finishDigest(fw.report.take(DigestShutdown, false))
+}
+
+// finishDigest adds the most versioned files and the size of the repository
+func (fw *FileWatcher) finishDigest(digest Digest, versions map[string]int) Digest {
+	for path, n := range versions {
+		if rel, err := filepath.Rel(fw.config.SourceDir, path); err == nil {
+			path = rel
+		}
+		digest.TopFiles = append(digest.TopFiles, FileCount{Path: path, Versions: n})
+	}
+	sort.Slice(digest.TopFiles, func(i, j int) bool {
+		a, b := digest.TopFiles[i], digest.TopFiles[j]
+		if a.Versions != b.Versions {
+			return a.Versions > b.Versions
+		}
+		return a.Path < b.Path
+	})
+	if len(digest.TopFiles) > fw.config.DigestTop {
+		digest.TopFiles = digest.TopFiles[:fw.config.DigestTop]
+	}
+
+	// Counting walks the backup directory, once a day or on shutdown that is fine
+	digest.RepositorySize, _ = fw.BackupManager.repositorySize()
+
+	return digest
+}
+
+// EmitDigest appends digest to config.DigestFile as a line of JSON and posts
+// it to config.DigestWebhook, when they are set
+func (fw *FileWatcher) EmitDigest(digest Digest) error {
+	var errs []error
+
+	if path := fw.config.DigestFile; path != "" {
+		if err := appendDigest(path, digest); err != nil {
+			errs = append(errs, fmt.Errorf("error writing digest file: %w", err))
+		}
+	}
+
+	if url := fw.config.DigestWebhook; url != "" {
+		if err := notify.PostReport(url, fw.config.WebhookFormat, digest, digest.Text()); err != nil {
+			errs = append(errs, fmt.Errorf("error posting digest: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// appendDigest appends digest to the file at path as a line of JSON
+func appendDigest(path string, digest Digest) error {
+	data, err := json.Marshal(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Text renders the digest as a chat message
+func (d Digest) Text() string {
+	var b strings.Builder
+
+	title := "Daily digest"
+	if d.Kind == DigestShutdown {
+		title = "Run summary"
+	}
+	fmt.Fprintf(&b, "%s, %s to %s\n", title, d.Since.Format("2006-01-02 15:04"), d.Until.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Backed up: %d versions, written: %s\n", d.BackedUp, utils.FormatSize(d.BytesWritten))
+	fmt.Fprintf(&b, "Errors: %d\n", d.Failed)
+	fmt.Fprintf(&b, "Space used: %s", utils.FormatSize(d.RepositorySize))
+	for i, file := range d.TopFiles {
+		if i == 0 {
+			b.WriteString("\nMost versioned:")
+		}
+		fmt.Fprintf(&b, "\n  %s (%d versions)", file.Path, file.Versions)
+	}
+	return b.String()
+}
+
+// NextDigest returns the next time of day at, given as "15:04", after now
+func NextDigest(at string, now time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
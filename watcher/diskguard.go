@@ -0,0 +1,128 @@
+package watcher
+
+// Disk space guard. Before a backup, the free space of the backup file system
+// is checked against config.MinFreeSpace, so the tool does not fill the disk
+// and then fail every backup that follows.
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+	"github.com/cpprian/file-watcher-backup/notify"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// diskPollInterval is how often paused backups check whether space was freed
+const diskPollInterval = 10 * time.Second
+
+// diskGuard tracks the free space state of the backup file system
+type diskGuard struct {
+	low atomic.Bool // Set while free space is below the threshold
+}
+
+// ensureSpace makes sure backing up job leaves the configured free space,
+// applying the low space action when it would not. A non-nil error means
+// the backup must not be made.
+func (fw *FileWatcher) ensureSpace(job BackupJob) error {
+	if fw.config.MinFreeSpace <= 0 {
+		return nil
+	}
+
+	info, err := os.Lstat(job.FilePath)
+	if err != nil {
+		// CreateBackup reports vanished files
+		return nil
+	}
+	need := info.Size() + fw.config.MinFreeSpace
+
+	free, err := utils.FreeSpace(fw.config.BackupDir)
+	if err != nil || free >= need {
+		fw.spaceRecovered()
+		return nil
+	}
+	fw.spaceLow(free)
+
+	switch fw.config.LowSpaceAction {
+	case config.LowSpaceAlert:
+		return nil
+
+	case config.LowSpacePrune:
+		if free = fw.prune(need); free >= need {
+			fw.spaceRecovered()
+			return nil
+		}
+	}
+
+	// Pause until space is freed, the queue holds the changes meanwhile
+	ticker := time.NewTicker(diskPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Waiting would hold up the shutdown
+		if fw.stopping.Load() {
+			return utils.NewBackupError(job.FilePath, utils.OpCreateDest,
+				fmt.Errorf("%w: %s free on the backup disk, %s required",
+					utils.ErrDestinationFull, utils.FormatSize(free), utils.FormatSize(need)))
+		}
+
+		select {
+		case <-fw.quit:
+		case <-ticker.C:
+		}
+
+		if free, err = utils.FreeSpace(fw.config.BackupDir); err != nil || free >= need {
+			fw.spaceRecovered()
+			return nil
+		}
+	}
+}
+
+// spaceLow reports free space falling below the threshold, once
+func (fw *FileWatcher) spaceLow(free int64) {
+	if fw.disk.low.Swap(true) {
+		return
+	}
+
+	msg := fmt.Sprintf("Only %s free on the backup disk, below the minimum of %s, action: %s",
+		utils.FormatSize(free), utils.FormatSize(fw.config.MinFreeSpace), fw.config.LowSpaceAction)
+	fw.logger.Warning("%s", msg)
+
+	fw.publish(notify.Event{
+		Type:    notify.EventDiskLow,
+		Time:    time.Now(),
+		Message: msg,
+		Error:   utils.ErrorLabel(utils.ErrDestinationFull),
+	})
+}
+
+// spaceRecovered reports free space being back above the threshold, once
+func (fw *FileWatcher) spaceRecovered() {
+	if fw.disk.low.Swap(false) {
+		fw.logger.Info("Free space on the backup disk is above the minimum again, backups resume")
+	}
+}
+
+// prune removes the oldest versions of all files, always keeping the latest
+// version of each, until need bytes are free. It returns the free space left.
+func (fw *FileWatcher) prune(need int64) int64 {
+	bm := fw.BackupManager
+	bm.pruneMu.Lock()
+	defer bm.pruneMu.Unlock()
+
+	// Another worker may have pruned enough while this one waited
+	free, err := utils.FreeSpace(fw.config.BackupDir)
+	if err != nil || free >= need {
+		return need
+	}
+
+	freed := bm.pruneOldest(need-free, "minimum free space")
+	bm.totalSize.Add(-freed)
+
+	if free, err = utils.FreeSpace(fw.config.BackupDir); err != nil {
+		return need
+	}
+	return free
+}
@@ -0,0 +1,85 @@
+package watcher
+
+// Symbolic links to directories are not followed, except into the
+// directories of config.FollowLinks. A followed link is treated as a
+// directory of the source tree: it is watched and its files are backed up
+// under the path of the link. Every target is entered at most once per walk,
+// so links pointing back up the tree or at each other can not loop, and
+// links to anywhere else are left alone.
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// resolveFollowed returns the real paths of the directories links may be followed into
+func resolveFollowed(dirs []string) []string {
+	var resolved []string
+	for _, dir := range dirs {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if abs, err := filepath.Abs(real); err == nil {
+				resolved = append(resolved, abs)
+			}
+		}
+	}
+	return resolved
+}
+
+// followLink returns the real path of the directory the link at path points
+// to, when it is one of the directories links may be followed into or inside one
+func (fw *FileWatcher) followLink(path string) (string, bool) {
+	if len(fw.follow) == 0 {
+		return "", false
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		real, err = filepath.Abs(real)
+	}
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(real); err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	for _, dir := range fw.follow {
+		if rel, err := filepath.Rel(dir, real); err == nil && filepath.IsLocal(rel) {
+			return real, true
+		}
+	}
+	return "", false
+}
+
+// walkDir is filepath.WalkDir following the links that followLink allows.
+// Paths below a followed link are passed to fn under the path of the link.
+func (fw *FileWatcher) walkDir(root string, fn fs.WalkDirFunc) error {
+	return fw.walkFollowing(root, root, fn, make(map[string]bool))
+}
+
+// walkFollowing walks dir, passing its paths to fn as if dir was at as
+func (fw *FileWatcher) walkFollowing(dir, as string, fn fs.WalkDirFunc, visited map[string]bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if dir != as {
+			rel, relErr := filepath.Rel(dir, path)
+			if relErr != nil {
+				return relErr
+			}
+			path = filepath.Join(as, rel)
+		}
+
+		if err == nil && d.Type()&fs.ModeSymlink != 0 {
+			if real, ok := fw.followLink(path); ok {
+				if visited[real] {
+					fw.logger.Warning("Not following %s again, it leads to a directory already walked", path)
+					return nil
+				}
+				visited[real] = true
+				return fw.walkFollowing(real, path, fn, visited)
+			}
+		}
+
+		return fn(path, d, err)
+	})
+}
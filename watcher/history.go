@@ -0,0 +1,81 @@
+package watcher
+
+// History of the stats. GetStats only tells the present; a snapshot taken
+// every StatsSampleInterval is kept in a ring buffer of the size set by
+// config.StatsHistory, so tools querying the watcher can show trends such
+// as a growing queue or the backup rate over the last hour.
+
+import (
+	"sync"
+	"time"
+)
+
+// StatsSampleInterval is the time between two snapshots of the stats history
+const StatsSampleInterval = 10 * time.Second
+
+// StatsSample is a snapshot of the stats
+type StatsSample struct {
+	Time  time.Time `json:"time"`
+	Stats Stats     `json:"stats"`
+}
+
+// statsHistory keeps the latest samples, the oldest is overwritten when full
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []StatsSample
+	next    int  // Index the next sample is written to
+	full    bool // Whether every slot holds a sample
+}
+
+func newStatsHistory(size int) *statsHistory {
+	return &statsHistory{samples: make([]StatsSample, size)}
+}
+
+// add records a sample
+func (h *statsHistory) add(sample StatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns the samples, oldest first
+func (h *statsHistory) list() []StatsSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]StatsSample{}, h.samples[:h.next]...)
+	}
+	return append(append([]StatsSample{}, h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+// sampleStats adds a snapshot of the stats to the history right away and
+// every StatsSampleInterval, until the watcher is stopped
+func (fw *FileWatcher) sampleStats() {
+	ticker := time.NewTicker(StatsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		fw.history.add(StatsSample{Time: time.Now(), Stats: fw.GetStats()})
+
+		select {
+		case <-fw.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StatsHistory returns the snapshots of the stats kept, oldest first, see
+// config.StatsHistory. It is empty when no history is kept.
+func (fw *FileWatcher) StatsHistory() []StatsSample {
+	if fw.history == nil {
+		return []StatsSample{}
+	}
+	return fw.history.list()
+}
@@ -0,0 +1,127 @@
+package watcher
+
+// Callback hooks letting library users and scripts react to file events
+// and backups without patching the worker code.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileEvent describes a file system change observed in the watched directory
+type FileEvent struct {
+	Path string    // Absolute path of the changed file or directory
+	Type string    // "CREATE", "WRITE", "REMOVE", "RENAME" or "CHMOD"
+	Time time.Time // Time when the event was received
+}
+
+// hooks holds the registered callbacks. Callbacks are called synchronously
+// from the watcher and worker goroutines, so they must be safe for concurrent
+// use and return quickly. A panicking callback is recovered and logged.
+type hooks struct {
+	mu        sync.RWMutex
+	onEvent   []func(FileEvent)
+	onCreated []func(job BackupJob, backupPath string)
+	onFailed  []func(job BackupJob, err error)
+	onOutcome []func(audit.Entry, error)
+}
+
+// OnEvent registers a function called for every file system event that is not ignored
+func (fw *FileWatcher) OnEvent(fn func(FileEvent)) {
+	fw.hooks.mu.Lock()
+	defer fw.hooks.mu.Unlock()
+
+	fw.hooks.onEvent = append(fw.hooks.onEvent, fn)
+}
+
+// OnBackupCreated registers a function called after a new version has been stored
+func (fw *FileWatcher) OnBackupCreated(fn func(job BackupJob, backupPath string)) {
+	fw.hooks.mu.Lock()
+	defer fw.hooks.mu.Unlock()
+
+	fw.hooks.onCreated = append(fw.hooks.onCreated, fn)
+}
+
+// OnBackupFailed registers a function called when a backup job fails.
+// Files that vanished before they could be backed up are not failures.
+func (fw *FileWatcher) OnBackupFailed(fn func(job BackupJob, err error)) {
+	fw.hooks.mu.Lock()
+	defer fw.hooks.mu.Unlock()
+
+	fw.hooks.onFailed = append(fw.hooks.onFailed, fn)
+}
+
+// OnOutcome registers a function called with the outcome of every backup job,
+// the same information that is written to the audit log, and the error that
+// caused it, if any.
+func (fw *FileWatcher) OnOutcome(fn func(audit.Entry, error)) {
+	fw.hooks.mu.Lock()
+	defer fw.hooks.mu.Unlock()
+
+	fw.hooks.onOutcome = append(fw.hooks.onOutcome, fn)
+}
+
+// emitEvent calls the OnEvent hooks
+func (fw *FileWatcher) emitEvent(event FileEvent) {
+	fw.hooks.mu.RLock()
+	defer fw.hooks.mu.RUnlock()
+
+	for _, fn := range fw.hooks.onEvent {
+		fw.callHook("OnEvent", func() { fn(event) })
+	}
+}
+
+// emitOutcome calls the OnOutcome hooks and, depending on the outcome,
+// the OnBackupCreated or OnBackupFailed hooks
+func (fw *FileWatcher) emitOutcome(job BackupJob, entry audit.Entry, err error) {
+	fw.hooks.mu.RLock()
+	defer fw.hooks.mu.RUnlock()
+
+	for _, fn := range fw.hooks.onOutcome {
+		fw.callHook("OnOutcome", func() { fn(entry, err) })
+	}
+
+	switch entry.Outcome {
+	case audit.OutcomeBackedUp:
+		for _, fn := range fw.hooks.onCreated {
+			fw.callHook("OnBackupCreated", func() { fn(job, entry.Backup) })
+		}
+
+	case audit.OutcomeFailed:
+		for _, fn := range fw.hooks.onFailed {
+			fw.callHook("OnBackupFailed", func() { fn(job, err) })
+		}
+	}
+}
+
+// callHook runs a single callback, a panic in user code must not take down the watcher
+func (fw *FileWatcher) callHook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fw.logger.Error("PANIC in %s hook: %v", name, r)
+		}
+	}()
+
+	fn()
+}
+
+// eventTypeOf returns the name of the most significant operation of an fsnotify event
+func eventTypeOf(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Create == fsnotify.Create:
+		return "CREATE"
+	case op&fsnotify.Write == fsnotify.Write:
+		return "WRITE"
+	case op&fsnotify.Remove == fsnotify.Remove:
+		return "REMOVE"
+	case op&fsnotify.Rename == fsnotify.Rename:
+		return "RENAME"
+	case op&fsnotify.Chmod == fsnotify.Chmod:
+		return "CHMOD"
+	default:
+		return ""
+	}
+}
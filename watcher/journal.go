@@ -0,0 +1,399 @@
+package watcher
+
+// Queue journal. Every job queued by the watcher is appended to a journal in
+// the backup directory before it enters the queue, and marked done once its
+// outcome is recorded. Jobs left in the journal when the process died, or
+// abandoned on exit, are queued again at the next start.
+//
+// Records are not synced, so a crash of the process loses nothing, a crash of
+// the operating system may lose the last records.
+//
+// A job that crashes the process every time it runs would be queued again
+// after every start. ReadQueue lists the pending jobs and DropQueued marks one
+// as done, without a watcher running; a running watcher does the same with
+// Queue and DropJob.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// journalFileName is the journal in the backup directory
+	journalFileName = ".queue"
+
+	// journalCompactSize is the size above which the journal is emptied
+	// once no job is pending anymore
+	journalCompactSize = 1 << 20
+)
+
+// journalRecord is one line of the journal, either a queued job or the ID of a finished one
+type journalRecord struct {
+	ID    uint64    `json:"id"`
+	Done  bool      `json:"done,omitempty"`
+	Path  string    `json:"path,omitempty"` // Relative to the source directory, with forward slashes
+	Event string    `json:"event,omitempty"`
+	Time  time.Time `json:"time,omitzero"`
+	Size  int64     `json:"size,omitempty"`
+}
+
+// errNotQueued is returned when dropping a job that is not pending
+var errNotQueued = errors.New("no such job in the queue")
+
+// QueuedJob is a job pending in the queue journal
+type QueuedJob struct {
+	ID      uint64    `json:"id"`
+	Path    string    `json:"path"` // Relative to the source directory, with forward slashes
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"` // When the change was seen
+	Size    int64     `json:"size,omitempty"`
+	Running bool      `json:"running,omitempty"` // Being backed up, only known by a running watcher
+}
+
+// queueJournal appends queued and finished jobs to the journal file.
+// A nil *queueJournal does nothing, so the journal is optional.
+type queueJournal struct {
+	mu        sync.Mutex
+	file      *os.File
+	sourceDir string
+	nextID    uint64
+	pending   map[uint64]bool // IDs of the jobs queued but not finished
+	size      int64           // Bytes written to the file
+}
+
+// openJournal opens the journal of backupDir and returns it together with the
+// jobs that were still pending in it, in the order they were queued
+func openJournal(backupDir, sourceDir string) (*queueJournal, []BackupJob, error) {
+	path := filepath.Join(backupDir, journalFileName)
+
+	pending, lastID, err := readJournal(path, sourceDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &queueJournal{sourceDir: sourceDir, nextID: lastID + 1, pending: make(map[uint64]bool, len(pending))}
+
+	// Rewrite the journal with only the pending jobs, replacing it atomically
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening queue journal: %w", err)
+	}
+	for _, job := range pending {
+		if err := j.write(file, j.recordOf(job)); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return nil, nil, fmt.Errorf("error writing queue journal: %w", err)
+		}
+	}
+	file.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, nil, fmt.Errorf("error writing queue journal: %w", err)
+	}
+
+	if j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, nil, fmt.Errorf("error opening queue journal: %w", err)
+	}
+	for _, job := range pending {
+		j.pending[job.ID] = true
+	}
+
+	return j, pending, nil
+}
+
+// readJournal returns the pending jobs of the journal at path and the highest ID in it
+func readJournal(path, sourceDir string) ([]BackupJob, uint64, error) {
+	queued, lastID, err := readPending(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var pending []BackupJob
+	for _, rec := range queued {
+		pending = append(pending, BackupJob{
+			ID:        rec.ID,
+			FilePath:  filepath.Join(sourceDir, filepath.FromSlash(rec.Path)),
+			EventType: rec.Event,
+			Timestamp: rec.Time,
+			Size:      rec.Size,
+		})
+	}
+	return pending, lastID, nil
+}
+
+// readPending returns the records of the jobs pending in the journal at path,
+// in the order they were queued, and the highest ID in it
+func readPending(path string) ([]journalRecord, uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading queue journal: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		queued []journalRecord
+		done   = make(map[uint64]bool)
+		lastID uint64
+	)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec journalRecord
+		// The last line may be cut off by a crash
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.ID == 0 {
+			continue
+		}
+
+		lastID = max(lastID, rec.ID)
+		if rec.Done {
+			done[rec.ID] = true
+		} else {
+			queued = append(queued, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading queue journal: %w", err)
+	}
+
+	pending := queued[:0]
+	for _, rec := range queued {
+		if !done[rec.ID] {
+			pending = append(pending, rec)
+		}
+	}
+	return pending, lastID, nil
+}
+
+// ReadQueue returns the jobs pending in the queue journal of backupDir, in
+// the order they were queued. A running watcher may change it meanwhile, ask
+// it with Queue instead.
+func ReadQueue(backupDir string) ([]QueuedJob, error) {
+	records, _, err := readPending(filepath.Join(backupDir, journalFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]QueuedJob, 0, len(records))
+	for _, rec := range records {
+		jobs = append(jobs, QueuedJob{ID: rec.ID, Path: rec.Path, Event: rec.Event, Time: rec.Time, Size: rec.Size})
+	}
+	return jobs, nil
+}
+
+// DropQueued marks the job id pending in the queue journal of backupDir as
+// done, so the next start does not queue it again. No watcher may be running
+// for backupDir, use DropJob then.
+func DropQueued(backupDir string, id uint64) error {
+	path := filepath.Join(backupDir, journalFileName)
+	records, _, err := readPending(path)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(records, func(rec journalRecord) bool { return rec.ID == id }) {
+		return fmt.Errorf("job %d: %w", id, errNotQueued)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening queue journal: %w", err)
+	}
+	j := &queueJournal{}
+	if err := j.write(file, journalRecord{ID: id, Done: true}); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing queue journal: %w", err)
+	}
+	return file.Close()
+}
+
+// recordOf returns the journal record of a queued job
+func (j *queueJournal) recordOf(job BackupJob) journalRecord {
+	rel, err := filepath.Rel(j.sourceDir, job.FilePath)
+	if err != nil {
+		rel = job.FilePath
+	}
+
+	return journalRecord{
+		ID:    job.ID,
+		Path:  filepath.ToSlash(rel),
+		Event: job.EventType,
+		Time:  job.Timestamp,
+		Size:  job.Size,
+	}
+}
+
+// write appends one record to file
+func (j *queueJournal) write(file *os.File, rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	n, err := file.Write(append(data, '\n'))
+	j.size += int64(n)
+	return err
+}
+
+// add gives job an ID and appends it to the journal. It must be called before
+// the job is sent to the queue, and done when sending it failed.
+func (j *queueJournal) add(job *BackupJob) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job.ID = j.nextID
+	j.nextID++
+	j.pending[job.ID] = true
+
+	return j.write(j.file, j.recordOf(*job))
+}
+
+// done marks a job as finished. Jobs without an ID were not journaled, those
+// dropped are marked already.
+func (j *queueJournal) done(id uint64) error {
+	if j == nil || id == 0 {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.pending[id] {
+		return nil
+	}
+	return j.finish(id)
+}
+
+// drop marks the pending job id as finished before it ran
+func (j *queueJournal) drop(id uint64) error {
+	if j == nil {
+		return errors.New("the queue is not journaled")
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.pending[id] {
+		return fmt.Errorf("job %d: %w", id, errNotQueued)
+	}
+	return j.finish(id)
+}
+
+// dropped reports whether the journaled job id was dropped while queued
+func (j *queueJournal) dropped(id uint64) bool {
+	if j == nil || id == 0 {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return !j.pending[id]
+}
+
+// finish writes the done record of id, j.mu must be held
+func (j *queueJournal) finish(id uint64) error {
+	delete(j.pending, id)
+	if len(j.pending) == 0 && j.size > journalCompactSize {
+		// Nothing is pending, the journal can start over
+		if err := j.file.Truncate(0); err == nil {
+			j.size = 0
+			return nil
+		}
+	}
+
+	return j.write(j.file, journalRecord{ID: id, Done: true})
+}
+
+// Close closes the journal file, pending jobs stay in it
+func (j *queueJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// replayJournal queues the jobs left pending by the previous run, waiting for
+// room in the queue, until ctx is canceled
+func (fw *FileWatcher) replayJournal(ctx context.Context, jobs []BackupJob) {
+	defer fw.senders.Done()
+
+	fw.logger.Info("Queueing %d backup jobs left over from the last run", len(jobs))
+
+	for _, job := range jobs {
+		select {
+		case fw.backupQueue <- job:
+		case <-ctx.Done():
+			return
+		}
+
+		fw.mu.Lock()
+		fw.lastBackup[job.FilePath] = time.Now()
+		fw.mu.Unlock()
+	}
+}
+
+// Queue returns the jobs pending in the queue journal, in the order they
+// were queued, with those being backed up marked as running
+func (fw *FileWatcher) Queue() ([]QueuedJob, error) {
+	if fw.journal == nil {
+		return nil, errors.New("the queue is not journaled")
+	}
+
+	fw.journal.mu.Lock()
+	jobs, err := ReadQueue(fw.config.BackupDir)
+	fw.journal.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[uint64]bool)
+	for _, slot := range fw.slots.busy() {
+		slot.mu.Lock()
+		running[slot.job.ID] = true
+		slot.mu.Unlock()
+	}
+	for i := range jobs {
+		jobs[i].Running = running[jobs[i].ID]
+	}
+	return jobs, nil
+}
+
+// DropJob removes the pending job id from the queue, it is skipped when its
+// turn comes and not queued again at the next start. A job being backed up
+// can not be dropped.
+func (fw *FileWatcher) DropJob(id uint64) error {
+	for _, slot := range fw.slots.busy() {
+		slot.mu.Lock()
+		running := slot.job.ID == id
+		slot.mu.Unlock()
+		if running {
+			return fmt.Errorf("job %d is being backed up, it can not be dropped", id)
+		}
+	}
+
+	if err := fw.journal.drop(id); err != nil {
+		return err
+	}
+	fw.logger.Info("Dropped job %d from the queue", id)
+	return nil
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDropQueued(t *testing.T) {
+	backupDir, sourceDir := t.TempDir(), t.TempDir()
+
+	j, _, err := openJournal(backupDir, sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for _, name := range []string{"a.txt", "poison.bin", "b.txt"} {
+		job := BackupJob{FilePath: filepath.Join(sourceDir, name), EventType: "WRITE"}
+		if err := j.add(&job); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	j.done(ids[0])
+	j.Close()
+
+	jobs, err := ReadQueue(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].Path != "poison.bin" || jobs[1].Path != "b.txt" {
+		t.Fatalf("queued jobs %+v, want poison.bin and b.txt", jobs)
+	}
+
+	if err := DropQueued(backupDir, jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DropQueued(backupDir, ids[0]); !errors.Is(err, errNotQueued) {
+		t.Errorf("dropping a finished job: %v, want %v", err, errNotQueued)
+	}
+
+	// The next start only queues what was not dropped
+	j, pending, err := openJournal(backupDir, sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if len(pending) != 1 || pending[0].ID != ids[2] {
+		t.Fatalf("pending jobs %+v, want only b.txt", pending)
+	}
+
+	if err := j.drop(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if !j.dropped(ids[2]) {
+		t.Error("a dropped job is not reported as dropped")
+	}
+	// Finishing a dropped job writes nothing more
+	if err := j.done(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, _ := ReadQueue(backupDir); len(jobs) != 0 {
+		t.Errorf("queued jobs %+v after dropping all", jobs)
+	}
+}
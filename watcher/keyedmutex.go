@@ -0,0 +1,88 @@
+package watcher
+
+// Locks per file. Two workers may pick up jobs for the same file at once,
+// e.g. a CREATE quickly followed by a WRITE without a minimum interval. Their
+// versions must still be created one after the other, or the manifest order,
+// the retention and the version names of the file race with each other.
+
+import (
+	"context"
+	"sync"
+)
+
+// keyedMutex holds one lock per key, entries are dropped once unused
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock of a key
+type keyedLock struct {
+	token chan struct{} // Holds a token while locked
+	users int           // Holder and waiters, the entry is dropped at 0
+}
+
+// tryLock locks key unless it is locked already. It returns the function
+// unlocking it, nil when it was locked.
+func (km *keyedMutex) tryLock(key string) func() {
+	l := km.acquire(key)
+	select {
+	case l.token <- struct{}{}:
+		return km.unlocker(key, l)
+	default:
+		km.release(key, l)
+		return nil
+	}
+}
+
+// lock waits until key is unlocked and locks it, or until ctx is done. It
+// returns the function unlocking it.
+func (km *keyedMutex) lock(ctx context.Context, key string) (func(), error) {
+	l := km.acquire(key)
+	select {
+	case l.token <- struct{}{}:
+		return km.unlocker(key, l), nil
+	case <-ctx.Done():
+		km.release(key, l)
+		return nil, ctx.Err()
+	}
+}
+
+// acquire returns the lock of key, counting the caller as a user
+func (km *keyedMutex) acquire(key string) *keyedLock {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if km.locks == nil {
+		km.locks = make(map[string]*keyedLock)
+	}
+	l, ok := km.locks[key]
+	if !ok {
+		l = &keyedLock{token: make(chan struct{}, 1)}
+		km.locks[key] = l
+	}
+	l.users++
+	return l
+}
+
+// release drops the caller as a user of the lock of key
+func (km *keyedMutex) release(key string, l *keyedLock) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	l.users--
+	if l.users == 0 {
+		delete(km.locks, key)
+	}
+}
+
+// unlocker returns the function unlocking l, it does nothing when called again
+func (km *keyedMutex) unlocker(key string, l *keyedLock) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.token
+			km.release(key, l)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package watcher
+
+// Layout of the backup directory. The versions of a file are stored in
+// <relative path>_versions, with forward slashes turned into the separators
+// of the platform. Names allowed on one system are not on another, e.g. a
+// colon or a trailing dot on Windows, so every path component is stored
+// portably: characters Windows rejects, control characters and % become %XX,
+// as do a trailing dot or space and the first letter of a reserved device
+// name like CON or NUL. A directory whose name ends in _versions gets its
+// last letter encoded, or it would be taken for the versions of a file.
+// Repositories written before are still read: a version directory stored
+// under the plain path is used as long as there is none under the encoded one.
+// The date layout stores the versions of a day together, see datelayout.go.
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionsSuffix is appended to the path of a file to name its version directory
+const versionsSuffix = "_versions"
+
+// reservedNames are the device names Windows reserves, with any extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// versionDir returns the version directory of the file relPath, relative to
+// the watched directory with either separator, and the file name its
+// versions are named after
+func (bm *BackupManager) versionDir(relPath string) (string, string) {
+	stored := encodePath(filepath.ToSlash(relPath))
+	dir := filepath.Join(bm.backupDir, filepath.FromSlash(stored)+versionsSuffix)
+
+	plain := filepath.ToSlash(filepath.Clean(relPath))
+	if stored != plain {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			legacy := filepath.Join(bm.backupDir, filepath.FromSlash(plain)+versionsSuffix)
+			if _, err := os.Lstat(legacy); err == nil {
+				return legacy, path.Base(plain)
+			}
+		}
+	}
+	return dir, path.Base(stored)
+}
+
+// newVersionDir returns the directory a version of the file relPath made at
+// created is stored in, and the file name its versions are named after
+func (bm *BackupManager) newVersionDir(relPath string, created time.Time) (string, string) {
+	if bm.dateLayout {
+		stored := filepath.FromSlash(encodeDatePath(filepath.ToSlash(relPath)))
+		return filepath.Join(bm.backupDir, created.Format(dayLayout), filepath.Dir(stored)), filepath.Base(stored)
+	}
+	return bm.versionDir(relPath)
+}
+
+// versionPath returns the path of the version named version of the file relPath
+func (bm *BackupManager) versionPath(relPath, version string) string {
+	if bm.dateLayout {
+		return filepath.Join(bm.backupDir, version, filepath.FromSlash(encodeDatePath(filepath.ToSlash(relPath))))
+	}
+	dir, _ := bm.versionDir(relPath)
+	return filepath.Join(dir, version)
+}
+
+// versionNameOf returns the name of the version stored at versionPath,
+// see VersionInfo.Version
+func (bm *BackupManager) versionNameOf(versionPath string) string {
+	if bm.dateLayout {
+		if rel, err := filepath.Rel(bm.backupDir, versionPath); err == nil {
+			day, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+			return day
+		}
+	}
+	return filepath.Base(versionPath)
+}
+
+// fileKey returns the key the versions of the file relPath are locked and
+// indexed by: its version directory, or in the date layout its path below
+// the backup directory, which no day has
+func (bm *BackupManager) fileKey(relPath string) string {
+	if bm.dateLayout {
+		return filepath.Join(bm.backupDir, filepath.FromSlash(encodeDatePath(filepath.ToSlash(relPath))))
+	}
+	dir, _ := bm.versionDir(relPath)
+	return dir
+}
+
+// scanVersions lists the versions of the file with the key key, see fileKey,
+// named after fileName in the file layout
+func (bm *BackupManager) scanVersions(key, fileName string) ([]string, error) {
+	if bm.dateLayout {
+		storedRel, err := filepath.Rel(bm.backupDir, key)
+		if err != nil {
+			return nil, err
+		}
+		return bm.listDateVersions(filepath.ToSlash(storedRel))
+	}
+	return bm.listVersions(key, fileName)
+}
+
+// encodePath returns how the path p, with forward slashes, is stored
+func encodePath(p string) string {
+	parts := strings.Split(path.Clean(p), "/")
+	for i, part := range parts {
+		parts[i] = encodeName(part, i < len(parts)-1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// encodeName returns how a path component is stored, dir tells whether it
+// names a directory
+func encodeName(name string, dir bool) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		last := i == len(name)-1
+		switch {
+		case c < 0x20, c == 0x7f, strings.IndexByte(`<>:"\|?*%`, c) >= 0:
+		case last && (c == '.' || c == ' ') && name != "." && name != "..":
+		case last && dir && strings.HasSuffix(name, versionsSuffix):
+		case i == 0 && isReservedName(name):
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// isReservedName reports whether Windows reserves name for a device
+func isReservedName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	return reservedNames[strings.ToUpper(strings.TrimRight(base, " "))]
+}
+
+// decodePath returns the path, with forward slashes, stored as p. Names
+// that were not encoded, from repositories written before, stay as they are.
+func decodePath(p string) string {
+	if !strings.Contains(p, "%") {
+		return p
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '%' && i+2 < len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
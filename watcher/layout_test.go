@@ -0,0 +1,39 @@
+package watcher
+
+import "testing"
+
+func TestEncodePath(t *testing.T) {
+	tests := []struct {
+		path, stored string
+	}{
+		{"docs/report.txt", "docs/report.txt"},
+		{"a:b.txt", "a%3Ab.txt"},
+		{"what?/why*.txt", "what%3F/why%2A.txt"},
+		{"50%.txt", "50%25.txt"},
+		{"tab\there.txt", "tab%09here.txt"},
+		{"dir./file.", "dir%2E/file%2E"},
+		{"dir /file ", "dir%20/file%20"},
+		{"CON.txt", "%43ON.txt"},
+		{"nul/aux", "%6Eul/%61ux"},
+		{"console.txt", "console.txt"},
+		{"photos_versions/a.jpg", "photos_version%73/a.jpg"},
+		{"photos_versions", "photos_versions"},
+		{"..a/b", "..a/b"},
+	}
+	for _, tt := range tests {
+		stored := encodePath(tt.path)
+		if stored != tt.stored {
+			t.Errorf("encodePath(%q) = %q, want %q", tt.path, stored, tt.stored)
+		}
+		if decoded := decodePath(stored); decoded != tt.path {
+			t.Errorf("decodePath(%q) = %q, want %q", stored, decoded, tt.path)
+		}
+	}
+
+	// Written before paths were encoded, kept as they are
+	for _, stored := range []string{"plain/name.txt", "100%", "50%zz.txt"} {
+		if decoded := decodePath(stored); decoded != stored {
+			t.Errorf("decodePath(%q) = %q", stored, decoded)
+		}
+	}
+}
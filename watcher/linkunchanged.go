@@ -0,0 +1,108 @@
+package watcher
+
+// Linking unchanged files, like rsync --link-dest. A file renamed away and
+// back, or saved by an editor without changes, is backed up again although
+// its content is the latest version already. With config.LinkUnchanged such
+// a file is hashed first, and when the checksum matches the latest version
+// the new version becomes a hard link to it instead of a fresh copy. Unlike
+// dedup this writes nothing, at the cost of reading files of the same size
+// as their latest version twice when they did change.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// linkToLatest links the new version at path to the latest version of the
+// file at sourcePath when the content is the same. relPath is the file
+// relative to the watched directory. It fills the checksum and modification
+// time of entry and reports whether the version was linked.
+func (bm *BackupManager) linkToLatest(ctx context.Context, sourcePath, relPath, path string, entry *manifestEntry) bool {
+	if !bm.linkUnchanged || bm.linkUnsupported.Load() {
+		return false
+	}
+
+	source, err := os.Stat(sourcePath)
+	if err != nil || source.Size() == 0 {
+		// Linking empty files saves nothing
+		return false
+	}
+
+	versions, err := bm.Versions(relPath)
+	if err != nil || len(versions) == 0 {
+		return false
+	}
+	latest := versions[len(versions)-1]
+	stored, err := os.Stat(latest)
+	if err != nil || stored.Size() != source.Size() {
+		return false
+	}
+
+	entries, err := bm.readManifest(filepath.Dir(latest))
+	if err != nil {
+		return false
+	}
+	latestEntry := entries[filepath.Base(latest)]
+	if latestEntry.SHA256 == "" {
+		// Made before checksums were recorded
+		return false
+	}
+
+	sum, err := bm.hashSource(ctx, sourcePath)
+	if err != nil || sum != latestEntry.SHA256 {
+		return false
+	}
+
+	if err := os.Link(latest, path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false
+		}
+		// Most likely a file system without hard links, do not try again
+		bm.linkUnsupported.Store(true)
+		bm.logger.Warning("Could not link %s to its latest version, copying unchanged files from now on: %v", filepath.Base(path), err)
+		return false
+	}
+
+	entry.SHA256 = sum
+	entry.Origin = latestEntry.Origin
+	// The link has the modification time and permissions of the latest version
+	entry.ModTime = source.ModTime()
+	entry.Mode = source.Mode().Perm()
+	bm.logger.Info("%s is unchanged, linked to %s", relPath, filepath.Base(latest))
+	return true
+}
+
+// hashSource returns the SHA-256 checksum of a source file, hex encoded.
+// Reading shares the copy bandwidth.
+func (bm *BackupManager) hashSource(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	buf := make([]byte, 32*1024)
+	reader := bm.throttle.Reader(ctx, f)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		n, err := reader.Read(buf)
+		sum.Write(buf[:n])
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
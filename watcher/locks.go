@@ -0,0 +1,149 @@
+package watcher
+
+// Files locked by another process, e.g. a database while it writes or a
+// document open in an office suite, would be copied in an inconsistent state.
+// Their backup is deferred and tried again later, with the delay doubled for
+// each try up to lockMaxDelay, and fails once config.LockRetries tries are
+// used up.
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// lockMaxDelay caps the delay before a locked file is tried again
+const lockMaxDelay = 24 * time.Hour
+
+// deferredFiles are the files whose backup is deferred, one deferral per file
+type deferredFiles struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// add reports whether path was not deferred yet and marks it deferred
+func (d *deferredFiles) add(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.paths[path] {
+		return false
+	}
+	if d.paths == nil {
+		d.paths = make(map[string]bool)
+	}
+	d.paths[path] = true
+	return true
+}
+
+func (d *deferredFiles) remove(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.paths, path)
+}
+
+// deferLocked finishes the job of a locked file, reason tells why it is
+// locked, and queues it again after a delay. Once every try is used up the
+// job fails instead. It returns like finishJob.
+func (fw *FileWatcher) deferLocked(slot *workerSlot, job BackupJob, reason string) bool {
+	if job.Deferrals >= fw.config.LockRetries {
+		err := utils.NewBackupError(job.FilePath, utils.OpOpenSource, utils.ErrLocked)
+		fw.logger.Error("Worker #%d: %s is still %s, giving up after %d tries",
+			slot.id, filepath.Base(job.FilePath), reason, job.Deferrals+1)
+		return fw.finishJob(slot, audit.OutcomeFailed, "", reason, err)
+	}
+
+	delay := deferDelay(fw.config.LockDelay, job.Deferrals, lockMaxDelay)
+	return fw.deferJob(slot, job, audit.OutcomeDeferredLocked, reason, delay, fw.config.LockRetries, nil)
+}
+
+// deferJob finishes the job in slot as outcome, reason telling why, and
+// queues it again after delay. limit is the number of deferrals allowed, 0
+// for no limit. err is logged, and reported with the first deferral only;
+// nil for none. A later change of a file already deferred is backed up with
+// it. It returns like finishJob.
+func (fw *FileWatcher) deferJob(slot *workerSlot, job BackupJob, outcome, reason string, delay time.Duration, limit int, err error) bool {
+	name := filepath.Base(job.FilePath)
+
+	if !fw.deferred.add(job.FilePath) {
+		fw.logger.BackupSkipped(name, reason+", already deferred")
+		return fw.finishJob(slot, outcome, "", reason+", already deferred", nil)
+	}
+
+	retry := fmt.Sprintf("tried again in %s", delay)
+	if limit > 0 {
+		retry += fmt.Sprintf(" (%d of %d)", job.Deferrals+1, limit)
+	}
+	detail := reason
+	if err != nil {
+		detail = err.Error()
+	}
+	fw.logger.Warning("Worker #%d: backup of %s deferred, %s, %s", slot.id, name, detail, retry)
+
+	// Reported once, not at every try
+	var reported error
+	if job.Deferrals == 0 {
+		reported = err
+	}
+	if !fw.finishJob(slot, outcome, "", reason+", "+retry, reported) {
+		fw.deferred.remove(job.FilePath)
+		return false
+	}
+
+	next := BackupJob{
+		FilePath:  job.FilePath,
+		EventType: job.EventType,
+		Timestamp: job.Timestamp,
+		Size:      job.Size,
+		Deferrals: job.Deferrals + 1,
+	}
+	fw.requeueAfter(next, delay)
+	return true
+}
+
+// deferDelay returns base doubled n times, at most maxDelay
+func deferDelay(base time.Duration, n int, maxDelay time.Duration) time.Duration {
+	delay := min(base, maxDelay)
+	for i := 0; i < n && delay < maxDelay; i++ {
+		delay = min(delay*2, maxDelay)
+	}
+	return delay
+}
+
+// requeueAfter queues a deferred job after delay. The job is journaled
+// meanwhile, when the watcher stops first it is backed up at the next start.
+func (fw *FileWatcher) requeueAfter(job BackupJob, delay time.Duration) {
+	if err := fw.journal.add(&job); err != nil {
+		fw.logger.Warning("Could not write queue journal: %v", err)
+	}
+
+	// Registered as a sender, Stop must not close the queue while sending
+	fw.senders.Add(1)
+	if fw.stopping.Load() {
+		fw.senders.Done()
+		return
+	}
+
+	go func() {
+		defer fw.senders.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-fw.sendersCtx.Done():
+			return
+		}
+
+		fw.deferred.remove(job.FilePath)
+		select {
+		case fw.backupQueue <- job:
+		case <-fw.sendersCtx.Done():
+		}
+	}()
+}
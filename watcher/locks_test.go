@@ -0,0 +1,26 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeferDelay(t *testing.T) {
+	tests := []struct {
+		base     time.Duration
+		n        int
+		maxDelay time.Duration
+		want     time.Duration
+	}{
+		{30 * time.Second, 0, time.Hour, 30 * time.Second},
+		{30 * time.Second, 3, time.Hour, 4 * time.Minute},
+		{30 * time.Second, 7, time.Hour, time.Hour},
+		{30 * time.Second, 1000, time.Hour, time.Hour},
+		{1 << 62, 70, 24 * time.Hour, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := deferDelay(tt.base, tt.n, tt.maxDelay); got != tt.want {
+			t.Errorf("deferDelay(%s, %d, %s) = %s, want %s", tt.base, tt.n, tt.maxDelay, got, tt.want)
+		}
+	}
+}
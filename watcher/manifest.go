@@ -0,0 +1,209 @@
+package watcher
+
+// Version manifests keep the stable ID and the full creation time of every
+// version, names may not show the time and may be changed by migrations.
+// Each version directory holds its own manifest, one JSON object per line,
+// optionally encrypted line by line with AES-256-GCM so the times are only
+// readable with the key.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestName is the file name of the manifest in a version directory
+const manifestName = ".manifest"
+
+// manifestEntry is one line of a manifest
+type manifestEntry struct {
+	ID        string      `json:"id,omitempty"`
+	Version   string      `json:"version"`
+	Time      time.Time   `json:"time"`
+	ACL       []byte      `json:"acl,omitempty"`        // ACL of the source file, see utils.ReadACL
+	SHA256    string      `json:"sha256,omitempty"`     // Checksum of the content, hex encoded
+	Origin    string      `json:"origin,omitempty"`     // File whose version the content is linked to, see dedupVersion
+	ModTime   time.Time   `json:"mtime,omitzero"`       // Modification time of the source, of linked versions only
+	Mode      os.FileMode `json:"mode,omitempty"`       // Permissions of the source, of linked versions only
+	Seq       int         `json:"seq,omitempty"`        // Number of the version, of sequence names only
+	Host      string      `json:"host,omitempty"`       // Host the source was backed up on
+	MovedFrom string      `json:"moved_from,omitempty"` // Path of the file the version was made of, when it moved since, see MoveHistory
+}
+
+// LoadIndexKey reads the key encrypting version manifests. Any secret works,
+// the AES key is derived from the whole file content.
+func LoadIndexKey(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index key: %w", err)
+	}
+
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("index key file is empty: %s", path)
+	}
+
+	key := sha256.Sum256(secret)
+	return key[:], nil
+}
+
+// SetIndexKey encrypts the version manifests written from now on with key,
+// and lets encrypted manifests be read. See LoadIndexKey.
+func (bm *BackupManager) SetIndexKey(key []byte) {
+	bm.indexKey = key
+}
+
+// appendManifest records the creation time of a version in the manifest of dir
+func (bm *BackupManager) appendManifest(dir string, entry manifestEntry) error {
+	line, err := bm.encodeManifestEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, manifestName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compactManifest drops the entries of versions that no longer exist
+func (bm *BackupManager) compactManifest(dir string, versions []string) error {
+	entries, err := bm.readManifest(dir)
+	if err != nil || entries == nil {
+		return err
+	}
+
+	kept := make([]manifestEntry, 0, len(versions))
+	for _, path := range versions {
+		if entry, ok := entries[filepath.Base(path)]; ok {
+			kept = append(kept, entry)
+		}
+	}
+	return bm.writeManifest(dir, kept)
+}
+
+// writeManifest replaces the manifest of dir by entries
+func (bm *BackupManager) writeManifest(dir string, entries []manifestEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := bm.encodeManifestEntry(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+
+	// Replace atomically, a crash must not lose the times of the remaining versions
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// readManifest returns the entries of the manifest of dir by version name,
+// nil if there is none. Lines that can not be decoded, e.g. encrypted ones
+// without the key, are skipped.
+func (bm *BackupManager) readManifest(dir string) (map[string]manifestEntry, error) {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]manifestEntry)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, err := bm.decodeManifestEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		// A later entry wins, reduced precision names are reused within their period
+		entries[entry.Version] = entry
+	}
+	return entries, scanner.Err()
+}
+
+// encodeManifestEntry returns one manifest line, encrypted when a key is set
+func (bm *BackupManager) encodeManifestEntry(entry manifestEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if bm.indexKey != nil {
+		gcm, err := newManifestCipher(bm.indexKey)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		sealed := gcm.Seal(nonce, nonce, data, nil)
+		data = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+
+	return append(data, '\n'), nil
+}
+
+// decodeManifestEntry parses one manifest line, plain JSON lines start with '{'
+func (bm *BackupManager) decodeManifestEntry(line []byte) (manifestEntry, error) {
+	var entry manifestEntry
+
+	if len(line) > 0 && line[0] != '{' {
+		if bm.indexKey == nil {
+			return entry, errors.New("manifest is encrypted")
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return entry, err
+		}
+
+		gcm, err := newManifestCipher(bm.indexKey)
+		if err != nil {
+			return entry, err
+		}
+		if len(sealed) < gcm.NonceSize() {
+			return entry, errors.New("manifest entry too short")
+		}
+
+		line, err = gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			return entry, err
+		}
+	}
+
+	err := json.Unmarshal(line, &entry)
+	return entry, err
+}
+
+func newManifestCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+package watcher
+
+// Requests to a running watcher from outside, e.g. over the control socket of
+// the CLI: backing up files right away, waiting for the queue to drain and
+// reopening the files it writes to.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EventManual is the event type of jobs queued by BackupNow
+const EventManual = "MANUAL"
+
+// flushPollInterval is how often Flush checks whether the queue drained
+const flushPollInterval = 100 * time.Millisecond
+
+// BackupNow queues path for backup right away, without waiting for a change
+// and regardless of the minimum interval. path is a file or a directory, then
+// every file below it passing the filters is queued, absolute or relative to
+// the source directory. It waits for room in the queue until ctx is canceled
+// or the watcher stops, and returns the number of files queued.
+func (fw *FileWatcher) BackupNow(ctx context.Context, path string) (int, error) {
+	if filepath.IsAbs(path) {
+		// The source directory may be relative, paths of events are below it as given
+		source, err := filepath.Abs(fw.config.SourceDir)
+		if err != nil {
+			return 0, err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return 0, fmt.Errorf("%s is outside the source directory", path)
+		}
+		path = rel
+	} else if rel := filepath.Clean(path); rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return 0, fmt.Errorf("%s is outside the source directory", path)
+	}
+	path = filepath.Join(fw.config.SourceDir, path)
+	if fw.isExcluded(path) || fw.shouldIgnore(path) {
+		return 0, fmt.Errorf("%s is ignored", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() && (!info.Mode().IsRegular() || !fw.isIncluded(path) || !fw.owners.matches(info)) {
+		return 0, fmt.Errorf("%s is ignored", path)
+	}
+
+	// Registered as a sender, Stop must not close the queue while sending
+	fw.senders.Add(1)
+	defer fw.senders.Done()
+	if fw.stopping.Load() {
+		return 0, errors.New("watcher is stopping")
+	}
+
+	queued := 0
+	send := func(path string, info os.FileInfo) error {
+		if fw.skipTooLarge(path, EventManual, info) {
+			return nil
+		}
+
+		job := BackupJob{FilePath: path, EventType: EventManual, Timestamp: time.Now(), Size: info.Size()}
+
+		if err := fw.journal.add(&job); err != nil {
+			fw.logger.Warning("Could not write queue journal: %v", err)
+		}
+
+		select {
+		case fw.backupQueue <- job:
+		case <-ctx.Done():
+			fw.journal.done(job.ID)
+			return ctx.Err()
+		case <-fw.sendersCtx.Done():
+			fw.journal.done(job.ID)
+			return errors.New("watcher is stopping")
+		}
+
+		fw.mu.Lock()
+		fw.lastBackup[path] = time.Now()
+		fw.mu.Unlock()
+
+		queued++
+		fw.logger.Debug("Add to backup queue: %s [%s]", filepath.Base(path), EventManual)
+		return nil
+	}
+
+	if !info.IsDir() {
+		return queued, send(path, info)
+	}
+	return queued, fw.walkTree(ctx, path, send)
+}
+
+// Flush waits until every queued backup is done, then writes the watcher
+// state and the audit log to disk. Changes arriving meanwhile are waited for
+// as well. It fails right away while backups are paused.
+func (fw *FileWatcher) Flush(ctx context.Context) error {
+	if paused, _ := fw.Paused(); paused {
+		return errors.New("backups are paused")
+	}
+
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for fw.PendingJobs() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if err := fw.saveState(); err != nil {
+		return err
+	}
+	return fw.FlushAudit()
+}
+
+// Reload reopens the audit log, so a running watcher follows log rotation,
+// and compiles the ignore and include patterns of its configuration again
+func (fw *FileWatcher) Reload() error {
+	fw.mu.Lock()
+	fw.filters.Store(compileFilters(fw.config, fw.filters.Load()))
+	fw.mu.Unlock()
+
+	if fw.audit == nil {
+		return nil
+	}
+	if err := fw.audit.Reopen(); err != nil {
+		return err
+	}
+	fw.logger.Info("Audit log reopened")
+	return nil
+}
@@ -0,0 +1,303 @@
+package watcher
+
+// Merkle-tree manifests of snapshots. Next to its archive, a snapshot
+// records the checksum of every file and of every directory, the checksum
+// of a directory covering the names and checksums of its entries. Two
+// manifests are compared by only descending into directories whose
+// checksums differ, so comparing snapshots of a million-file tree in which
+// a few files changed reads a handful of directories. Comparing a manifest
+// with the source directory walks the source, but only reads the files
+// whose size or modification time differ from the manifest.
+//
+// A manifest is JSON lines, one per directory, sorted by path.
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// TreeEntry is a file or directory in a directory of a Tree
+type TreeEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	SHA256  string    `json:"sha256"`         // Of the content of a file, of the entries of a directory
+	Size    int64     `json:"size,omitempty"` // Size of a file in bytes
+	ModTime time.Time `json:"mtime,omitzero"` // Modification time of a file
+}
+
+// treeDir is a directory of a Tree, a line of its manifest
+type treeDir struct {
+	Path    string      `json:"path"` // Relative to the snapshot with forward slashes, empty for the top
+	SHA256  string      `json:"sha256"`
+	Entries []TreeEntry `json:"entries"` // Sorted by name
+}
+
+// Tree is the Merkle-tree manifest of the files of a snapshot
+type Tree struct {
+	dirs map[string]*treeDir
+}
+
+// Kinds of TreeChange
+const (
+	TreeAdded    = "added"
+	TreeModified = "modified"
+	TreeDeleted  = "deleted"
+)
+
+// TreeChange is a file that differs between two trees
+type TreeChange struct {
+	Path string // Relative to the snapshot with forward slashes
+	Kind string // One of TreeAdded, TreeModified or TreeDeleted
+}
+
+// treeBuilder collects the files of a snapshot into a Tree
+type treeBuilder struct {
+	files map[string]TreeEntry // By path
+}
+
+func newTreeBuilder() *treeBuilder {
+	return &treeBuilder{files: make(map[string]TreeEntry)}
+}
+
+// add records the file rel, a slash separated relative path, with the
+// content checksum sum
+func (b *treeBuilder) add(rel string, size int64, modTime time.Time, sum string) {
+	b.files[rel] = TreeEntry{Name: path.Base(rel), SHA256: sum, Size: size, ModTime: modTime}
+}
+
+// tree returns the Tree of the files added
+func (b *treeBuilder) tree() *Tree {
+	t := &Tree{dirs: map[string]*treeDir{"": {}}}
+
+	var dir func(p string) *treeDir
+	dir = func(p string) *treeDir {
+		if d, ok := t.dirs[p]; ok {
+			return d
+		}
+		d := &treeDir{Path: p}
+		t.dirs[p] = d
+		parent := dir(parentPath(p))
+		parent.Entries = append(parent.Entries, TreeEntry{Name: path.Base(p), Dir: true})
+		return d
+	}
+	for rel, entry := range b.files {
+		d := dir(parentPath(rel))
+		d.Entries = append(d.Entries, entry)
+	}
+
+	t.hash("")
+	return t
+}
+
+// parentPath returns the directory of the slash separated relative path p,
+// empty for the top
+func parentPath(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// hash sets the checksums of the directory p and of the directories below
+// it, and returns the one of p
+func (t *Tree) hash(p string) string {
+	d := t.dirs[p]
+	sort.Slice(d.Entries, func(i, j int) bool { return d.Entries[i].Name < d.Entries[j].Name })
+
+	sum := sha256.New()
+	for i := range d.Entries {
+		e := &d.Entries[i]
+		kind := "F"
+		if e.Dir {
+			e.SHA256 = t.hash(path.Join(p, e.Name))
+			kind = "D"
+		}
+		fmt.Fprintf(sum, "%s %s\x00%s\n", kind, e.Name, e.SHA256)
+	}
+	d.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return d.SHA256
+}
+
+// Root returns the checksum of the whole tree
+func (t *Tree) Root() string {
+	return t.dirs[""].SHA256
+}
+
+// entries returns the entries of the directory p, none when it is missing
+func (t *Tree) entries(p string) []TreeEntry {
+	if d := t.dirs[p]; d != nil {
+		return d.Entries
+	}
+	return nil
+}
+
+// file returns the entry of the file rel
+func (t *Tree) file(rel string) (TreeEntry, bool) {
+	entries := t.entries(parentPath(rel))
+	name := path.Base(rel)
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Name >= name })
+	if i < len(entries) && entries[i].Name == name && !entries[i].Dir {
+		return entries[i], true
+	}
+	return TreeEntry{}, false
+}
+
+// Write writes the manifest of the tree to w
+func (t *Tree) Write(w io.Writer) error {
+	paths := make([]string, 0, len(t.dirs))
+	for p := range t.dirs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, p := range paths {
+		if err := enc.Encode(t.dirs[p]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadTree reads a manifest written by Tree.Write
+func ReadTree(r io.Reader) (*Tree, error) {
+	t := &Tree{dirs: make(map[string]*treeDir)}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		d := &treeDir{}
+		if err := dec.Decode(d); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading tree manifest: %w", err)
+		}
+		t.dirs[d.Path] = d
+	}
+
+	if _, ok := t.dirs[""]; !ok {
+		return nil, fmt.Errorf("error reading tree manifest: no top directory")
+	}
+	return t, nil
+}
+
+// ReadTreeFile reads the manifest at path, see ReadTree
+func ReadTreeFile(path string) (*Tree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTree(f)
+}
+
+// CompareTrees returns the files that differ between older and newer, sorted
+// by path. Directories with the same checksum in both are skipped.
+func CompareTrees(older, newer *Tree) []TreeChange {
+	var changes []TreeChange
+	if older.Root() != newer.Root() {
+		compareDirs(older, newer, "", &changes)
+	}
+	return changes
+}
+
+// compareDirs appends the files that differ in the directory p of older and
+// newer to changes
+func compareDirs(older, newer *Tree, p string, changes *[]TreeChange) {
+	olderEntries, newerEntries := older.entries(p), newer.entries(p)
+
+	i, j := 0, 0
+	for i < len(olderEntries) || j < len(newerEntries) {
+		switch {
+		case j == len(newerEntries) || i < len(olderEntries) && olderEntries[i].Name < newerEntries[j].Name:
+			allFiles(older, path.Join(p, olderEntries[i].Name), olderEntries[i], TreeDeleted, changes)
+			i++
+		case i == len(olderEntries) || newerEntries[j].Name < olderEntries[i].Name:
+			allFiles(newer, path.Join(p, newerEntries[j].Name), newerEntries[j], TreeAdded, changes)
+			j++
+		default:
+			o, n := olderEntries[i], newerEntries[j]
+			rel := path.Join(p, o.Name)
+			switch {
+			case o.SHA256 == n.SHA256 && o.Dir == n.Dir:
+			case o.Dir && n.Dir:
+				compareDirs(older, newer, rel, changes)
+			case !o.Dir && !n.Dir:
+				*changes = append(*changes, TreeChange{Path: rel, Kind: TreeModified})
+			default:
+				// A file replaced by a directory or the other way round
+				allFiles(older, rel, o, TreeDeleted, changes)
+				allFiles(newer, rel, n, TreeAdded, changes)
+			}
+			i++
+			j++
+		}
+	}
+}
+
+// allFiles appends the file rel of t, or all files below it when it is a
+// directory, to changes as kind
+func allFiles(t *Tree, rel string, e TreeEntry, kind string, changes *[]TreeChange) {
+	if !e.Dir {
+		*changes = append(*changes, TreeChange{Path: rel, Kind: kind})
+		return
+	}
+	for _, child := range t.entries(rel) {
+		allFiles(t, path.Join(rel, child.Name), child, kind, changes)
+	}
+}
+
+// SourceTree returns the tree a snapshot of dir with opts would record.
+// Files whose size and modification time match their entry in known are
+// not read, their checksum is taken from there; known may be nil.
+func SourceTree(ctx context.Context, dir string, opts SnapshotOptions, known *Tree) (*Tree, error) {
+	b := newTreeBuilder()
+	err := walkSnapshot(ctx, dir, opts, func(rel, file string) error {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		if known != nil {
+			if e, ok := known.file(rel); ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+				b.add(rel, info.Size(), info.ModTime(), e.SHA256)
+				return nil
+			}
+		}
+
+		sum, err := hashFile(file)
+		if err != nil {
+			return err
+		}
+		b.add(rel, info.Size(), info.ModTime(), sum)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dir, err)
+	}
+	return b.tree(), nil
+}
+
+// hashFile returns the SHA-256 checksum of the file at path, hex encoded
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
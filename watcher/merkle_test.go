@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareTrees(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"same/a.txt", "same/deep/b.txt", "docs/report.txt", "docs/old.txt", "gone/c.txt", "notes.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeSource(t, path, name, 0644, mtime)
+	}
+
+	var manifest bytes.Buffer
+	summary, err := SnapshotSource(context.Background(), dir, io.Discard, SnapshotOptions{Format: SnapshotTarGz, Tree: &manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := ReadTree(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Files != 6 {
+		t.Fatalf("snapshot of %d files, want 6", summary.Files)
+	}
+
+	writeSource(t, filepath.Join(dir, "docs", "report.txt"), "rewritten", 0644, mtime.Add(time.Hour))
+	writeSource(t, filepath.Join(dir, "docs", "new.txt"), "new", 0644, mtime)
+	os.Remove(filepath.Join(dir, "docs", "old.txt"))
+	os.RemoveAll(filepath.Join(dir, "gone"))
+	// Same size and modification time, taken from the manifest unread
+	writeSource(t, filepath.Join(dir, "notes.txt"), "NOTES.TXT", 0644, mtime)
+
+	newer, err := SourceTree(context.Background(), dir, SnapshotOptions{}, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories with equal checksums are not descended into
+	delete(older.dirs, "same")
+	delete(older.dirs, "same/deep")
+
+	want := []TreeChange{
+		{Path: "docs/new.txt", Kind: TreeAdded},
+		{Path: "docs/old.txt", Kind: TreeDeleted},
+		{Path: "docs/report.txt", Kind: TreeModified},
+		{Path: "gone/c.txt", Kind: TreeDeleted},
+	}
+	if got := CompareTrees(older, newer); !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %v, want %v", got, want)
+	}
+	if got := CompareTrees(newer, newer); len(got) != 0 {
+		t.Errorf("tree differs from itself: %v", got)
+	}
+}
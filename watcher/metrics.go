@@ -0,0 +1,149 @@
+package watcher
+
+// Metrics of a running watcher in the Prometheus text format. The names and
+// labels are stable, dashboards and alerts rely on them: metrics are only
+// added, never renamed or removed, and every name starts with fwbackup_.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// metric writes a metric without labels
+func (mw *metricsWriter) metric(name, kind, help string, value float64) {
+	mw.header(name, kind, help)
+	mw.printf("%s %v\n", name, value)
+}
+
+// labeled writes a metric with one label, one sample per label value
+func (mw *metricsWriter) labeled(name, kind, help, label string, values map[string]int) {
+	mw.header(name, kind, help)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mw.printf("%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+// escapeLabel escapes a label value as the text format requires
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// WriteMetrics writes the metrics of the watcher to w in the Prometheus text format
+func (fw *FileWatcher) WriteMetrics(w io.Writer) error {
+	status := fw.Status()
+	stats := status.Stats
+	mw := &metricsWriter{w: w}
+
+	// Every outcome and error kind is reported from the start, so rates work before the first event
+	outcomes := map[string]int{
+		audit.OutcomeBackedUp:        0,
+		audit.OutcomeFailed:          0,
+		audit.OutcomeSkippedVanished: 0,
+		audit.OutcomeSkippedInterval: 0,
+		audit.OutcomeDropped:         0,
+		audit.OutcomeSkippedTooLarge: 0,
+		audit.OutcomeSkippedPolicy:   0,
+		audit.OutcomeDeferredLocked:  0,
+		audit.OutcomeDeferredChanged: 0,
+		audit.OutcomeDeferredCopies:  0,
+	}
+	for outcome, n := range status.Outcomes {
+		outcomes[outcome] = n
+	}
+	failures := make(map[string]int)
+	for _, kind := range utils.ErrorLabels() {
+		failures[kind] = 0
+	}
+	for kind, n := range status.Errors {
+		failures[kind] = n
+	}
+
+	var lastBackup float64
+	if len(status.Recent) > 0 {
+		lastBackup = float64(status.Recent[0].Time.UnixNano()) / 1e9
+	}
+	var paused float64
+	if status.Paused {
+		paused = 1
+	}
+
+	mw.metric("fwbackup_start_time_seconds", "gauge", "Time the watcher started, in seconds since the epoch.",
+		float64(status.Started.Unix()))
+	mw.labeled("fwbackup_jobs_total", "counter", "File events handled, by outcome.", "outcome", outcomes)
+	mw.labeled("fwbackup_failures_total", "counter", "Failed backups, by error kind.", "kind", failures)
+	mw.labeled("fwbackup_events_observed_total", "counter", "File events reported by the file system watcher, by type.",
+		"type", stats.EventsObserved)
+	mw.labeled("fwbackup_events_queued_total", "counter", "File events that queued a backup, by type.",
+		"type", stats.EventsQueued)
+	mw.labeled("fwbackup_ignore_hits_total", "counter", "Paths matched by each ignore pattern.",
+		"pattern", stats.IgnoreHits)
+	mw.labeled("fwbackup_include_hits_total", "counter", "Paths matched by each include pattern of --only.",
+		"pattern", stats.IncludeHits)
+	mw.metric("fwbackup_last_backup_timestamp_seconds", "gauge",
+		"Time the last version was stored, in seconds since the epoch, 0 before the first one.", lastBackup)
+
+	mw.header("fwbackup_job_duration_seconds", "summary", "Time backup jobs took, from a worker taking them to their outcome.")
+	mw.printf("fwbackup_job_duration_seconds_sum %v\n", time.Duration(fw.jobsTime.Load()).Seconds())
+	mw.printf("fwbackup_job_duration_seconds_count %d\n", fw.jobsDone.Load())
+	mw.metric("fwbackup_bytes_written_total", "counter", "Size of the versions stored, in bytes.", float64(stats.BytesWritten))
+
+	mw.metric("fwbackup_queue_length", "gauge", "Backup jobs waiting for a worker.", float64(stats.QueueLength))
+	mw.metric("fwbackup_queue_capacity", "gauge", "Backup jobs the queue holds before it drops new ones.",
+		float64(stats.QueueCapacity))
+	mw.metric("fwbackup_workers_active", "gauge", "Backup workers running.", float64(stats.ActiveWorkers))
+	mw.metric("fwbackup_workers_restarted_total", "counter", "Workers restarted after a panic.",
+		float64(stats.RestartedWorkers))
+	mw.metric("fwbackup_workers_lost_total", "counter", "Workers that died and were not replaced.",
+		float64(stats.LostWorkers))
+	mw.metric("fwbackup_workers_stalled_total", "counter", "Workers replaced after a job ran past --job-timeout.",
+		float64(stats.StalledWorkers))
+	mw.metric("fwbackup_jobs_timed_out_total", "counter", "Jobs that ran past --job-timeout.",
+		float64(stats.TimedOutJobs))
+	mw.metric("fwbackup_copy_bytes_per_second", "gauge", "Bytes copied per second, averaged over the last seconds.",
+		stats.ThrottleBytesRate)
+	mw.metric("fwbackup_copy_ops_per_second", "gauge", "Copy operations per second, averaged over the last seconds.",
+		stats.ThrottleOpsRate)
+	mw.metric("fwbackup_tracked_files", "gauge", "Files with a known last backup time.", float64(stats.TrackedFiles))
+	mw.metric("fwbackup_sweep_missed_total", "counter", "Changes missed by the file system watcher and found by a sweep.",
+		float64(stats.SweepMissed))
+	mw.metric("fwbackup_unwatched_dirs", "gauge", "Directories polled because they can not be watched.",
+		float64(stats.UnwatchedDirs))
+	mw.metric("fwbackup_rewatch_dirs", "gauge", "Directories whose watch failed and is tried again.",
+		float64(stats.RewatchDirs))
+	mw.metric("fwbackup_dead_letters", "gauge", "Files whose backup failed for good, until they change again.",
+		float64(stats.DeadLetters))
+	mw.metric("fwbackup_paused", "gauge", "1 while backups are paused, 0 otherwise.", paused)
+	mw.metric("fwbackup_paused_changes", "gauge", "Files changed while backups are paused.",
+		float64(stats.PausedChanges))
+
+	return mw.err
+}
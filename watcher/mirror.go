@@ -0,0 +1,118 @@
+package watcher
+
+// Mirrors are further destinations every new version is copied to, e.g. a
+// mounted remote file system. A backup only succeeds once config.MinCopies
+// destinations, counting the backup directory, stored the version. The
+// mirrors get it before it is recorded in the manifest and old versions are
+// pruned, a version stored too few times is removed again and its backup
+// deferred until enough mirrors are back. Mirrors keep every version they
+// receive, they are neither pruned nor indexed.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+const (
+	// copiesDelay is the delay before a backup stored too few times is tried
+	// again, doubled for every further try up to copiesMaxDelay
+	copiesDelay    = 30 * time.Second
+	copiesMaxDelay = time.Hour
+)
+
+// replicate copies the version at src, stored as backupPath, to every
+// mirror. Mirrors that
+// fail are retried like jobs, see config.JobRetries. It fails with
+// utils.ErrTooFewCopies when fewer than config.MinCopies destinations hold
+// the version in the end.
+func (fw *FileWatcher) replicate(ctx context.Context, id int, src, backupPath string) error {
+	if len(fw.config.Mirrors) == 0 {
+		return nil
+	}
+
+	rel, err := filepath.Rel(fw.config.BackupDir, backupPath)
+	if err != nil {
+		return err
+	}
+
+	failed := make(map[string]error, len(fw.config.Mirrors))
+	for _, mirror := range fw.config.Mirrors {
+		failed[mirror] = nil
+	}
+
+	copyAll := func() error {
+		for mirror := range failed {
+			if err := fw.copyToMirror(ctx, src, filepath.Join(mirror, rel)); err != nil {
+				failed[mirror] = err
+				continue
+			}
+			delete(failed, mirror)
+		}
+		if len(failed) > 0 {
+			return utils.ErrIO
+		}
+		return nil
+	}
+
+	attempt := 0
+	utils.RetryWithBackoff(ctx, fw.config.JobRetries+1, fw.config.JobRetryDelay, func() error {
+		attempt++
+		if attempt > 1 {
+			fw.logger.Warning("Worker #%d: retrying %d mirrors of %s (retry %d of %d)",
+				id, len(failed), filepath.Base(backupPath), attempt-1, fw.config.JobRetries)
+		}
+		return copyAll()
+	})
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	var reasons []string
+	for mirror, err := range failed {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", mirror, err))
+	}
+	sort.Strings(reasons)
+	destinations := 1 + len(fw.config.Mirrors)
+	copies := destinations - len(failed)
+
+	if copies >= fw.config.MinCopies {
+		fw.logger.Warning("Version %s is stored in %d of %d destinations: %s",
+			filepath.Base(backupPath), copies, destinations, strings.Join(reasons, "; "))
+		return nil
+	}
+
+	return utils.NewBackupError(backupPath, utils.OpReplicate,
+		fmt.Errorf("%w: %d of %d, %d required: %s", utils.ErrTooFewCopies,
+			copies, destinations, fw.config.MinCopies, strings.Join(reasons, "; ")))
+}
+
+// deferCopies finishes the job of a file whose version too few destinations
+// stored and queues it again after a delay. It is tried until enough mirrors
+// are back, with the delay doubled every time up to copiesMaxDelay; the job
+// is journaled meanwhile. It returns like finishJob.
+func (fw *FileWatcher) deferCopies(slot *workerSlot, job BackupJob, err error) bool {
+	delay := deferDelay(max(copiesDelay, fw.config.JobRetryDelay), job.Deferrals, copiesMaxDelay)
+	return fw.deferJob(slot, job, audit.OutcomeDeferredCopies, "too few destinations stored the version", delay, 0, err)
+}
+
+// copyToMirror copies a version to its path in a mirror
+func (fw *FileWatcher) copyToMirror(ctx context.Context, src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return utils.CopyFile(ctx, src, dst, utils.CopyOptions{
+		MaxRetries: 3,
+		Throttle:   fw.BackupManager.throttle,
+		Clone:      fw.BackupManager.reflink,
+	})
+}
@@ -0,0 +1,202 @@
+package watcher
+
+// Moves of files. A file moved to another directory of the source, or renamed,
+// arrives as a RENAME of its old path and a CREATE of its new one. Taken as
+// they are the history stays at the old path and the file starts a new one.
+// A CREATE shortly after a RENAME is paired with it when the new file has the
+// size and the modification time of the latest version of the renamed one, a
+// move keeps both. Its version directory is then moved to the new path, the
+// versions renamed after the new name, and each manifest entry records the
+// path its version was made of. A file moved onto a path with versions of its
+// own keeps both histories apart.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// moveWindow is how long a renamed file waits for its new path
+const moveWindow = 2 * time.Second
+
+// errHistoryBusy is returned when a backup of the moved file is running, the
+// file is then backed up as a new one
+var errHistoryBusy = errors.New("its versions are being written")
+
+// movedFiles are the files renamed recently, by path
+type movedFiles struct {
+	mu      sync.Mutex
+	renamed map[string]time.Time
+}
+
+// add records path as renamed
+func (m *movedFiles) add(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.renamed == nil {
+		m.renamed = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for old, at := range m.renamed {
+		if now.Sub(at) > moveWindow {
+			delete(m.renamed, old)
+		}
+	}
+	m.renamed[path] = now
+}
+
+// take removes and returns the most recently renamed file matching, "" when
+// none does
+func (m *movedFiles) take(match func(path string) bool) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found string
+	var foundAt time.Time
+	for path, at := range m.renamed {
+		if time.Since(at) > moveWindow || at.Before(foundAt) || !match(path) {
+			continue
+		}
+		found, foundAt = path, at
+	}
+	delete(m.renamed, found)
+	return found
+}
+
+// followMove moves the history of the file renamed to path when it was moved
+// there, info is the file at path. It reports whether it was a move.
+func (fw *FileWatcher) followMove(path string, info os.FileInfo) bool {
+	newRel := fw.relPath(path)
+	if versions, _ := fw.BackupManager.Versions(filepath.FromSlash(newRel)); len(versions) > 0 {
+		return false
+	}
+
+	old := fw.moves.take(func(old string) bool {
+		return fw.BackupManager.IsCurrent(filepath.FromSlash(fw.relPath(old)), info)
+	})
+	if old == "" {
+		return false
+	}
+
+	oldRel := fw.relPath(old)
+	if err := fw.BackupManager.MoveHistory(filepath.FromSlash(oldRel), filepath.FromSlash(newRel)); err != nil {
+		fw.logger.Warning("Could not move the history of %s to %s: %v", oldRel, newRel, err)
+		return false
+	}
+
+	fw.mu.Lock()
+	if last, ok := fw.lastBackup[old]; ok {
+		fw.lastBackup[path] = last
+		delete(fw.lastBackup, old)
+	}
+	fw.mu.Unlock()
+
+	fw.logger.Info("Moved %s to %s, its versions moved along", oldRel, newRel)
+	return true
+}
+
+// MoveHistory moves the versions of the file oldRel to newRel, both relative
+// to the watched directory, renaming them after the new name. newRel must not
+// have versions yet.
+func (bm *BackupManager) MoveHistory(oldRel, newRel string) error {
+	// Locked in a fixed order, a move back at the same time must not deadlock
+	first, second := bm.fileKey(oldRel), bm.fileKey(newRel)
+	if second < first {
+		first, second = second, first
+	}
+	// Called for file events, it must not wait for a backup of either file
+	for _, dir := range []string{first, second} {
+		unlock := bm.fileLocks.tryLock(dir)
+		if unlock == nil {
+			return errHistoryBusy
+		}
+		defer unlock()
+	}
+
+	if bm.dateLayout {
+		return bm.moveDateHistory(oldRel, newRel)
+	}
+
+	oldDir, oldName := bm.versionDir(oldRel)
+	newDir, newName := bm.versionDir(newRel)
+	if _, err := os.Lstat(newDir); err == nil {
+		return fmt.Errorf("%s has versions already", newRel)
+	}
+
+	versions, err := bm.listVersions(oldDir, oldName)
+	if err != nil {
+		return fmt.Errorf("error listing versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("%s has no versions", oldRel)
+	}
+	entries, err := bm.readManifest(oldDir)
+	if err != nil {
+		return fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	// Names are chosen before anything moves, a move failing halfway would
+	// leave versions under both names
+	names := make(map[string]string, len(versions))
+	taken := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		name := filepath.Base(version)
+		renamed, ok := renameVersion(oldName, newName, name)
+		if !ok {
+			renamed = name
+		}
+		if taken[renamed] {
+			return fmt.Errorf("versions %s would have the same name", renamed)
+		}
+		names[name] = renamed
+		taken[renamed] = true
+	}
+	for name, renamed := range names {
+		if _, ok := names[renamed]; ok && renamed != name {
+			// Renamed one by one, it would replace a version not renamed yet
+			return fmt.Errorf("version %s would replace %s", name, renamed)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return err
+	}
+	bm.index.forgetDir(oldDir)
+	bm.index.forgetDir(newDir)
+
+	moved := make([]manifestEntry, 0, len(versions))
+	for _, version := range versions {
+		name := filepath.Base(version)
+		entry, ok := entries[name]
+		if !ok {
+			// Made before manifests, its time is in its name
+			entry = manifestEntry{Version: name}
+			entry.Time, _ = parseVersionTime(oldName, name)
+		}
+		if entry.MovedFrom == "" {
+			entry.MovedFrom = filepath.ToSlash(oldRel)
+		}
+
+		// Versions named otherwise, known from the manifest, keep their name
+		if names[name] != name {
+			entry.Version = names[name]
+			if err := os.Rename(filepath.Join(newDir, name), filepath.Join(newDir, entry.Version)); err != nil {
+				return fmt.Errorf("error renaming version %s: %w", name, err)
+			}
+			streams := streamsDir(filepath.Join(newDir, name))
+			if _, err := os.Stat(streams); err == nil {
+				os.Rename(streams, streamsDir(filepath.Join(newDir, entry.Version)))
+			}
+		}
+		moved = append(moved, entry)
+	}
+
+	return bm.writeManifest(newDir, moved)
+}
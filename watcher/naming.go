@@ -0,0 +1,178 @@
+package watcher
+
+// Version file names. By default they embed the creation time with microsecond
+// precision, which shows activity patterns to anyone who can list the backup
+// directory. Reduced precision, sequence and hash names hide them, the full
+// creation time is then kept in the version manifest instead. Hash names embed
+// the start of the content checksum, for tools that address content by hash.
+// Sequence names number the versions as <name>.v001<ext>; repositories
+// written before numbered them <name>_00000001<ext>, those names are still read.
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// namingLayouts maps timestamp naming modes to the layout embedded in names
+var namingLayouts = map[string]string{
+	config.NamingMicrosecond: "20060102_150405.000000",
+	config.NamingSecond:      "20060102_150405",
+	config.NamingMinute:      "20060102_1504",
+	config.NamingHour:        "20060102_15",
+	config.NamingDay:         "20060102",
+}
+
+// versionTimeLayout formats the creation time embedded in default version names
+var versionTimeLayout = namingLayouts[config.NamingMicrosecond]
+
+// sequenceDigits is the minimum width of sequence numbers, wider ones take
+// more digits
+const sequenceDigits = 3
+
+// legacySequenceDigits is the width of sequence numbers in names of the
+// versions numbered <name>_00000001<ext>
+const legacySequenceDigits = 8
+
+// hashDigits is the number of hex digits of the checksum in hash names
+const hashDigits = 16
+
+// versionName returns the name of a version of the file baseName+ext in a
+// timestamp or the sequence naming mode. seq is only used by sequence naming.
+func versionName(naming, baseName, ext string, created time.Time, seq int) string {
+	if naming == config.NamingSequence {
+		return fmt.Sprintf("%s.v%0*d%s", baseName, sequenceDigits, seq, ext)
+	}
+
+	layout, ok := namingLayouts[naming]
+	if !ok {
+		layout = versionTimeLayout
+	}
+	return fmt.Sprintf("%s_%s%s", baseName, created.Format(layout), ext)
+}
+
+// splitVersionName returns the stamp of versionName, a version of fileName
+func splitVersionName(fileName, versionName string) (string, bool) {
+	ext := filepath.Ext(fileName)
+	stamp, ok := strings.CutPrefix(versionName, strings.TrimSuffix(fileName, ext)+"_")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(stamp, ext)
+}
+
+// splitSequenceName returns the digits of versionName, a version of fileName
+// named <name>.v<digits><ext>
+func splitSequenceName(fileName, versionName string) (string, bool) {
+	ext := filepath.Ext(fileName)
+	digits, ok := strings.CutPrefix(versionName, strings.TrimSuffix(fileName, ext)+".v")
+	if !ok {
+		return "", false
+	}
+	digits, ok = strings.CutSuffix(digits, ext)
+	if !ok || len(digits) < sequenceDigits || strings.Trim(digits, "0123456789") != "" {
+		return "", false
+	}
+	return digits, true
+}
+
+// parseSequence returns the sequence number of a version name, if it has one
+func parseSequence(fileName, versionName string) (int, bool) {
+	digits, ok := splitSequenceName(fileName, versionName)
+	if !ok {
+		// Numbered before, <name>_00000001<ext>
+		digits, ok = splitVersionName(fileName, versionName)
+		if !ok || len(digits) < legacySequenceDigits {
+			return 0, false
+		}
+	}
+
+	seq, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// renameVersion returns the name versionName, a version of oldName, takes as
+// a version of newName. It reports false for names not made by any naming
+// mode, e.g. versions only known from their manifest entry.
+func renameVersion(oldName, newName, versionName string) (string, bool) {
+	newExt := filepath.Ext(newName)
+	newBase := strings.TrimSuffix(newName, newExt)
+
+	if digits, ok := splitSequenceName(oldName, versionName); ok {
+		return newBase + ".v" + digits + newExt, true
+	}
+	if stamp, ok := splitVersionName(oldName, versionName); ok {
+		return newBase + "_" + stamp + newExt, true
+	}
+	return "", false
+}
+
+// parseVersionTime extracts the creation time from the name of a version of fileName,
+// with the precision of its naming mode
+func parseVersionTime(fileName, versionName string) (time.Time, bool) {
+	stamp, ok := splitVersionName(fileName, versionName)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	for _, naming := range []string{config.NamingMicrosecond, config.NamingSecond, config.NamingMinute, config.NamingHour, config.NamingDay} {
+		layout := namingLayouts[naming]
+		if len(stamp) != len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, stamp, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isVersionName reports whether versionName is a version of fileName, named
+// in any of the naming modes
+func isVersionName(fileName, versionName string) bool {
+	if _, ok := parseVersionTime(fileName, versionName); ok {
+		return true
+	}
+	if _, ok := parseSequence(fileName, versionName); ok {
+		return true
+	}
+	stamp, ok := splitVersionName(fileName, versionName)
+	return ok && isHashStamp(stamp)
+}
+
+// isTimeStamp reports whether stamp is the creation time created in one of
+// the timestamp naming modes
+func isTimeStamp(stamp string, created time.Time) bool {
+	for _, layout := range namingLayouts {
+		if created.Format(layout) == stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// isHashStamp reports whether stamp is the checksum part of a hash name
+func isHashStamp(stamp string) bool {
+	if len(stamp) != hashDigits {
+		return false
+	}
+	for _, c := range stamp {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// hashVersionName returns the hash name of a version of the file with the
+// content checksum sum, hex encoded
+func hashVersionName(baseName, ext, sum string) string {
+	return fmt.Sprintf("%s_%s%s", baseName, sum[:hashDigits], ext)
+}
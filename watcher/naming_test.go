@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+func TestSequenceNames(t *testing.T) {
+	name := versionName(config.NamingSequence, "report", ".txt", time.Now(), 42)
+	if name != "report.v042.txt" {
+		t.Fatalf("sequence name %s, want report.v042.txt", name)
+	}
+
+	tests := []struct {
+		version string
+		seq     int
+		ok      bool
+	}{
+		{"report.v042.txt", 42, true},
+		{"report.v1000.txt", 1000, true},
+		{"report_00000042.txt", 42, true}, // Numbered by earlier releases
+		{"report.v42.txt", 0, false},
+		{"report.v042.md", 0, false},
+		{"report_20240601.txt", 20240601, true}, // A day name, told apart by lastSequence
+		{"other.v001.txt", 0, false},
+	}
+	for _, tt := range tests {
+		seq, ok := parseSequence("report.txt", tt.version)
+		if seq != tt.seq || ok != tt.ok {
+			t.Errorf("parseSequence(%s) = %d, %v, want %d, %v", tt.version, seq, ok, tt.seq, tt.ok)
+		}
+	}
+}
+
+func TestRenameVersion(t *testing.T) {
+	tests := []struct {
+		version, renamed string
+		ok               bool
+	}{
+		{"report.v007.txt", "notes.v007.md", true},
+		{"report_20240601_120000.000000.txt", "notes_20240601_120000.000000.md", true},
+		{"report_00000042.txt", "notes_00000042.md", true},
+		{"imported.bin", "", false},
+	}
+	for _, tt := range tests {
+		renamed, ok := renameVersion("report.txt", "notes.md", tt.version)
+		if renamed != tt.renamed || ok != tt.ok {
+			t.Errorf("renameVersion(%s) = %s, %v, want %s, %v", tt.version, renamed, ok, tt.renamed, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package watcher
+
+// A single backup pass over the source directory without watching it,
+// e.g. for cron jobs. It shares the workers, filters, versioning and
+// retention with the watcher.
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EventScan is the event type of jobs queued by a backup pass
+const EventScan = "SCAN"
+
+// PassSummary counts what a backup pass found
+type PassSummary struct {
+	Scanned   int // Files matching the filters
+	Unchanged int // Files whose latest version is still current, they were not backed up
+	Queued    int // Files handed to the workers
+}
+
+// BackupAll backs up every file in the source directory that changed since
+// its latest version and returns once the workers are done. Outcomes are
+// reported like the ones of the watcher, see OnOutcome. When ctx is canceled
+// no more files are queued and only the backups in progress are finished.
+// The FileWatcher can not be used anymore afterwards.
+func (fw *FileWatcher) BackupAll(ctx context.Context) (PassSummary, error) {
+	var summary PassSummary
+
+	fw.startWorkerPool()
+	if fw.config.JobTimeout > 0 {
+		go fw.watchdog()
+	}
+
+	// Leave the queued files alone once canceled, like Stop without DrainOnExit
+	stop := context.AfterFunc(ctx, func() {
+		fw.stopping.Store(true)
+		fw.abandonQueue()
+	})
+	defer stop()
+
+	walkErr := fw.walkSource(ctx, func(path string, info os.FileInfo) error {
+		summary.Scanned++
+
+		if fw.skipTooLarge(path, EventScan, info) || fw.skipByPolicy(path, EventScan, info) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(fw.config.SourceDir, path)
+		if err == nil && fw.BackupManager.IsCurrent(relPath, info) {
+			summary.Unchanged++
+			return nil
+		}
+
+		// Wait for a free slot instead of dropping the job, nothing is lost by waiting here
+		select {
+		case fw.backupQueue <- BackupJob{FilePath: path, EventType: EventScan, Timestamp: time.Now(), Size: info.Size()}:
+			summary.Queued++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	close(fw.backupQueue)
+
+	fw.waitWorkers(context.Background())
+	fw.release()
+	fw.watcher.Close()
+
+	return summary, walkErr
+}
+
+// walkSource calls fn for every regular file of the source directory that
+// passes the ignore, include and owner filters, until fn returns an error
+// or ctx is canceled. Unreadable directories are logged and skipped.
+func (fw *FileWatcher) walkSource(ctx context.Context, fn func(path string, info os.FileInfo) error) error {
+	return fw.walkTree(ctx, fw.config.SourceDir, fn)
+}
+
+// walkTree is walkSource for the directory root inside the source directory
+func (fw *FileWatcher) walkTree(ctx context.Context, root string, fn func(path string, info os.FileInfo) error) error {
+	return fw.walkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if err != nil {
+			fw.logger.Warning("Could not read %s: %v", path, err)
+			return nil
+		}
+
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			// Never back up the backups when they are stored inside the source
+			if fw.isExcluded(path) || fw.shouldIgnore(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || fw.isExcluded(path) || fw.shouldIgnore(path) || !fw.isIncluded(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !fw.owners.matches(info) {
+			return nil
+		}
+
+		return fn(path, info)
+	})
+}
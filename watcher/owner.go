@@ -0,0 +1,79 @@
+package watcher
+
+// Filtering of files by owner and group, so churn from other users in
+// shared directories does not trigger backups.
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ownerFilter matches files owned by a user and/or group, -1 matches anyone
+type ownerFilter struct {
+	uid int
+	gid int
+}
+
+// newOwnerFilter resolves user and group names or numeric IDs, empty matches anyone
+func newOwnerFilter(owner, group string) (ownerFilter, error) {
+	filter := ownerFilter{uid: -1, gid: -1}
+
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return filter, fmt.Errorf("unknown owner %q: %w", owner, lookupErr)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return filter, fmt.Errorf("owner %q has no numeric user ID", owner)
+			}
+		}
+		filter.uid = id
+	}
+
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return filter, fmt.Errorf("unknown group %q: %w", group, lookupErr)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return filter, fmt.Errorf("group %q has no numeric group ID", group)
+			}
+		}
+		filter.gid = id
+	}
+
+	if filter.active() && !ownershipSupported {
+		return filter, fmt.Errorf("owner and group filters are not supported on this platform")
+	}
+
+	return filter, nil
+}
+
+// active reports whether the filter excludes anything
+func (f ownerFilter) active() bool {
+	return f.uid >= 0 || f.gid >= 0
+}
+
+// matches reports whether a file passes the filter. Files that could not be
+// stat'ed only pass an inactive filter.
+func (f ownerFilter) matches(info os.FileInfo) bool {
+	if !f.active() {
+		return true
+	}
+	if info == nil {
+		return false
+	}
+
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return false
+	}
+
+	return (f.uid < 0 || f.uid == uid) && (f.gid < 0 || f.gid == gid)
+}
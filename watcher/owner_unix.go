@@ -0,0 +1,19 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+const ownershipSupported = true
+
+// fileOwner returns the numeric owner and group of a file
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
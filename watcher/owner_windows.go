@@ -0,0 +1,10 @@
+package watcher
+
+import "os"
+
+const ownershipSupported = false
+
+// fileOwner is not available, Windows files have security descriptors instead of owner IDs
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,170 @@
+package watcher
+
+// Ignore and include patterns are checked for every file event and every
+// file of a walk, so they are compiled once instead of parsed on each check.
+// Most patterns are a file name (".git"), an extension ("*.tmp") or a prefix
+// ("~$*"), those are matched with plain string comparisons. Every rule counts
+// the paths it matched, to find the rules worth keeping.
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// globKind is how a compiled pattern is matched
+type globKind int
+
+const (
+	globLiteral globKind = iota // No wildcards, the name equals the pattern
+	globSuffix                  // "*" followed by a literal, e.g. "*.tmp"
+	globPrefix                  // A literal followed by "*", e.g. "~$*"
+	globGeneric                 // Anything else, matched with filepath.Match or path.Match
+)
+
+// globMeta are the characters that make a pattern more than a literal
+const globMeta = `*?[\`
+
+// patternRule is a compiled ignore or include pattern
+type patternRule struct {
+	pattern string
+	kind    globKind
+	literal string       // The literal part of the pattern, for every kind but globGeneric
+	onPath  bool         // Include patterns with a slash match the relative path, not the file name
+	hits    atomic.Int64 // Paths matched by the rule
+}
+
+func compileRule(pattern string, onPath bool) *patternRule {
+	rule := &patternRule{pattern: pattern, kind: globGeneric, onPath: onPath}
+
+	// On paths "*" stops at a slash, only literals are safe to compare
+	switch n := len(pattern); {
+	case !strings.ContainsAny(pattern, globMeta):
+		rule.kind, rule.literal = globLiteral, pattern
+	case onPath:
+	case pattern[0] == '*' && !strings.ContainsAny(pattern[1:], globMeta):
+		rule.kind, rule.literal = globSuffix, pattern[1:]
+	case pattern[n-1] == '*' && !strings.ContainsAny(pattern[:n-1], globMeta):
+		rule.kind, rule.literal = globPrefix, pattern[:n-1]
+	}
+	return rule
+}
+
+// match reports whether name, a file name or a slash separated relative
+// path for onPath rules, matches the pattern
+func (r *patternRule) match(name string) bool {
+	switch r.kind {
+	case globLiteral:
+		return name == r.literal
+	case globSuffix:
+		return strings.HasSuffix(name, r.literal)
+	case globPrefix:
+		return strings.HasPrefix(name, r.literal)
+	}
+
+	var matched bool
+	if r.onPath {
+		matched, _ = path.Match(r.pattern, name)
+	} else {
+		matched, _ = filepath.Match(r.pattern, name)
+	}
+	return matched
+}
+
+// filters are the compiled ignore and include patterns of a configuration
+type filters struct {
+	ignore  []*patternRule
+	include []*patternRule
+	onPath  bool // Some include pattern matches the relative path
+}
+
+// ignores reports whether path matches one of the ignore patterns
+func (f *filters) ignores(path string) bool {
+	base := filepath.Base(path)
+	for _, rule := range f.ignore {
+		if rule.match(base) || strings.Contains(path, rule.pattern) {
+			rule.hits.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// includes reports whether file matches one of the include patterns, all
+// files do when there are none. Patterns without a slash match the file name,
+// the others the path relative to sourceDir.
+func (f *filters) includes(file, sourceDir string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+
+	base := filepath.Base(file)
+	var rel string
+	if f.onPath {
+		var err error
+		if rel, err = filepath.Rel(sourceDir, file); err != nil {
+			rel = file
+		}
+		rel = filepath.ToSlash(rel)
+	}
+
+	for _, rule := range f.include {
+		name := base
+		if rule.onPath {
+			name = rel
+		}
+
+		if rule.match(name) {
+			rule.hits.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// filterSet holds the filters in use, replaced as a whole by Reload
+type filterSet struct {
+	atomic.Pointer[filters]
+}
+
+// compileFilters compiles the patterns of cfg. Rules also found in previous
+// keep their hit counts, so counts survive a reload.
+func compileFilters(cfg *config.Config, previous *filters) *filters {
+	f := &filters{}
+	for _, pattern := range cfg.IgnorePatterns {
+		f.ignore = append(f.ignore, compileRule(pattern, false))
+	}
+	for _, pattern := range cfg.OnlyPatterns {
+		onPath := strings.Contains(pattern, "/")
+		f.include = append(f.include, compileRule(pattern, onPath))
+		f.onPath = f.onPath || onPath
+	}
+
+	if previous != nil {
+		carryHits(f.ignore, previous.ignore)
+		carryHits(f.include, previous.include)
+	}
+	return f
+}
+
+func carryHits(rules, previous []*patternRule) {
+	hits := make(map[string]int64, len(previous))
+	for _, rule := range previous {
+		hits[rule.pattern] = rule.hits.Load()
+	}
+	for _, rule := range rules {
+		rule.hits.Store(hits[rule.pattern])
+	}
+}
+
+// ruleHits returns the hit count of every rule by pattern
+func ruleHits(rules []*patternRule) map[string]int {
+	hits := make(map[string]int, len(rules))
+	for _, rule := range rules {
+		hits[rule.pattern] = int(rule.hits.Load())
+	}
+	return hits
+}
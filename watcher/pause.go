@@ -0,0 +1,168 @@
+package watcher
+
+// Pausing backups, e.g. during a big build or a git rebase. Changes are still
+// watched while paused, each changed file is collected once and queued when
+// backups are resumed, so only its latest content is backed up. Collected
+// changes are journaled, a restart while paused backs them up as well.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// pauseState holds the changes collected while backups are paused
+type pauseState struct {
+	mu      sync.Mutex
+	resumed chan struct{}        // Closed on resume, nil while not paused
+	since   time.Time            // When backups were paused
+	changes map[string]BackupJob // Changed files by path, already journaled
+	order   []string             // Paths in the order they first changed
+}
+
+// hold collects job instead of queueing it while paused and reports whether it
+// did. Only the first change of a file is kept, later ones are backed up with it.
+func (fw *FileWatcher) hold(job BackupJob) bool {
+	p := &fw.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed == nil {
+		return false
+	}
+	// A change dropped from the queue is replaced by the next one
+	held, ok := p.changes[job.FilePath]
+	if ok && !fw.journal.dropped(held.ID) {
+		return true
+	}
+
+	if err := fw.journal.add(&job); err != nil {
+		fw.logger.Warning("Could not write queue journal: %v", err)
+	}
+	p.changes[job.FilePath] = job
+	if !ok {
+		p.order = append(p.order, job.FilePath)
+	}
+	return true
+}
+
+// waitResumed holds a worker while backups are paused. It returns early when
+// the workers are told to quit.
+func (fw *FileWatcher) waitResumed() {
+	fw.pause.mu.Lock()
+	resumed := fw.pause.resumed
+	fw.pause.mu.Unlock()
+
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-fw.quit:
+	}
+}
+
+// Pause stops backing up changes until Resume is called. Jobs in progress are
+// finished, queued ones wait. It reports false when backups were already paused.
+func (fw *FileWatcher) Pause() bool {
+	p := &fw.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed != nil {
+		return false
+	}
+
+	p.resumed = make(chan struct{})
+	p.since = time.Now()
+	p.changes = make(map[string]BackupJob)
+	p.order = nil
+
+	fw.logger.Warning("Backups paused, changes are collected and backed up on resume")
+	return true
+}
+
+// Paused reports whether backups are paused and since when
+func (fw *FileWatcher) Paused() (bool, time.Time) {
+	fw.pause.mu.Lock()
+	defer fw.pause.mu.Unlock()
+
+	return fw.pause.resumed != nil, fw.pause.since
+}
+
+// count returns the number of changes collected, 0 when not paused
+func (p *pauseState) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.changes)
+}
+
+// release ends a pause without queueing the collected changes, they stay in
+// the journal. It returns them, in the order they first changed.
+func (p *pauseState) release() []BackupJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed == nil {
+		return nil
+	}
+	close(p.resumed)
+	p.resumed = nil
+
+	jobs := make([]BackupJob, 0, len(p.order))
+	for _, path := range p.order {
+		jobs = append(jobs, p.changes[path])
+	}
+	p.changes = nil
+	p.order = nil
+	return jobs
+}
+
+// Resume backs up again after Pause, queueing the files changed while paused.
+// It waits for room in the queue until ctx is canceled or the watcher stops,
+// and returns the number of files queued. Files removed meanwhile are skipped.
+func (fw *FileWatcher) Resume(ctx context.Context) (int, error) {
+	// Registered as a sender, Stop must not close the queue while sending
+	fw.senders.Add(1)
+	defer fw.senders.Done()
+	if fw.stopping.Load() {
+		return 0, errors.New("watcher is stopping")
+	}
+
+	_, since := fw.Paused()
+	jobs := fw.pause.release()
+	if jobs == nil {
+		return 0, nil
+	}
+	fw.logger.Success("Backups resumed after %s, queueing %d files changed meanwhile",
+		time.Since(since).Round(time.Second), len(jobs))
+
+	queued := 0
+	for _, job := range jobs {
+		info, err := os.Stat(job.FilePath)
+		if err != nil || !info.Mode().IsRegular() {
+			fw.journal.done(job.ID)
+			continue
+		}
+		job.Size = info.Size()
+
+		select {
+		case fw.backupQueue <- job:
+		case <-ctx.Done():
+			return queued, ctx.Err()
+		case <-fw.sendersCtx.Done():
+			// Left in the journal for the next start
+			return queued, errors.New("watcher is stopping")
+		}
+
+		fw.mu.Lock()
+		fw.lastBackup[job.FilePath] = time.Now()
+		fw.mu.Unlock()
+		queued++
+	}
+	return queued, nil
+}
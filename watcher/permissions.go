@@ -0,0 +1,129 @@
+package watcher
+
+// Auditing of permission and ownership changes. The mode and owner of every
+// watched file are remembered, from a scan at the start and from the events
+// since, so a CHMOD event can be recorded in the audit log with the values
+// before and after it. Nothing is backed up for it; who changed a file is not
+// known to the file system watcher, only when.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+)
+
+// permSnapshot is the mode and owner of a file when it was last seen
+type permSnapshot struct {
+	mode     os.FileMode
+	uid, gid int
+	owned    bool // Whether uid and gid are known
+}
+
+// permState holds the last seen mode and owner of every watched file
+type permState struct {
+	mu    sync.Mutex
+	files map[string]permSnapshot
+}
+
+func snapshotOf(info os.FileInfo) permSnapshot {
+	s := permSnapshot{mode: info.Mode()}
+	s.uid, s.gid, s.owned = fileOwner(info)
+	return s
+}
+
+// see remembers the mode and owner of a file and returns the ones seen before
+func (ps *permState) see(path string, info os.FileInfo) (permSnapshot, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.files == nil {
+		ps.files = make(map[string]permSnapshot)
+	}
+	before, known := ps.files[path]
+	ps.files[path] = snapshotOf(info)
+	return before, known
+}
+
+// remember records a file found by a scan, unless an event was quicker
+func (ps *permState) remember(path string, info os.FileInfo) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.files == nil {
+		ps.files = make(map[string]permSnapshot)
+	}
+	if _, known := ps.files[path]; !known {
+		ps.files[path] = snapshotOf(info)
+	}
+}
+
+// forget drops a file that was removed or renamed
+func (ps *permState) forget(path string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.files, path)
+}
+
+// scanPermissions remembers the mode and owner of every file in the source
+// directory, so changes of files not touched since the start can be audited
+func (fw *FileWatcher) scanPermissions(ctx context.Context) {
+	defer fw.senders.Done()
+
+	start := time.Now()
+	n := 0
+	err := fw.walkSource(ctx, func(path string, info os.FileInfo) error {
+		fw.perms.remember(path, info)
+		n++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	fw.logger.Info("Permissions of %d files recorded for auditing (%s)", n, time.Since(start).Round(time.Millisecond))
+}
+
+// auditPermissions records a change of the mode or the owner of a file in
+// the audit log. Other attribute changes, e.g. of the modification time,
+// are reported as CHMOD as well and are not recorded.
+func (fw *FileWatcher) auditPermissions(path string, info os.FileInfo) {
+	before, known := fw.perms.see(path, info)
+	if !known {
+		return
+	}
+	after := snapshotOf(info)
+
+	entry := audit.Entry{
+		Time:    time.Now(),
+		Event:   "CHMOD",
+		Path:    path,
+		Outcome: audit.OutcomePermissionsChanged,
+	}
+	var changes []string
+	if before.mode != after.mode {
+		entry.Mode = &audit.Change{From: before.mode.String(), To: after.mode.String()}
+		changes = append(changes, fmt.Sprintf("mode %s → %s", entry.Mode.From, entry.Mode.To))
+	}
+	if before.owned && after.owned && (before.uid != after.uid || before.gid != after.gid) {
+		entry.Owner = &audit.Change{
+			From: fmt.Sprintf("%d:%d", before.uid, before.gid),
+			To:   fmt.Sprintf("%d:%d", after.uid, after.gid),
+		}
+		changes = append(changes, fmt.Sprintf("owner %s → %s", entry.Owner.From, entry.Owner.To))
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	entry.Reason = strings.Join(changes, ", ")
+	fw.logger.Info("Permissions of %s changed: %s", filepath.Base(path), entry.Reason)
+	if err := fw.audit.Record(entry); err != nil {
+		fw.logger.Warning("Could not write audit log: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package watcher
+
+// Backup policy: a CEL expression, see config.Policy, deciding for every
+// changed file that passed the other filters whether it is backed up. It
+// sees the path of the file relative to the watched directory with forward
+// slashes, its size, owner and group names, modification time and the
+// event, e.g.
+//
+//	!(path.endsWith(".log") && size > 10485760) && owner != "build"
+//
+// A policy that fails for a file, e.g. dividing by zero, backs it up: a
+// mistake in the policy must not lose changes.
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// backupPolicy is a compiled backup policy
+type backupPolicy struct {
+	program cel.Program
+	names   sync.Map // Owner and group names by "u<uid>" and "g<gid>"
+}
+
+// newBackupPolicy compiles the policy expr, empty backs up every file
+func newBackupPolicy(expr string) (*backupPolicy, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("path", cel.StringType),
+		cel.Variable("size", cel.IntType),
+		cel.Variable("owner", cel.StringType),
+		cel.Variable("group", cel.StringType),
+		cel.Variable("mtime", cel.TimestampType),
+		cel.Variable("event", cel.StringType),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid policy: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid policy: it returns %s instead of bool", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return &backupPolicy{program: program}, nil
+}
+
+// allows reports whether the policy backs up the file relPath after an
+// event of eventType. info may be nil when the file could not be stat'ed,
+// its size and owner are empty then. Files the policy fails for are allowed.
+func (p *backupPolicy) allows(relPath, eventType string, info os.FileInfo) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+
+	vars := map[string]any{
+		"path":  filepath.ToSlash(relPath),
+		"event": eventType,
+		"size":  int64(0),
+		"owner": "",
+		"group": "",
+		"mtime": time.Time{},
+	}
+	if info != nil {
+		vars["size"] = info.Size()
+		vars["mtime"] = info.ModTime()
+		if uid, gid, ok := fileOwner(info); ok {
+			vars["owner"] = p.name("u", uid)
+			vars["group"] = p.name("g", gid)
+		}
+	}
+
+	out, _, err := p.program.Eval(vars)
+	if err != nil {
+		return true, err
+	}
+	allowed, ok := out.Value().(bool)
+	return allowed || !ok, nil
+}
+
+// name returns the name of the user ("u") or group ("g") with the ID id,
+// or the ID when it has none
+func (p *backupPolicy) name(kind string, id int) string {
+	key := kind + strconv.Itoa(id)
+	if name, ok := p.names.Load(key); ok {
+		return name.(string)
+	}
+
+	name := strconv.Itoa(id)
+	if kind == "u" {
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+	} else if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	p.names.Store(key, name)
+	return name
+}
+
+// policyAllows reports whether the backup policy backs up the file at path
+func (fw *FileWatcher) policyAllows(path, eventType string, info os.FileInfo) bool {
+	relPath, err := filepath.Rel(fw.config.SourceDir, path)
+	if err != nil {
+		relPath = path
+	}
+	allowed, err := fw.policy.allows(relPath, eventType, info)
+	if err != nil {
+		fw.logger.Warning("Backup policy failed for %s, backing it up: %v", relPath, err)
+	}
+	return allowed
+}
@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big.log")
+	writeSource(t, path, "0123456789", 0644, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr    string
+		info    os.FileInfo
+		allowed bool
+	}{
+		{`!path.endsWith(".log")`, info, false},
+		{`path.startsWith("logs/") && size >= 10`, info, true},
+		{`size > 10`, info, false},
+		{`size == 0`, nil, true},
+		{`mtime < timestamp("2024-06-02T00:00:00Z")`, info, true},
+		{`event == "WRITE"`, info, true},
+		{`event == "CREATE"`, info, false},
+		// Failing for the file backs it up
+		{`size / (size - 10) > 0`, info, true},
+	}
+	for _, tt := range tests {
+		policy, err := newBackupPolicy(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		allowed, _ := policy.allows("logs/big.log", "WRITE", tt.info)
+		if allowed != tt.allowed {
+			t.Errorf("%s: allowed %v, want %v", tt.expr, allowed, tt.allowed)
+		}
+	}
+
+	for _, expr := range []string{`size`, `path.endsWith(`, `unknown == 1`} {
+		if _, err := newBackupPolicy(expr); err == nil {
+			t.Errorf("%s: compiled", expr)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package watcher
+
+// Polling of directories that can not be watched. Huge trees exhaust the
+// inotify watch limit (fs.inotify.max_user_watches) on Linux, or the open
+// file limit with kqueue on macOS and the BSDs. The directories above the
+// limit are listed periodically instead and changes found are handled like
+// file system events.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollInterval is how often unwatched directories are listed
+const pollInterval = 10 * time.Second
+
+// fileStamp is what a poll compares to tell whether a file changed
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// poller holds the unwatched directories and what they contained at the last poll
+type poller struct {
+	mu     sync.Mutex
+	dirs   map[string]map[string]fileStamp // Directory → entry name → stamp at the last poll
+	warned bool                            // Set once the watch limit was reported
+}
+
+// count returns the number of polled directories
+func (p *poller) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.dirs)
+}
+
+// isWatchLimit reports whether adding a watch failed because of the inotify
+// watch limit (ENOSPC) or the open file limit (EMFILE) for kqueue
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
+
+// pollDir polls dir from now on. The contents of an existing directory are
+// listed right away, in a new one everything is reported as created.
+func (fw *FileWatcher) pollDir(dir string, existing bool) {
+	stamps := make(map[string]fileStamp)
+	if existing {
+		stamps, _ = listStamps(dir)
+	}
+
+	fw.poll.mu.Lock()
+	defer fw.poll.mu.Unlock()
+
+	if fw.poll.dirs == nil {
+		fw.poll.dirs = make(map[string]map[string]fileStamp)
+	}
+	if _, ok := fw.poll.dirs[dir]; ok {
+		return
+	}
+	fw.poll.dirs[dir] = stamps
+
+	if !fw.poll.warned {
+		fw.poll.warned = true
+		fw.logger.Warning("Could not watch %s, %s. Directories above the limit are polled every %s, "+
+			"which is slower and misses short-lived files. %s",
+			dir, watchLimitName(), pollInterval, watchLimitHint())
+	}
+}
+
+// watchLimitName describes the limit that was hit
+func watchLimitName() string {
+	if runtime.GOOS == "linux" {
+		limit := "unknown"
+		if b, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches"); err == nil {
+			limit = strings.TrimSpace(string(b))
+		}
+		return "the inotify watch limit is reached (fs.inotify.max_user_watches = " + limit + ")"
+	}
+	return "the open file limit is reached"
+}
+
+// watchLimitHint tells how to raise the limit
+func watchLimitHint() string {
+	if runtime.GOOS == "linux" {
+		return "Raise it with 'sudo sysctl fs.inotify.max_user_watches=1048576' " +
+			"and make it permanent in /etc/sysctl.d/, then restart the watcher."
+	}
+	return "Raise it with 'ulimit -n' before starting the watcher."
+}
+
+// pollLoop lists the polled directories every pollInterval until ctx is canceled
+func (fw *FileWatcher) pollLoop(ctx context.Context) {
+	defer fw.senders.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fw.poll.mu.Lock()
+		dirs := make([]string, 0, len(fw.poll.dirs))
+		for dir := range fw.poll.dirs {
+			dirs = append(dirs, dir)
+		}
+		fw.poll.mu.Unlock()
+
+		for _, dir := range dirs {
+			if ctx.Err() != nil {
+				return
+			}
+			fw.pollOnce(dir)
+		}
+	}
+}
+
+// listStamps lists the entries of a directory
+func listStamps(dir string) (map[string]fileStamp, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stamps := make(map[string]fileStamp, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		stamps[entry.Name()] = fileStamp{size: info.Size(), modTime: info.ModTime(), dir: entry.IsDir()}
+	}
+	return stamps, nil
+}
+
+// pollOnce lists a polled directory and handles what changed since the last
+// listing like the corresponding file system events
+func (fw *FileWatcher) pollOnce(dir string) {
+	stamps, err := listStamps(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fw.poll.mu.Lock()
+			delete(fw.poll.dirs, dir)
+			fw.poll.mu.Unlock()
+		}
+		return
+	}
+
+	fw.poll.mu.Lock()
+	previous, ok := fw.poll.dirs[dir]
+	if ok {
+		fw.poll.dirs[dir] = stamps
+	}
+	fw.poll.mu.Unlock()
+
+	// Stopped polling meanwhile
+	if !ok {
+		return
+	}
+
+	for name, stamp := range stamps {
+		old, existed := previous[name]
+		path := filepath.Join(dir, name)
+
+		switch {
+		case !existed:
+			fw.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case !stamp.dir && (old.size != stamp.size || !old.modTime.Equal(stamp.modTime)):
+			fw.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package watcher
+
+// Global pruning across all files, used by the backup size quota and the disk
+// space guard. Unlike retention, which only looks at the versions of one file,
+// it removes the oldest versions of the whole repository first.
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// pruneOldest removes the oldest versions across all files, always keeping the
+// latest version of each file, until at least bytes were freed. It returns the
+// number of bytes freed. bm.pruneMu must be held.
+func (bm *BackupManager) pruneOldest(bytes int64, reason string) int64 {
+	var candidates []VersionInfo
+	var previous *VersionInfo
+
+	// Versions of a file come in a row, oldest first, so all but the last one are candidates
+	_, err := bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		if previous != nil && previous.Path == v.Path {
+			candidates = append(candidates, *previous)
+		}
+		previous = &v
+		return nil
+	})
+	if err != nil {
+		bm.logger.Error("Could not list versions to prune: %v", err)
+		return 0
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Time.Before(candidates[j].Time)
+	})
+
+	var freed int64
+	removed := 0
+	for _, v := range candidates {
+		if freed >= bytes {
+			break
+		}
+
+		path := bm.versionPath(v.Path, v.Version)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			bm.logger.Warning("Could not prune %s: %v", v.Version, err)
+			continue
+		}
+		if err := removeStreams(path); err != nil {
+			bm.logger.Warning("Could not remove the streams of %s: %v", v.Version, err)
+		}
+		if bm.dateLayout {
+			if err := bm.compactDay(filepath.Dir(path)); err != nil {
+				bm.logger.Warning("Could not update the manifest of %s: %v", filepath.Dir(path), err)
+			}
+		}
+		bm.index.forgetDir(bm.fileKey(v.Path))
+		bm.logger.Info("	Removed old version: %s (%s)", filepath.Join(v.Path, v.Version), reason)
+
+		freed += v.Size
+		removed++
+	}
+
+	if removed > 0 {
+		bm.logger.Warning("Pruned %d old versions to stay within the %s", removed, reason)
+	}
+	return freed
+}
+
+// repositorySize returns the total size of all stored versions
+func (bm *BackupManager) repositorySize() (int64, error) {
+	var total int64
+	_, err := bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		total += v.Size
+		return nil
+	})
+	return total, err
+}
+
+// enforceQuota accounts for added bytes of new versions and prunes the oldest
+// versions globally when the repository exceeds the size quota. The running
+// total is an estimate, it is recounted before anything is removed.
+func (bm *BackupManager) enforceQuota(added int64) {
+	if bm.maxTotalSize <= 0 {
+		return
+	}
+
+	if bm.totalSize.Add(added) <= bm.maxTotalSize && bm.totalKnown.Load() {
+		return
+	}
+
+	bm.pruneMu.Lock()
+	defer bm.pruneMu.Unlock()
+
+	total, err := bm.repositorySize()
+	if err != nil {
+		bm.logger.Error("Could not measure backup size: %v", err)
+		return
+	}
+
+	if total > bm.maxTotalSize {
+		total -= bm.pruneOldest(total-bm.maxTotalSize, "backup size quota")
+	}
+	if total > bm.maxTotalSize {
+		bm.logger.Warning("Backups use %s, above the quota of %s, only the latest version of each file is left",
+			utils.FormatSize(total), utils.FormatSize(bm.maxTotalSize))
+	}
+
+	bm.totalSize.Store(total)
+	bm.totalKnown.Store(true)
+}
+
+// SetMaxTotalSize limits the total size of all stored versions, 0 for no limit
+func (bm *BackupManager) SetMaxTotalSize(bytes int64) {
+	bm.maxTotalSize = bytes
+}
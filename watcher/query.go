@@ -0,0 +1,279 @@
+package watcher
+
+// Query layer for browsing stored versions. Results are streamed in a stable
+// order, the order of a sorted walk of the backup directory with the versions
+// of each file oldest first, so a page can be resumed from an opaque cursor
+// without listing everything before it. Whole subtrees before the cursor or
+// outside the path prefix are never read.
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VersionInfo describes one stored version of a file
+type VersionInfo struct {
+	ID        string    `json:"id,omitempty"`         // Stable ID of the version, empty for versions made before IDs existed
+	Path      string    `json:"path"`                 // File path relative to the watched directory, with forward slashes
+	Version   string    `json:"version"`              // Version file name, as accepted by Restore
+	Time      time.Time `json:"time"`                 // When the version was created
+	Size      int64     `json:"size"`                 // Size of the version in bytes
+	SHA256    string    `json:"sha256,omitempty"`     // Checksum of the content, empty for versions made before checksums existed
+	Origin    string    `json:"origin,omitempty"`     // File with the same content stored before, the version shares its storage
+	MovedFrom string    `json:"moved_from,omitempty"` // Path of the file the version was made of, when the file moved since
+}
+
+// VersionQuery selects stored versions, zero fields do not filter
+type VersionQuery struct {
+	Prefix  string    // Only files whose relative path starts with this, e.g. "docs/"
+	Since   time.Time // Only versions created at or after this time
+	Until   time.Time // Only versions created before this time
+	MinSize int64     // Only versions of at least this many bytes
+	MaxSize int64     // Only versions of at most this many bytes, 0 for no limit
+	Cursor  string    // Continue after the last result of a previous page
+	Limit   int       // Maximum number of results, 0 for all
+}
+
+// VersionPage is one page of query results
+type VersionPage struct {
+	Versions   []VersionInfo `json:"versions"`
+	NextCursor string        `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// errPageFull stops the walk once a page is complete
+var errPageFull = errors.New("page full")
+
+// cursorTimeLayout has a fixed width, so keys of versions made of it sort
+// in the order of their creation time
+const cursorTimeLayout = "20060102T150405.000000000"
+
+// QueryVersions calls fn for every version matching q, in a stable order, without
+// holding more than one directory listing in memory. When q.Limit results were
+// passed and more exist, it returns the cursor of the next page.
+// An error returned by fn stops the query and is returned.
+func (bm *BackupManager) QueryVersions(q VersionQuery, fn func(VersionInfo) error) (string, error) {
+	var after []string
+	if q.Cursor != "" {
+		key, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil || len(key) == 0 {
+			return "", fmt.Errorf("invalid cursor: %s", q.Cursor)
+		}
+		after = strings.Split(string(key), "/")
+	}
+
+	w := &versionWalk{
+		bm:    bm,
+		query: q,
+		after: after,
+		fn:    fn,
+	}
+
+	var err error
+	if bm.dateLayout {
+		var days []string
+		if days, err = bm.days(); err == nil {
+			err = w.walkDays(days, nil, len(after) > 0)
+		}
+	} else {
+		err = w.walk(bm.backupDir, nil, len(after) > 0)
+	}
+	if errors.Is(err, errPageFull) {
+		return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(w.last, "/"))), nil
+	}
+	return "", err
+}
+
+// ListVersions returns one page of versions matching q
+func (bm *BackupManager) ListVersions(q VersionQuery) (VersionPage, error) {
+	page := VersionPage{Versions: []VersionInfo{}}
+
+	next, err := bm.QueryVersions(q, func(v VersionInfo) error {
+		page.Versions = append(page.Versions, v)
+		return nil
+	})
+	page.NextCursor = next
+	return page, err
+}
+
+// RepositoryStats summarizes the stored versions
+type RepositoryStats struct {
+	Files      int       // Files with at least one version
+	Versions   int       // Number of versions
+	Size       int64     // Size of all versions in bytes
+	LastBackup time.Time // When the newest version was created, zero when there is none
+}
+
+// Stats walks all stored versions and summarizes them
+func (bm *BackupManager) Stats() (RepositoryStats, error) {
+	var stats RepositoryStats
+	var lastPath string
+
+	_, err := bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		// The versions of a file are passed one after another
+		if stats.Versions == 0 || v.Path != lastPath {
+			stats.Files++
+			lastPath = v.Path
+		}
+		stats.Versions++
+		stats.Size += v.Size
+		if v.Time.After(stats.LastBackup) {
+			stats.LastBackup = v.Time
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// versionWalk holds the state of one query
+type versionWalk struct {
+	bm    *BackupManager
+	query VersionQuery
+	after []string // Key of the cursor, path components relative to the backup directory, then the version time and name
+	last  []string // Key of the last result passed to fn
+	count int      // Number of results passed to fn
+	fn    func(VersionInfo) error
+}
+
+// walk visits dir, whose path relative to the backup directory is key.
+// onCursor is set while key is a prefix of the cursor key.
+func (w *versionWalk) walk(dir string, key []string, onCursor bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && len(key) > 0 {
+			// Removed by retention while we were walking
+			return nil
+		}
+		return err
+	}
+
+	depth := len(key)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		childOnCursor := false
+		if onCursor {
+			switch {
+			case name < w.after[depth]:
+				continue
+			case name == w.after[depth]:
+				childOnCursor = depth+1 < len(w.after)
+			}
+		}
+
+		childKey := append(key[:depth:depth], name)
+		relDir := path.Join(childKey...)
+
+		if storedRel, ok := strings.CutSuffix(relDir, versionsSuffix); ok {
+			if !strings.HasPrefix(decodePath(storedRel), w.query.Prefix) {
+				continue
+			}
+			if err := w.versions(filepath.Join(dir, name), childKey, storedRel, childOnCursor); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Only descend where files matching the prefix can be
+		plainDir := decodePath(relDir)
+		if !strings.HasPrefix(plainDir+"/", w.query.Prefix) && !strings.HasPrefix(w.query.Prefix, plainDir+"/") {
+			continue
+		}
+		if err := w.walk(filepath.Join(dir, name), childKey, childOnCursor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versions visits the version directory dir of the file stored as storedRel,
+// see encodePath
+func (w *versionWalk) versions(dir string, key []string, storedRel string, onCursor bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	// Unreadable manifests only cost precision and IDs
+	manifest, _ := w.bm.readManifest(dir)
+
+	var versions []versionCandidate
+	for _, v := range orderVersions(entries, manifest, path.Base(storedRel)) {
+		versions = append(versions, versionCandidate{
+			storedVersion: v,
+			path:          filepath.Join(dir, v.name),
+			entry:         manifest[v.name],
+		})
+	}
+	return w.emit(key, decodePath(storedRel), versions, onCursor)
+}
+
+// versionCandidate is a stored version the query may pass on
+type versionCandidate struct {
+	storedVersion
+	path  string        // Version file
+	entry manifestEntry // Its manifest entry, zero when it has none
+}
+
+// emit passes the versions of the file fileRel that match the query to fn,
+// versions are ordered by time, see orderVersions. key is the key of the file.
+func (w *versionWalk) emit(key []string, fileRel string, versions []versionCandidate, onCursor bool) error {
+	depth := len(key)
+	for _, v := range versions {
+		// Versions are ordered by time, so is the key the cursor holds
+		versionKey := v.created.UTC().Format(cursorTimeLayout) + v.name
+		if onCursor && versionKey <= w.after[depth] {
+			continue
+		}
+
+		if !w.query.Since.IsZero() && v.created.Before(w.query.Since) {
+			continue
+		}
+		if !w.query.Until.IsZero() && !v.created.Before(w.query.Until) {
+			continue
+		}
+
+		info, err := os.Lstat(v.path)
+		if err != nil {
+			// Removed by retention since the directory was listed
+			continue
+		}
+		if info.Size() < w.query.MinSize || (w.query.MaxSize > 0 && info.Size() > w.query.MaxSize) {
+			continue
+		}
+
+		if w.query.Limit > 0 && w.count == w.query.Limit {
+			return errPageFull
+		}
+
+		if err := w.fn(VersionInfo{
+			ID:        v.entry.ID,
+			Path:      fileRel,
+			Version:   v.name,
+			Time:      v.created,
+			Size:      info.Size(),
+			SHA256:    v.entry.SHA256,
+			Origin:    v.entry.Origin,
+			MovedFrom: v.entry.MovedFrom,
+		}); err != nil {
+			return err
+		}
+
+		w.count++
+		w.last = append(key[:depth:depth], versionKey)
+	}
+
+	return nil
+}
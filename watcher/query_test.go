@@ -0,0 +1,151 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// backupVersions stores n versions of each of files, with changing content
+func backupVersions(t *testing.T, bm *BackupManager, source string, files []string, n int) {
+	t.Helper()
+	for i := range n {
+		for _, file := range files {
+			path := filepath.Join(source, filepath.FromSlash(file))
+			writeSource(t, path, fmt.Sprintf("%s version %d", file, i), 0644, time.Now())
+			if _, err := bm.CreateBackup(context.Background(), path, source); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestQueryVersionsOrderedByTime(t *testing.T) {
+	bm, source := newTestManager(t)
+	// Hash names do not sort in the order the versions were made
+	bm.naming = config.NamingHash
+	backupVersions(t, bm, source, []string{"notes.txt"}, 5)
+
+	var versions []VersionInfo
+	if _, err := bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		versions = append(versions, v)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 5 {
+		t.Fatalf("got %d versions, want 5", len(versions))
+	}
+	for i := 1; i < len(versions); i++ {
+		if versions[i].Time.Before(versions[i-1].Time) {
+			t.Errorf("version %s made at %s listed after %s made at %s",
+				versions[i].Version, versions[i].Time, versions[i-1].Version, versions[i-1].Time)
+		}
+	}
+
+	// Pruning keeps the latest version, not the last name
+	latest := versions[len(versions)-1].Version
+	bm.pruneOldest(1<<40, "test quota")
+	kept, err := bm.Versions("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || filepath.Base(kept[0]) != latest {
+		t.Fatalf("pruning kept %v, want %s", kept, latest)
+	}
+}
+
+func TestQueryVersions(t *testing.T) {
+	bm, source := newTestManager(t)
+	files := []string{"a.txt", "docs/b.txt", "docs/sub/c.txt", "docs2/d.txt", "e%f.txt"}
+	for _, dir := range []string{"docs/sub", "docs2"} {
+		if err := os.MkdirAll(filepath.Join(source, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backupVersions(t, bm, source, files, 2)
+
+	tests := []struct {
+		name  string
+		query VersionQuery
+		want  []string // Paths of the versions found, in order
+	}{
+		{"all", VersionQuery{}, []string{
+			"a.txt", "a.txt", "docs/b.txt", "docs/b.txt", "docs/sub/c.txt", "docs/sub/c.txt",
+			"docs2/d.txt", "docs2/d.txt", "e%f.txt", "e%f.txt",
+		}},
+		{"directory", VersionQuery{Prefix: "docs/"}, []string{
+			"docs/b.txt", "docs/b.txt", "docs/sub/c.txt", "docs/sub/c.txt",
+		}},
+		{"name prefix", VersionQuery{Prefix: "docs"}, []string{
+			"docs/b.txt", "docs/b.txt", "docs/sub/c.txt", "docs/sub/c.txt", "docs2/d.txt", "docs2/d.txt",
+		}},
+		{"subdirectory", VersionQuery{Prefix: "docs/sub/"}, []string{"docs/sub/c.txt", "docs/sub/c.txt"}},
+		{"file", VersionQuery{Prefix: "e%f.txt"}, []string{"e%f.txt", "e%f.txt"}},
+		{"none", VersionQuery{Prefix: "nothing/"}, nil},
+		{"size", VersionQuery{MinSize: int64(len("docs/sub/c.txt version 0"))}, []string{
+			"docs/sub/c.txt", "docs/sub/c.txt",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all []VersionInfo
+			if _, err := bm.QueryVersions(tt.query, func(v VersionInfo) error {
+				all = append(all, v)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			var paths []string
+			for i, v := range all {
+				paths = append(paths, v.Path)
+				if i > 0 && v.Path == all[i-1].Path && v.Time.Before(all[i-1].Time) {
+					t.Errorf("version %s of %s listed after a later one", v.Version, v.Path)
+				}
+			}
+			if fmt.Sprint(paths) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", paths, tt.want)
+			}
+
+			// Pages continued by their cursor find the same versions
+			for _, limit := range []int{1, 3} {
+				q := tt.query
+				q.Limit = limit
+				var paged []VersionInfo
+				for {
+					page, err := bm.ListVersions(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page.Versions) > limit {
+						t.Fatalf("page of %d versions, limit %d", len(page.Versions), limit)
+					}
+					paged = append(paged, page.Versions...)
+					if page.NextCursor == "" {
+						break
+					}
+					q.Cursor = page.NextCursor
+				}
+				if len(paged) != len(all) {
+					t.Fatalf("pages of %d found %d versions, want %d", limit, len(paged), len(all))
+				}
+				for i := range all {
+					if paged[i].Path != all[i].Path || paged[i].Version != all[i].Version {
+						t.Fatalf("pages of %d: version %d is %s %s, want %s %s", limit, i,
+							paged[i].Path, paged[i].Version, all[i].Path, all[i].Version)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := bm.QueryVersions(VersionQuery{Cursor: "not a cursor!"}, func(VersionInfo) error { return nil }); err == nil {
+		t.Error("invalid cursor accepted")
+	}
+}
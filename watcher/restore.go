@@ -0,0 +1,268 @@
+package watcher
+
+// Restoring backup versions back into the source tree or to another location.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Versions returns the paths of all stored versions of a file, oldest first.
+// relPath is the path of the file relative to the watched source directory.
+func (bm *BackupManager) Versions(relPath string) ([]string, error) {
+	if bm.dateLayout {
+		return bm.listDateVersions(encodeDatePath(filepath.ToSlash(relPath)))
+	}
+	dir, fileName := bm.versionDir(relPath)
+	return bm.listVersions(dir, fileName)
+}
+
+// VersionPath returns the path of a stored version of a file, given by its
+// name or its ID, or of the latest version when version is empty
+func (bm *BackupManager) VersionPath(relPath, version string) (string, error) {
+	versions, err := bm.Versions(relPath)
+	if err != nil {
+		return "", fmt.Errorf("error listing versions: %w", err)
+	}
+
+	if len(versions) == 0 {
+		return "", notFoundError(fmt.Sprintf("no backup versions found for: %s", relPath))
+	}
+
+	if version == "" {
+		return versions[len(versions)-1], nil
+	}
+
+	// A version is given by name or by ID, versions of the date layout are in a manifest each
+	manifests := make(map[string]map[string]manifestEntry)
+	for _, v := range versions {
+		if bm.versionNameOf(v) == version {
+			return v, nil
+		}
+		dir := filepath.Dir(v)
+		if _, ok := manifests[dir]; !ok {
+			manifests[dir], _ = bm.readManifest(dir)
+		}
+		if id := manifests[dir][filepath.Base(v)].ID; id != "" && id == version {
+			return v, nil
+		}
+	}
+	return "", notFoundError(fmt.Sprintf("version %s not found for: %s", version, relPath))
+}
+
+// notFoundError is a missing version, errors.Is matches it with os.ErrNotExist
+type notFoundError string
+
+func (e notFoundError) Error() string        { return string(e) }
+func (e notFoundError) Is(target error) bool { return target == os.ErrNotExist }
+
+// RestoreOptions controls how a version is written to its target
+type RestoreOptions struct {
+	// ClearProtection overwrites a read-only or immutable target by clearing
+	// the attribute first and setting it again afterwards. Without it such
+	// targets fail with utils.ErrProtected.
+	ClearProtection bool
+
+	// WithACLs sets the ACL or Windows security descriptor the file had when
+	// the version was created. Without it the target keeps its current ACL,
+	// or gets the default one of its directory when it is created.
+	WithACLs bool
+
+	// BackupInfo writes a BackupInfo next to every restored file, named
+	// after it with BackupInfoSuffix, for handing restored files on with
+	// their provenance.
+	BackupInfo bool
+}
+
+// Restore copies a stored version of a file to target, together with the
+// original permissions, timestamps, ownership, extended attributes and
+// alternate streams, and the ACL when opts.WithACLs is set, and writes its
+// BackupInfo when opts.BackupInfo is set.
+// version is the name or the ID of the version, an empty one restores the latest. It returns the path of the restored version.
+// Canceling ctx aborts the copy.
+func (bm *BackupManager) Restore(ctx context.Context, relPath, version, target string, opts RestoreOptions) (string, error) {
+	versionPath, err := bm.VersionPath(relPath, version)
+	if err != nil {
+		return "", err
+	}
+	manifest, _ := bm.readManifest(filepath.Dir(versionPath))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("error creating target directory: %w",
+			utils.NewBackupError(filepath.Dir(target), utils.OpCreateDir, err))
+	}
+
+	protection, err := utils.FileProtection(target)
+	if err != nil {
+		return "", fmt.Errorf("error checking target attributes: %w",
+			utils.NewBackupError(target, utils.OpCreateDest, err))
+	}
+
+	if protection.Protected() {
+		if !opts.ClearProtection {
+			return "", utils.NewBackupError(target, utils.OpCreateDest, utils.ProtectedError(target, protection))
+		}
+
+		if err := utils.ClearProtection(target, protection); err != nil {
+			return "", utils.NewBackupError(target, utils.OpCreateDest, err)
+		}
+		bm.logger.Warning("Cleared protection of %s, it is set again after the restore", target)
+	}
+
+	copyErr := utils.SafeCopyFile(ctx, versionPath, target, 3)
+
+	// Before the protection is set again, it would forbid adding streams and changing the ACL
+	if copyErr == nil {
+		if n, err := bm.restoreStreams(ctx, versionPath, target); err != nil {
+			bm.logger.Error("Could not restore the streams of %s: %v", target, err)
+		} else if n > 0 {
+			bm.logger.Info("Restored %d alternate streams of %s", n, target)
+		}
+	}
+
+	if copyErr == nil && opts.WithACLs {
+		if acl := manifest[filepath.Base(versionPath)].ACL; acl != nil {
+			if err := utils.ApplyACL(target, acl); err != nil {
+				bm.logger.Error("Could not restore ACL of %s: %v", target, err)
+			}
+		} else {
+			bm.logger.Warning("No ACL recorded for %s, %s keeps its current one", filepath.Base(versionPath), target)
+		}
+	}
+
+	// A linked version has the metadata of the version it is linked to,
+	// the manifest keeps the one of the file it was made of
+	if copyErr == nil {
+		if err := applyRecorded(target, manifest[filepath.Base(versionPath)]); err != nil {
+			bm.logger.Error("Could not restore the times and permissions of %s: %v", target, err)
+		}
+	}
+
+	// Put the attributes back even when the copy failed, the old content may still be there
+	if protection.Protected() {
+		if err := utils.ApplyProtection(target, protection); err != nil {
+			bm.logger.Error("Could not restore protection of %s: %v", target, err)
+		}
+	}
+
+	if copyErr != nil {
+		return "", fmt.Errorf("error restoring file: %w", copyErr)
+	}
+
+	if opts.BackupInfo {
+		if err := bm.writeBackupInfo(relPath, versionPath, target, manifest[filepath.Base(versionPath)]); err != nil {
+			return "", err
+		}
+	}
+
+	bm.logger.Success("Restored %s → %s", bm.versionNameOf(versionPath), target)
+
+	return versionPath, nil
+}
+
+// applyRecorded applies the modification time and permissions recorded for
+// a linked version to the file restored from it at target
+func applyRecorded(target string, entry manifestEntry) error {
+	if entry.Mode != 0 {
+		if err := os.Chmod(target, entry.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if !entry.ModTime.IsZero() {
+		// A zero access time is left as it is
+		return os.Chtimes(target, time.Time{}, entry.ModTime)
+	}
+	return nil
+}
+
+// TreeRestoreSummary counts the files of a RestoreTree
+type TreeRestoreSummary struct {
+	Restored int // Files restored
+	Failed   int // Files that could not be restored
+	Newer    int // Files left out, every stored version of them was created after the time
+}
+
+// RestoreTree restores the source tree as it was at the time at into the
+// directory target: the newest version of every file created at or before at
+// is restored under the path of the file, like Restore does. Deletions are
+// not recorded, so a file deleted before at is restored as well when a
+// version of it from before is still stored. Files failing to restore are
+// logged and counted, the others are restored all the same.
+func (bm *BackupManager) RestoreTree(ctx context.Context, at time.Time, target string, opts RestoreOptions) (TreeRestoreSummary, error) {
+	var summary TreeRestoreSummary
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return summary, err
+	}
+	absBackup, err := filepath.Abs(bm.backupDir)
+	if err != nil {
+		return summary, err
+	}
+	if absTarget == absBackup || strings.HasPrefix(absTarget, absBackup+string(filepath.Separator)) {
+		return summary, fmt.Errorf("can not restore into the backup directory: %s", target)
+	}
+
+	// The versions of a file are passed one after another
+	var file string
+	var best VersionInfo
+	var found, newer bool
+
+	restoreFile := func() error {
+		switch {
+		case file == "":
+			return nil
+		case !found:
+			if newer {
+				summary.Newer++
+			}
+			return nil
+		}
+
+		dst := filepath.Join(target, filepath.FromSlash(file))
+		if _, err := bm.Restore(ctx, file, best.Version, dst, opts); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			bm.logger.Error("Could not restore %s: %v", file, err)
+			summary.Failed++
+			return nil
+		}
+		summary.Restored++
+		return nil
+	}
+
+	_, err = bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		if v.Path != file {
+			if err := restoreFile(); err != nil {
+				return err
+			}
+			file, found, newer = v.Path, false, false
+		}
+
+		switch {
+		case v.Time.After(at):
+			newer = true
+		case !found || !v.Time.Before(best.Time):
+			best, found = v, true
+		}
+		return nil
+	})
+	if err == nil {
+		err = restoreFile()
+	}
+	if err != nil {
+		return summary, fmt.Errorf("error restoring tree: %w", err)
+	}
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d files could not be restored", summary.Failed)
+	}
+	return summary, nil
+}
@@ -0,0 +1,105 @@
+package watcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// writeSource writes a source file with the given content, permissions and
+// modification time
+func writeSource(t *testing.T, path, content string, mode os.FileMode, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkRestored restores the latest version of relPath and checks the
+// metadata of the restored file
+func checkRestored(t *testing.T, bm *BackupManager, relPath string, mode os.FileMode, mtime time.Time) {
+	t.Helper()
+	target := filepath.Join(t.TempDir(), "restored")
+	if _, err := bm.Restore(context.Background(), relPath, "", target, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("restored modification time %s, want %s", info.ModTime(), mtime)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("restored permissions %s, want %s", info.Mode().Perm(), mode)
+	}
+}
+
+func newTestManager(t *testing.T) (*BackupManager, string) {
+	t.Helper()
+	source := t.TempDir()
+	bm := NewBackupManager(t.TempDir(), 10, utils.NewLogger(io.Discard, false, false))
+	return bm, source
+}
+
+func TestRestoreLinkedVersion(t *testing.T) {
+	bm, source := newTestManager(t)
+	bm.linkUnchanged = true
+
+	path := filepath.Join(source, "notes.txt")
+	first := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	writeSource(t, path, "unchanged content", 0644, first)
+	firstVersion, err := bm.CreateBackup(context.Background(), path, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saved again without changes, the new version is linked to the first one
+	second := first.Add(time.Hour)
+	writeSource(t, path, "unchanged content", 0600, second)
+	secondVersion, err := bm.CreateBackup(context.Background(), path, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := os.Stat(firstVersion)
+	b, _ := os.Stat(secondVersion)
+	if !os.SameFile(a, b) {
+		t.Fatal("the unchanged file was not linked to its latest version")
+	}
+
+	checkRestored(t, bm, "notes.txt", 0600, second)
+}
+
+func TestRestoreDedupVersion(t *testing.T) {
+	bm, source := newTestManager(t)
+	bm.contents = newContentIndex()
+
+	first := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	writeSource(t, filepath.Join(source, "a.txt"), "same content", 0644, first)
+	if _, err := bm.CreateBackup(context.Background(), filepath.Join(source, "a.txt"), source); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy stored later shares the content of a.txt
+	second := first.Add(24 * time.Hour)
+	writeSource(t, filepath.Join(source, "b.txt"), "same content", 0600, second)
+	if _, err := bm.CreateBackup(context.Background(), filepath.Join(source, "b.txt"), source); err != nil {
+		t.Fatal(err)
+	}
+
+	checkRestored(t, bm, "b.txt", 0600, second)
+	checkRestored(t, bm, "a.txt", 0644, first)
+}
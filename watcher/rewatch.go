@@ -0,0 +1,191 @@
+package watcher
+
+// Retrying watches that failed. Adding a watch fails for reasons that pass:
+// a directory still being created, a permission changed for a moment, an
+// antivirus scanner holding it on Windows. Instead of leaving such a
+// directory unwatched for good, it is tried again with a growing delay until
+// it works or the directory is gone. Once watched, the directory is scanned
+// for the changes made while it was not.
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delays between two attempts to watch a directory, doubled after each failure
+const (
+	rewatchFirstDelay = time.Second
+	rewatchMaxDelay   = 5 * time.Minute
+)
+
+// RewatchDir is a directory whose watch is tried again
+type RewatchDir struct {
+	Path     string    `json:"path"`     // Relative to the source directory in Status
+	Since    time.Time `json:"since"`    // First failure
+	Attempts int       `json:"attempts"` // Failed attempts so far
+	Next     time.Time `json:"next"`     // Next attempt
+	Error    string    `json:"error"`    // Error of the last attempt
+}
+
+// rewatchQueue holds the directories whose watch is tried again
+type rewatchQueue struct {
+	mu   sync.Mutex
+	dirs map[string]*RewatchDir
+}
+
+// count returns the number of directories waiting for another attempt
+func (q *rewatchQueue) count() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.dirs)
+}
+
+// list returns the directories waiting for another attempt, by path
+func (q *rewatchQueue) list() []RewatchDir {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dirs := make([]RewatchDir, 0, len(q.dirs))
+	for _, d := range q.dirs {
+		dirs = append(dirs, *d)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+	return dirs
+}
+
+// failed records a failed attempt to watch dir and schedules the next one.
+// It reports whether dir was not waiting before.
+func (q *rewatchQueue) failed(dir string, err error) (*RewatchDir, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.dirs == nil {
+		q.dirs = make(map[string]*RewatchDir)
+	}
+	d, waiting := q.dirs[dir]
+	if !waiting {
+		d = &RewatchDir{Path: dir, Since: time.Now()}
+		q.dirs[dir] = d
+	}
+	delay := rewatchFirstDelay << min(d.Attempts, 16)
+	d.Attempts++
+	d.Next = time.Now().Add(min(delay, rewatchMaxDelay))
+	d.Error = err.Error()
+	copied := *d
+	return &copied, !waiting
+}
+
+// due returns the directories whose next attempt is due
+func (q *rewatchQueue) due(now time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dirs []string
+	for dir, d := range q.dirs {
+		if !d.Next.After(now) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// remove drops dir and returns its entry
+func (q *rewatchQueue) remove(dir string) (RewatchDir, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d, ok := q.dirs[dir]
+	if !ok {
+		return RewatchDir{}, false
+	}
+	delete(q.dirs, dir)
+	return *d, true
+}
+
+// watchDir adds a watch for dir. Above the watch limit dir is polled instead,
+// after another failure the watch is tried again later. It returns the error
+// when dir is neither watched nor polled.
+func (fw *FileWatcher) watchDir(dir string, existing bool) error {
+	err := fw.watcher.Add(dir)
+	switch {
+	case err == nil:
+		return nil
+	case isWatchLimit(err):
+		fw.pollDir(dir, existing)
+		return nil
+	case !errors.Is(err, fs.ErrNotExist):
+		fw.retryWatch(dir, err)
+	}
+	return err
+}
+
+// retryWatch schedules another attempt to watch dir after err
+func (fw *FileWatcher) retryWatch(dir string, err error) {
+	d, first := fw.rewatch.failed(dir, err)
+	if first {
+		fw.logger.Warning("Could not watch %s, trying again in %s: %v",
+			fw.relPath(dir), time.Until(d.Next).Round(time.Second), err)
+	}
+}
+
+// rewatchLoop tries the directories whose watch failed again when due, until
+// ctx is canceled
+func (fw *FileWatcher) rewatchLoop(ctx context.Context) {
+	defer fw.senders.Done()
+
+	ticker := time.NewTicker(rewatchFirstDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, dir := range fw.rewatch.due(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			fw.tryRewatch(ctx, dir)
+		}
+	}
+}
+
+// tryRewatch watches dir and the directories below it, and queues the files
+// in them changed since their last backup
+func (fw *FileWatcher) tryRewatch(ctx context.Context, dir string) {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		fw.rewatch.remove(dir)
+		fw.logger.Info("Not watching %s anymore, it was removed", fw.relPath(dir))
+		return
+	}
+
+	if err := fw.addDirectoryRecursive(dir); err != nil {
+		fw.retryWatch(dir, err)
+		return
+	}
+	d, ok := fw.rewatch.remove(dir)
+	if !ok {
+		return
+	}
+	fw.logger.Success("Watching %s after %d failed attempts", fw.relPath(dir), d.Attempts)
+
+	missed := 0
+	fw.walkTree(ctx, dir, func(path string, info os.FileInfo) error {
+		queued, err := fw.sweepFile(ctx, path, info)
+		if queued {
+			missed++
+		}
+		return err
+	})
+	if missed > 0 {
+		fw.logger.Info("Queued %d files of %s changed while it was not watched", missed, fw.relPath(dir))
+	}
+}
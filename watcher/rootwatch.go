@@ -0,0 +1,126 @@
+package watcher
+
+// Removal of the source directory itself, e.g. by "rm -rf src && git clone".
+// Its watches are gone with it, so the watcher would silently stop seeing
+// changes. Instead an alert is sent, the source directory is checked until it
+// is back, then watched again and scanned for the files that changed meanwhile.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/notify"
+)
+
+// rootPollInterval is how often a removed source directory is checked for
+const rootPollInterval = 2 * time.Second
+
+// isSourceRoot reports whether path is the source directory itself
+func (fw *FileWatcher) isSourceRoot(path string) bool {
+	return filepath.Clean(path) == filepath.Clean(fw.config.SourceDir)
+}
+
+// sourceRemoved handles the source directory being removed or moved away, once
+func (fw *FileWatcher) sourceRemoved() {
+	if fw.rootGone.Swap(true) {
+		return
+	}
+
+	// Watches of a moved directory would report paths that no longer exist
+	for _, path := range fw.watcher.WatchList() {
+		fw.watcher.Remove(path)
+	}
+	fw.poll.mu.Lock()
+	fw.poll.dirs = nil
+	fw.poll.mu.Unlock()
+
+	msg := "source directory " + fw.config.SourceDir + " was removed, changes are not backed up until it is back"
+	fw.logger.Error("Source directory %s was removed, watching resumes when it is back", fw.config.SourceDir)
+	fw.publish(notify.Event{
+		Type:    notify.EventSourceRemoved,
+		Time:    time.Now(),
+		Message: msg,
+	})
+
+	select {
+	case fw.rootRemoved <- struct{}{}:
+	default:
+	}
+}
+
+// rootLoop watches the source directory again each time it is back after a removal,
+// until ctx is canceled
+func (fw *FileWatcher) rootLoop(ctx context.Context) {
+	defer fw.senders.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fw.rootRemoved:
+		}
+
+		if !fw.awaitSource(ctx) {
+			return
+		}
+	}
+}
+
+// awaitSource waits for the source directory to be back, watches it again and
+// queues the files that changed while it was gone. It returns false when ctx
+// was canceled first.
+func (fw *FileWatcher) awaitSource(ctx context.Context) bool {
+	ticker := time.NewTicker(rootPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		if info, err := os.Stat(fw.config.SourceDir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := fw.addDirectoryRecursive(fw.config.SourceDir); err != nil {
+			// Removed again while it was being walked
+			fw.logger.Warning("Could not watch the source directory again: %v", err)
+			continue
+		}
+		fw.rootGone.Store(false)
+		fw.logger.Success("Source directory %s is back, watching it again", fw.config.SourceDir)
+
+		start := time.Now()
+		scanned, missed, err := fw.sweep(ctx)
+		if err != nil {
+			return ctx.Err() == nil
+		}
+		fw.logger.Info("Reconciliation scan checked %d files, %d queued for backup (%s)",
+			scanned, missed, time.Since(start).Round(time.Millisecond))
+		return true
+	}
+}
+
+// waitSourceBack holds a job while the source directory is removed, when
+// configured to pause, so the job is not failed when the directory is only
+// being replaced. It returns early when the watcher stops.
+func (fw *FileWatcher) waitSourceBack() {
+	if !fw.config.PauseOnRemoval || !fw.rootGone.Load() {
+		return
+	}
+
+	ticker := time.NewTicker(rootPollInterval)
+	defer ticker.Stop()
+
+	for fw.rootGone.Load() && !fw.stopping.Load() {
+		select {
+		case <-fw.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
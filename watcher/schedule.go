@@ -0,0 +1,85 @@
+package watcher
+
+// Scheduling of large files. A multi-GB copy occupies a worker for minutes,
+// so while small files keep changing they are handed to the workers first.
+// A large file is only held back up to config.LargeFileDelay, after that it
+// goes first, so constant churn can not starve it.
+
+import (
+	"time"
+)
+
+// scheduleInterval is how often held back large files are checked against the delay bound
+const scheduleInterval = time.Second
+
+// isLarge reports whether a job is scheduled after the small ones
+func (fw *FileWatcher) isLarge(job BackupJob) bool {
+	return fw.config.LargeFileSize > 0 && job.Size >= fw.config.LargeFileSize
+}
+
+// schedule moves jobs from the backup queue to the workers, small files first.
+// It holds at most as many jobs as the queue does, so a full queue still drops
+// new jobs instead of growing without bounds. It closes fw.jobs once the queue
+// is closed and everything was handed out, or when the queue is abandoned.
+func (fw *FileWatcher) schedule() {
+	defer close(fw.jobs)
+
+	var small, large []BackupJob
+	queue := fw.backupQueue
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		// Pick the next job: a large file that waited too long, any small file, any large file
+		var out chan BackupJob
+		var next BackupJob
+		takeLarge := len(large) > 0 &&
+			(len(small) == 0 || time.Since(large[0].Timestamp) >= fw.config.LargeFileDelay)
+		switch {
+		case takeLarge:
+			out, next = fw.jobs, large[0]
+		case len(small) > 0:
+			out, next = fw.jobs, small[0]
+		}
+
+		in := queue
+		if len(small)+len(large) >= cap(fw.backupQueue) {
+			in = nil
+		}
+
+		if in == nil && out == nil && queue == nil {
+			return
+		}
+
+		select {
+		case job, ok := <-in:
+			if !ok {
+				queue = nil
+				continue
+			}
+
+			fw.scheduled.Add(1)
+			if fw.isLarge(job) {
+				large = append(large, job)
+			} else {
+				small = append(small, job)
+			}
+
+		case out <- next:
+			fw.scheduled.Add(-1)
+			if takeLarge {
+				large = large[1:]
+			} else {
+				small = small[1:]
+			}
+
+		case <-ticker.C:
+			// Check again whether a large file waited long enough
+
+		case <-fw.quit:
+			fw.scheduled.Add(-int64(len(small) + len(large)))
+			return
+		}
+	}
+}
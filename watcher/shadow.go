@@ -0,0 +1,172 @@
+package watcher
+
+// Trying new ignore and include patterns before they are used. Shadow rules
+// are checked alongside the rules in use for every changed file, files they
+// would decide differently are logged and counted, but the rules in use still
+// decide what is backed up. Once promoted, by hand or after a trial period,
+// the shadow rules replace them, so a bad ignore rule shows up in the log
+// instead of silently stopping backups the moment it is loaded.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowRules are ignore and include patterns to try, see TryRules
+type ShadowRules struct {
+	IgnorePatterns []string `json:"ignore_patterns"` // nil keeps the ignore patterns in use
+	OnlyPatterns   []string `json:"only_patterns"`   // nil keeps the include patterns in use
+}
+
+// ShadowStatus is the trial of shadow rules in progress
+type ShadowStatus struct {
+	Rules       ShadowRules `json:"rules"` // Complete lists, as they would be used
+	Since       time.Time   `json:"since"`
+	PromoteAt   time.Time   `json:"promote_at,omitzero"` // Zero when only promoted by PromoteRules
+	WouldSkip   int         `json:"would_skip"`          // Changes backed up that the shadow rules would skip
+	WouldBackUp int         `json:"would_back_up"`       // Changes skipped that the shadow rules would back up
+}
+
+// shadowTrial is a trial of shadow rules, replaced as a whole
+type shadowTrial struct {
+	rules       ShadowRules
+	filters     *filters
+	since       time.Time
+	promoteAt   time.Time
+	timer       *time.Timer // Promotes the rules at promoteAt, nil without
+	wouldSkip   atomic.Int64
+	wouldBackUp atomic.Int64
+}
+
+// shadowState holds the trial in progress, nil without shadow rules
+type shadowState struct {
+	mu    sync.Mutex // Serializes starting, promoting and discarding trials
+	trial atomic.Pointer[shadowTrial]
+}
+
+// TryRules starts checking rules alongside the rules in use, replacing the
+// rules tried so far. After period, unless 0, they are promoted on their own.
+func (fw *FileWatcher) TryRules(rules ShadowRules, period time.Duration) error {
+	if rules.IgnorePatterns == nil && rules.OnlyPatterns == nil {
+		return errors.New("no patterns to try")
+	}
+	for _, pattern := range append(rules.IgnorePatterns, rules.OnlyPatterns...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	fw.shadow.mu.Lock()
+	defer fw.shadow.mu.Unlock()
+
+	// Lists not given are the ones in use
+	cfg := *fw.config
+	if rules.IgnorePatterns == nil {
+		rules.IgnorePatterns = cfg.IgnorePatterns
+	}
+	if rules.OnlyPatterns == nil {
+		rules.OnlyPatterns = cfg.OnlyPatterns
+	}
+	cfg.IgnorePatterns, cfg.OnlyPatterns = rules.IgnorePatterns, rules.OnlyPatterns
+
+	trial := &shadowTrial{rules: rules, filters: compileFilters(&cfg, nil), since: time.Now()}
+	if period > 0 {
+		trial.promoteAt = trial.since.Add(period)
+		trial.timer = time.AfterFunc(period, func() { fw.promoteTrial(trial) })
+	}
+
+	fw.stopTrial(fw.shadow.trial.Swap(trial))
+	fw.logger.Info("Trying new rules, changes they decide differently are logged")
+	return nil
+}
+
+// PromoteRules makes the rules tried the rules in use, it returns false
+// when no rules are tried
+func (fw *FileWatcher) PromoteRules() bool {
+	return fw.promoteTrial(fw.shadow.trial.Load())
+}
+
+// DiscardRules stops trying rules and keeps the rules in use, it returns
+// false when no rules are tried
+func (fw *FileWatcher) DiscardRules() bool {
+	fw.shadow.mu.Lock()
+	defer fw.shadow.mu.Unlock()
+
+	trial := fw.shadow.trial.Swap(nil)
+	if trial == nil {
+		return false
+	}
+	fw.stopTrial(trial)
+	fw.logger.Info("Rules tried discarded")
+	return true
+}
+
+// Shadow returns the trial of shadow rules in progress, nil without
+func (fw *FileWatcher) Shadow() *ShadowStatus {
+	trial := fw.shadow.trial.Load()
+	if trial == nil {
+		return nil
+	}
+	return &ShadowStatus{
+		Rules:       trial.rules,
+		Since:       trial.since,
+		PromoteAt:   trial.promoteAt,
+		WouldSkip:   int(trial.wouldSkip.Load()),
+		WouldBackUp: int(trial.wouldBackUp.Load()),
+	}
+}
+
+// promoteTrial makes the rules of trial the rules in use, unless another
+// trial replaced it meanwhile
+func (fw *FileWatcher) promoteTrial(trial *shadowTrial) bool {
+	fw.shadow.mu.Lock()
+	defer fw.shadow.mu.Unlock()
+
+	if trial == nil || !fw.shadow.trial.CompareAndSwap(trial, nil) {
+		return false
+	}
+	fw.stopTrial(trial)
+
+	// Kept in the configuration, so a reload compiles them again
+	fw.mu.Lock()
+	fw.config.IgnorePatterns = trial.rules.IgnorePatterns
+	fw.config.OnlyPatterns = trial.rules.OnlyPatterns
+	fw.filters.Store(compileFilters(fw.config, fw.filters.Load()))
+	fw.mu.Unlock()
+
+	fw.logger.Success("Rules tried promoted: %d would have been skipped, %d backed up in addition",
+		trial.wouldSkip.Load(), trial.wouldBackUp.Load())
+	return true
+}
+
+func (fw *FileWatcher) stopTrial(trial *shadowTrial) {
+	if trial != nil && trial.timer != nil {
+		trial.timer.Stop()
+	}
+}
+
+// checkShadow checks the change of file against the rules tried, passed is
+// whether the rules in use back it up
+func (fw *FileWatcher) checkShadow(file string, passed bool) {
+	trial := fw.shadow.trial.Load()
+	if trial == nil {
+		return
+	}
+
+	f := trial.filters
+	if shadowPassed := !f.ignores(file) && f.includes(file, fw.config.SourceDir); shadowPassed == passed {
+		return
+	}
+
+	if passed {
+		trial.wouldSkip.Add(1)
+		fw.logger.Warning("Rules tried would skip %s", file)
+	} else {
+		trial.wouldBackUp.Add(1)
+		fw.logger.Info("Rules tried would back up %s", file)
+	}
+}
@@ -0,0 +1,163 @@
+package watcher
+
+// Detection of other watchers of the same source directory. Two watchers
+// backing one source up into different backup directories is nearly always a
+// mistake, e.g. a service and a forgotten terminal, and doubles the work. The
+// watcher of a source directory holds a lock on a marker file in it that
+// describes the watcher, another one finds the lock taken and reads who holds
+// it. The lock goes with the process, so a marker left by a crash is taken over.
+// Watchers of one process that share the source, the profiles of one run,
+// share the claim of the first of them.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// SourceMarkerName is the marker file in the source directory, it is never backed up
+const SourceMarkerName = ".file-watcher-backup.lock"
+
+// SourceClaim describes the watcher holding the marker of a source directory
+type SourceClaim struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Backup  string    `json:"backup"` // Absolute path of its backup directory
+	Started time.Time `json:"started"`
+}
+
+func (c SourceClaim) String() string {
+	return fmt.Sprintf("PID %d on %s, backing up into %s since %s",
+		c.PID, c.Host, c.Backup, c.Started.Local().Format(time.DateTime))
+}
+
+// ErrSourceClaimed is returned when another watcher watches the source directory
+var ErrSourceClaimed = errors.New("another watcher watches the source directory")
+
+// processClaims are the source directories claimed by watchers of this process
+var processClaims = struct {
+	sync.Mutex
+	held map[string]*processClaim // By absolute path of the source directory
+}{held: make(map[string]*processClaim)}
+
+// processClaim is the claim of a source directory held by this process
+type processClaim struct {
+	claim   SourceClaim
+	shared  bool   // Set when taken by a watcher that shares it, see config.ShareSource
+	refs    int    // Watchers holding it
+	release func() // Releases the marker once the last watcher let go
+}
+
+// claimSource takes the marker of the source directory. When another watcher
+// holds it, the claim of that watcher is returned and the marker is not taken;
+// otherwise fw.unclaim releases it.
+func (fw *FileWatcher) claimSource() (*SourceClaim, error) {
+	key, err := filepath.Abs(fw.config.SourceDir)
+	if err != nil {
+		key = fw.config.SourceDir
+	}
+
+	// Held while the marker is locked, so watchers sharing it never race for it
+	processClaims.Lock()
+	defer processClaims.Unlock()
+
+	if held, ok := processClaims.held[key]; ok {
+		if !held.shared || !fw.config.ShareSource {
+			other := held.claim
+			return &other, nil
+		}
+		held.refs++
+		fw.unclaim = func() { unclaimProcess(key) }
+		return nil, nil
+	}
+
+	path := filepath.Join(fw.config.SourceDir, SourceMarkerName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := utils.TryLock(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !locked {
+		defer f.Close()
+
+		// Written right after the lock was taken, it may still be empty
+		var other SourceClaim
+		if err := json.NewDecoder(f).Decode(&other); err != nil {
+			other = SourceClaim{Host: "?", Backup: "?"}
+		}
+		return &other, nil
+	}
+
+	claim := SourceClaim{PID: os.Getpid(), Started: fw.started}
+	claim.Host, _ = os.Hostname()
+	if claim.Backup, err = filepath.Abs(fw.config.BackupDir); err != nil {
+		claim.Backup = fw.config.BackupDir
+	}
+	data, _ := json.Marshal(claim)
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt(append(data, '\n'), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	processClaims.held[key] = &processClaim{
+		claim:  claim,
+		shared: fw.config.ShareSource,
+		refs:   1,
+		release: func() {
+			// Emptied rather than removed, a watcher starting meanwhile may already have it open
+			f.Truncate(0)
+			f.Close()
+		},
+	}
+	fw.unclaim = func() { unclaimProcess(key) }
+	return nil, nil
+}
+
+// unclaimProcess lets go of the claim of the source directory key, the
+// marker is released with the last watcher holding it
+func unclaimProcess(key string) {
+	processClaims.Lock()
+	defer processClaims.Unlock()
+
+	held, ok := processClaims.held[key]
+	if !ok {
+		return
+	}
+	if held.refs--; held.refs == 0 {
+		delete(processClaims.held, key)
+		held.release()
+	}
+}
+
+// checkSiblings claims the source directory, it refuses to start when another
+// watcher holds it unless config.AllowMultiple is set
+func (fw *FileWatcher) checkSiblings() error {
+	other, err := fw.claimSource()
+	if err != nil {
+		fw.logger.Warning("Could not check for other watchers of the source directory: %v", err)
+		return nil
+	}
+	if other == nil {
+		return nil
+	}
+
+	if !fw.config.AllowMultiple {
+		return fmt.Errorf("%w: %s", ErrSourceClaimed, other)
+	}
+	fw.logger.Warning("Another watcher watches this source directory, every change is backed up twice: %s", other)
+	return nil
+}
@@ -0,0 +1,229 @@
+package watcher
+
+// Replay of recorded file events against a configuration, without watching or
+// copying anything. It applies the same filters, minimum interval, queue size
+// and worker count as the watcher and models every backup as taking a fixed
+// time, so ignore patterns and intervals can be tuned before a rollout.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/config"
+)
+
+// OutcomeIgnored is the simulated outcome of events filtered out by the
+// ignore and include patterns, the watcher does not record those
+const OutcomeIgnored = "ignored"
+
+// TraceEvent is a line of an event trace. Audit logs are traces as well, their
+// entries carry the outcome the event had when it was recorded.
+type TraceEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`             // File event, e.g. "WRITE"
+	Path    string    `json:"path"`              // Path of the file as the watcher saw it
+	Size    int64     `json:"size,omitempty"`    // Size of the file at the event, 0 when unknown
+	Outcome string    `json:"outcome,omitempty"` // Outcome recorded in an audit log, empty in a trace
+}
+
+// ReadTrace reads an event trace or an audit log, one JSON object per line,
+// and returns its events in the order they happened
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("error reading trace line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading trace: %w", err)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// TraceWriter records the file events of a running watcher as a trace for
+// Simulate, see OnEvent
+type TraceWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewTraceWriter creates or appends to the trace file at path
+func NewTraceWriter(path string) (*TraceWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening trace: %w", err)
+	}
+	return &TraceWriter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record appends a file event with the current size of the file, directories are left out
+func (tw *TraceWriter) Record(event FileEvent) {
+	var size int64
+	if info, err := os.Stat(event.Path); err == nil {
+		if info.IsDir() {
+			return
+		}
+		size = info.Size()
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.encoder.Encode(TraceEvent{Time: event.Time, Event: event.Type, Path: event.Path, Size: size})
+}
+
+// Close closes the trace file
+func (tw *TraceWriter) Close() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	return tw.file.Close()
+}
+
+// SimulationResult summarizes the replay of a trace
+type SimulationResult struct {
+	Events   int            `json:"events"`   // Events that would make the watcher back up a file
+	Other    int            `json:"other"`    // Removals, renames and permission changes, the watcher does not back up for those
+	Outcomes map[string]int `json:"outcomes"` // Simulated outcome, an audit outcome or OutcomeIgnored → events
+	Changed  map[string]int `json:"changed"`  // "recorded → simulated" outcome → events, for audit logs only
+	Files    int            `json:"files"`    // Distinct files that would be backed up
+	MaxQueue int            `json:"max_queue"`
+	MaxWait  time.Duration  `json:"max_wait"`     // Longest a job waited in the queue for a worker, in nanoseconds
+	Busy     time.Duration  `json:"busy"`         // Time the workers would spend backing up, summed over all workers
+	Ignores  map[string]int `json:"ignore_hits"`  // Ignore pattern → paths it matched
+	Includes map[string]int `json:"include_hits"` // Include pattern → paths it matched
+}
+
+// Simulate replays events against cfg. Every backup is assumed to take
+// jobDuration. The source and backup directories are not touched, except
+// that files larger than config.MaxFileSize are found by their size in the
+// trace or, when it has none, their current size.
+func Simulate(cfg *config.Config, events []TraceEvent, jobDuration time.Duration) SimulationResult {
+	// Only the filters of a FileWatcher are used
+	fw := &FileWatcher{config: cfg}
+	fw.filters.Store(compileFilters(cfg, nil))
+	if dir, inside := cfg.BackupInsideSource(); inside {
+		fw.excluded = dir
+	}
+
+	result := SimulationResult{
+		Outcomes: make(map[string]int),
+		Changed:  make(map[string]int),
+	}
+
+	lastBackup := make(map[string]time.Time)
+	backedUp := make(map[string]bool)
+	workers := make([]time.Time, workerCount) // When each worker is free again
+	var queue []time.Time                     // When each waiting job was queued
+
+	// run hands queued jobs to workers that are free by now
+	run := func(now time.Time) {
+		for len(queue) > 0 {
+			next := 0
+			for i := range workers {
+				if workers[i].Before(workers[next]) {
+					next = i
+				}
+			}
+
+			start := queue[0]
+			if workers[next].After(start) {
+				start = workers[next]
+			}
+			if start.After(now) {
+				return
+			}
+
+			result.MaxWait = max(result.MaxWait, start.Sub(queue[0]))
+			result.Busy += jobDuration
+			workers[next] = start.Add(jobDuration)
+			queue = queue[1:]
+		}
+	}
+
+	for _, event := range events {
+		switch event.Event {
+		case "REMOVE", "RENAME", "CHMOD":
+			result.Other++
+			continue
+		}
+		result.Events++
+		run(event.Time)
+
+		outcome := fw.simulateEvent(event, lastBackup)
+		if outcome == audit.OutcomeBackedUp {
+			if len(queue) >= queueCapacity {
+				outcome = audit.OutcomeDropped
+			} else {
+				queue = append(queue, event.Time)
+				lastBackup[event.Path] = event.Time
+				backedUp[event.Path] = true
+				result.MaxQueue = max(result.MaxQueue, len(queue))
+				run(event.Time)
+			}
+		}
+
+		result.Outcomes[outcome]++
+		// Copies are not simulated, a backup that failed would have been attempted all the same
+		if event.Outcome != "" && event.Outcome != audit.OutcomeFailed && event.Outcome != outcome {
+			result.Changed[event.Outcome+" → "+outcome]++
+		}
+	}
+	run(time.Unix(1<<62, 0))
+
+	result.Files = len(backedUp)
+	result.Ignores = ruleHits(fw.filters.Load().ignore)
+	result.Includes = ruleHits(fw.filters.Load().include)
+	return result
+}
+
+// simulateEvent returns the outcome the filters and the minimum interval
+// give an event, audit.OutcomeBackedUp when it would be queued
+func (fw *FileWatcher) simulateEvent(event TraceEvent, lastBackup map[string]time.Time) string {
+	if fw.isExcluded(event.Path) || fw.shouldIgnore(event.Path) || !fw.isIncluded(event.Path) {
+		return OutcomeIgnored
+	}
+
+	// Files gone since are only known by the size in the trace
+	info, err := os.Stat(event.Path)
+	if err != nil {
+		info = nil
+	}
+	size := event.Size
+	if size == 0 && info != nil {
+		size = info.Size()
+	}
+	if fw.config.MaxFileSize > 0 && size > fw.config.MaxFileSize {
+		return audit.OutcomeSkippedTooLarge
+	}
+	if !fw.policyAllows(event.Path, event.Event, info) {
+		return audit.OutcomeSkippedPolicy
+	}
+
+	if last, ok := lastBackup[event.Path]; ok && event.Time.Sub(last) < fw.config.MinInterval {
+		return audit.OutcomeSkippedInterval
+	}
+	return audit.OutcomeBackedUp
+}
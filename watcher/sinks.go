@@ -0,0 +1,185 @@
+package watcher
+
+// Event sinks forward backup outcomes and watcher errors to the notify package
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/config"
+	"github.com/cpprian/file-watcher-backup/notify"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Desktop notifications are shown after this many consecutive failures,
+// and at most once per notifyCooldown
+const (
+	notifyFailureThreshold = 3
+	notifyCooldown         = time.Minute
+)
+
+// newSinks creates the event sinks enabled in the configuration
+func newSinks(cfg *config.Config, logger *utils.Logger) ([]notify.EventSink, error) {
+	var sinks []notify.EventSink
+
+	if cfg.WebhookURL != "" {
+		webhook, err := notify.NewWebhook(cfg.WebhookURL, cfg.WebhookFormat, logger)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, webhook)
+	}
+
+	if len(cfg.EmailTo) > 0 {
+		email, err := notify.NewEmail(notify.EmailOptions{
+			Server:           cfg.SMTPServer,
+			Username:         cfg.SMTPUser,
+			Password:         cfg.SMTPPassword,
+			From:             cfg.EmailFrom,
+			To:               cfg.EmailTo,
+			FailureThreshold: cfg.EmailThreshold,
+			MinInterval:      cfg.EmailInterval,
+		}, logger)
+		if err != nil {
+			closeSinks(sinks, logger)
+			return nil, err
+		}
+		sinks = append(sinks, email)
+	}
+
+	if cfg.KafkaURL != "" {
+		kafka, err := notify.NewKafka(cfg.KafkaURL, cfg.KafkaTopic, logger)
+		if err != nil {
+			closeSinks(sinks, logger)
+			return nil, err
+		}
+		sinks = append(sinks, kafka)
+	}
+
+	if cfg.ExternalTool != "" {
+		external, err := notify.NewExternal(cfg.ExternalTool, cfg.ExternalRepo, cfg.BackupDir, cfg.ExternalDelay, logger)
+		if err != nil {
+			closeSinks(sinks, logger)
+			return nil, err
+		}
+		sinks = append(sinks, external)
+	}
+
+	if cfg.Notify {
+		sinks = append(sinks, notify.NewDesktop(notifyFailureThreshold, notifyCooldown))
+	}
+	return sinks, nil
+}
+
+// AddSink registers an additional destination for watcher events,
+// it is closed when the watcher stops. Must be called before Start.
+func (fw *FileWatcher) AddSink(sink notify.EventSink) {
+	fw.sinks = append(fw.sinks, sink)
+}
+
+// publish sends an event to every sink
+func (fw *FileWatcher) publish(event notify.Event) {
+	fw.sinksMu.RLock()
+	defer fw.sinksMu.RUnlock()
+	if fw.sinksClosed {
+		return
+	}
+
+	for _, sink := range fw.sinks {
+		sink.Publish(event)
+	}
+}
+
+// publishChange sends a file change to the sinks that want every change
+func (fw *FileWatcher) publishChange(event FileEvent) {
+	fw.sinksMu.RLock()
+	defer fw.sinksMu.RUnlock()
+	if fw.sinksClosed {
+		return
+	}
+
+	for _, sink := range fw.sinks {
+		if cs, ok := sink.(notify.ChangeSink); ok && cs.WantsChanges() {
+			cs.Publish(notify.Event{
+				Type:    notify.EventFileChanged,
+				Time:    event.Time,
+				Path:    event.Path,
+				Message: strings.ToLower(event.Type) + " " + event.Path,
+			})
+		}
+	}
+}
+
+// publishOutcome turns the outcome of a job into an event, outcomes
+// nobody needs to be told about are not published
+func (fw *FileWatcher) publishOutcome(entry audit.Entry, err error) {
+	event := notify.Event{
+		Time:   entry.Time,
+		Path:   entry.Path,
+		Backup: entry.Backup,
+		Error:  entry.Error,
+	}
+
+	switch entry.Outcome {
+	case audit.OutcomeBackedUp:
+		event.Type = notify.EventBackupCreated
+		event.Message = "backed up " + entry.Path
+	case audit.OutcomeFailed:
+		event.Type = notify.EventBackupFailed
+		event.Message = err.Error()
+	case audit.OutcomeDeferredCopies:
+		// Only the first deferral has an error, the others are not repeated
+		if err == nil {
+			return
+		}
+		event.Type = notify.EventBackupFailed
+		event.Message = err.Error() + ", tried again later"
+	case audit.OutcomeDropped:
+		event.Type = notify.EventQueueFull
+		event.Message = "queue full, skipped " + entry.Path
+	default:
+		return
+	}
+
+	fw.publish(event)
+}
+
+// publishWatchError reports an error of the file system watcher
+func (fw *FileWatcher) publishWatchError(err error) {
+	fw.publish(notify.Event{
+		Type:    notify.EventWatcherError,
+		Time:    time.Now(),
+		Message: err.Error(),
+		Error:   utils.ErrorLabel(err),
+	})
+}
+
+// publishWatchClosed reports that the file system watcher stopped on its own
+func (fw *FileWatcher) publishWatchClosed() {
+	fw.publish(notify.Event{
+		Type:    notify.EventWatcherClosed,
+		Time:    time.Now(),
+		Message: "file system watcher closed unexpectedly, changes are no longer backed up",
+	})
+}
+
+// closeWatcherSinks closes the sinks of the watcher. Workers still running
+// after the shutdown timeout publish nothing from then on.
+func (fw *FileWatcher) closeWatcherSinks() {
+	// No Publish is in progress once the flag is set
+	fw.sinksMu.Lock()
+	fw.sinksClosed = true
+	fw.sinksMu.Unlock()
+
+	closeSinks(fw.sinks, fw.logger)
+}
+
+// closeSinks delivers pending events and closes every sink
+func closeSinks(sinks []notify.EventSink, logger *utils.Logger) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logger.Warning("Could not close event sink: %v", err)
+		}
+	}
+}
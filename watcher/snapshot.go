@@ -0,0 +1,309 @@
+package watcher
+
+// Snapshots package the files of the source directory, or the latest stored
+// version of every file, into a single tar.gz or zip archive, e.g. to carry
+// them off-site. Paths in the archive are relative to the source directory.
+// A snapshot can record a Merkle-tree manifest of its files, see Tree.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats of a snapshot
+const (
+	SnapshotTarGz = "tar.gz"
+	SnapshotZip   = "zip"
+)
+
+// SnapshotOptions selects the format and the files of a snapshot. Patterns
+// without a slash match the file name, the others the path relative to the
+// source directory, like --only.
+type SnapshotOptions struct {
+	Format  string    // SnapshotTarGz or SnapshotZip
+	Include []string  // Only files matching one of these patterns, all files when empty
+	Exclude []string  // Files and directories matching one of these patterns are left out
+	Skip    []string  // Absolute paths left out of a source snapshot, e.g. the backup directory
+	Tree    io.Writer // Receives the manifest of the files in the snapshot, see Tree, nil for none
+}
+
+// SnapshotSummary counts what was written to a snapshot
+type SnapshotSummary struct {
+	Files int   // Files in the archive
+	Bytes int64 // Size of the files before compression
+}
+
+// selects reports whether the file at rel, relative to the source directory,
+// belongs in the snapshot
+func (opts SnapshotOptions) selects(rel string) bool {
+	if matchesAny(opts.Exclude, rel) {
+		return false
+	}
+	return len(opts.Include) == 0 || matchesAny(opts.Include, rel)
+}
+
+// matchesAny reports whether rel, a slash separated relative path, matches one of patterns
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		var matched bool
+		if strings.Contains(pattern, "/") {
+			matched, _ = path.Match(pattern, rel)
+		} else {
+			matched, _ = path.Match(pattern, path.Base(rel))
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotSource writes the regular files of dir to w as an archive.
+// Symbolic links and special files are left out.
+func SnapshotSource(ctx context.Context, dir string, w io.Writer, opts SnapshotOptions) (SnapshotSummary, error) {
+	var summary SnapshotSummary
+
+	archive, err := newArchiveWriter(opts.Format, w)
+	if err != nil {
+		return summary, err
+	}
+	tree := newTreeBuilder()
+
+	err = walkSnapshot(ctx, dir, opts, func(rel, file string) error {
+		return addFile(archive, tree, rel, file, &summary)
+	})
+	if err != nil {
+		archive.Close()
+		return summary, fmt.Errorf("error creating snapshot: %w", err)
+	}
+
+	if err := closeSnapshot(archive, tree, opts); err != nil {
+		return summary, fmt.Errorf("error creating snapshot: %w", err)
+	}
+	return summary, nil
+}
+
+// walkSnapshot calls fn for the regular files of dir opts selects, with
+// their path relative to dir with forward slashes and their path
+func walkSnapshot(ctx context.Context, dir string, opts SnapshotOptions, fn func(rel, file string) error) error {
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, p := range opts.Skip {
+		if abs, err := filepath.Abs(p); err == nil {
+			skip[abs] = true
+		}
+	}
+
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if file == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if abs, err := filepath.Abs(file); err == nil && skip[abs] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if matchesAny(opts.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !opts.selects(rel) {
+			return nil
+		}
+		return fn(rel, file)
+	})
+}
+
+// SnapshotLatest writes the latest stored version of every file to w as an
+// archive, under the path of the file. Files whose source was deleted are
+// included as long as a version of them is stored.
+func (bm *BackupManager) SnapshotLatest(ctx context.Context, w io.Writer, opts SnapshotOptions) (SnapshotSummary, error) {
+	var summary SnapshotSummary
+
+	archive, err := newArchiveWriter(opts.Format, w)
+	if err != nil {
+		return summary, err
+	}
+	tree := newTreeBuilder()
+
+	// The versions of a file are passed one after another, oldest first
+	var latest *VersionInfo
+	addLatest := func() error {
+		if latest == nil || !opts.selects(latest.Path) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return addFile(archive, tree, latest.Path, bm.versionPath(latest.Path, latest.Version), &summary)
+	}
+
+	_, err = bm.QueryVersions(VersionQuery{}, func(v VersionInfo) error {
+		if latest != nil && latest.Path != v.Path {
+			if err := addLatest(); err != nil {
+				return err
+			}
+		}
+		latest = &v
+		return nil
+	})
+	if err == nil {
+		err = addLatest()
+	}
+	if err != nil {
+		archive.Close()
+		return summary, fmt.Errorf("error creating snapshot: %w", err)
+	}
+
+	if err := closeSnapshot(archive, tree, opts); err != nil {
+		return summary, fmt.Errorf("error creating snapshot: %w", err)
+	}
+	return summary, nil
+}
+
+// closeSnapshot finishes the archive and writes the manifest of tree to opts.Tree
+func closeSnapshot(archive archiveWriter, tree *treeBuilder, opts SnapshotOptions) error {
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if opts.Tree == nil {
+		return nil
+	}
+	if err := tree.tree().Write(opts.Tree); err != nil {
+		return fmt.Errorf("error writing tree manifest: %w", err)
+	}
+	return nil
+}
+
+// archiveWriter writes files to a tar.gz or zip archive
+type archiveWriter interface {
+	// add writes a file with the metadata of info and the content of r
+	add(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case SnapshotTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	case SnapshotZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot format: %s", format)
+	}
+}
+
+// addFile writes the file at file to aw as name, records it in tree and
+// counts it in summary
+func addFile(aw archiveWriter, tree *treeBuilder, name, file string, summary *SnapshotSummary) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The size is taken from the open file, a file replaced meanwhile is read consistently
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Hashed while archived, the manifest costs no second read
+	sum := sha256.New()
+	if err := aw.add(name, info, io.TeeReader(f, sum)); err != nil {
+		return fmt.Errorf("error adding %s: %w", name, err)
+	}
+	tree.add(name, info.Size(), info.ModTime(), hex.EncodeToString(sum.Sum(nil)))
+
+	summary.Files++
+	summary.Bytes += info.Size()
+	return nil
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// The header promised info.Size() bytes, a file that grew is cut there
+	n, err := io.Copy(t.tw, io.LimitReader(r, info.Size()))
+	if err != nil {
+		return err
+	}
+	if n < info.Size() {
+		return fmt.Errorf("file shrank while it was archived")
+	}
+	return nil
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		t.gz.Close()
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,87 @@
+package watcher
+
+// Watcher state kept across restarts. The time each file was last queued for
+// backup is saved in the backup directory when the watcher stops and loaded
+// when it is created again, so MinInterval still applies to the files backed
+// up just before a restart, and a burst of changes around the restart does not
+// back up every file again.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFileName is the state file in the backup directory
+const stateFileName = ".state"
+
+// savedState is the content of the state file
+type savedState struct {
+	// LastBackup maps paths relative to the source directory, with forward
+	// slashes, to when they were last queued for backup
+	LastBackup map[string]time.Time `json:"last_backup"`
+}
+
+// loadState returns the last backup times saved by a previous run, keyed like
+// lastBackup. A missing or unreadable state file gives an empty map.
+func (fw *FileWatcher) loadState() map[string]time.Time {
+	lastBackup := make(map[string]time.Time)
+
+	data, err := os.ReadFile(filepath.Join(fw.config.BackupDir, stateFileName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fw.logger.Warning("Could not read watcher state, starting without it: %v", err)
+		}
+		return lastBackup
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		fw.logger.Warning("Could not read watcher state, starting without it: %v", err)
+		return lastBackup
+	}
+
+	now := time.Now()
+	for rel, t := range state.LastBackup {
+		// Ignore times from a clock that was ahead, they would block backups
+		if t.After(now) {
+			continue
+		}
+		lastBackup[filepath.Join(fw.config.SourceDir, filepath.FromSlash(rel))] = t
+	}
+	return lastBackup
+}
+
+// saveState writes the last backup times to the state file, replacing it atomically
+func (fw *FileWatcher) saveState() error {
+	fw.mu.Lock()
+	state := savedState{LastBackup: make(map[string]time.Time, len(fw.lastBackup))}
+	for path, t := range fw.lastBackup {
+		rel, err := filepath.Rel(fw.config.SourceDir, path)
+		if err != nil {
+			continue
+		}
+		state.LastBackup[filepath.ToSlash(rel)] = t
+	}
+	fw.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding watcher state: %w", err)
+	}
+
+	path := filepath.Join(fw.config.BackupDir, stateFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing watcher state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing watcher state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package watcher
+
+// Status of a running watcher, for tools querying it from outside the process.
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+)
+
+// recentBackupCount is the number of most recently backed up files kept for Status
+const recentBackupCount = 10
+
+// Status is a snapshot of a running watcher
+type Status struct {
+	Started     time.Time      `json:"started"`
+	Source      string         `json:"source"`
+	Backup      string         `json:"backup"`
+	Paused      bool           `json:"paused"`
+	PausedSince time.Time      `json:"paused_since,omitzero"`
+	Outcomes    map[string]int `json:"outcomes"` // Jobs since the start by audit outcome
+	Errors      map[string]int `json:"errors"`   // Failed jobs since the start by error kind, see utils.ErrorLabel
+	Recent      []RecentBackup `json:"recent"`   // Files backed up most recently, newest first
+	Stats       Stats          `json:"stats"`
+	Rewatch     []RewatchDir   `json:"rewatch,omitempty"` // Directories whose watch failed and is tried again, relative to the source directory
+	Shadow      *ShadowStatus  `json:"shadow,omitempty"`
+}
+
+// Stats are the counters and gauges of a running watcher, see GetStats
+type Stats struct {
+	ThrottleOpsRate    float64 `json:"throttle_ops_rate"`
+	ThrottleOpsLimit   float64 `json:"throttle_ops_limit"` // 0 when unlimited
+	ThrottleBytesRate  float64 `json:"throttle_bytes_rate"`
+	ThrottleBytesLimit float64 `json:"throttle_bytes_limit"` // 0 when unlimited
+
+	TrackedFiles     int `json:"tracked_files"`  // Files with a known last backup time
+	QueueLength      int `json:"queue_length"`   // Jobs waiting for a worker
+	QueueCapacity    int `json:"queue_capacity"` // Jobs the queue holds before it drops new ones
+	ActiveWorkers    int `json:"active_workers"`
+	RestartedWorkers int `json:"restarted_workers"` // Workers restarted after a panic
+	LostWorkers      int `json:"lost_workers"`      // Workers that died and were not replaced
+	StalledWorkers   int `json:"stalled_workers"`   // Workers replaced after a job ran past the timeout
+	TimedOutJobs     int `json:"timed_out_jobs"`
+	SweepMissed      int `json:"sweep_missed"`   // Changes missed by the file system watcher and found by a sweep
+	UnwatchedDirs    int `json:"unwatched_dirs"` // Directories polled because they can not be watched
+	RewatchDirs      int `json:"rewatch_dirs"`   // Directories whose watch failed and is tried again
+	DeadLetters      int `json:"dead_letters"`   // Files whose backup failed for good, until they change again
+	PausedChanges    int `json:"paused_changes"` // Files changed while backups are paused
+
+	// Since the start
+	BackupsSucceeded int           `json:"backups_succeeded"`
+	BackupsFailed    int           `json:"backups_failed"`
+	BytesWritten     int64         `json:"bytes_written"`  // Size of the versions stored
+	JobsDropped      int           `json:"jobs_dropped"`   // Jobs dropped because the queue was full
+	AvgLatency       time.Duration `json:"avg_latency_ns"` // Average time of a successful backup, from a worker taking it to the version stored
+
+	EventsObserved map[string]int `json:"events_observed"` // By event type
+	EventsQueued   map[string]int `json:"events_queued"`   // By event type
+	IgnoreHits     map[string]int `json:"ignore_hits"`     // By ignore pattern
+	IncludeHits    map[string]int `json:"include_hits"`    // By include pattern
+}
+
+// RecentBackup is the last backup of a file
+type RecentBackup struct {
+	Path    string    `json:"path"` // Relative to the source directory
+	Time    time.Time `json:"time"`
+	Version string    `json:"version"`
+}
+
+// statusCounter collects the outcomes of jobs since the start
+type statusCounter struct {
+	mu       sync.Mutex
+	outcomes map[string]int
+	errors   map[string]int
+	recent   []RecentBackup
+	written  int64         // Size of the versions stored
+	latency  time.Duration // Total time of the successful backups
+}
+
+// add counts the outcome of a job, path is relative to the source directory
+func (sc *statusCounter) add(entry audit.Entry, path string) {
+	var size int64
+	if entry.Outcome == audit.OutcomeBackedUp {
+		if info, err := os.Stat(entry.Backup); err == nil {
+			size = info.Size()
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.outcomes == nil {
+		sc.outcomes = make(map[string]int)
+		sc.errors = make(map[string]int)
+	}
+	sc.outcomes[entry.Outcome]++
+	if entry.Outcome == audit.OutcomeFailed && entry.Error != "" {
+		sc.errors[entry.Error]++
+	}
+
+	if entry.Outcome != audit.OutcomeBackedUp {
+		return
+	}
+	sc.written += size
+
+	// One entry per file, the newest first
+	recent := []RecentBackup{{Path: path, Time: entry.Time, Version: filepath.Base(entry.Backup)}}
+	for _, r := range sc.recent {
+		if r.Path != path && len(recent) < recentBackupCount {
+			recent = append(recent, r)
+		}
+	}
+	sc.recent = recent
+}
+
+// took adds the time a successful backup took
+func (sc *statusCounter) took(elapsed time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.latency += elapsed
+}
+
+// totals fills the counters since the start in stats
+func (sc *statusCounter) totals(stats *Stats) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	stats.BackupsSucceeded = sc.outcomes[audit.OutcomeBackedUp]
+	stats.BackupsFailed = sc.outcomes[audit.OutcomeFailed]
+	stats.JobsDropped = sc.outcomes[audit.OutcomeDropped]
+	stats.BytesWritten = sc.written
+	if stats.BackupsSucceeded > 0 {
+		stats.AvgLatency = sc.latency / time.Duration(stats.BackupsSucceeded)
+	}
+}
+
+// eventTypes are the file events counted by eventCounter
+var eventTypes = []string{"CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD"}
+
+// eventCounter counts file events by type, the ones seen and the ones that
+// queued a backup, to tell create-heavy from modify-heavy workloads
+type eventCounter struct {
+	mu       sync.Mutex
+	observed map[string]int
+	queued   map[string]int
+}
+
+// observe counts an event reported by the file system watcher
+func (ec *eventCounter) observe(eventType string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.observed == nil {
+		ec.observed = make(map[string]int)
+	}
+	ec.observed[eventType]++
+}
+
+// queue counts an event that queued a backup, or was held for one while paused
+func (ec *eventCounter) queue(eventType string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.queued == nil {
+		ec.queued = make(map[string]int)
+	}
+	ec.queued[eventType]++
+}
+
+// counts returns copies of the counters, with every event type present
+func (ec *eventCounter) counts() (observed, queued map[string]int) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	observed = make(map[string]int, len(eventTypes))
+	queued = make(map[string]int, len(eventTypes))
+	for _, eventType := range eventTypes {
+		observed[eventType] = ec.observed[eventType]
+		queued[eventType] = ec.queued[eventType]
+	}
+	return observed, queued
+}
+
+// Status returns a snapshot of the watcher
+func (fw *FileWatcher) Status() Status {
+	paused, since := fw.Paused()
+
+	status := Status{
+		Started:  fw.started,
+		Source:   fw.config.SourceDir,
+		Backup:   fw.config.BackupDir,
+		Paused:   paused,
+		Outcomes: make(map[string]int),
+		Errors:   make(map[string]int),
+		Stats:    fw.GetStats(),
+		Shadow:   fw.Shadow(),
+	}
+	if paused {
+		status.PausedSince = since
+	}
+	for _, d := range fw.rewatch.list() {
+		d.Path = fw.relPath(d.Path)
+		status.Rewatch = append(status.Rewatch, d)
+	}
+
+	fw.status.mu.Lock()
+	for outcome, n := range fw.status.outcomes {
+		status.Outcomes[outcome] = n
+	}
+	for kind, n := range fw.status.errors {
+		status.Errors[kind] = n
+	}
+	status.Recent = append([]RecentBackup{}, fw.status.recent...)
+	fw.status.mu.Unlock()
+
+	return status
+}
+
+// relPath returns path relative to the source directory, path itself when it is outside
+func (fw *FileWatcher) relPath(path string) string {
+	rel, err := filepath.Rel(fw.config.SourceDir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
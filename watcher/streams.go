@@ -0,0 +1,125 @@
+package watcher
+
+// Alternate data streams of NTFS and resource forks of macOS. A plain copy
+// only carries the main content of a file. When enabled, the other streams of
+// a file are stored in .streams/<version>/ of its version directory, one file
+// per stream, and written back when the version is restored.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// streamsDirName is the directory of a version directory holding the streams of its versions
+const streamsDirName = ".streams"
+
+// streamsDir returns the directory holding the streams of a version
+func streamsDir(versionPath string) string {
+	return filepath.Join(filepath.Dir(versionPath), streamsDirName, filepath.Base(versionPath))
+}
+
+// backupStreams copies the streams of sourcePath next to the version at
+// versionPath. When streams are not copied, each file having some is reported
+// once. A stream that can not be copied does not stop the others.
+func (bm *BackupManager) backupStreams(ctx context.Context, sourcePath, versionPath string) error {
+	names, err := utils.ListStreams(sourcePath)
+	if err != nil {
+		return fmt.Errorf("error listing streams: %w", err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if !bm.copyStreams {
+		if _, reported := bm.streamsSeen.LoadOrStore(sourcePath, true); !reported {
+			bm.logger.Warning("%s has alternate streams that are not backed up: %s", sourcePath, strings.Join(names, ", "))
+		}
+		return nil
+	}
+
+	dir := streamsDir(versionPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return utils.NewBackupError(dir, utils.OpCreateDir, err)
+	}
+
+	opts := utils.CopyOptions{MaxRetries: 3, Throttle: bm.throttle, SkipMetadata: true}
+
+	var errs []error
+	for _, name := range names {
+		// Stream names may contain characters file names can not
+		dst := filepath.Join(dir, url.PathEscape(name))
+		if err := utils.CopyFile(ctx, utils.StreamPath(sourcePath, name), dst, opts); err != nil {
+			errs = append(errs, fmt.Errorf("stream %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreStreams writes the streams stored with the version at versionPath to
+// target and returns how many there were. The modification time of target is
+// set again afterwards, writing the streams changes it.
+func (bm *BackupManager) restoreStreams(ctx context.Context, versionPath, target string) (int, error) {
+	entries, err := os.ReadDir(streamsDir(versionPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	if utils.StreamPath(target, "") == "" {
+		return len(entries), fmt.Errorf("%d streams can not be restored on this platform", len(entries))
+	}
+
+	// The version carries the mode and the times of the file
+	info, err := os.Stat(versionPath)
+	if err != nil {
+		return len(entries), err
+	}
+
+	// A read-only file can not get streams
+	if info.Mode().Perm()&0200 == 0 {
+		if err := os.Chmod(target, info.Mode().Perm()|0200); err != nil {
+			return len(entries), err
+		}
+		defer os.Chmod(target, info.Mode().Perm())
+	}
+
+	opts := utils.CopyOptions{MaxRetries: 3, SkipMetadata: true}
+
+	var errs []error
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		src := filepath.Join(streamsDir(versionPath), entry.Name())
+		if err := utils.CopyFile(ctx, src, utils.StreamPath(target, name), opts); err != nil {
+			errs = append(errs, fmt.Errorf("stream %s: %w", name, err))
+		}
+	}
+
+	if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+		errs = append(errs, err)
+	}
+	return len(entries), errors.Join(errs...)
+}
+
+// removeStreams removes the streams stored with a version
+func removeStreams(versionPath string) error {
+	err := os.RemoveAll(streamsDir(versionPath))
+	// The streams directory itself stays until all its versions are gone
+	os.Remove(filepath.Dir(streamsDir(versionPath)))
+	return err
+}
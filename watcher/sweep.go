@@ -0,0 +1,103 @@
+package watcher
+
+// Periodic full sweeps of the source directory while watching. fsnotify can
+// miss changes, e.g. when the inotify queue overflows or a directory is
+// created and filled before its watch is added. A sweep compares every file
+// with its latest version and queues the ones that changed since.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EventSweep is the event type of jobs queued by a sweep
+const EventSweep = "SWEEP"
+
+// sweepLoop runs a sweep every config.SweepInterval until ctx is canceled
+func (fw *FileWatcher) sweepLoop(ctx context.Context) {
+	defer fw.senders.Done()
+
+	ticker := time.NewTicker(fw.config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		scanned, missed, err := fw.sweep(ctx)
+		if err != nil {
+			return
+		}
+
+		fw.sweepMissed.Add(int64(missed))
+		if missed > 0 {
+			fw.logger.Warning("Sweep found %d changes missed by the file system watcher in %d files (%s)",
+				missed, scanned, time.Since(start).Round(time.Millisecond))
+		} else {
+			fw.logger.Info("Sweep checked %d files, nothing missed (%s)", scanned, time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+// sweep queues every file whose latest version is out of date and that is not
+// already on its way. It returns the number of files checked and queued.
+func (fw *FileWatcher) sweep(ctx context.Context) (scanned, missed int, err error) {
+	err = fw.walkSource(ctx, func(path string, info os.FileInfo) error {
+		scanned++
+		queued, err := fw.sweepFile(ctx, path, info)
+		if queued {
+			missed++
+		}
+		return err
+	})
+	return scanned, missed, err
+}
+
+// sweepFile queues a file found by a scan when its latest version is out of
+// date and it is not already on its way, and reports whether it did
+func (fw *FileWatcher) sweepFile(ctx context.Context, path string, info os.FileInfo) (bool, error) {
+	// The watcher reports these when they change, no need to repeat it every sweep
+	if fw.config.MaxFileSize > 0 && info.Size() > fw.config.MaxFileSize || !fw.policyAllows(path, EventSweep, info) {
+		return false, nil
+	}
+
+	relPath, err := filepath.Rel(fw.config.SourceDir, path)
+	if err != nil || fw.BackupManager.IsCurrent(relPath, info) {
+		return false, nil
+	}
+
+	// Queued after the last change, the version may just not be there yet
+	fw.mu.Lock()
+	last, known := fw.lastBackup[path]
+	fw.mu.Unlock()
+	if known && !last.Before(info.ModTime()) {
+		return false, nil
+	}
+
+	job := BackupJob{FilePath: path, EventType: EventSweep, Timestamp: time.Now(), Size: info.Size()}
+
+	if err := fw.journal.add(&job); err != nil {
+		fw.logger.Warning("Could not write queue journal: %v", err)
+	}
+
+	// Wait for room in the queue, a sweep must not crowd out live events by dropping them
+	select {
+	case fw.backupQueue <- job:
+	case <-ctx.Done():
+		fw.journal.done(job.ID)
+		return false, ctx.Err()
+	}
+
+	fw.mu.Lock()
+	fw.lastBackup[path] = time.Now()
+	fw.mu.Unlock()
+
+	fw.logger.Debug("Add to backup queue: %s [%s]", filepath.Base(path), EventSweep)
+	return true, nil
+}
@@ -0,0 +1,258 @@
+package watcher
+
+// Verification of stored versions. Each run reads a share of the versions,
+// continuing where the previous run stopped, and compares their content with
+// the checksum recorded when they were created. Running it every night with
+// the same share checks every version within a bounded number of nights at a
+// predictable cost. Versions made before checksums existed are only read.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/notify"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// verifyStateName is the file in the backup directory keeping where the last verification stopped
+const verifyStateName = ".verify"
+
+// errChecksumMismatch is returned for versions whose content changed since they were stored
+var errChecksumMismatch = errors.New("content does not match its checksum")
+
+// VerifyResult summarizes a verification run
+type VerifyResult struct {
+	Total      int           // Versions in the repository
+	Checked    int           // Versions read
+	Bytes      int64         // Bytes read
+	Unhashed   int           // Checked versions without a checksum, only checked to be readable
+	Corrupt    []VersionInfo // Versions whose content no longer matches their checksum
+	Unreadable []VersionInfo // Versions that could not be read
+	Duration   time.Duration // Time the run took
+}
+
+// Failed reports whether a checked version was corrupt or unreadable
+func (r VerifyResult) Failed() bool {
+	return len(r.Corrupt) > 0 || len(r.Unreadable) > 0
+}
+
+// verifyState is the content of the verify state file
+type verifyState struct {
+	Cursor  string    `json:"cursor,omitempty"` // Query cursor after the last version checked
+	LastRun time.Time `json:"last_run"`
+}
+
+// VerifySample verifies percent of the stored versions, at least one,
+// starting after the last version checked by the previous run and wrapping
+// around at the end of the repository. Problems are logged per version.
+// Canceling ctx stops the run, the versions checked so far are returned.
+func (bm *BackupManager) VerifySample(ctx context.Context, percent float64) (VerifyResult, error) {
+	start := time.Now()
+
+	stats, err := bm.Stats()
+	if err != nil {
+		return VerifyResult{}, fmt.Errorf("error counting versions: %w", err)
+	}
+
+	result := VerifyResult{Total: stats.Versions}
+	if result.Total == 0 {
+		return result, nil
+	}
+	n := min(result.Total, max(1, int(math.Ceil(float64(result.Total)*percent/100))))
+
+	check := func(v VersionInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		result.Checked++
+		size, err := bm.verifyVersion(ctx, v)
+		result.Bytes += size
+
+		switch {
+		case errors.Is(err, errChecksumMismatch):
+			result.Corrupt = append(result.Corrupt, v)
+			bm.logger.Error("Version %s of %s is corrupt, its content does not match its checksum", v.Version, v.Path)
+		case errors.Is(err, context.Canceled):
+			return err
+		case err != nil:
+			result.Unreadable = append(result.Unreadable, v)
+			bm.logger.Error("Could not verify version %s of %s: %v", v.Version, v.Path, err)
+		case v.SHA256 == "":
+			result.Unhashed++
+		}
+		return nil
+	}
+
+	state := bm.loadVerifyState()
+	cursor := state.Cursor
+	for result.Checked < n {
+		before := result.Checked
+		next, err := bm.QueryVersions(VersionQuery{Cursor: cursor, Limit: n - result.Checked}, check)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if cursor != "" {
+				// A cursor that can not be decoded, e.g. from an edited state file, start over
+				cursor = ""
+				continue
+			}
+			return result, err
+		}
+
+		// The end of the repository was reached, go on from its start
+		if next == "" && cursor == "" && result.Checked == before {
+			break
+		}
+		cursor = next
+	}
+
+	result.Duration = time.Since(start)
+	if ctx.Err() == nil {
+		state = verifyState{Cursor: cursor, LastRun: time.Now()}
+		if err := bm.saveVerifyState(state); err != nil {
+			bm.logger.Warning("Could not save where verification stopped: %v", err)
+		}
+	}
+	return result, ctx.Err()
+}
+
+// verifyVersion reads a version and compares it with its checksum, when it
+// has one. It returns the number of bytes read.
+func (bm *BackupManager) verifyVersion(ctx context.Context, v VersionInfo) (int64, error) {
+	path := bm.versionPath(v.Path, v.Version)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Verification shares the copy bandwidth, it must not slow down backups
+	sum := sha256.New()
+	reader := bm.throttle.Reader(ctx, f)
+	buf := make([]byte, 32*1024)
+
+	var size int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return size, err
+		}
+
+		n, err := reader.Read(buf)
+		sum.Write(buf[:n])
+		size += int64(n)
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+
+	if v.SHA256 != "" && hex.EncodeToString(sum.Sum(nil)) != v.SHA256 {
+		return size, errChecksumMismatch
+	}
+	return size, nil
+}
+
+// loadVerifyState returns where the last verification stopped, the start of
+// the repository when the state file is missing or unreadable
+func (bm *BackupManager) loadVerifyState() verifyState {
+	var state verifyState
+
+	data, err := os.ReadFile(filepath.Join(bm.backupDir, verifyStateName))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return verifyState{}
+	}
+	return state
+}
+
+// saveVerifyState writes where the last verification stopped, replacing the file atomically
+func (bm *BackupManager) saveVerifyState(state verifyState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(bm.backupDir, verifyStateName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LogVerifyResult reports the outcome of a verification run. percent is the
+// share verified per run, it gives the number of runs checking every version.
+func (bm *BackupManager) LogVerifyResult(result VerifyResult, percent float64) {
+	if result.Failed() {
+		bm.logger.Error("Verified %d of %d versions in %s: %d corrupt, %d unreadable",
+			result.Checked, result.Total, result.Duration.Round(time.Millisecond),
+			len(result.Corrupt), len(result.Unreadable))
+	} else {
+		bm.logger.Success("Verified %d of %d versions (%s) in %s, every version is checked within %d runs",
+			result.Checked, result.Total, utils.FormatSize(result.Bytes), result.Duration.Round(time.Millisecond),
+			int(math.Ceil(100/percent)))
+	}
+
+	if result.Unhashed > 0 {
+		bm.logger.Info("%d versions were stored without a checksum and only checked to be readable", result.Unhashed)
+	}
+}
+
+// verifyLoop verifies a share of the versions every day at config.VerifyAt,
+// until ctx is canceled
+func (fw *FileWatcher) verifyLoop(ctx context.Context) {
+	defer fw.senders.Done()
+
+	for {
+		next, _ := NextDigest(fw.config.VerifyAt, time.Now())
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		result, err := fw.BackupManager.VerifySample(ctx, fw.config.VerifyPercent)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fw.logger.Error("Verification failed: %v", err)
+			continue
+		}
+		fw.BackupManager.LogVerifyResult(result, fw.config.VerifyPercent)
+
+		if result.Failed() {
+			fw.publish(notify.Event{
+				Type: notify.EventVerifyFailed,
+				Time: time.Now(),
+				Message: fmt.Sprintf("%d of %d verified versions are corrupt or unreadable",
+					len(result.Corrupt)+len(result.Unreadable), result.Checked),
+			})
+		}
+	}
+}
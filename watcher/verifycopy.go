@@ -0,0 +1,90 @@
+package watcher
+
+// Verifying copies. A file written while it is copied, e.g. a log appended to
+// or a document saved again, yields a version mixing old and new content, and
+// the writes meanwhile were skipped as too soon since the backup. With
+// config.VerifyCopies the source is read again after the copy: when it changed
+// the version is dropped and the backup tried again once the writes settled,
+// like the backup of a locked file. The written version is read back as well,
+// so a version differing from what was read fails as an i/o error.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// Tries and delay of backups of files changed while they were copied, the
+// delay is doubled for each further try, at least the minimum interval and
+// at most changedMaxDelay
+const (
+	changedRetries  = 3
+	changedDelay    = 2 * time.Second
+	changedMaxDelay = time.Hour
+)
+
+// verifyCopy checks the version at path copied from sourcePath, before is the
+// source as it was before the copy and sum the checksum of what was read. A
+// source changed meanwhile fails with utils.ErrSourceChanged.
+func (bm *BackupManager) verifyCopy(ctx context.Context, sourcePath, path string, before os.FileInfo, sum string) error {
+	if !bm.verifyCopies || before == nil {
+		return nil
+	}
+
+	after, err := os.Stat(sourcePath)
+	if err != nil {
+		// Removed meanwhile, the version has its last content
+		if os.IsNotExist(err) {
+			return bm.verifyWritten(ctx, path, sum)
+		}
+		return utils.NewBackupError(sourcePath, utils.OpVerify, err)
+	}
+
+	// Only hashed while it looks unchanged, anything else was written to
+	changed := after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime())
+	if !changed {
+		current, err := bm.hashSource(ctx, sourcePath)
+		if err != nil {
+			return utils.NewBackupError(sourcePath, utils.OpVerify, err)
+		}
+		changed = current != sum
+	}
+	if changed {
+		return utils.NewBackupError(sourcePath, utils.OpVerify, utils.ErrSourceChanged)
+	}
+
+	return bm.verifyWritten(ctx, path, sum)
+}
+
+// verifyWritten reads the version at path back and compares it to sum
+func (bm *BackupManager) verifyWritten(ctx context.Context, path, sum string) error {
+	written, err := bm.hashSource(ctx, path)
+	if err != nil {
+		return utils.NewBackupError(path, utils.OpVerify, err)
+	}
+	if written != sum {
+		return utils.NewBackupError(path, utils.OpVerify,
+			fmt.Errorf("%w: %w", utils.ErrIO, errChecksumMismatch))
+	}
+	return nil
+}
+
+// deferChanged finishes the job of a file changed while it was copied and
+// queues it again after a delay, so the version has the content the writes
+// settled on. Once every try is used up the job fails with err instead. It
+// returns like finishJob.
+func (fw *FileWatcher) deferChanged(slot *workerSlot, job BackupJob, err error) bool {
+	if job.Deferrals >= changedRetries {
+		fw.logger.Error("Worker #%d: %s still changes, giving up after %d tries",
+			slot.id, filepath.Base(job.FilePath), job.Deferrals+1)
+		return fw.finishJob(slot, audit.OutcomeFailed, "", err.Error(), err)
+	}
+
+	delay := deferDelay(max(changedDelay, fw.config.MinInterval), job.Deferrals, changedMaxDelay)
+	return fw.deferJob(slot, job, audit.OutcomeDeferredChanged, "changed while it was copied", delay, changedRetries, nil)
+}
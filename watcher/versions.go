@@ -0,0 +1,234 @@
+package watcher
+
+// In-memory index of stored versions, so retention does not have to list
+// version directories on every backup. This matters for very wide trees
+// where the backup directory holds hundreds of thousands of version directories.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// versionIndex caches the sorted list of versions per version directory
+// and the version directories known to exist
+type versionIndex struct {
+	mu       sync.Mutex
+	scan     versionLister       // Lists the versions of a directory on first use
+	versions map[string][]string // Version directory → version paths, oldest first
+	dirs     map[string]struct{} // Version directories known to exist
+	sequence map[string]int      // Version directory → last sequence number handed out
+}
+
+// versionLister returns the paths of the versions of fileName stored in dir, oldest first
+type versionLister func(dir, fileName string) ([]string, error)
+
+func newVersionIndex(scan versionLister) *versionIndex {
+	return &versionIndex{
+		scan:     scan,
+		versions: make(map[string][]string),
+		dirs:     make(map[string]struct{}),
+		sequence: make(map[string]int),
+	}
+}
+
+// ensureDir creates a version directory unless it was already created before
+func (vi *versionIndex) ensureDir(dir string) error {
+	vi.mu.Lock()
+	_, known := vi.dirs[dir]
+	vi.mu.Unlock()
+
+	if known {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	vi.mu.Lock()
+	vi.dirs[dir] = struct{}{}
+	vi.mu.Unlock()
+	return nil
+}
+
+// forgetDir drops everything cached about a version directory,
+// e.g. after it was removed or changed behind our back
+func (vi *versionIndex) forgetDir(dir string) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	delete(vi.dirs, dir)
+	delete(vi.versions, dir)
+	delete(vi.sequence, dir)
+}
+
+// list returns a copy of the cached versions of a directory
+func (vi *versionIndex) list(dir string) []string {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	return append([]string(nil), vi.versions[dir]...)
+}
+
+// nextSequence reserves the next sequence number of a version directory,
+// so concurrent backups of the same file never get the same name. lastSeq
+// returns the last number used so far, it is only called on first use.
+func (vi *versionIndex) nextSequence(dir string, lastSeq func() (int, error)) (int, error) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	last, ok := vi.sequence[dir]
+	if !ok {
+		var err error
+		if last, err = lastSeq(); err != nil {
+			return 0, err
+		}
+	}
+
+	vi.sequence[dir] = last + 1
+	return last + 1, nil
+}
+
+// load returns the cached versions of a directory, listing it on first use.
+// vi.mu must be held.
+func (vi *versionIndex) load(dir, baseName, ext string) ([]string, error) {
+	if versions, ok := vi.versions[dir]; ok {
+		return versions, nil
+	}
+
+	versions, err := vi.scan(dir, baseName+ext)
+	if err != nil {
+		return nil, err
+	}
+
+	vi.versions[dir] = versions
+	return versions, nil
+}
+
+// add records a new version and returns the versions exceeding maxVersions,
+// oldest first. They are removed from the index, the caller deletes the files.
+func (vi *versionIndex) add(dir, baseName, ext, path string, maxVersions int) ([]string, error) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	versions, err := vi.load(dir, baseName, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions of a file are created one at a time, the new one is the newest,
+	// also when it replaced or reused a version of the same name
+	versions = slices.DeleteFunc(slices.Clone(versions), func(v string) bool { return v == path })
+	versions = append(versions, path)
+
+	var excess []string
+	if len(versions) > maxVersions {
+		n := len(versions) - maxVersions
+		excess = append(excess, versions[:n]...)
+		versions = append([]string(nil), versions[n:]...)
+	}
+
+	vi.versions[dir] = versions
+	return excess, nil
+}
+
+// listVersions returns the paths of the versions of fileName stored in dir,
+// oldest first. Versions are the files recorded in the manifest, and those
+// made before it whose name is a version of exactly fileName in one of the
+// naming modes, anything else in dir is left alone. Versions are
+// ordered by their creation time from the manifest, or from their name when
+// missing there, so versions named in different modes keep their order.
+func (bm *BackupManager) listVersions(dir, fileName string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Unreadable manifests only cost the order of versions named in several modes
+	manifest, _ := bm.readManifest(dir)
+
+	found := orderVersions(entries, manifest, fileName)
+	paths := make([]string, len(found))
+	for i, v := range found {
+		paths[i] = filepath.Join(dir, v.name)
+	}
+	return paths, nil
+}
+
+// storedVersion is a version with its creation time
+type storedVersion struct {
+	name    string    // Version name, see VersionInfo.Version
+	created time.Time // Zero when unknown, ordered first
+}
+
+// orderVersions picks the versions of fileName out of the entries of its
+// version directory and orders them by creation time, then by name, see
+// listVersions
+func orderVersions(entries []os.DirEntry, manifest map[string]manifestEntry, fileName string) []storedVersion {
+	var found []storedVersion
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		// The manifest knows its versions, only the others are told by their name
+		recorded, known := manifest[name]
+		if !known && !isVersionName(fileName, name) {
+			continue
+		}
+
+		created := recorded.Time
+		if created.IsZero() {
+			created, _ = parseVersionTime(fileName, name)
+		}
+		found = append(found, storedVersion{name: name, created: created})
+	}
+	sortVersions(found)
+	return found
+}
+
+// sortVersions orders versions by creation time, then by name
+func sortVersions(versions []storedVersion) {
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].created.Equal(versions[j].created) {
+			return versions[i].created.Before(versions[j].created)
+		}
+		return versions[i].name < versions[j].name
+	})
+}
+
+// lastSequence returns the highest sequence number of the versions of
+// fileName stored in dir, 0 when none has one. The manifest records them;
+// a name is only parsed for versions it does not number, and then only when
+// the number is not the creation time, e.g. a day name like 20240101.
+func (bm *BackupManager) lastSequence(dir, fileName string) (int, error) {
+	versions, err := bm.listVersions(dir, fileName)
+	if err != nil {
+		return 0, err
+	}
+	manifest, _ := bm.readManifest(dir)
+
+	last := 0
+	for _, path := range versions {
+		name := filepath.Base(path)
+		recorded, known := manifest[name]
+		seq := recorded.Seq
+		if seq == 0 {
+			stamp, _ := splitVersionName(fileName, name)
+			if known && isTimeStamp(stamp, recorded.Time) {
+				continue
+			}
+			seq, _ = parseSequence(fileName, name)
+		}
+		last = max(last, seq)
+	}
+	return last, nil
+}
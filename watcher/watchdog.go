@@ -0,0 +1,215 @@
+package watcher
+
+// Watchdog for stalled workers. Every job gets a deadline, config.JobTimeout,
+// and is canceled when it expires, which stops a copy between two reads.
+// A worker that still does not return, e.g. because it is blocked in a read
+// from a hung NFS server, is given up on and replaced, so the pool keeps its size.
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/audit"
+	"github.com/cpprian/file-watcher-backup/notify"
+	"github.com/cpprian/file-watcher-backup/utils"
+)
+
+// stallGrace is how long a canceled job may take to return before its worker is replaced
+const stallGrace = 5 * time.Second
+
+// States of a worker slot
+const (
+	slotIdle     int32 = iota // Waiting for a job
+	slotBusy                  // Processing a job
+	slotDetached              // Given up on by the watchdog, a replacement took over
+	slotExited                // The worker returned
+)
+
+// workerSlot tracks one worker goroutine and the job it is processing
+type workerSlot struct {
+	id    int
+	state atomic.Int32 // One of the slot* constants
+
+	mu       sync.Mutex
+	job      BackupJob          // Job being processed, while busy
+	started  time.Time          // When the job started
+	deadline time.Time          // When the job times out, zero while it has none
+	cancel   context.CancelFunc // Cancels the job
+}
+
+// workerSlots is the set of running workers
+type workerSlots struct {
+	mu    sync.Mutex
+	slots map[*workerSlot]struct{}
+}
+
+func (ws *workerSlots) add(slot *workerSlot) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.slots == nil {
+		ws.slots = make(map[*workerSlot]struct{})
+	}
+	ws.slots[slot] = struct{}{}
+}
+
+func (ws *workerSlots) remove(slot *workerSlot) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	delete(ws.slots, slot)
+}
+
+// busy returns the slots processing a job
+func (ws *workerSlots) busy() []*workerSlot {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var busy []*workerSlot
+	for slot := range ws.slots {
+		if slot.state.Load() == slotBusy {
+			busy = append(busy, slot)
+		}
+	}
+	return busy
+}
+
+// beginJob marks the slot busy with a job
+func (fw *FileWatcher) beginJob(slot *workerSlot, job BackupJob) {
+	slot.mu.Lock()
+	slot.job = job
+	slot.started = time.Now()
+	slot.deadline = time.Time{}
+	slot.cancel = nil
+	slot.mu.Unlock()
+
+	fw.inFlight.Add(1)
+	slot.state.Store(slotBusy)
+}
+
+// jobContext starts the deadline of the job in the slot and returns its context,
+// which is also canceled when the shutdown gives up on jobs in progress
+func (fw *FileWatcher) jobContext(slot *workerSlot) (context.Context, context.CancelFunc) {
+	if fw.config.JobTimeout <= 0 {
+		return context.WithCancel(fw.jobsCtx)
+	}
+
+	ctx, cancel := context.WithTimeout(fw.jobsCtx, fw.config.JobTimeout)
+
+	slot.mu.Lock()
+	slot.deadline = time.Now().Add(fw.config.JobTimeout)
+	slot.cancel = cancel
+	slot.mu.Unlock()
+
+	return ctx, cancel
+}
+
+// finishJob marks the slot idle again and records the outcome of its job.
+// It returns false when the watchdog replaced the worker in the meantime,
+// the outcome was already recorded then.
+func (fw *FileWatcher) finishJob(slot *workerSlot, outcome, backupPath, reason string, err error) bool {
+	slot.mu.Lock()
+	job, elapsed := slot.job, time.Since(slot.started)
+	slot.mu.Unlock()
+
+	if !slot.state.CompareAndSwap(slotBusy, slotIdle) {
+		fw.logger.Warning("Worker #%d returned from %s after it was replaced, exiting",
+			slot.id, filepath.Base(job.FilePath))
+		return false
+	}
+
+	fw.inFlight.Add(-1)
+	fw.jobsDone.Add(1)
+	fw.jobsTime.Add(int64(elapsed))
+	if outcome == audit.OutcomeBackedUp {
+		fw.status.took(elapsed)
+	}
+
+	fw.record(job, outcome, backupPath, reason, err)
+	return true
+}
+
+// releaseSlot removes the slot of an exiting worker from the pool. It returns
+// false when the watchdog already did so, because the worker was replaced.
+func (fw *FileWatcher) releaseSlot(slot *workerSlot) bool {
+	fw.slots.remove(slot)
+
+	if slot.state.CompareAndSwap(slotBusy, slotExited) {
+		// Panicked in the middle of a job
+		fw.inFlight.Add(-1)
+	} else if !slot.state.CompareAndSwap(slotIdle, slotExited) {
+		return false
+	}
+
+	fw.liveWorkers.Add(-1)
+	return true
+}
+
+// watchdog replaces workers that do not return from a job after its deadline,
+// until the watcher is stopped
+func (fw *FileWatcher) watchdog() {
+	ticker := time.NewTicker(min(time.Second, fw.config.JobTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fw.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		for _, slot := range fw.slots.busy() {
+			slot.mu.Lock()
+			deadline := slot.deadline
+			slot.mu.Unlock()
+
+			if !deadline.IsZero() && time.Now().After(deadline.Add(stallGrace)) {
+				fw.replaceWorker(slot)
+			}
+		}
+	}
+}
+
+// replaceWorker gives up on a worker stuck in a job, records the job as timed out
+// and starts a replacement. The stuck goroutine exits if it ever returns.
+func (fw *FileWatcher) replaceWorker(slot *workerSlot) {
+	if !slot.state.CompareAndSwap(slotBusy, slotDetached) {
+		// Returned just now
+		return
+	}
+
+	slot.mu.Lock()
+	job, elapsed := slot.job, time.Since(slot.started)
+	slot.cancel()
+	slot.mu.Unlock()
+
+	fw.inFlight.Add(-1)
+	fw.jobsDone.Add(1)
+	fw.jobsTime.Add(int64(elapsed))
+	fw.timedOut.Add(1)
+	stalled := fw.stalledWorkers.Add(1)
+
+	err := utils.NewBackupError(job.FilePath, utils.OpRead, fmt.Errorf("worker stalled for %s: %w",
+		elapsed.Round(time.Second), context.DeadlineExceeded))
+	fw.logger.Error("Worker #%d stalled on %s for %s, replacing it (%d stalled workers so far)",
+		slot.id, filepath.Base(job.FilePath), elapsed.Round(time.Second), stalled)
+	fw.record(job, audit.OutcomeFailed, "", err.Error(), err)
+
+	fw.publish(notify.Event{
+		Type:    notify.EventWorkerStalled,
+		Time:    time.Now(),
+		Path:    job.FilePath,
+		Message: fmt.Sprintf("worker #%d stalled on %s and was replaced", slot.id, job.FilePath),
+		Error:   utils.ErrorLabel(err),
+	})
+
+	// Add before Done, so Stop never sees the pool as empty in between
+	fw.liveWorkers.Add(-1)
+	fw.workerWg.Add(1)
+	go fw.backupWorker(slot.id)
+	fw.workerWg.Done()
+}
@@ -11,82 +11,344 @@ package watcher
 // that backups are not created too frequently for the same file.
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cpprian/file-watcher-backup/audit"
 	"github.com/cpprian/file-watcher-backup/config"
+	"github.com/cpprian/file-watcher-backup/notify"
 	"github.com/cpprian/file-watcher-backup/utils"
 	"github.com/fsnotify/fsnotify"
 )
 
+// Size of the backup queue and number of workers of a FileWatcher
+const (
+	queueCapacity = 100
+	workerCount   = 3
+)
+
 // BackupJob represents a job to back up a specific file
 type BackupJob struct {
+	ID        uint64    // ID in the queue journal, 0 when the job is not journaled
 	FilePath  string    // Absolute path to the file
 	EventType string    // Type of event (e.g., "CREATE", "MODIFY")
 	Timestamp time.Time // Time when the event was detected
+	Size      int64     // Size of the file when the event was detected
+	Deferrals int       // Times the job was deferred because the file was locked
 }
 
 // FileWatcher monitors file system events and manages backup jobs
 type FileWatcher struct {
-	config        *config.Config       // Configuration settings
-	BackupManager *BackupManager       // Manages backup operations
-	watcher       *fsnotify.Watcher    // fsnotify watcher instance
-	lastBackup    map[string]time.Time // Tracks last backup times for files
-	mu            sync.Mutex           // Mutex for synchronizing access to lastBackup
-	backupQueue   chan BackupJob       // Channel for backup jobs
-	workerWg      sync.WaitGroup       // WaitGroup for worker goroutines
-	stopChan      chan struct{}        // Channel to signal stopping the watcher
-	numWorkers    int                  // Number of worker goroutines
-	logger        *utils.Logger        // Logger for logging events and errors
-}
-
-// NewFileWatcher creates a new FileWatcher instance with the provided configuration
-func NewFileWatcher(cfg *config.Config) (*FileWatcher, error) {
+	config         *config.Config       // Configuration settings
+	BackupManager  *BackupManager       // Manages backup operations
+	watcher        *fsnotify.Watcher    // fsnotify watcher instance
+	lastBackup     map[string]time.Time // Tracks last backup times for files
+	mu             sync.Mutex           // Mutex for synchronizing access to lastBackup
+	backupQueue    chan BackupJob       // Channel for backup jobs
+	jobs           chan BackupJob       // Jobs handed to the workers, the backup queue itself unless large files are scheduled
+	scheduled      atomic.Int64         // Jobs taken from the backup queue but not handed to a worker yet
+	workerWg       sync.WaitGroup       // WaitGroup for worker goroutines
+	stopChan       chan struct{}        // Channel to signal stopping the watcher
+	quit           chan struct{}        // Closed to make workers exit without draining the queue
+	jobsCtx        context.Context      // Parent of every job context, canceled to abort jobs in progress
+	cancelJobs     context.CancelFunc   // Cancels jobsCtx
+	loopDone       chan struct{}        // Closed when watchLoop has returned
+	startMu        sync.Mutex           // Held while Start sets up, Stop waits for it
+	running        atomic.Bool          // Set once Start has registered watches and started workers
+	ready          chan struct{}        // Closed once running is set, see Ready
+	stopping       atomic.Bool          // Set as soon as Stop is called
+	abandoned      atomic.Bool          // Set when queued jobs are no longer going to be processed
+	numWorkers     int                  // Number of worker goroutines
+	liveWorkers    atomic.Int64         // Number of worker goroutines currently running
+	restarts       atomic.Int64         // Number of workers restarted after a panic
+	lostWorkers    atomic.Int64         // Number of workers that died and were not replaced
+	stalledWorkers atomic.Int64         // Number of workers replaced by the watchdog
+	timedOut       atomic.Int64         // Number of jobs that ran past config.JobTimeout
+	slots          workerSlots          // Running workers, checked by the watchdog
+	inFlight       atomic.Int64         // Number of jobs currently being processed by workers
+	jobsDone       atomic.Int64         // Number of jobs processed so far
+	jobsTime       atomic.Int64         // Total time spent processing jobs, in nanoseconds
+	watchErr       error                // First error reported by fsnotify, guarded by mu
+	logger         *utils.Logger        // Logger for logging events and errors
+	audit          *audit.Log           // Audit log of event outcomes, nil when disabled
+	hooks          hooks                // Registered callbacks, see OnEvent
+	owners         ownerFilter          // Only files owned by this user and group are backed up
+	policy         *backupPolicy        // Decides which changed files are backed up, nil backs up all
+	sinks          []notify.EventSink   // Destinations of backup outcomes and errors, see AddSink
+	sinksMu        sync.RWMutex         // Held while publishing to the sinks, and to close them
+	sinksClosed    bool                 // Set once the sinks are closed, see closeWatcherSinks
+	disk           diskGuard            // Free space state of the backup file system
+	sendersCtx     context.Context      // Canceled to stop the goroutines queueing jobs besides watchLoop
+	stopSenders    context.CancelFunc   // Cancels sendersCtx
+	senders        sync.WaitGroup       // Goroutines queueing jobs besides watchLoop
+	poll           poller               // Directories polled because they could not be watched
+	sweepMissed    atomic.Int64         // Number of changes found by sweeps that fsnotify missed
+	digest         digestCounter        // Outcomes since the last digest, see TakeDigest
+	report         digestCounter        // Outcomes since the watcher was created, see RunReport
+	dateLinks      dateLinks            // Pruning state of config.DateLinks
+	events         eventCounter         // File events by type, seen and queued
+	journal        *queueJournal        // Journal of queued jobs, nil when it could not be opened
+	excluded       string               // Absolute path of the backup directory when it is inside the source
+	follow         []string             // Real paths of the directories links are followed into, see followLink
+	filters        filterSet            // Compiled ignore and include patterns, see Reload
+	shadow         shadowState          // Ignore and include patterns tried, see TryRules
+	rootGone       atomic.Bool          // Set while the source directory is removed
+	rootRemoved    chan struct{}        // Signals rootLoop that the source directory was removed
+	deadLetters    deadLetters          // Files whose backup failed after all retries
+	pause          pauseState           // Changes collected while backups are paused
+	deferred       deferredFiles        // Files whose backup waits for a lock to be released
+	moves          movedFiles           // Files renamed recently, see followMove
+	status         statusCounter        // Outcomes since the start, see Status
+	unclaim        func()               // Releases the marker of the source directory, nil when not held
+	perms          permState            // Last seen permissions of the files, see auditPermissions
+	history        *statsHistory        // Recent snapshots of the stats, nil when none are kept
+	rewatch        rewatchQueue         // Directories whose watch failed and is tried again
+	started        time.Time            // When the watcher was created
+}
+
+// NewFileWatcher creates a new FileWatcher instance with the provided configuration,
+// all output goes through the given logger
+func NewFileWatcher(cfg *config.Config, logger *utils.Logger) (*FileWatcher, error) {
+	owners, err := newOwnerFilter(cfg.Owner, cfg.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := newBackupPolicy(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexKey []byte
+	if cfg.IndexKeyFile != "" {
+		if indexKey, err = LoadIndexKey(cfg.IndexKeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		if auditLog, err = audit.Open(cfg.AuditLog); err != nil {
+			return nil, err
+		}
+	}
+
+	backupManager := NewBackupManager(cfg.BackupDir, cfg.MaxVersions, logger)
+	if err := backupManager.useLayout(cfg.Layout); err != nil {
+		auditLog.Close()
+		return nil, err
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
+		auditLog.Close()
 		return nil, fmt.Errorf("error creating watcher: %w", err)
 	}
 
-	return &FileWatcher{
+	sinks, err := newSinks(cfg, logger)
+	if err != nil {
+		watcher.Close()
+		auditLog.Close()
+		return nil, err
+	}
+
+	backupManager.throttle = utils.NewThrottle(cfg.MaxOpsPerSec, cfg.MaxBytesPerSec)
+	backupManager.readLimit = cfg.MaxReadPerSec
+	backupManager.dropCache = cfg.DropCache
+	backupManager.reflink = cfg.Reflink
+	backupManager.copyStreams = cfg.CopyStreams
+	if cfg.Dedup {
+		backupManager.contents = newContentIndex()
+	}
+	backupManager.linkUnchanged = cfg.LinkUnchanged
+	backupManager.verifyCopies = cfg.VerifyCopies
+	backupManager.naming = cfg.VersionNaming
+	backupManager.maxTotalSize = cfg.MaxBackupSize
+	if cfg.VersionIDs == config.IDUUID {
+		backupManager.newID = utils.NewUUID
+	}
+	backupManager.indexKey = indexKey
+
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	sendersCtx, stopSenders := context.WithCancel(context.Background())
+
+	backupQueue := make(chan BackupJob, queueCapacity)
+	jobs := backupQueue
+	if cfg.LargeFileSize > 0 {
+		jobs = make(chan BackupJob)
+	}
+
+	fw := &FileWatcher{
 		config:        cfg,
-		BackupManager: NewBackupManager(cfg.BackupDir, cfg.MaxVersions),
+		BackupManager: backupManager,
 		watcher:       watcher,
-		lastBackup:    make(map[string]time.Time),
-		backupQueue:   make(chan BackupJob, 100),
+		backupQueue:   backupQueue,
+		jobs:          jobs,
 		stopChan:      make(chan struct{}),
-		numWorkers:    3,
-		logger:        utils.NewLogger(true, true),
-	}, nil
+		quit:          make(chan struct{}),
+		jobsCtx:       jobsCtx,
+		cancelJobs:    cancelJobs,
+		sendersCtx:    sendersCtx,
+		stopSenders:   stopSenders,
+		loopDone:      make(chan struct{}),
+		ready:         make(chan struct{}),
+		numWorkers:    workerCount,
+		logger:        logger,
+		audit:         auditLog,
+		owners:        owners,
+		policy:        policy,
+		digest:        digestCounter{since: time.Now()},
+		report:        digestCounter{since: time.Now()},
+		sinks:         sinks,
+		rootRemoved:   make(chan struct{}, 1),
+		started:       time.Now(),
+	}
+	fw.lastBackup = fw.loadState()
+	fw.filters.Store(compileFilters(cfg, nil))
+	fw.follow = resolveFollowed(cfg.FollowLinks)
+	if cfg.StatsHistory > 0 {
+		fw.history = newStatsHistory(cfg.StatsHistory)
+	}
+
+	if dir, inside := cfg.BackupInsideSource(); inside {
+		logger.Warning("The backup directory %s is inside the source directory, it is neither watched nor backed up", cfg.BackupDir)
+		fw.excluded = dir
+	}
+
+	return fw, nil
+}
+
+// Start begins watching the configured directory for file changes.
+// It blocks until ctx is cancelled or Stop is called, Stop must be called
+// in both cases to release resources and finish the queued backup jobs.
+func (fw *FileWatcher) Start(ctx context.Context) error {
+	started, err := fw.start()
+	if err != nil || !started {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-fw.stopChan:
+	case <-fw.loopDone:
+		// The event channels were closed by fsnotify, not by Stop
+		if !fw.stopping.Load() {
+			return fmt.Errorf("file system watcher closed unexpectedly")
+		}
+	}
+	return nil
 }
 
-// Start begins watching the configured directory for file changes
-func (fw *FileWatcher) Start() error {
+// start registers the watches and starts the workers and the goroutines
+// queueing jobs. It holds startMu, so a Stop called meanwhile waits for it,
+// and starts nothing once Stop was called. It reports whether it started.
+func (fw *FileWatcher) start() (bool, error) {
+	fw.startMu.Lock()
+	defer fw.startMu.Unlock()
+
+	if fw.stopping.Load() {
+		return false, nil
+	}
+
+	if err := fw.checkSiblings(); err != nil {
+		return false, err
+	}
+
 	if err := fw.addDirectoryRecursive(fw.config.SourceDir); err != nil {
-		return fmt.Errorf("error adding directory: %w", err)
+		if fw.stopping.Load() {
+			// Stop closed the watcher while the directories were added
+			return false, nil
+		}
+		return false, fmt.Errorf("error adding directory: %w", err)
+	}
+
+	journal, leftover, err := openJournal(fw.config.BackupDir, fw.config.SourceDir)
+	if err != nil {
+		fw.logger.Warning("Could not open queue journal, queued jobs are lost on a crash: %v", err)
+	}
+	fw.journal = journal
+
+	repo, err := fw.BackupManager.Stats()
+	if err != nil && !os.IsNotExist(err) {
+		fw.logger.Warning("Could not read the backup repository: %v", err)
 	}
 
-	fw.logger.Headder(
-		fw.config.SourceDir,
-		fw.config.BackupDir,
-		fw.config.MaxVersions,
-		fw.numWorkers,
-	)
+	fw.logger.Header(utils.HeaderInfo{
+		Source:         fw.config.SourceDir,
+		Backup:         fw.config.BackupDir,
+		Versions:       fw.config.MaxVersions,
+		Workers:        fw.numWorkers,
+		Files:          repo.Files,
+		StoredVersions: repo.Versions,
+		RepositorySize: repo.Size,
+		LastBackup:     repo.LastBackup,
+	})
+
+	// Stopped during the setup, the queue is closed or about to be
+	if fw.stopping.Load() {
+		return false, nil
+	}
 
 	fw.startWorkerPool()
+	if fw.config.JobTimeout > 0 {
+		go fw.watchdog()
+	}
 
 	go fw.watchLoop()
+	if fw.history != nil {
+		go fw.sampleStats()
+	}
 
-	<-fw.stopChan
-	return nil
+	sendersCtx := fw.sendersCtx
+	fw.senders.Add(1)
+	go fw.pollLoop(sendersCtx)
+	if len(leftover) > 0 {
+		fw.senders.Add(1)
+		go fw.replayJournal(sendersCtx, leftover)
+	}
+	fw.senders.Add(1)
+	go fw.rootLoop(sendersCtx)
+	fw.senders.Add(1)
+	go fw.rewatchLoop(sendersCtx)
+	if fw.config.SweepInterval > 0 {
+		fw.senders.Add(1)
+		go fw.sweepLoop(sendersCtx)
+	}
+	if fw.config.AuditPerms {
+		fw.senders.Add(1)
+		go fw.scanPermissions(sendersCtx)
+	}
+	if fw.config.VerifyAt != "" {
+		// Not a sender, but it must be done before the sinks are closed
+		fw.senders.Add(1)
+		go fw.verifyLoop(sendersCtx)
+	}
+	fw.running.Store(true)
+	close(fw.ready)
+	return true, nil
+}
+
+// Ready returns a channel closed once Start has registered the watches and
+// started the workers, so changes from then on are backed up
+func (fw *FileWatcher) Ready() <-chan struct{} {
+	return fw.ready
 }
 
 // startWorkerPool initializes the pool of worker goroutines
 func (fw *FileWatcher) startWorkerPool() {
+	if fw.jobs != fw.backupQueue {
+		go fw.schedule()
+	}
+
 	for i := range fw.numWorkers {
 		fw.workerWg.Add(1)
 		go fw.backupWorker(i + 1)
@@ -95,52 +357,315 @@ func (fw *FileWatcher) startWorkerPool() {
 
 // backupWorker processes backup jobs from the queue
 func (fw *FileWatcher) backupWorker(id int) {
-	defer fw.workerWg.Done()
-	defer utils.HandlePanic(fw.logger, fmt.Sprintf("Worker #%d", id))
+	slot := &workerSlot{id: id}
+	fw.slots.add(slot)
+	fw.liveWorkers.Add(1)
+	defer fw.recoverWorker(slot)
+
+	for job := range fw.jobs {
+		select {
+		case <-fw.quit:
+			return
+		default:
+		}
+
+		fw.waitResumed()
+
+		// Dropped from the queue while it waited, see DropJob
+		if fw.journal.dropped(job.ID) {
+			fw.logger.Info("Skipping %s, it was dropped from the queue", filepath.Base(job.FilePath))
+			continue
+		}
+
+		if !fw.processJob(slot, job) {
+			return
+		}
+	}
+}
+
+// processJob runs a single backup job and records its outcome. It returns
+// false when the watchdog replaced the worker while the job was running.
+func (fw *FileWatcher) processJob(slot *workerSlot, job BackupJob) bool {
+	id := slot.id
+	fw.beginJob(slot, job)
+
+	fw.logger.WorkerStarted(id, filepath.Base(job.FilePath))
+
+	// Waiting for free space does not count against the job timeout
+	if err := fw.ensureSpace(job); err != nil {
+		fw.logger.Error("Worker #%d: %v", id, err)
+		return fw.finishJob(slot, audit.OutcomeFailed, "", err.Error(), err)
+	}
 
-	for job := range fw.backupQueue {
-		fw.logger.WorkerStarted(id, filepath.Base(job.FilePath))
+	fw.waitSourceBack()
 
-		if err := fw.BackupManager.CreateBackup(job.FilePath, fw.config.SourceDir); err != nil {
-			fw.logger.Error("Worker #%d: %v", id, err)
+	if fw.config.LockRetries > 0 {
+		if reason := utils.LockReason(job.FilePath); reason != "" {
+			return fw.deferLocked(slot, job, reason)
 		}
 	}
+
+	ctx, cancel := fw.jobContext(slot)
+	defer cancel()
+
+	if fw.config.PreBackupCmd != "" {
+		if err := runCommand(ctx, fw.config.PreBackupCmd, fw.config.HookTimeout, job, "", fw.config.MaxBytesPerSec); err != nil {
+			err = fmt.Errorf("pre-backup command failed: %w", err)
+			fw.logger.Error("Worker #%d: %s: %v", id, filepath.Base(job.FilePath), err)
+			return fw.finishJob(slot, audit.OutcomeFailed, "", err.Error(), err)
+		}
+	}
+
+	backupPath, err := fw.createBackup(ctx, id, job)
+	if err == nil {
+		fw.addToDateLinks(ctx, backupPath, fw.relPath(job.FilePath))
+	}
+	if err == nil && fw.config.PostBackupCmd != "" {
+		// The backup itself succeeded, a failing command is only reported
+		if err := runCommand(ctx, fw.config.PostBackupCmd, fw.config.HookTimeout, job, backupPath, fw.config.MaxBytesPerSec); err != nil {
+			fw.logger.Warning("Post-backup command failed for %s: %v", filepath.Base(job.FilePath), err)
+		}
+	}
+
+	switch {
+	case err == nil:
+		return fw.finishJob(slot, audit.OutcomeBackedUp, backupPath, "", nil)
+
+	case errors.Is(err, utils.ErrSourceVanished):
+		// Temporary files often disappear before a worker gets to them, that is expected
+		fw.logger.BackupSkipped(filepath.Base(job.FilePath), "file vanished before backup")
+		return fw.finishJob(slot, audit.OutcomeSkippedVanished, "", "file vanished before backup", err)
+
+	case errors.Is(err, utils.ErrSourceChanged):
+		return fw.deferChanged(slot, job, err)
+
+	case errors.Is(err, utils.ErrTooFewCopies):
+		return fw.deferCopies(slot, job, err)
+
+	case errors.Is(err, utils.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		fw.timedOut.Add(1)
+		fw.logger.Error("Worker #%d: %s timed out after %s", id, filepath.Base(job.FilePath), fw.config.JobTimeout)
+		return fw.finishJob(slot, audit.OutcomeFailed, "", err.Error(), err)
+
+	default:
+		fw.logger.Error("Worker #%d: %v", id, err)
+		return fw.finishJob(slot, audit.OutcomeFailed, "", err.Error(), err)
+	}
+}
+
+// createBackup backs up the file of a job, retrying it as configured while the
+// error is retryable. The job timeout covers all attempts. New versions are
+// copied to the mirrors before they count, see replicate.
+func (fw *FileWatcher) createBackup(ctx context.Context, id int, job BackupJob) (string, error) {
+	replicate := func(src, backupPath string) error {
+		return fw.replicate(ctx, id, src, backupPath)
+	}
+	if fw.config.JobRetries <= 0 {
+		return fw.BackupManager.createBackup(ctx, job.FilePath, fw.config.SourceDir, replicate)
+	}
+
+	var backupPath string
+	var tooFewCopies error
+	attempt := 0
+	err := utils.RetryWithBackoff(ctx, fw.config.JobRetries+1, fw.config.JobRetryDelay, func() error {
+		attempt++
+		if attempt > 1 {
+			fw.logger.Warning("Worker #%d: retrying %s (retry %d of %d)",
+				id, filepath.Base(job.FilePath), attempt-1, fw.config.JobRetries)
+		}
+
+		var err error
+		backupPath, err = fw.BackupManager.createBackup(ctx, job.FilePath, fw.config.SourceDir, replicate)
+		if errors.Is(err, utils.ErrTooFewCopies) {
+			// The mirrors were retried already, the job is deferred instead
+			tooFewCopies = err
+			return nil
+		}
+		return err
+	})
+	if tooFewCopies != nil {
+		return "", tooFewCopies
+	}
+	return backupPath, err
+}
+
+// record writes the outcome of a job to the audit log
+func (fw *FileWatcher) record(job BackupJob, outcome, backupPath, reason string, err error) {
+	entry := audit.Entry{
+		Time:    time.Now(),
+		Event:   job.EventType,
+		Path:    job.FilePath,
+		Outcome: outcome,
+		Backup:  backupPath,
+		Reason:  reason,
+	}
+	if err != nil {
+		entry.Error = utils.ErrorLabel(err)
+	}
+
+	if err := fw.audit.Record(entry); err != nil {
+		fw.logger.Warning("Could not write audit log: %v", err)
+	}
+
+	fw.deadLetters.update(job, entry)
+
+	if err := fw.journal.done(job.ID); err != nil {
+		fw.logger.Warning("Could not write queue journal: %v", err)
+	}
+
+	if fw.config.DigestAt != "" {
+		fw.digest.add(entry)
+	}
+	fw.report.add(entry)
+	fw.status.add(entry, fw.relPath(job.FilePath))
+	fw.emitOutcome(job, entry, err)
+	fw.publishOutcome(entry, err)
+}
+
+// FlushAudit makes sure all audit log entries recorded so far are on disk
+func (fw *FileWatcher) FlushAudit() error {
+	return fw.audit.Flush()
+}
+
+// recoverWorker releases the slot of an exiting worker and handles a panic
+// according to the configured panic policy. It must be deferred directly by backupWorker.
+func (fw *FileWatcher) recoverWorker(slot *workerSlot) {
+	id := slot.id
+	r := recover()
+
+	// A worker replaced by the watchdog no longer counts as part of the pool
+	if fw.releaseSlot(slot) {
+		defer fw.workerWg.Done()
+	} else if r != nil && fw.config.PanicPolicy != config.PanicCrash {
+		fw.logger.Error("PANIC in Worker #%d after it was replaced: %v", id, r)
+		return
+	}
+
+	if r == nil {
+		return
+	}
+
+	switch fw.config.PanicPolicy {
+	case config.PanicCrash:
+		fw.logger.Error("PANIC in Worker #%d: %v (panic policy: crash)", id, r)
+		panic(r)
+
+	case config.PanicStop:
+		lost := fw.lostWorkers.Add(1)
+		fw.logger.Error("PANIC in Worker #%d: %v, worker lost (%d of %d workers lost)", id, r, lost, fw.numWorkers)
+
+	default:
+		restarts := fw.restarts.Add(1)
+		fw.logger.Error("PANIC in Worker #%d: %v, restarting worker (restart #%d)", id, r, restarts)
+
+		// Add before the deferred Done of the dying worker runs,
+		// so Stop never sees the pool as empty in between.
+		fw.workerWg.Add(1)
+		go fw.backupWorker(id)
+	}
 }
 
 // watchLoop continuously listens for file system events and errors
 func (fw *FileWatcher) watchLoop() {
+	defer close(fw.loopDone)
+
 	for {
 		select {
 		case event, ok := <-fw.watcher.Events:
 			if !ok {
+				fw.watchClosed()
 				return
 			}
 			fw.handleEvent(event)
 
 		case err, ok := <-fw.watcher.Errors:
 			if !ok {
+				fw.watchClosed()
 				return
 			}
 
-			log.Printf("❌ Error from watcher: %v\n", err)
+			fw.logger.Error("Error from watcher: %v", err)
+			fw.publishWatchError(err)
+
+			fw.mu.Lock()
+			if fw.watchErr == nil {
+				fw.watchErr = err
+			}
+			fw.mu.Unlock()
 		}
 	}
 }
 
+// watchClosed reports the event channels being closed by fsnotify instead of Stop
+func (fw *FileWatcher) watchClosed() {
+	if !fw.stopping.Load() {
+		fw.publishWatchClosed()
+	}
+}
+
 // hanldeEvent processes a single fsnotify event
 func (fw *FileWatcher) handleEvent(event fsnotify.Event) {
 	var eventType string
 
+	if fw.isExcluded(event.Name) {
+		return
+	}
+
+	fw.events.observe(eventTypeOf(event.Op))
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && fw.isSourceRoot(event.Name) {
+		fw.sourceRemoved()
+		return
+	}
+
+	// Checked once per event, every check counts as a hit of the matching pattern
+	ignored := fw.shouldIgnore(event.Name)
+	if !ignored {
+		fileEvent := FileEvent{
+			Path: event.Name,
+			Type: eventTypeOf(event.Op),
+			Time: time.Now(),
+		}
+		fw.emitEvent(fileEvent)
+		fw.publishChange(fileEvent)
+	}
+
+	// Stat once per event, the result is needed by several checks below
+	var info os.FileInfo
+	if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) != 0 {
+		info, _ = os.Lstat(event.Name)
+	}
+	directory := info != nil && info.IsDir()
+	if info != nil && info.Mode()&os.ModeSymlink != 0 {
+		// A link to a directory links are followed into is watched like a new directory
+		_, directory = fw.followLink(event.Name)
+	}
+
+	if fw.config.AuditPerms && !ignored {
+		switch {
+		case event.Op&(fsnotify.Create|fsnotify.Write) != 0 && info != nil && info.Mode().IsRegular():
+			fw.perms.see(event.Name, info)
+		case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			fw.perms.forget(event.Name)
+		}
+	}
+
 	switch {
 	case event.Op&fsnotify.Create == fsnotify.Create:
 		eventType = "CREATE"
 
-		if isDir(event.Name) {
-			fw.watcher.Add(event.Name)
+		if directory {
 			fw.logger.Info("New catalog: %s", filepath.Base(event.Name))
 		}
 		fw.logger.FileCreated(filepath.Base(event.Name))
 
+		if directory && !ignored {
+			fw.addNewDirectory(event.Name)
+		}
+		if !ignored && info != nil && info.Mode().IsRegular() && fw.followMove(event.Name, info) {
+			return
+		}
+
 	case event.Op&fsnotify.Write == fsnotify.Write:
 		eventType = "WRITE"
 		fw.logger.FileModified(filepath.Base(event.Name))
@@ -155,93 +680,272 @@ func (fw *FileWatcher) handleEvent(event fsnotify.Event) {
 	case event.Op&fsnotify.Rename == fsnotify.Rename:
 		eventType = "RENAME"
 		fw.logger.FileRenamed(filepath.Base(event.Name))
+		if !ignored {
+			fw.moves.add(event.Name)
+		}
 		return
 
 	case event.Op&fsnotify.Chmod == fsnotify.Chmod:
-		// TODO: Handle chmod if necessary
-		return
+		if fw.config.AuditPerms && !ignored && info != nil && info.Mode().IsRegular() {
+			fw.auditPermissions(event.Name, info)
+		}
+
+		// Only backed up for permission auditing, the manifest records the ACL
+		eventType = "CHMOD"
+		if !fw.backsUpOn(eventType) {
+			return
+		}
+		fw.logger.Info("Permissions changed: %s", filepath.Base(event.Name))
 
 	default:
 		return
 	}
 
-	if isDir(event.Name) {
+	if directory {
 		return
 	}
-
-	if fw.shouldIgnore(event.Name) {
+	if ignored {
+		fw.checkShadow(event.Name, false)
 		return
 	}
 
-	fw.enqueueBackup(event.Name, eventType)
+	fw.queueFile(event.Name, eventType, info)
 }
 
-// enqueueBackup adds a backup job to the queue if conditions are met
-func (fw *FileWatcher) enqueueBackup(path string, eventType string) {
-	fw.mu.Lock()
-	defer fw.mu.Unlock()
+// queueFile queues the backup of a changed file unless it is filtered out.
+// info may be nil when the file could not be stat'ed.
+func (fw *FileWatcher) queueFile(path, eventType string, info os.FileInfo) {
+	if !fw.backsUpOn(eventType) {
+		return
+	}
 
-	lastTime, exists := fw.lastBackup[path]
-	if exists && time.Since(lastTime) < fw.config.MinInterval {
-		fw.logger.BackupSkipped(filepath.Base(path), "too soon since last backup")
+	passed := !fw.shouldIgnore(path) && fw.isIncluded(path)
+	fw.checkShadow(path, passed)
+	if !passed {
+		return
+	}
+
+	if !fw.owners.matches(info) {
+		return
+	}
+
+	// Creating a file often changes its permissions as well, both wait for the first write
+	if eventType != "WRITE" && fw.config.EmptyCreate == config.EmptySkip && info != nil && info.Size() == 0 {
+		fw.logger.BackupSkipped(filepath.Base(path), "created empty, backed up once written")
 		return
 	}
 
+	if fw.skipTooLarge(path, eventType, info) || fw.skipByPolicy(path, eventType, info) {
+		return
+	}
+
+	var size int64
+	if info != nil {
+		size = info.Size()
+	}
+	fw.enqueueBackup(path, eventType, size)
+}
+
+// backsUpOn reports whether events of eventType trigger backups
+func (fw *FileWatcher) backsUpOn(eventType string) bool {
+	return slices.Contains(fw.config.BackupOn, eventType)
+}
+
+// skipTooLarge records files above the size limit as skipped and reports whether it did.
+// A huge file would keep a worker busy for minutes.
+func (fw *FileWatcher) skipTooLarge(path, eventType string, info os.FileInfo) bool {
+	if fw.config.MaxFileSize <= 0 || info == nil || info.Size() <= fw.config.MaxFileSize {
+		return false
+	}
+
+	reason := fmt.Sprintf("%s is larger than the maximum of %s",
+		utils.FormatSize(info.Size()), utils.FormatSize(fw.config.MaxFileSize))
+	fw.logger.BackupSkipped(filepath.Base(path), reason)
+	fw.record(BackupJob{FilePath: path, EventType: eventType, Timestamp: time.Now()},
+		audit.OutcomeSkippedTooLarge, "", reason, nil)
+	return true
+}
+
+// skipByPolicy records files the backup policy excludes as skipped and reports whether it did
+func (fw *FileWatcher) skipByPolicy(path, eventType string, info os.FileInfo) bool {
+	if fw.policyAllows(path, eventType, info) {
+		return false
+	}
+
+	reason := "excluded by the backup policy"
+	fw.logger.BackupSkipped(filepath.Base(path), reason)
+	fw.record(BackupJob{FilePath: path, EventType: eventType, Timestamp: time.Now()},
+		audit.OutcomeSkippedPolicy, "", reason, nil)
+	return true
+}
+
+// enqueueBackup adds a backup job to the queue if conditions are met
+func (fw *FileWatcher) enqueueBackup(path string, eventType string, size int64) {
 	job := BackupJob{
 		FilePath:  path,
 		EventType: eventType,
 		Timestamp: time.Now(),
+		Size:      size,
+	}
+
+	// Recorded once fw.mu is released, hooks may call GetStats
+	if outcome, reason := fw.queueJob(&job); outcome != "" {
+		fw.record(job, outcome, "", reason, nil)
+	}
+}
+
+// queueJob queues job under fw.mu, it returns the outcome to record when
+// the job was not queued. The job gets its journal ID.
+func (fw *FileWatcher) queueJob(job *BackupJob) (outcome, reason string) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.hold(*job) {
+		fw.events.queue(job.EventType)
+		return "", ""
+	}
+
+	lastTime, exists := fw.lastBackup[job.FilePath]
+	if exists && time.Since(lastTime) < fw.config.MinInterval {
+		fw.logger.BackupSkipped(filepath.Base(job.FilePath), "too soon since last backup")
+		return audit.OutcomeSkippedInterval, "too soon since last backup"
+	}
+
+	if err := fw.journal.add(job); err != nil {
+		fw.logger.Warning("Could not write queue journal: %v", err)
 	}
 
 	select {
-	case fw.backupQueue <- job:
-		fw.lastBackup[path] = time.Now()
-		fw.logger.Info("Add to backup queue: %s [%s]", filepath.Base(path), eventType)
+	case fw.backupQueue <- *job:
+		fw.lastBackup[job.FilePath] = time.Now()
+		fw.events.queue(job.EventType)
+		fw.logger.Debug("Add to backup queue: %s [%s]", filepath.Base(job.FilePath), job.EventType)
+		return "", ""
 
 	default:
-		fw.logger.Warning("Queue full, skipping backup for: %s", filepath.Base(path))
+		fw.logger.Warning("Queue full, skipping backup for: %s", filepath.Base(job.FilePath))
+		return audit.OutcomeDropped, "queue full"
 	}
 }
 
-// addDirectoryRecursive adds a directory and its subdirectories to the watcher
+// addDirectoryRecursive adds a directory and its subdirectories to the watcher.
+// WalkDir uses the file types from the directory listing, so files are never
+// stat'ed, which keeps startup fast for directories with very many files.
+// Only failing to watch or list the directory itself is an error, the
+// subdirectories that fail are tried again later, see retryWatch.
 func (fw *FileWatcher) addDirectoryRecursive(path string) error {
-	return filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
+	return fw.walkDir(path, func(walkPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return err
+			if walkPath == path {
+				return err
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				fw.retryWatch(walkPath, err)
+			}
+			return filepath.SkipDir
 		}
 
-		if fw.shouldIgnore(walkPath) {
-			if info.IsDir() {
+		if !d.IsDir() {
+			return nil
+		}
+
+		if fw.shouldIgnore(walkPath) || fw.isExcluded(walkPath) {
+			return filepath.SkipDir
+		}
+
+		if walkPath != path {
+			// The directories below are added once it is watched
+			if err := fw.watchDir(walkPath, true); err != nil {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		if isDir(walkPath) {
-			if err := fw.watcher.Add(walkPath); err != nil {
-				return err
+		err = fw.watcher.Add(walkPath)
+		if err != nil && isWatchLimit(err) {
+			// Keep going, the rest of the tree is polled instead
+			fw.pollDir(walkPath, true)
+			return nil
+		}
+		return err
+	})
+}
+
+// addNewDirectory watches a directory created while watching together with the
+// directories nested in it, and queues the files already in them. A tree created
+// quickly, e.g. by extracting an archive, is filled before the watch of its top
+// directory is added, so no events are sent for what is below it.
+func (fw *FileWatcher) addNewDirectory(dir string) {
+	fw.walkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Removed again meanwhile, or not readable yet
+			if d != nil && d.IsDir() && !errors.Is(err, fs.ErrNotExist) {
+				fw.retryWatch(path, err)
+			}
+			return nil
+		}
+
+		if path != dir && !fw.shouldIgnore(path) {
+			fw.emitEvent(FileEvent{Path: path, Type: "CREATE", Time: time.Now()})
+		}
+
+		if d.IsDir() {
+			if fw.shouldIgnore(path) || fw.isExcluded(path) {
+				return filepath.SkipDir
+			}
+
+			// The watch is added before the directory is listed, so files
+			// created meanwhile are either listed or reported by events.
+			// What it holds is queued once the watch works.
+			if err := fw.watchDir(path, true); err != nil {
+				return filepath.SkipDir
+			}
+			if path != dir {
+				fw.logger.Info("New catalog: %s", filepath.Base(path))
 			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
 		}
 
+		fw.logger.FileCreated(filepath.Base(path))
+		fw.queueFile(path, "CREATE", info)
 		return nil
 	})
 }
 
-// shouldIgnore checks if a file or directory should be ignored based on the ignore patterns
-func (fw *FileWatcher) shouldIgnore(path string) bool {
-	base := filepath.Base(path)
-	for _, pattern := range fw.config.IgnorePatterns {
-		matched, _ := filepath.Match(pattern, base)
-		if matched {
-			return true
-		}
+// isExcluded reports whether path is the backup directory or inside it,
+// when the backup directory is inside the source
+func (fw *FileWatcher) isExcluded(path string) bool {
+	if filepath.Base(path) == SourceMarkerName {
+		return true
+	}
+	if fw.excluded == "" {
+		return false
+	}
 
-		if strings.Contains(path, pattern) {
-			return true
-		}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
 	}
+	return abs == fw.excluded || strings.HasPrefix(abs, fw.excluded+string(filepath.Separator))
+}
+
+// shouldIgnore checks if a file or directory should be ignored based on the ignore patterns
+func (fw *FileWatcher) shouldIgnore(path string) bool {
+	return fw.filters.Load().ignores(path)
+}
 
-	return false
+// isIncluded checks if a file matches one of the include patterns, all files do when there are none
+func (fw *FileWatcher) isIncluded(file string) bool {
+	return fw.filters.Load().includes(file, fw.config.SourceDir)
 }
 
 // isDir checks if the given path is a directory
@@ -254,35 +958,194 @@ func isDir(path string) bool {
 }
 
 // GetStats returns statistics about the FileWatcher
-func (fw *FileWatcher) GetStats() map[string]interface{} {
+func (fw *FileWatcher) GetStats() Stats {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	usage := fw.BackupManager.throttle.Usage()
+	observed, queued := fw.events.counts()
+	f := fw.filters.Load()
+
+	stats := Stats{
+		ThrottleOpsRate:    usage.OpsPerSec,
+		ThrottleOpsLimit:   usage.OpsLimit,
+		ThrottleBytesRate:  usage.BytesPerSec,
+		ThrottleBytesLimit: usage.BytesLimit,
+		TrackedFiles:       len(fw.lastBackup),
+		QueueLength:        fw.queueLength(),
+		QueueCapacity:      cap(fw.backupQueue),
+		ActiveWorkers:      int(fw.liveWorkers.Load()),
+		RestartedWorkers:   int(fw.restarts.Load()),
+		LostWorkers:        int(fw.lostWorkers.Load()),
+		StalledWorkers:     int(fw.stalledWorkers.Load()),
+		TimedOutJobs:       int(fw.timedOut.Load()),
+		SweepMissed:        int(fw.sweepMissed.Load()),
+		UnwatchedDirs:      fw.poll.count(),
+		RewatchDirs:        fw.rewatch.count(),
+		DeadLetters:        fw.deadLetters.count(),
+		PausedChanges:      fw.pause.count(),
+		EventsObserved:     observed,
+		EventsQueued:       queued,
+		IgnoreHits:         ruleHits(f.ignore),
+		IncludeHits:        ruleHits(f.include),
+	}
+	fw.status.totals(&stats)
+	return stats
+}
+
+// Err returns the first error reported by the underlying file system watcher, if any
+func (fw *FileWatcher) Err() error {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
-	return map[string]interface{}{
-		"tracked_files":  len(fw.lastBackup),
-		"queue_length":   len(fw.backupQueue),
-		"queue_capacity": cap(fw.backupQueue),
-		"active_workers": fw.numWorkers,
+	return fw.watchErr
+}
+
+// queueLength returns the number of jobs waiting for a worker
+func (fw *FileWatcher) queueLength() int {
+	return len(fw.backupQueue) + int(fw.scheduled.Load())
+}
+
+// PendingJobs returns the number of jobs waiting in the queue or being processed
+func (fw *FileWatcher) PendingJobs() int {
+	if fw.abandoned.Load() {
+		return int(fw.inFlight.Load())
+	}
+	return fw.queueLength() + int(fw.inFlight.Load())
+}
+
+// estimateDrain estimates how long the workers need to process all pending jobs,
+// based on the average job duration observed so far
+func (fw *FileWatcher) estimateDrain(pending int) time.Duration {
+	done := fw.jobsDone.Load()
+	workers := fw.liveWorkers.Load()
+	if done == 0 || workers == 0 {
+		return 0
 	}
+
+	avg := time.Duration(fw.jobsTime.Load() / done)
+	return avg * time.Duration(pending) / time.Duration(workers)
 }
 
-// Stop gracefully stops the FileWatcher and all its workers
-func (fw *FileWatcher) Stop() {
+// Stop gracefully stops the FileWatcher and all its workers.
+// File system events are no longer accepted once Stop is called. With DrainOnExit
+// the queued jobs are processed first, reporting progress, otherwise only the
+// jobs already in progress are finished. If ctx expires before the workers are done,
+// the remaining jobs are abandoned and the context error is returned.
+func (fw *FileWatcher) Stop(ctx context.Context) error {
+	fw.stopping.Store(true)
 	fw.logger.Shutdown()
 
+	// Closing the watcher ends watchLoop, and stopping the senders the sweeps
+	// and the polling, so nothing sends on backupQueue when it is closed afterwards
+	fw.watcher.Close()
+
+	// Start may still be setting up, it starts nothing once it sees stopping
+	fw.startMu.Lock()
+	running := fw.running.Load()
+	fw.startMu.Unlock()
+	if running {
+		<-fw.loopDone
+		fw.stopSenders()
+		fw.senders.Wait()
+	}
+
+	// Held workers would never get to the queue
+	if held := fw.pause.release(); len(held) > 0 {
+		fw.logger.Warning("%d files changed while paused are backed up at the next start", len(held))
+	}
+
+	if !fw.config.DrainOnExit {
+		fw.abandonQueue()
+	}
 	close(fw.backupQueue)
 
-	fw.workerWg.Wait()
+	err := fw.waitWorkers(ctx)
+	fw.release()
 
-	fw.watcher.Close()
+	if err != nil {
+		return err
+	}
+
+	fw.logger.Success("Watcher stopped")
+	return nil
+}
 
+// release stops the watchdog and closes the audit log and notification sinks
+// once the workers are done
+func (fw *FileWatcher) release() {
 	close(fw.stopChan)
+	fw.cancelJobs()
 
-	fw.logger.Success("Watcher stopped")
+	if err := fw.saveState(); err != nil {
+		fw.logger.Warning("Could not save watcher state: %v", err)
+	}
+
+	if err := fw.audit.Close(); err != nil {
+		fw.logger.Warning("Could not close audit log: %v", err)
+	}
+	if err := fw.journal.Close(); err != nil {
+		fw.logger.Warning("Could not close queue journal: %v", err)
+	}
+	fw.closeWatcherSinks()
+
+	if fw.unclaim != nil {
+		fw.unclaim()
+	}
 }
 
-// Close implements the io.Closer interface for FileWatcher
+// abandonQueue makes the workers exit after their current job, leaving queued jobs unprocessed
+func (fw *FileWatcher) abandonQueue() {
+	if fw.abandoned.Swap(true) {
+		return
+	}
+
+	if queued := fw.queueLength(); queued > 0 {
+		fw.logger.Warning("Abandoning %d queued backup jobs", queued)
+	}
+	close(fw.quit)
+}
+
+// waitWorkers waits for all workers to exit, reporting the drain progress every second
+func (fw *FileWatcher) waitWorkers(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		fw.workerWg.Wait()
+		close(drained)
+	}()
+
+	progress := time.NewTicker(time.Second)
+	defer progress.Stop()
+
+	if pending := fw.PendingJobs(); pending > 0 {
+		fw.logger.DrainProgress(pending, fw.estimateDrain(pending))
+	}
+
+	for {
+		select {
+		case <-drained:
+			return nil
+
+		case <-ctx.Done():
+			pending := fw.PendingJobs()
+			fw.abandonQueue()
+			// Abort the copies in progress instead of waiting them out,
+			// giving them a moment to record that
+			fw.cancelJobs()
+			select {
+			case <-drained:
+			case <-time.After(time.Second):
+			}
+			return fmt.Errorf("%d jobs still pending: %w", pending, ctx.Err())
+
+		case <-progress.C:
+			pending := fw.PendingJobs()
+			fw.logger.DrainProgress(pending, fw.estimateDrain(pending))
+		}
+	}
+}
+
+// Close implements the io.Closer interface for FileWatcher, draining without a deadline
 func (fw *FileWatcher) Close() error {
-	fw.Stop()
-	return nil
+	return fw.Stop(context.Background())
 }
@@ -0,0 +1,285 @@
+package main
+
+// Web UI for browsing the stored versions of a running watcher, downloading
+// them and restoring them in place. It is two embedded pages, the list of
+// versions and the timeline of one file, using the JSON API below, nothing
+// is loaded from elsewhere.
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cpprian/file-watcher-backup/utils"
+	"github.com/cpprian/file-watcher-backup/watcher"
+)
+
+//go:embed web/index.html
+var webIndex []byte
+
+//go:embed web/timeline.html
+var webTimeline []byte
+
+// webPageSize is the number of versions the UI loads at once
+const webPageSize = 500
+
+// webPreview is the content of a version and its changes to the previous one
+type webPreview struct {
+	Text   string `json:"text"`    // Content, empty when it is not shown
+	Binary bool   `json:"binary"`  // Binary or too large to be shown
+	Diff   string `json:"diff"`    // Unified diff from the previous version, empty when equal
+	NoDiff bool   `json:"no_diff"` // The previous version is binary, or too many lines differ
+}
+
+// webTokenCookie keeps the token of the web UI once it was given in the URL
+const webTokenCookie = "fwbackup_token"
+
+// serveWeb serves the web UI of fw on addr until the returned function is called.
+// Versions are restored to their file in sourceDir. With a tokenFile, every
+// request must carry the token in it, see guardWeb.
+func serveWeb(fw *watcher.FileWatcher, sourceDir, addr, tokenFile string) (func(), error) {
+	var token string
+	if tokenFile != "" {
+		var err error
+		if token, err = readToken(tokenFile); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	fail := func(w http.ResponseWriter, status int, err error) {
+		reply(w, status, map[string]string{"error": err.Error()})
+	}
+
+	// file returns the file parameter, a path relative to the source directory
+	file := func(r *http.Request) (string, error) {
+		name := r.URL.Query().Get("file")
+		if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+			return "", fmt.Errorf("invalid file: %q", name)
+		}
+		return filepath.FromSlash(name), nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webIndex)
+	})
+	mux.HandleFunc("GET /timeline", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webTimeline)
+	})
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, fw.Status())
+	})
+	mux.HandleFunc("GET /api/versions", func(w http.ResponseWriter, r *http.Request) {
+		page, err := fw.BackupManager.ListVersions(watcher.VersionQuery{
+			Prefix: r.URL.Query().Get("prefix"),
+			Cursor: r.URL.Query().Get("cursor"),
+			Limit:  webPageSize,
+		})
+		if err != nil {
+			fail(w, http.StatusBadRequest, err)
+			return
+		}
+		reply(w, http.StatusOK, page)
+	})
+	mux.HandleFunc("GET /api/download", func(w http.ResponseWriter, r *http.Request) {
+		name, err := file(r)
+		if err != nil {
+			fail(w, http.StatusBadRequest, err)
+			return
+		}
+		versionPath, err := fw.BackupManager.VersionPath(name, r.URL.Query().Get("version"))
+		if err != nil {
+			fail(w, http.StatusNotFound, err)
+			return
+		}
+
+		f, err := os.Open(versionPath)
+		if err != nil {
+			fail(w, http.StatusNotFound, err)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			fail(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Downloaded under the name of the file, the version is in the name of the backup
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+			map[string]string{"filename": path.Base(filepath.ToSlash(name))}))
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	})
+	mux.HandleFunc("GET /api/preview", func(w http.ResponseWriter, r *http.Request) {
+		name, err := file(r)
+		if err != nil {
+			fail(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// side reads a version, nil when it is not text or too large to show
+		side := func(version string) (*diffSide, error) {
+			versionPath, err := fw.BackupManager.VersionPath(name, version)
+			if err != nil {
+				return nil, err
+			}
+			s, err := readDiffSide(versionPath, filepath.Base(versionPath))
+			if err != nil || s.data == nil || !utils.IsText(s.data) {
+				return nil, err
+			}
+			return s, nil
+		}
+
+		current, err := side(r.URL.Query().Get("version"))
+		if err != nil {
+			fail(w, http.StatusNotFound, err)
+			return
+		}
+		if current == nil {
+			reply(w, http.StatusOK, webPreview{Binary: true})
+			return
+		}
+
+		// The first version is diffed against nothing, all of it was added
+		previous := &diffSide{label: "/dev/null"}
+		if version := r.URL.Query().Get("previous"); version != "" {
+			if previous, err = side(version); err != nil {
+				fail(w, http.StatusNotFound, err)
+				return
+			}
+		}
+
+		preview := webPreview{Text: string(current.data), NoDiff: previous == nil}
+		if previous != nil {
+			var ok bool
+			preview.Diff, ok = utils.UnifiedDiff(previous.label, current.label, previous.data, current.data)
+			preview.NoDiff = !ok
+		}
+		reply(w, http.StatusOK, preview)
+	})
+	mux.HandleFunc("POST /api/restore", func(w http.ResponseWriter, r *http.Request) {
+		// Browsers send custom headers to other sites only when allowed, so other pages can not restore
+		if r.Header.Get("X-Requested-With") != "fwbackup" {
+			fail(w, http.StatusForbidden, errors.New("missing X-Requested-With header"))
+			return
+		}
+		name, err := file(r)
+		if err != nil {
+			fail(w, http.StatusBadRequest, err)
+			return
+		}
+
+		restored, err := fw.BackupManager.Restore(r.Context(), name, r.URL.Query().Get("version"),
+			filepath.Join(sourceDir, name), watcher.RestoreOptions{})
+		if err != nil {
+			fail(w, http.StatusConflict, err)
+			return
+		}
+		reply(w, http.StatusOK, map[string]string{"restored": filepath.Base(restored)})
+	})
+
+	listenHost, _, _ := net.SplitHostPort(addr)
+	srv := &http.Server{Handler: guardWeb(mux, listenHost, token), ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+
+	return func() { srv.Close() }, nil
+}
+
+// guardWeb rejects requests to next naming another host than listenHost, so
+// a web page can not reach the UI through a DNS name it rebinds to a local
+// address. With a token, requests must also send it as a bearer token or in
+// the cookie set when it is given once as the token parameter of a URL.
+func guardWeb(next http.Handler, listenHost, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webHostAllowed(r.Host, listenHost) {
+			http.Error(w, "unexpected Host header", http.StatusForbidden)
+			return
+		}
+
+		if token != "" {
+			query := r.URL.Query()
+			if sent := query.Get("token"); sent != "" && sameToken(sent, token) {
+				// Kept in a cookie other sites can not send, and out of the address bar
+				http.SetCookie(w, &http.Cookie{
+					Name:     webTokenCookie,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+				query.Del("token")
+				r.URL.RawQuery = query.Encode()
+				http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			if !webTokenSent(r, token) {
+				http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webHostAllowed reports whether the Host header of a request names the
+// listen address, localhost or an IP address, which DNS can not rebind
+func webHostAllowed(hostHeader, listenHost string) bool {
+	host := hostHeader
+	if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil ||
+		(listenHost != "" && strings.EqualFold(host, listenHost))
+}
+
+// webTokenSent reports whether r carries token as a bearer token or in the cookie
+func webTokenSent(r *http.Request, token string) bool {
+	if sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && sameToken(sent, token) {
+		return true
+	}
+	cookie, err := r.Cookie(webTokenCookie)
+	return err == nil && sameToken(cookie.Value, token)
+}
+
+// sameToken compares tokens in constant time
+func sameToken(sent, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// loopbackAddr reports whether the listen address addr only accepts local connections
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}